@@ -0,0 +1,129 @@
+package plotbot
+
+import "github.com/slack-go/slack"
+
+// Adapter connects the Bot to a chat transport.  The Bot drives the
+// Adapter: it calls `Connect()` once per connection attempt, consumes
+// normalized events from `Events()`, and sends everything it has to
+// say through `Send()` and `Update()`.
+//
+// Users and channels are still described with the `slack` types, as
+// every plugin already speaks them.
+type Adapter interface {
+	// Connect establishes the connection with the chat service.  It
+	// must push a `*ConnectedEvent` on `Events()` once the bot's
+	// identity is known.
+	Connect() error
+	// Disconnect tears down the connection.  It can be called many
+	// times.
+	Disconnect()
+	// Events returns the channel of normalized incoming events.  The
+	// same channel is returned across reconnections.
+	Events() <-chan Event
+
+	// Send posts a new message and returns its timestamp.
+	Send(reply *BotReply) (string, error)
+	// Update replaces the content of a previously sent message.
+	Update(channelID, timestamp string, reply *BotReply) error
+
+	// GetUsers and GetChannels fetch the full directory, used to
+	// populate the Bot's Directory upon connection.  Private groups
+	// are returned as channels.
+	GetUsers() ([]slack.User, error)
+	GetChannels() ([]slack.Channel, error)
+	// GetUser and GetChannel fetch a single user or channel the
+	// Directory doesn't know yet.
+	GetUser(id string) (*slack.User, error)
+	GetChannel(id string) (*slack.Channel, error)
+}
+
+// Event is a normalized, transport-independent event handed by an
+// Adapter to the Bot.  See the `*Event` types below.
+type Event interface{}
+
+// ConnectedEvent is sent when a connection is (re-)established.
+type ConnectedEvent struct {
+	Myself *slack.UserDetails
+}
+
+// MessageEvent carries an incoming chat message.
+type MessageEvent struct {
+	Msg        slack.Msg
+	SubMessage *slack.Msg
+}
+
+// MessageEditEvent is sent when a message is edited.  `Msg` is the
+// message as edited, with the `Timestamp` of the original message.
+type MessageEditEvent struct {
+	Msg          slack.Msg
+	PreviousText string
+}
+
+// MessageDeleteEvent is sent when a message is deleted.  `Msg` is the
+// deleted message, as much as it is known.
+type MessageDeleteEvent struct {
+	Msg slack.Msg
+}
+
+// UserChangeEvent is sent when a user joins the team or updates his
+// profile.
+type UserChangeEvent struct {
+	User slack.User
+}
+
+// PresenceChangeEvent is sent when a user goes active or away.
+type PresenceChangeEvent struct {
+	UserID   string
+	Presence string
+}
+
+// ChannelChangeEvent is sent when a channel or group is created or
+// joined.
+type ChannelChangeEvent struct {
+	Channel slack.Channel
+}
+
+// ChannelRenameEvent is sent when a channel or group is renamed.
+type ChannelRenameEvent struct {
+	ChannelID string
+	Name      string
+}
+
+// ChannelArchiveEvent is sent when a channel or group is archived or
+// unarchived.
+type ChannelArchiveEvent struct {
+	ChannelID string
+	Archived  bool
+}
+
+// ChannelDeleteEvent is sent when a channel is deleted or a group is
+// closed.
+type ChannelDeleteEvent struct {
+	ChannelID string
+}
+
+// MemberJoinedEvent is sent when a user joins a channel or group.
+type MemberJoinedEvent struct {
+	ChannelID string
+	UserID    string
+}
+
+// MemberLeftEvent is sent when a user leaves, or is removed from, a
+// channel or group.
+type MemberLeftEvent struct {
+	ChannelID string
+	UserID    string
+}
+
+// SlashCommandEvent is sent when a user invokes one of the bot's slash
+// commands.  It is dispatched to Conversations as a message addressed
+// to the bot.
+type SlashCommandEvent struct {
+	Command slack.SlashCommand
+}
+
+// InteractionEvent is sent when a user clicks a button or picks an
+// option in a menu attached to one of the bot's messages.
+type InteractionEvent struct {
+	Callback slack.InteractionCallback
+}
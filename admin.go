@@ -0,0 +1,284 @@
+package plotbot
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// setupAdminCommands listens for the chat commands managing the bot
+// itself.  They are restricted to the users listed in the `admins`
+// setting of the Slack section.
+func (bot *Bot) setupAdminCommands() {
+	bot.adminCommands = NewRouter()
+	bot.adminCommands.Add(&Command{
+		Usage:       "reload config|configuration",
+		Description: "re-read the configuration file, and hand the new settings to the plugins supporting it.  An invalid file is rejected, and the running configuration kept.",
+		HandlerFunc: bot.adminOnly(bot.reloadConfigCommand),
+	})
+	bot.adminCommands.Add(&Command{
+		Usage:       "upcoming jobs|runs",
+		Description: "list the next runs of the scheduled jobs, over the coming week.",
+		HandlerFunc: bot.adminOnly(bot.upcomingJobsCommand),
+	})
+	bot.adminCommands.Add(&Command{
+		Usage:       "audit [last <count:int>] [by <user>] [since <since...>]",
+		Description: "list the privileged actions of the audit log, like deploys and locks, the latest 20 by default.  <user> is a name or a @mention, and <since> a date like monday, yesterday or 3 days ago.",
+		Examples:    []string{"audit last 20", "audit by alice since monday"},
+		HandlerFunc: bot.adminOnly(bot.auditCommand),
+	})
+
+	bot.ListenFor(&Conversation{
+		MentionsMeOnly: true,
+		HandlerFunc: func(conv *Conversation, msg *Message) {
+			bot.adminCommands.Handle(conv, msg)
+		},
+	})
+}
+
+// adminOnly wraps a Command handler, so it refuses users who are not
+// admins.
+func (bot *Bot) adminOnly(handler func(*Conversation, *Message, CommandArgs)) func(*Conversation, *Message, CommandArgs) {
+	return func(conv *Conversation, msg *Message, args CommandArgs) {
+		if !bot.IsAdmin(msg.FromUser) {
+			conv.Reply(msg, msg.AtMentionIfPublic("only admins can do that, see `admins` in the Slack config."))
+			return
+		}
+		handler(conv, msg, args)
+	}
+}
+
+// IsAdmin reports whether a user is listed in the `admins` setting,
+// by ID, name or email.
+func (bot *Bot) IsAdmin(user *slack.User) bool {
+	if user == nil {
+		return false
+	}
+
+	bot.configLock.RLock()
+	defer bot.configLock.RUnlock()
+	for _, admin := range bot.admins {
+		if admin == user.ID || admin == user.Name ||
+			(user.Profile.Email != "" && strings.EqualFold(admin, user.Profile.Email)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (bot *Bot) reloadConfigCommand(conv *Conversation, msg *Message, args CommandArgs) {
+	record := AuditRecordOf(msg, "reload config")
+	if err := bot.ReloadConfig(); err != nil {
+		record.Outcome = "failure"
+		bot.Audit.Record(record)
+		conv.Reply(msg, msg.AtMentionIfPublic(fmt.Sprintf("could not reload the configuration: %s", err)))
+		return
+	}
+	record.Outcome = "success"
+	bot.Audit.Record(record)
+	conv.Reply(msg, msg.AtMentionIfPublic("configuration reloaded."))
+}
+
+func (bot *Bot) upcomingJobsCommand(conv *Conversation, msg *Message, args CommandArgs) {
+	if bot.Scheduler == nil {
+		conv.Reply(msg, msg.AtMentionIfPublic("the scheduler is not running."))
+		return
+	}
+
+	runs := bot.Scheduler.Upcoming(upcomingJobsCount, time.Now().Add(7*24*time.Hour))
+	if len(runs) == 0 {
+		conv.Reply(msg, msg.AtMentionIfPublic("no jobs are scheduled this week."))
+		return
+	}
+	loc := bot.Location(msg.FromUser)
+	lines := make([]string, 0, len(runs))
+	for _, run := range runs {
+		lines = append(lines, fmt.Sprintf("%s  `%s` (%s)", run.At.In(loc).Format("Mon Jan 2 15:04 MST"), run.Job.ID, run.Job.Handler))
+	}
+	conv.Reply(msg, "Upcoming runs:\n"+strings.Join(lines, "\n"))
+}
+
+// upcomingJobsCount is the number of runs listed by `upcoming jobs`.
+const upcomingJobsCount = 15
+
+// auditDefaultCount is the number of records listed by `audit`, and
+// auditMaxCount the most it lists.
+const (
+	auditDefaultCount = 20
+	auditMaxCount     = 100
+)
+
+func (bot *Bot) auditCommand(conv *Conversation, msg *Message, args CommandArgs) {
+	loc := bot.Location(msg.FromUser)
+	query := AuditQuery{Last: args.Int("count")}
+	if args.Has("user") {
+		query.User = bot.auditUser(args.String("user"))
+	}
+	if args.Has("since") {
+		since, err := ParseSince(args.String("since"), time.Now().In(loc))
+		if err != nil {
+			conv.Reply(msg, msg.AtMentionIfPublic(fmt.Sprintf("sorry, %s, try monday, yesterday or 3 days ago.", err)))
+			return
+		}
+		query.Since = since
+	}
+	switch {
+	case query.Last == 0 && query.Since.IsZero():
+		query.Last = auditDefaultCount
+	case query.Last <= 0 || query.Last > auditMaxCount:
+		query.Last = auditMaxCount
+	}
+
+	records, err := bot.Audit.Query(query)
+	if err != nil {
+		bot.Logger.Error("Could not read the audit log", "err", err)
+		conv.Reply(msg, msg.AtMentionIfPublic("sorry, I could not read the audit log."))
+		return
+	}
+	if len(records) == 0 {
+		conv.Reply(msg, msg.AtMentionIfPublic("no actions were recorded."))
+		return
+	}
+
+	lines := make([]string, 0, len(records)+1)
+	lines = append(lines, fmt.Sprintf("Audit log, latest %d:", len(records)))
+	for _, record := range records {
+		lines = append(lines, fmt.Sprintf("%s  %s", record.Time.In(loc).Format("Mon Jan 2 15:04 MST"), record))
+	}
+	conv.Reply(msg, strings.Join(lines, "\n"))
+}
+
+// auditUser returns the ID of the user named or @mentioned in `text`,
+// or the name itself when unknown, which may be in older records.
+func (bot *Bot) auditUser(text string) string {
+	if match := reAtMention.FindStringSubmatch(text); match != nil {
+		return match[1]
+	}
+	name := strings.TrimPrefix(text, "@")
+	if user := bot.GetUser(name); user != nil {
+		return user.ID
+	}
+	return name
+}
+
+// ReloadConfig re-reads the configuration file, and hands it to the
+// plugins implementing PluginReconfigurer.  The file is first checked
+// like `CheckConfig()` does, along with the sections of the running
+// plugins implementing PluginConfigSchema: if any problem is found, it
+// is rejected as a whole, and everything keeps running with the current
+// configuration.  Plugins rejecting their new section for reasons their
+// schema can't tell keep their current one, and their errors are
+// returned.
+//
+// The `admins` and the channel settings of the plugins are reloaded
+// too, but other changes to the Slack, LevelDB and Plugins sections
+// only apply upon restart.
+//
+// Reloads triggered by SIGHUP or the `reload config` command run
+// between two events, so plugins are never reconfigured while handling
+// a message.
+func (bot *Bot) ReloadConfig() error {
+	if err := checkPermission(bot.configFile); err != nil {
+		return err
+	}
+	content, err := bot.readConfig()
+	if err != nil {
+		return fmt.Errorf("invalid configuration: %s", err)
+	}
+	if errs := bot.checkConfig(content); len(errs) != 0 {
+		return fmt.Errorf("invalid configuration: %s", joinErrors(errs))
+	}
+
+	// The sections were checked above, so these can't fail.
+	load := func(config interface{}) error {
+		return json.Unmarshal(content, config)
+	}
+	var slackConfig SlackConfig
+	DecodeSection(load, "Slack", &slackConfig)
+	var levelDBConfig LevelDBConfig
+	DecodeSection(load, "LevelDB", &levelDBConfig)
+	var logConfig LogConfig
+	DecodeSection(load, "Log", &logConfig)
+	var pluginsConfig PluginsConfig
+	DecodeSection(load, "Plugins", &pluginsConfig)
+
+	bot.updatePluginSettings(pluginsConfig)
+	bot.Logger.Configure(logConfig, bot.Config.Debug)
+	bot.LogConfig = logConfig
+
+	errs := reconfigurePlugins(bot.plugins, load)
+
+	bot.configLock.Lock()
+	bot.admins = slackConfig.Admins
+	bot.configLock.Unlock()
+
+	slackConfig.Admins = bot.Config.Admins
+	if !reflect.DeepEqual(slackConfig, bot.Config) || levelDBConfig != bot.LevelDBConfig {
+		bot.Logger.Warn("Changes to the Slack and LevelDB sections apply upon restart")
+	}
+
+	if len(errs) != 0 {
+		return fmt.Errorf("rejected by %s", joinErrors(errs))
+	}
+	bot.Logger.Info("Configuration reloaded")
+	return nil
+}
+
+func joinErrors(errs []error) string {
+	messages := make([]string, 0, len(errs))
+	for _, err := range errs {
+		messages = append(messages, err.Error())
+	}
+	return strings.Join(messages, "; ")
+}
+
+// updatePluginSettings applies the new channel settings of the running
+// plugins.  Plugins can only be enabled or disabled, and external
+// plugins given another command, upon restart.
+func (bot *Bot) updatePluginSettings(config PluginsConfig) {
+	enabled, _ := enablePlugins(config)
+	settings := make(map[string]PluginSettings)
+	for _, plugin := range enabled {
+		settings[plugin.name] = plugin.settings
+	}
+
+	changed := len(enabled) != len(bot.plugins)
+	for _, plugin := range bot.plugins {
+		newSettings, ok := settings[plugin.name]
+		if !ok {
+			changed = true
+			continue
+		}
+		if external, ok := plugin.plugin.(*externalPlugin); ok {
+			if !sameCommand(external.settings, newSettings) {
+				bot.Logger.Warn("Changes to the command of external plugins apply upon restart", "plugin", plugin.name)
+			}
+			external.setChannels(newSettings)
+		}
+		plugin.setSettings(newSettings)
+	}
+	if changed {
+		bot.Logger.Warn("Enabling or disabling plugins applies upon restart")
+	}
+}
+
+// sameCommand reports whether two settings run an external plugin the
+// same way.
+func sameCommand(a, b PluginSettings) bool {
+	return a.Command == b.Command && reflect.DeepEqual(a.Args, b.Args) &&
+		reflect.DeepEqual(a.Env, b.Env) && bytes.Equal(a.Config, b.Config)
+}
+
+// requestReload makes the message handler reload the configuration
+// between two events.  Requests made while one is pending are merged.
+func (bot *Bot) requestReload() {
+	select {
+	case bot.reloadCh <- true:
+	default:
+	}
+}
@@ -0,0 +1,216 @@
+package plotbot
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+type reconfigurablePlugin struct {
+	mu     sync.Mutex
+	Name   string
+	reject bool
+	calls  int
+}
+
+func (p *reconfigurablePlugin) Reconfigure(load func(config interface{}) error) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.calls++
+	var conf struct {
+		Fake struct {
+			Name string
+		}
+	}
+	if err := load(&conf); err != nil {
+		return err
+	}
+	if p.reject {
+		return errors.New("no way")
+	}
+	p.Name = conf.Fake.Name
+	return nil
+}
+
+func (p *reconfigurablePlugin) state() (string, int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.Name, p.calls
+}
+
+type fakeConfig struct {
+	Name string
+}
+
+func (c *fakeConfig) Validate() error {
+	if c.Name == "invalid" {
+		return errors.New("invalid name")
+	}
+	return nil
+}
+
+// checkedPlugin is a reconfigurablePlugin declaring its section.
+type checkedPlugin struct {
+	reconfigurablePlugin
+}
+
+func (p *checkedPlugin) ConfigSchema() (string, interface{}) {
+	return "Fake", &fakeConfig{}
+}
+
+func writeConfig(t *testing.T, path, content string) {
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReloadConfig(t *testing.T) {
+	plugin := &reconfigurablePlugin{}
+
+	dir, err := ioutil.TempDir("", "plotbot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "plotbot.conf")
+
+	bot := New(path)
+	bot.plugins = []*enabledPlugin{{name: "fake", plugin: plugin}}
+	writeConfig(t, path, `{"Slack": {"api_token": "xoxb-1", "admins": ["hodor"]}, "LevelDB": {"path": "/tmp/db"}, "Fake": {"name": "one"}}`)
+	if err := bot.ReloadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if plugin.Name != "one" {
+		t.Errorf("expected the plugin to be reconfigured, got %q", plugin.Name)
+	}
+	if !bot.IsAdmin(&slack.User{ID: "U1", Name: "hodor"}) {
+		t.Error("expected admins to be reloaded")
+	}
+
+	writeConfig(t, path, `{"Fake": {"name": "two"},`)
+	if err := bot.ReloadConfig(); err == nil {
+		t.Error("expected an invalid file to be rejected")
+	}
+	if plugin.calls != 1 || plugin.Name != "one" {
+		t.Error("expected plugins to be left alone on an invalid file")
+	}
+	if !bot.IsAdmin(&slack.User{Name: "hodor"}) {
+		t.Error("expected admins to be kept on an invalid file")
+	}
+
+	writeConfig(t, path, `{"Slack": {"mode": "events"}, "Fake": {"name": "two"}}`)
+	if err := bot.ReloadConfig(); err == nil {
+		t.Error("expected an invalid Slack section to be rejected")
+	}
+	if _, calls := plugin.state(); calls != 1 {
+		t.Error("expected plugins to be left alone on an invalid Slack section")
+	}
+
+	plugin.reject = true
+	writeConfig(t, path, `{"Slack": {"api_token": "xoxb-1"}, "LevelDB": {"path": "/tmp/db"}, "Fake": {"name": "three"}}`)
+	if err := bot.ReloadConfig(); err == nil || !strings.Contains(err.Error(), "no way") {
+		t.Errorf("expected the plugin error to be reported, got %v", err)
+	}
+	if plugin.Name != "one" {
+		t.Errorf("expected the plugin to keep its configuration, got %q", plugin.Name)
+	}
+}
+
+func TestReloadConfigChecksPluginSections(t *testing.T) {
+	plugin := &checkedPlugin{}
+
+	dir, err := ioutil.TempDir("", "plotbot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "plotbot.conf")
+
+	bot := New(path)
+	bot.plugins = []*enabledPlugin{{name: "fake", plugin: plugin}}
+	writeConfig(t, path, `{"Slack": {"api_token": "xoxb-1", "admins": ["hodor"]}, "LevelDB": {"path": "/tmp/db"}, "Log": {"level": "info"}, "Fake": {"name": "one"}}`)
+	if err := bot.ReloadConfig(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing is applied when a plugin section is invalid, even the
+	// sections checked before it.
+	writeConfig(t, path, `{"Slack": {"api_token": "xoxb-1", "admins": ["bran"]}, "LevelDB": {"path": "/tmp/db"}, "Log": {"level": "debug"}, "Fake": {"name": "invalid"}}`)
+	if err := bot.ReloadConfig(); err == nil || !strings.Contains(err.Error(), "Fake: invalid name") {
+		t.Errorf("expected the plugin section to be rejected, got %v", err)
+	}
+	if name, calls := plugin.state(); calls != 1 || name != "one" {
+		t.Errorf("expected the plugin to be left alone, got %q after %d calls", name, calls)
+	}
+	if !bot.IsAdmin(&slack.User{Name: "hodor"}) || bot.IsAdmin(&slack.User{Name: "bran"}) {
+		t.Error("expected admins to be kept")
+	}
+	if bot.LogConfig.Level != "info" {
+		t.Errorf("expected the log configuration to be kept, got %q", bot.LogConfig.Level)
+	}
+}
+
+func TestReloadConfigCommand(t *testing.T) {
+	plugin := &reconfigurablePlugin{}
+
+	dir, err := ioutil.TempDir("", "plotbot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "plotbot.conf")
+	writeConfig(t, path, `{"Slack": {"api_token": "xoxb-1", "admins": ["hodor@plot.ly"]}, "LevelDB": {"path": "/tmp/db"}, "Fake": {"name": "one"}}`)
+
+	adapter := newFakeAdapter()
+	admin := slack.User{ID: "U1", Name: "hodor"}
+	admin.Profile.Email = "Hodor@plot.ly"
+	adapter.users = []slack.User{admin, {ID: "U2", Name: "bran"}}
+	bot := newTestBot(adapter)
+	defer bot.Disconnect()
+	bot.plugins = []*enabledPlugin{{name: "fake", plugin: plugin}}
+	bot.configFile = path
+	bot.admins = []string{"hodor@plot.ly"}
+	bot.setupAdminCommands()
+	time.Sleep(50 * time.Millisecond)
+
+	expectations := []struct {
+		user     string
+		expected string
+	}{
+		{"U2", "only admins can do that"},
+		{"U1", "configuration reloaded"},
+	}
+	for _, expect := range expectations {
+		adapter.events <- &MessageEvent{Msg: slack.Msg{
+			User:    expect.user,
+			Channel: "D1",
+			Text:    "<@UBOT> reload config",
+		}}
+		select {
+		case reply := <-adapter.sent:
+			if !strings.Contains(reply.Text, expect.expected) {
+				t.Errorf("expected %q, got %q", expect.expected, reply.Text)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expected reply %q", expect.expected)
+		}
+	}
+	if _, calls := plugin.state(); calls != 1 {
+		t.Errorf("expected one reload, got %d", calls)
+	}
+
+	writeConfig(t, path, `{"Slack": {"api_token": "xoxb-1"}, "LevelDB": {"path": "/tmp/db"}, "Fake": {"name": "two"}}`)
+	bot.requestReload()
+	time.Sleep(50 * time.Millisecond)
+	if name, calls := plugin.state(); calls != 2 || name != "two" {
+		t.Error("expected the requested reload to be run by the message handler")
+	}
+}
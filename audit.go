@@ -0,0 +1,358 @@
+package plotbot
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AuditLog keeps a durable trace of the privileged actions, like
+// deploys or locking deployment: who did what, when, where and with
+// which parameters.
+//
+// Records are appended to the database, and never changed nor deleted
+// by the bot.  They can also be written, as JSON lines, to a file
+// rotated once it grows too big, set in the Audit section:
+//
+//	"Audit": {"file": "/var/log/plotbot/audit.jsonl", "max_size_mb": 100, "max_files": 10}
+//
+// Plugins implementing PluginAuditInitializer get a log filling in
+// their name.  A nil *AuditLog ignores records, so code can be
+// exercised without one, in tests.
+type AuditLog struct {
+	plugin string
+	shared *auditShared
+}
+
+// auditShared is shared by the logs of all the plugins.
+type auditShared struct {
+	store  Store
+	file   *rotatingFile
+	now    func() time.Time
+	logger *Logger
+
+	lock    sync.Mutex
+	lastKey string
+}
+
+// PluginAuditInitializer is implemented by plugins doing privileged
+// actions.  They get an audit log recording their name before
+// `InitPlugin()`.
+type PluginAuditInitializer interface {
+	InitAudit(audit *AuditLog)
+}
+
+// AuditConfig is the Audit section of the configuration.  Records are
+// only written to a file when `File` is set.
+type AuditConfig struct {
+	File string `json:"file"`
+	// MaxSizeMB is the size above which the file is rotated: renamed
+	// with a ".1" suffix, the previous ".1" becoming ".2", and so on.
+	MaxSizeMB int `json:"max_size_mb" default:"100"`
+	// MaxFiles is the number of rotated files kept.
+	MaxFiles int `json:"max_files" default:"10"`
+}
+
+// Validate checks the rotation settings.
+func (c *AuditConfig) Validate() error {
+	if c.MaxSizeMB <= 0 || c.MaxFiles <= 0 {
+		return fmt.Errorf("max_size_mb and max_files must be positive")
+	}
+	return nil
+}
+
+// AuditRecord is an entry of the audit log.
+type AuditRecord struct {
+	// ID orders the records, and is set by `Record()`, like Time and
+	// Plugin.
+	ID   string    `json:"id"`
+	Time time.Time `json:"time"`
+
+	// User and UserName are who did it.
+	User     string `json:"user,omitempty"`
+	UserName string `json:"user_name,omitempty"`
+	// Channel and ChannelName are where they asked, if in the chat.
+	Channel     string `json:"channel,omitempty"`
+	ChannelName string `json:"channel_name,omitempty"`
+
+	// Plugin and Action are what was done, like "deployer" and
+	// "deploy", with its parameters.
+	Plugin string            `json:"plugin"`
+	Action string            `json:"action"`
+	Params map[string]string `json:"params,omitempty"`
+	// Outcome is how it went, like "started", "success" or "failure",
+	// when it matters.
+	Outcome string `json:"outcome,omitempty"`
+}
+
+// AuditRecordOf returns a record of `action`, done by asking with
+// `msg`.
+func AuditRecordOf(msg *Message, action string) AuditRecord {
+	record := AuditRecord{User: msg.User, Channel: msg.Channel, Action: action}
+	if msg.FromUser != nil {
+		record.UserName = msg.FromUser.Name
+	}
+	if msg.FromChannel != nil {
+		record.ChannelName = msg.FromChannel.Name
+	}
+	return record
+}
+
+// String formats the record on one line, without its time.
+func (record AuditRecord) String() string {
+	who := record.UserName
+	if who == "" {
+		who = record.User
+	}
+	if who == "" {
+		who = "someone"
+	}
+	text := fmt.Sprintf("%s: %s %s", who, record.Plugin, record.Action)
+	if record.Outcome != "" {
+		text += " (" + record.Outcome + ")"
+	}
+	if record.ChannelName != "" {
+		text += " in #" + record.ChannelName
+	}
+
+	names := make([]string, 0, len(record.Params))
+	for name := range record.Params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		text += fmt.Sprintf(" %s=%s", name, record.Params[name])
+	}
+	return text
+}
+
+// AuditQuery selects records.  Zero fields select everything.
+type AuditQuery struct {
+	// User is the ID or the name of who did it.
+	User  string
+	Since time.Time
+	// Last keeps only the latest records.
+	Last int
+}
+
+func (query AuditQuery) matches(record AuditRecord) bool {
+	if query.User != "" && record.User != query.User && !strings.EqualFold(record.UserName, query.User) {
+		return false
+	}
+	return query.Since.IsZero() || !record.Time.Before(query.Since)
+}
+
+// NewAuditLog returns an audit log appending to `store`, and to the
+// file of `config` if set.
+func NewAuditLog(store Store, config AuditConfig) (*AuditLog, error) {
+	shared := &auditShared{
+		store:  store,
+		now:    time.Now,
+		logger: defaultLogger.With("component", "audit"),
+	}
+	if config.File != "" {
+		file, err := openRotatingFile(config.File, int64(config.MaxSizeMB)<<20, config.MaxFiles)
+		if err != nil {
+			return nil, err
+		}
+		shared.file = file
+	}
+
+	// Records from the future, if the clock went back, would sort
+	// after the new ones.
+	err := store.Range(auditKey(shared.now().UnixNano()), "", func(entry StoreEntry) error {
+		shared.lastKey = entry.Key
+		return nil
+	})
+	if err != nil {
+		if shared.file != nil {
+			shared.file.Close()
+		}
+		return nil, err
+	}
+	return &AuditLog{plugin: "plotbot", shared: shared}, nil
+}
+
+// For returns the log of a plugin, which records its name.
+func (audit *AuditLog) For(plugin string) *AuditLog {
+	if audit == nil {
+		return nil
+	}
+	return &AuditLog{plugin: plugin, shared: audit.shared}
+}
+
+// Record appends a record to the log, setting its ID, its time and
+// the name of the plugin.  Errors are logged, and returned for the
+// callers which can't go on without a trace.
+func (audit *AuditLog) Record(record AuditRecord) error {
+	if audit == nil {
+		return nil
+	}
+	shared := audit.shared
+	shared.lock.Lock()
+	defer shared.lock.Unlock()
+
+	record.Plugin = audit.plugin
+	record.Time = shared.now().UTC()
+	// Keys are the times, which sort in order and allow range queries,
+	// bumped to stay unique.
+	record.ID = auditKey(record.Time.UnixNano())
+	if record.ID <= shared.lastKey {
+		last, _ := strconv.ParseInt(shared.lastKey, 10, 64)
+		record.ID = auditKey(last + 1)
+	}
+
+	if err := shared.store.Put(record.ID, record); err != nil {
+		shared.logger.Error("Could not record an audit record", "record", record.String(), "err", err)
+		return err
+	}
+	shared.lastKey = record.ID
+
+	if shared.file != nil {
+		line, _ := json.Marshal(record)
+		if _, err := shared.file.Write(append(line, '\n')); err != nil {
+			shared.logger.Error("Could not write the audit file", "err", err)
+		}
+	}
+	return nil
+}
+
+// Query returns the records selected by `query`, oldest first.
+func (audit *AuditLog) Query(query AuditQuery) ([]AuditRecord, error) {
+	records := make([]AuditRecord, 0)
+	if audit == nil {
+		return records, nil
+	}
+
+	start := ""
+	if !query.Since.IsZero() {
+		start = auditKey(query.Since.UnixNano())
+	}
+	err := audit.shared.store.Range(start, "", func(entry StoreEntry) error {
+		var record AuditRecord
+		if err := entry.Decode(&record); err != nil {
+			return fmt.Errorf("audit record %q: %s", entry.Key, err)
+		}
+		if query.matches(record) {
+			records = append(records, record)
+			if query.Last > 0 && len(records) > 2*query.Last {
+				records = append(records[:0], records[len(records)-query.Last:]...)
+			}
+		}
+		return nil
+	})
+	if query.Last > 0 && len(records) > query.Last {
+		records = records[len(records)-query.Last:]
+	}
+	return records, err
+}
+
+// Export writes the records selected by `query` as JSON lines, oldest
+// first, and returns how many there were.
+func (audit *AuditLog) Export(w io.Writer, query AuditQuery) (int, error) {
+	records, err := audit.Query(query)
+	if err != nil {
+		return 0, err
+	}
+	encoder := json.NewEncoder(w)
+	for i := range records {
+		if err := encoder.Encode(&records[i]); err != nil {
+			return i, err
+		}
+	}
+	return len(records), nil
+}
+
+// Close closes the audit file, if any.
+func (audit *AuditLog) Close() error {
+	if audit == nil || audit.shared.file == nil {
+		return nil
+	}
+	audit.shared.lock.Lock()
+	defer audit.shared.lock.Unlock()
+	return audit.shared.file.Close()
+}
+
+func auditKey(nanos int64) string {
+	return fmt.Sprintf("%019d", nanos)
+}
+
+// rotatingFile appends to a file, which is renamed with a ".1" suffix
+// once bigger than `maxSize`, keeping `maxFiles` of them.
+type rotatingFile struct {
+	path     string
+	maxSize  int64
+	maxFiles int
+
+	file *os.File
+	size int64
+}
+
+func openRotatingFile(path string, maxSize int64, maxFiles int) (*rotatingFile, error) {
+	f := &rotatingFile{path: path, maxSize: maxSize, maxFiles: maxFiles}
+	return f, f.open()
+}
+
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file, f.size = file, info.Size()
+	return nil
+}
+
+// Write writes `p` whole, rotating the file first if `p` would make it
+// too big.
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+	if f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+func (f *rotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	f.file = nil
+
+	os.Remove(fmt.Sprintf("%s.%d", f.path, f.maxFiles))
+	for i := f.maxFiles - 1; i >= 1; i-- {
+		err := os.Rename(fmt.Sprintf("%s.%d", f.path, i), fmt.Sprintf("%s.%d", f.path, i+1))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := os.Rename(f.path, f.path+".1"); err != nil {
+		return err
+	}
+	return f.open()
+}
+
+func (f *rotatingFile) Close() error {
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
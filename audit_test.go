@@ -0,0 +1,181 @@
+package plotbot
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+func newTestAuditLog(t *testing.T, store Store, now *time.Time) *AuditLog {
+	audit, err := NewAuditLog(store, AuditConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	audit.shared.now = func() time.Time { return *now }
+	return audit
+}
+
+func TestAuditLog(t *testing.T) {
+	store := NewMemoryStore()
+	now := time.Now().Add(24 * time.Hour)
+	audit := newTestAuditLog(t, store, &now)
+	deployer := audit.For("deployer")
+
+	msg := &Message{
+		Msg:         &slack.Msg{User: "U1", Channel: "C1"},
+		FromUser:    &slack.User{ID: "U1", Name: "alice"},
+		FromChannel: &slack.Channel{GroupConversation: slack.GroupConversation{Name: "devops"}},
+	}
+	deployer.Record(AuditRecordOf(msg, "lock"))
+	deployer.Record(AuditRecord{User: "U2", UserName: "bob", Action: "deploy",
+		Params: map[string]string{"environment": "prod", "service": "streambed"}, Outcome: "started"})
+	now = now.Add(48 * time.Hour)
+	audit.Record(AuditRecord{User: "U1", UserName: "alice", Action: "reload config"})
+	deployer.Record(AuditRecord{User: "U1", UserName: "alice", Action: "unlock"})
+
+	records, err := audit.Query(AuditQuery{})
+	if err != nil || len(records) != 4 {
+		t.Fatalf("expected 4 records, got %d, %v", len(records), err)
+	}
+	if records[0].String() != "alice: deployer lock in #devops" ||
+		records[1].String() != "bob: deployer deploy (started) environment=prod service=streambed" ||
+		records[2].Plugin != "plotbot" {
+		t.Errorf("unexpected records %v", records)
+	}
+	if records[0].ID >= records[1].ID || !records[0].Time.Equal(records[1].Time) {
+		t.Errorf("expected records at the same time to get ordered IDs, got %q and %q", records[0].ID, records[1].ID)
+	}
+
+	for _, test := range []struct {
+		query    AuditQuery
+		expected []string
+	}{
+		{AuditQuery{Last: 2}, []string{"reload config", "unlock"}},
+		{AuditQuery{User: "alice"}, []string{"lock", "reload config", "unlock"}},
+		{AuditQuery{User: "U2"}, []string{"deploy"}},
+		{AuditQuery{User: "U1", Since: now.Add(-time.Hour), Last: 1}, []string{"unlock"}},
+	} {
+		records, err := audit.Query(test.query)
+		actions := make([]string, 0)
+		for _, record := range records {
+			actions = append(actions, record.Action)
+		}
+		if err != nil || strings.Join(actions, ", ") != strings.Join(test.expected, ", ") {
+			t.Errorf("%#v: expected %v, got %v, %v", test.query, test.expected, actions, err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if count, err := audit.Export(&buf, AuditQuery{User: "bob"}); err != nil || count != 1 {
+		t.Fatalf("expected one record exported, got %d, %v", count, err)
+	}
+	var exported AuditRecord
+	if err := json.Unmarshal(buf.Bytes(), &exported); err != nil || exported.Params["service"] != "streambed" {
+		t.Errorf("unexpected export %s: %v", buf.String(), err)
+	}
+
+	// Records made after the clock went back, here to the day before the
+	// last records, still come last.
+	now = now.Add(-24 * time.Hour)
+	reopened := newTestAuditLog(t, store, &now)
+	reopened.Record(AuditRecord{Action: "later"})
+	if records, _ := reopened.Query(AuditQuery{Last: 1}); len(records) != 1 || records[0].Action != "later" {
+		t.Errorf("expected the new record to be the last one, got %v", records)
+	}
+
+	var nilAudit *AuditLog
+	if err := nilAudit.For("deployer").Record(AuditRecord{Action: "ignored"}); err != nil {
+		t.Errorf("expected a nil log to ignore records, got %s", err)
+	}
+}
+
+func TestAuditFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "plotbot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.jsonl")
+
+	audit, err := NewAuditLog(NewMemoryStore(), AuditConfig{File: path, MaxSizeMB: 1, MaxFiles: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	audit.shared.file.maxSize = 300
+	for i := 0; i < 8; i++ {
+		audit.Record(AuditRecord{User: "U1", Action: "deploy", Params: map[string]string{"environment": "stage"}})
+	}
+	if err := audit.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := 0
+	for _, name := range []string{path, path + ".1", path + ".2"} {
+		content, err := ioutil.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(content) > 300 {
+			t.Errorf("expected %s to be rotated, got %d bytes", name, len(content))
+		}
+		for _, line := range strings.Split(strings.TrimSpace(string(content)), "\n") {
+			var record AuditRecord
+			if err := json.Unmarshal([]byte(line), &record); err != nil || record.Action != "deploy" {
+				t.Errorf("unexpected line %q in %s: %v", line, name, err)
+			}
+			lines++
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("expected only 2 rotated files to be kept, got %v", err)
+	}
+	if lines >= 8 {
+		t.Errorf("expected the oldest records to be dropped from the files, got %d", lines)
+	}
+}
+
+func TestAuditCommand(t *testing.T) {
+	adapter := newFakeAdapter()
+	adapter.users = []slack.User{{ID: "U1", Name: "hodor"}, {ID: "U2", Name: "alice"}}
+	bot := newTestBot(adapter)
+	defer bot.Disconnect()
+	bot.admins = []string{"hodor"}
+	now := time.Now().Add(-time.Minute)
+	bot.Audit = newTestAuditLog(t, NewMemoryStore(), &now)
+	bot.setupAdminCommands()
+	time.Sleep(50 * time.Millisecond)
+
+	deployer := bot.Audit.For("deployer")
+	deployer.Record(AuditRecord{User: "U2", UserName: "alice", Action: "lock"})
+	deployer.Record(AuditRecord{User: "U1", UserName: "hodor", Action: "deploy", Outcome: "success"})
+
+	for _, expect := range []struct {
+		user, text string
+		expected   []string
+	}{
+		{"U2", "audit last 20", []string{"only admins can do that"}},
+		{"U1", "audit last 20", []string{"latest 2", "alice: deployer lock", "hodor: deployer deploy (success)"}},
+		{"U1", "audit by <@U2> since yesterday", []string{"latest 1", "alice: deployer lock"}},
+		{"U1", "audit by bob since monday", []string{"no actions were recorded"}},
+		{"U1", "audit since forever", []string{"could not understand"}},
+	} {
+		adapter.events <- &MessageEvent{Msg: slack.Msg{User: expect.user, Channel: "D1", Text: "<@UBOT> " + expect.text}}
+		select {
+		case reply := <-adapter.sent:
+			for _, expected := range expect.expected {
+				if !strings.Contains(reply.Text, expected) {
+					t.Errorf("%q: expected %q, got %q", expect.text, expected, reply.Text)
+				}
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%q: expected a reply", expect.text)
+		}
+	}
+}
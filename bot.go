@@ -1,14 +1,18 @@
 package plotbot
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
+	"os/signal"
 	"reflect"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/slack-go/slack"
@@ -18,6 +22,8 @@ import (
 type BotLike interface {
 	AtMention() string
 	CloseConversation(conv *Conversation)
+	HandleInteraction(string, InteractionHandler)
+	RemoveInteraction(string)
 	Id() string
 	ListenFor(*Conversation) error
 	LoadConfig(interface{}) error
@@ -26,76 +32,152 @@ type BotLike interface {
 	Reply(*Message, string)
 	ReplyMention(*Message, string)
 	ReplyPrivately(*Message, string)
+	ReplyInThread(*Message, string)
+	ReplyRich(*Message, *RichMessage)
+	NotifyRich(string, *RichMessage)
+	Send(*BotReply)
 	SendToChannel(string, string)
 	SetMood(Mood)
+	UpdateMessage(string, string, *RichMessage) error
 	WithMood(string, string) string
 }
 
 type Bot struct {
 	// Global bot configuration
-	configFile string
-	Config     SlackConfig
-
-	// Slack connectivity
-	Slack    *slack.Client
-	ws       *slack.RTM
-	Users    map[string]slack.User
-	Channels map[string]slack.Channel
-	Myself   *slack.UserDetails
+	configFile    string
+	Config        SlackConfig
+	configLock    sync.RWMutex
+	admins        []string
+	reloadCh      chan bool
+	adminCommands *Router
+
+	// Chat connectivity
+	Adapter   Adapter
+	Directory *Directory
+	Myself    *slack.UserDetails
 
 	// Internal handling
 	conversations     []*Conversation
 	addConversationCh chan *Conversation
 	delConversationCh chan *Conversation
 	disconnected      chan bool
-	replySink         chan *BotReply
+	outbox            *outbox
 	MentionPrefix     string
+	interactions      map[string]InteractionHandler
+	interactionsLock  sync.Mutex
+
+	// Plugins
+	plugins      []*enabledPlugin
+	initializing *enabledPlugin
 
-	// Storage
+	// Storage.  Plugins should use their own Store, see
+	// PluginStoreInitializer, rather than the raw DB.
 	LevelDBConfig LevelDBConfig
 	DB            *leveldb.DB
+	Store         Store
 
 	// Other features
-	WebServer WebServer
-	mood      Mood
+	Scheduler     *Scheduler
+	WebServer     WebServer
+	Metrics       *Metrics
+	MetricsConfig MetricsConfig
+	metrics       botMetrics
+	Logger        *Logger
+	LogConfig     LogConfig
+	Audit         *AuditLog
+	AuditConfig   AuditConfig
+	mood          Mood
+
+	// Shutdown
+	ctx             context.Context
+	cancel          context.CancelFunc
+	disconnectLock  sync.Mutex
+	ShutdownTimeout time.Duration
 }
 
 func New(configFile string) *Bot {
 	bot := &Bot{
 		configFile:        configFile,
-		replySink:         make(chan *BotReply, 10),
 		addConversationCh: make(chan *Conversation, 100),
 		delConversationCh: make(chan *Conversation, 100),
-
-		Users:    make(map[string]slack.User),
-		Channels: make(map[string]slack.Channel),
+		reloadCh:          make(chan bool, 1),
+		interactions:      make(map[string]InteractionHandler),
+		Directory:         NewDirectory(nil),
+		Metrics:           NewMetrics(),
+		Logger:            defaultLogger,
 	}
 
+	bot.metrics = newBotMetrics(bot.Metrics)
+	bot.outbox = newOutbox(bot.sendNow, bot.updateNow, defaultOutboxPolicy)
+	bot.outbox.instrument(bot.Metrics)
+	bot.ctx, bot.cancel = context.WithCancel(context.Background())
+	bot.ShutdownTimeout = 30 * time.Second
+
 	return bot
 }
 
+// Context is cancelled when the bot starts shutting down.  Plugins'
+// background goroutines should stop when it is done.
+func (bot *Bot) Context() context.Context {
+	return bot.ctx
+}
+
+// Stop makes `Run()` shut the bot down and return.  It is called upon
+// SIGINT or SIGTERM.
+func (bot *Bot) Stop() {
+	bot.cancel()
+}
+
 func (bot *Bot) Run() {
 	bot.loadBaseConfig()
 
+	// Plugins and libraries using the standard logger go through ours.
+	log.SetFlags(0)
+	log.SetOutput(bot.Logger.Writer(LevelInfo))
+
 	// Write PID
 	err := bot.writePID()
 	if err != nil {
-		log.Fatal("Couldn't write PID file:", err)
+		bot.Logger.Fatal("Couldn't write PID file", "err", err)
 	}
 
-	db, err := leveldb.OpenFile(bot.LevelDBConfig.Path, nil)
+	if err := bot.openDB(); err != nil {
+		bot.Logger.Fatal("Could not initialize Leveldb key/value store", "err", err)
+	}
+	go deleteExpiredEvery(bot.ctx, bot.Store, time.Hour)
+
+	bot.Audit, err = NewAuditLog(bot.Store.Namespace("_audit"), bot.AuditConfig)
 	if err != nil {
-		log.Fatal("Could not initialize Leveldb key/value store:", err)
+		bot.Logger.Fatal("Could not open the audit log", "err", err)
+	}
+	go bot.serveMetrics()
+
+	bot.Scheduler, err = NewScheduler(bot.Store.Namespace("_scheduler"))
+	if err != nil {
+		bot.Logger.Fatal("Could not load the scheduled jobs", "err", err)
+	}
+	bot.Scheduler.SetLocation(bot.DefaultLocation())
+	go bot.Scheduler.Run(bot.ctx)
+
+	go bot.handleSignals()
+
+	// Init the enabled plugins
+	var pluginsConfig PluginsConfig
+	if err := DecodeSection(bot.LoadConfig, "Plugins", &pluginsConfig); err != nil {
+		bot.Logger.Fatal("Error loading Plugins config section", "err", err)
+	}
+	if pluginsConfig == nil {
+		bot.Logger.Info("No Plugins section in the config, enabling all plugins")
+	}
+	var errs []error
+	bot.plugins, errs = enablePlugins(pluginsConfig)
+	for _, err := range errs {
+		bot.Logger.Error("Could not enable plugin", "err", err)
 	}
-	defer func() {
-		log.Fatal("Database is closing")
-		db.Close()
-	}()
-	bot.DB = db
 
-	// Init all plugins
 	enabledPlugins := make([]string, 0)
-	for _, plugin := range registeredPlugins {
+	for _, enabled := range bot.plugins {
+		plugin := enabled.plugin
 		pluginType := reflect.TypeOf(plugin)
 		if pluginType.Kind() == reflect.Ptr {
 			pluginType = pluginType.Elem()
@@ -114,12 +196,17 @@ func (bot *Bot) Run() {
 			typeList = append(typeList, "WebPlugin")
 		}
 
-		log.Printf("Plugin %s implements %s", pluginType.String(),
-			strings.Join(typeList, ", "))
+		bot.Logger.Info("Plugin enabled", "plugin", enabled.name, "type", pluginType.String(),
+			"implements", strings.Join(typeList, ", "))
 		enabledPlugins = append(enabledPlugins, strings.Replace(pluginType.String(), ".", "_", -1))
 	}
 
+	if err := migratePlugins(bot.Store, bot.plugins); err != nil {
+		bot.Logger.Fatal("Error migrating the database", "err", err)
+	}
+
 	initChatPlugins(bot)
+	bot.setupAdminCommands()
 	initWebServer(bot, enabledPlugins)
 	initWebPlugins(bot)
 
@@ -127,12 +214,25 @@ func (bot *Bot) Run() {
 		go bot.WebServer.RunServer()
 	}
 
-	for {
-		log.Println("Connecting client...")
+	if bot.Adapter == nil {
+		bot.Adapter, err = NewSlackAdapter(bot.Config)
+		if err != nil {
+			bot.Logger.Fatal("Error setting up Slack connectivity", "err", err)
+		}
+	}
+
+	bot.Directory.SetSource(bot.Adapter)
+	go bot.Directory.RefreshEvery(bot.ctx, bot.Config.directoryRefreshInterval())
+
+	for attempt := 0; bot.ctx.Err() == nil; attempt++ {
+		if attempt > 0 {
+			bot.metrics.reconnects.Inc()
+		}
+		bot.Logger.Info("Connecting client...", "attempt", attempt+1)
 		err := bot.connectClient()
 		if err != nil {
-			log.Println("Error in connectClient(): ", err)
-			time.Sleep(3 * time.Second)
+			bot.Logger.Error("Could not connect", "err", err)
+			bot.sleep(3 * time.Second)
 			continue
 		}
 
@@ -140,13 +240,74 @@ func (bot *Bot) Run() {
 
 		select {
 		case <-bot.disconnected:
-			log.Println("Disconnected...")
-			time.Sleep(1 * time.Second)
-			continue
+			bot.Logger.Warn("Disconnected...")
+			bot.sleep(1 * time.Second)
+		case <-bot.ctx.Done():
+		}
+	}
+
+	bot.shutdown()
+}
+
+// sleep waits for `d`, or until the bot shuts down.
+func (bot *Bot) sleep(d time.Duration) {
+	select {
+	case <-time.After(d):
+	case <-bot.ctx.Done():
+	}
+}
+
+// handleSignals stops the bot upon SIGINT or SIGTERM, and reloads the
+// configuration upon SIGHUP.  A second SIGINT or SIGTERM exits right
+// away.
+func (bot *Bot) handleSignals() {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+
+	stopping := false
+	for sig := range signals {
+		switch {
+		case sig == syscall.SIGHUP:
+			bot.Logger.Info("Reloading the configuration...", "signal", sig)
+			bot.requestReload()
+		case stopping:
+			bot.Logger.Fatal("Exiting now", "signal", sig)
+		default:
+			bot.Logger.Info("Shutting down...", "signal", sig)
+			stopping = true
+			bot.Stop()
 		}
 	}
 }
 
+// shutdown stops accepting messages, gives the plugins some time to
+// stop, flushes the pending replies and closes the database.
+func (bot *Bot) shutdown() {
+	bot.Disconnect()
+	if bot.Adapter != nil {
+		bot.Adapter.Disconnect()
+	}
+
+	deadline := time.Now().Add(bot.ShutdownTimeout)
+	stopPlugins(bot.plugins, bot.ShutdownTimeout)
+
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+	if err := bot.outbox.flush(ctx); err != nil {
+		bot.Logger.Error("Some replies were not sent", "err", err)
+	}
+	if err := bot.Audit.Close(); err != nil {
+		bot.Logger.Error("Error closing the audit file", "err", err)
+	}
+
+	if bot.DB != nil {
+		if err := bot.DB.Close(); err != nil {
+			bot.Logger.Error("Error closing database", "err", err)
+		}
+	}
+	bot.Logger.Info("Shutdown complete")
+}
+
 func (bot *Bot) writePID() error {
 	var serverConf struct {
 		Server struct {
@@ -168,12 +329,22 @@ func (bot *Bot) writePID() error {
 	return ioutil.WriteFile(serverConf.Server.Pidfile, pidb, 0755)
 }
 
+// ListenFor starts dispatching messages to `conv`.  Conversations
+// opened by a plugin, in its `InitPlugin()` or through its
+// `PluginBot()`, only get the messages of the channels of its
+// settings, and only reply there.
 func (bot *Bot) ListenFor(conv *Conversation) error {
 	conv.Bot = bot
+	if conv.plugin == nil {
+		conv.plugin = bot.initializing
+	}
+	if conv.plugin != nil {
+		conv.Bot = &pluginBot{bot, conv.plugin}
+	}
 
 	err := conv.checkParams()
 	if err != nil {
-		log.Println("Bot.ListenFor(): Invalid Conversation: ", err)
+		bot.Logger.Error("Invalid Conversation", "plugin", conv.pluginName(), "err", err)
 		return err
 	}
 
@@ -189,8 +360,8 @@ func (bot *Bot) ListenFor(conv *Conversation) error {
 }
 
 func (bot *Bot) Reply(msg *Message, reply string) {
-	log.Println("Replying:", reply)
-	bot.replySink <- msg.Reply(reply)
+	bot.Logger.Debug("Replying", "channel", msg.Channel, "text", Body(reply))
+	bot.outbox.enqueue(msg.Reply(reply))
 }
 
 // ReplyMention replies with a @mention named prefixed, when replying in public. When replying in private, nothing is added.
@@ -198,156 +369,136 @@ func (bot *Bot) ReplyMention(msg *Message, reply string) {
 	bot.Reply(msg, msg.AtMentionIfPublic(reply))
 }
 
+// ReplyInThread replies in the message's thread, starting a new one
+// under the message if needed.
+func (bot *Bot) ReplyInThread(msg *Message, reply string) {
+	bot.Logger.Debug("Replying in thread", "channel", msg.Channel, "text", Body(reply))
+	bot.outbox.enqueue(msg.ReplyInThread(reply))
+}
+
+// ReplyRich replies with a structured message.  See `RichMessage`.
+func (bot *Bot) ReplyRich(msg *Message, rich *RichMessage) {
+	bot.Logger.Debug("Replying", "channel", msg.Channel, "text", Body(rich.Text))
+	bot.outbox.enqueue(msg.ReplyRich(rich))
+}
+
 func (bot *Bot) ReplyPrivately(msg *Message, reply string) {
-	log.Println("Replying privately:", reply)
-	bot.replySink <- msg.ReplyPrivately(reply)
+	bot.Logger.Debug("Replying privately", "user", msg.User, "text", Body(reply))
+	bot.outbox.enqueue(msg.ReplyPrivately(reply))
 }
 
 func (bot *Bot) Notify(room, color, msg string) {
-	attachment := []slack.Attachment{{
-		Color: color,
+	bot.outbox.enqueue(&BotReply{
+		To:    room,
 		Text:  msg,
-	}}
-	msgoption := slack.MsgOptionAttachments(attachment...)
-	_, _, err := bot.Slack.PostMessage(room, msgoption)
+		Color: color,
+	})
+}
 
-	if err != nil {
-		log.Printf("Notify error: %s\n", err)
-	}
+// NotifyRich is `Notify()` for structured messages.
+func (bot *Bot) NotifyRich(room string, rich *RichMessage) {
+	bot.outbox.enqueue(rich.BotReply(room))
 }
 
 func (bot *Bot) SendToChannel(channelName string, message string) {
 	channel := bot.GetChannelByName(channelName)
 
 	if channel == nil {
-		log.Printf("Couldn't send message, channel %q not found: %q\n", channelName, message)
+		bot.Logger.Error("Couldn't send message, channel not found", "channel", channelName, "text", Body(message))
 		return
 	}
-	log.Printf("Sending to channel %q: %q\n", channelName, message)
+	bot.Logger.Debug("Sending to channel", "channel", channelName, "text", Body(message))
 
 	reply := &BotReply{
 		To:   channel.ID,
 		Text: message,
 	}
-	bot.replySink <- reply
-	return
+	bot.outbox.enqueue(reply)
 }
 
-func (bot *Bot) connectClient() (err error) {
-	resource := bot.Config.Resource
-	if resource == "" {
-		resource = "bot"
-	}
-
-	bot.Slack = slack.New(bot.Config.ApiToken)
-
-	ws := bot.Slack.NewRTM()
-	if err != nil {
-		return err
-	}
-	bot.ws = ws
-	go bot.ws.ManageConnection()
-	return
+func (bot *Bot) connectClient() error {
+	return bot.Adapter.Connect()
 }
 
 func (bot *Bot) setupHandlers() {
+	bot.disconnectLock.Lock()
 	bot.disconnected = make(chan bool)
-	go bot.replyHandler()
+	bot.disconnectLock.Unlock()
 	go bot.messageHandler()
-	log.Println("Bot ready")
+	bot.Logger.Info("Bot ready")
 }
 
-func (bot *Bot) cacheUsers(users []slack.User) {
-	bot.Users = make(map[string]slack.User)
-	for _, user := range users {
-		bot.Users[user.ID] = user
+func (bot *Bot) loadBaseConfig() {
+	if err := checkPermission(bot.configFile); err != nil {
+		bot.Logger.Fatal("Error checking permissions", "err", err)
 	}
-}
 
-func (bot *Bot) cacheChannels(channels []slack.Channel, groups []slack.Group) {
-	bot.Channels = make(map[string]slack.Channel)
-	for _, channel := range channels {
-		bot.Channels[channel.ID] = channel
+	if err := DecodeSection(bot.LoadConfig, "Slack", &bot.Config); err != nil {
+		bot.Logger.Fatal("Error loading Slack config section", "err", err)
 	}
+	bot.admins = bot.Config.Admins
 
-	for _, group := range groups {
-		bot.Channels[group.ID] = slack.Channel{
-			GroupConversation: slack.GroupConversation{
-				Conversation: slack.Conversation{
-					NumMembers: group.NumMembers,
-				},
-				Name:       group.Name,
-				Creator:    group.Creator,
-				IsArchived: group.IsArchived,
-				Members:    group.Members,
-				Topic:      group.Topic,
-				Purpose:    group.Purpose,
-			},
-			IsChannel: false,
-			IsMember:  true,
-		}
+	if err := DecodeSection(bot.LoadConfig, "Log", &bot.LogConfig); err != nil {
+		bot.Logger.Fatal("Error loading Log config section", "err", err)
 	}
-}
-
-func (bot *Bot) loadBaseConfig() {
-	if err := checkPermission(bot.configFile); err != nil {
-		log.Fatal("ERROR Checking Permissions: ", err)
+	if err := bot.Logger.Configure(bot.LogConfig, bot.Config.Debug); err != nil {
+		bot.Logger.Fatal("Error configuring the logs", "err", err)
 	}
 
-	var config1 struct {
-		Slack SlackConfig
-	}
-	err := bot.LoadConfig(&config1)
-	if err != nil {
-		log.Fatalln("Error loading Slack config section:", err)
-	} else {
-		bot.Config = config1.Slack
+	if err := DecodeSection(bot.LoadConfig, "LevelDB", &bot.LevelDBConfig); err != nil {
+		bot.Logger.Fatal("Error loading LevelDB config section", "err", err)
 	}
 
-	var config2 struct {
-		LevelDB LevelDBConfig
+	if err := DecodeSection(bot.LoadConfig, "Metrics", &bot.MetricsConfig); err != nil {
+		bot.Logger.Fatal("Error loading Metrics config section", "err", err)
 	}
-	err = bot.LoadConfig(&config2)
-	if err != nil {
-		log.Fatalln("Error loading LevelDB config section:", err)
-	} else {
-		bot.LevelDBConfig = config2.LevelDB
+
+	if err := DecodeSection(bot.LoadConfig, "Audit", &bot.AuditConfig); err != nil {
+		bot.Logger.Fatal("Error loading Audit config section", "err", err)
 	}
 }
 
+// LoadConfig decodes the configuration file into `config`, after
+// resolving the references to secrets, see `resolveConfig()`.
 func (bot *Bot) LoadConfig(config interface{}) (err error) {
 	content, err := ioutil.ReadFile(bot.configFile)
 	if err != nil {
-		log.Fatalln("LoadConfig(): Error reading config:", err)
+		bot.Logger.Fatal("Error reading config", "file", bot.configFile, "err", err)
+		return
+	}
+	content, err = resolveConfig(content)
+	if err != nil {
+		bot.Logger.Error("Error resolving config", "file", bot.configFile, "err", err)
 		return
 	}
 	err = json.Unmarshal(content, &config)
 
 	if err != nil {
-		log.Println("LoadConfig(): Error unmarshaling JSON", err)
+		bot.Logger.Error("Error unmarshaling config", "file", bot.configFile, "err", err)
 	}
 	return
 }
 
-func (bot *Bot) replyHandler() {
-	for {
-		select {
-		case <-bot.disconnected:
-			return
-		case reply := <-bot.replySink:
-			if reply != nil {
-				log.Println("REPLYING", reply.To, reply.Text)
-				attachment := []slack.Attachment{{Text: reply.Text}}
-				msgoption := slack.MsgOptionAttachments(attachment...)
-				_, _, err := bot.Slack.PostMessage(reply.To, msgoption)
-				if err != nil {
-					log.Fatalln("REPLY ERROR when sending", reply.Text, "->", err)
-				}
-				time.Sleep(50 * time.Millisecond)
+// Send queues a reply for delivery, and returns immediately.  Replies
+// are posted in order for each channel, at most one per second, and
+// retried on errors.  Set `reply.OnDelivery` to learn the outcome.
+func (bot *Bot) Send(reply *BotReply) {
+	bot.outbox.enqueue(reply)
+}
 
-			}
-		}
-	}
+// DeadLetters returns the latest replies that could not be delivered.
+func (bot *Bot) DeadLetters() []DeadLetter {
+	return bot.outbox.DeadLetters()
+}
+
+func (bot *Bot) sendNow(reply *BotReply) (string, error) {
+	bot.Logger.Debug("Sending reply", "to", reply.To, "text", Body(reply.Text))
+	return bot.Adapter.Send(reply)
+}
+
+func (bot *Bot) updateNow(channelID, timestamp string, reply *BotReply) error {
+	bot.Logger.Debug("Updating message", "channel", channelID, "ts", timestamp, "text", Body(reply.Text))
+	return bot.Adapter.Update(channelID, timestamp, reply)
 }
 
 func (bot *Bot) removeConversation(conv *Conversation) {
@@ -376,8 +527,13 @@ func (bot *Bot) messageHandler() {
 		case conv := <-bot.delConversationCh:
 			bot.removeConversation(conv)
 
-		case event := <-bot.ws.IncomingEvents:
-			bot.handleRTMEvent(&event)
+		case event := <-bot.Adapter.Events():
+			bot.handleEvent(event)
+
+		case <-bot.reloadCh:
+			if err := bot.ReloadConfig(); err != nil {
+				bot.Logger.Error("Could not reload the configuration", "err", err)
+			}
 		}
 
 		// Always flush conversations deletions between messages, so a
@@ -387,177 +543,229 @@ func (bot *Bot) messageHandler() {
 			bot.removeConversation(conv)
 		default:
 		}
+		bot.metrics.conversations.Set(float64(len(bot.conversations)))
 	}
 }
 
-func (bot *Bot) handleRTMEvent(event *slack.RTMEvent) {
-	switch ev := event.Data.(type) {
-	case *slack.HelloEvent:
-		fmt.Println("Got a HELLO from websocket")
-
-	case *slack.ConnectedEvent:
-		fmt.Println("Connected.. Syncing users and channels")
-		info := bot.ws.GetInfo()
-		bot.Myself = info.User
+func (bot *Bot) handleEvent(event Event) {
+	switch ev := event.(type) {
+	case *ConnectedEvent:
+		bot.Myself = ev.Myself
 		bot.MentionPrefix = fmt.Sprintf("@%s:", bot.Myself.Name)
 
-		users, _ := bot.Slack.GetUsers()
-		// true argument excludes archived channels/groups:
-		channels, _ := bot.Slack.GetChannels(true)
-		groups, _ := bot.Slack.GetGroups(true)
-		bot.cacheUsers(users)
-		bot.cacheChannels(channels, groups)
-
-	case *slack.MessageEvent:
-		fmt.Printf("Message: %v\n", ev)
-		msg := &Message{
-			Msg:        &ev.Msg,
-			SubMessage: ev.SubMessage,
+		bot.Directory.SetSource(bot.Adapter)
+		bot.Directory.Refresh()
+
+	case *MessageEvent:
+		bot.dispatchMessage(bot.newMessage(&ev.Msg, ev.SubMessage))
+
+	case *MessageEditEvent:
+		msg := bot.newMessage(&ev.Msg, nil)
+		msg.IsEdition = true
+		msg.PreviousText = ev.PreviousText
+		bot.dispatchMessage(msg)
+
+	case *MessageDeleteEvent:
+		msg := bot.newMessage(&ev.Msg, nil)
+		msg.IsDeletion = true
+		msg.PreviousText = ev.Msg.Text
+		msg.Text = ""
+		bot.dispatchMessage(msg)
+
+	case *SlashCommandEvent:
+		msg := bot.newMessage(&slack.Msg{
+			Channel: ev.Command.ChannelID,
+			User:    ev.Command.UserID,
+			Text:    ev.Command.Text,
+		}, nil)
+		msg.MentionsMe = true
+		bot.dispatchMessage(msg)
+
+	case *InteractionEvent:
+		interaction := newInteraction(bot, ev.Callback)
+		if handler := bot.interactionHandler(interaction.CallbackID); handler != nil {
+			if user := bot.Directory.User(interaction.User.ID); user != nil {
+				interaction.User = user
+			}
+			go handler(interaction)
+			return
 		}
 
-		user, ok := bot.Users[ev.Msg.User]
-		if ok {
-			msg.FromUser = &user
-		}
-		channel, ok := bot.Channels[ev.Msg.Channel]
-		if ok {
-			msg.FromChannel = &channel
-		}
+		msg := bot.newMessage(&slack.Msg{
+			Channel:   ev.Callback.Channel.ID,
+			User:      ev.Callback.User.ID,
+			Text:      interactionText(ev.Callback),
+			Timestamp: ev.Callback.MessageTs,
+		}, nil)
+		msg.MentionsMe = true
+		bot.dispatchMessage(msg)
 
-		msg.applyMentionsMe(bot)
-		msg.applyFromMe(bot)
+	case *PresenceChangeEvent:
+		bot.Directory.UpdateUser(ev.UserID, func(user *slack.User) {
+			bot.Logger.Debug("Presence changed", "user", user.Name, "presence", ev.Presence)
+			user.Presence = ev.Presence
+		})
 
-		log.Printf("Incoming message: %s\n", msg)
+	case *UserChangeEvent:
+		bot.Directory.PutUser(ev.User)
 
-		for _, conv := range bot.conversations {
-			filterFunc := defaultFilterFunc
-			if conv.FilterFunc != nil {
-				filterFunc = conv.FilterFunc
-			}
+	case *ChannelChangeEvent:
+		bot.Directory.PutChannel(ev.Channel)
 
-			if filterFunc(conv, msg) {
-				conv.HandlerFunc(conv, msg)
-			}
+	case *ChannelRenameEvent:
+		bot.Directory.UpdateChannel(ev.ChannelID, func(channel *slack.Channel) {
+			channel.Name = ev.Name
+		})
+
+	case *ChannelArchiveEvent:
+		bot.Directory.UpdateChannel(ev.ChannelID, func(channel *slack.Channel) {
+			channel.IsArchived = ev.Archived
+		})
+
+	case *ChannelDeleteEvent:
+		bot.Directory.DeleteChannel(ev.ChannelID)
+
+	case *MemberJoinedEvent:
+		bot.Directory.AddMember(ev.ChannelID, ev.UserID)
+
+	case *MemberLeftEvent:
+		bot.Directory.RemoveMember(ev.ChannelID, ev.UserID)
+
+	default:
+		bot.Logger.Debug("Ignoring unexpected event", "type", fmt.Sprintf("%T", ev))
+	}
+}
+
+func (bot *Bot) newMessage(m *slack.Msg, sub *slack.Msg) *Message {
+	msg := &Message{
+		Msg:        m,
+		SubMessage: sub,
+	}
+
+	msg.FromUser = bot.Directory.User(m.User)
+	msg.FromChannel = bot.Directory.Channel(m.Channel)
+
+	msg.applyMentionsMe(bot)
+	msg.applyFromMe(bot)
+
+	return msg
+}
+
+func (bot *Bot) dispatchMessage(msg *Message) {
+	bot.Logger.Debug("Incoming message", "channel", msg.Channel, "user", msg.User,
+		"ts", msg.Timestamp, "text", Body(msg.Text))
+	bot.metrics.messages.Inc(channelType(msg))
+
+	for _, conv := range bot.conversations {
+		if (msg.IsEdition || msg.IsDeletion) && !conv.MatchEditions {
+			continue
+		}
+		if conv.plugin != nil && !conv.plugin.allowsChannel(msg.FromChannel) {
+			continue
 		}
 
-	case *slack.PresenceChangeEvent:
-		user := bot.Users[ev.User]
-		log.Printf("User %q is now %q\n", user.Name, ev.Presence)
-		user.Presence = ev.Presence
-
-	case slack.LatencyReport:
-		break
-	case *slack.IncomingEventError:
-		fmt.Printf("Error: %s \n", ev.Error())
-
-	// TODO: manage im_open, im_close, and im_created ?
-
-	/**
-	 * User changes
-	 */
-	case *slack.UserChangeEvent:
-		bot.Users[ev.User.ID] = ev.User
-
-	/**
-	 * Handle channel changes
-	 */
-	case *slack.ChannelRenameEvent:
-		channel := bot.Channels[ev.Channel.ID]
-		channel.Name = ev.Channel.Name
-
-	case *slack.ChannelJoinedEvent:
-		bot.Channels[ev.Channel.ID] = ev.Channel
-
-	case *slack.ChannelCreatedEvent:
-		bot.Channels[ev.Channel.ID] = slack.Channel{
-			GroupConversation: slack.GroupConversation{
-				Name:    ev.Channel.Name,
-				Creator: ev.Channel.Creator,
-			},
+		filterFunc := defaultFilterFunc
+		if conv.FilterFunc != nil {
+			filterFunc = conv.FilterFunc
 		}
-		// NICE: poll the API to get a full Channel object ? many
-		// things are missing here
-
-	case *slack.ChannelDeletedEvent:
-		delete(bot.Channels, ev.Channel)
-
-	case *slack.ChannelArchiveEvent:
-		channel := bot.Channels[ev.Channel]
-		channel.IsArchived = true
-
-	case *slack.ChannelUnarchiveEvent:
-		channel := bot.Channels[ev.Channel]
-		channel.IsArchived = false
-
-	/**
-	 * Handle group changes
-	 */
-	case *slack.GroupRenameEvent:
-		group := bot.Channels[ev.Group.Name]
-		group.Name = ev.Group.Name
-
-	case *slack.GroupJoinedEvent:
-		bot.Channels[ev.Channel.ID] = ev.Channel
-
-	case *slack.GroupCreatedEvent:
-		bot.Channels[ev.Channel.ID] = slack.Channel{
-			GroupConversation: slack.GroupConversation{
-				Name:    ev.Channel.Name,
-				Creator: ev.Channel.Creator,
-			},
+
+		if filterFunc(conv, msg) {
+			start := time.Now()
+			conv.HandlerFunc(conv, msg)
+			bot.metrics.handlerDuration.Observe(time.Since(start).Seconds(), conv.pluginName())
 		}
-		// NICE: poll the API to get a full Group object ? many
-		// things are missing here
+	}
+}
 
-	case *slack.GroupCloseEvent:
-		// TODO: when a group is "closed"... does that mean removed ?
-		// TODO: how do we even manage groups ?!?!
-		delete(bot.Channels, ev.Channel)
+// HandleInteraction registers the handler called with the clicks on
+// the buttons and menus attached with `callbackID`.  Clicks on
+// components without a handler are dispatched to Conversations as
+// messages, see `interactionText()`.
+func (bot *Bot) HandleInteraction(callbackID string, handler InteractionHandler) {
+	bot.interactionsLock.Lock()
+	defer bot.interactionsLock.Unlock()
+	bot.interactions[callbackID] = handler
+}
 
-	case *slack.GroupArchiveEvent:
-		group := bot.Channels[ev.Channel]
-		group.IsArchived = true
+// RemoveInteraction unregisters the handler of `callbackID`, once its
+// buttons are not expected to be clicked anymore.
+func (bot *Bot) RemoveInteraction(callbackID string) {
+	bot.interactionsLock.Lock()
+	defer bot.interactionsLock.Unlock()
+	delete(bot.interactions, callbackID)
+}
 
-	case *slack.GroupUnarchiveEvent:
-		group := bot.Channels[ev.Channel]
-		group.IsArchived = false
+func (bot *Bot) interactionHandler(callbackID string) InteractionHandler {
+	bot.interactionsLock.Lock()
+	defer bot.interactionsLock.Unlock()
+	return bot.interactions[callbackID]
+}
 
-	default:
-		fmt.Printf("Unexpected: %v\n", ev)
+// UpdateMessage queues the replacement of the content of a message
+// previously sent by the bot, and returns immediately.  Updates are
+// delivered in order with the replies to the channel, and retried or
+// dead-lettered like them, see `Send()`.
+func (bot *Bot) UpdateMessage(channelID, timestamp string, rich *RichMessage) error {
+	bot.outbox.enqueueUpdate(timestamp, rich.BotReply(channelID))
+	return nil
+}
+
+// interactionText flattens the values picked in an interactive
+// message, so they read like a typed answer ("yes", "no", ...).
+func interactionText(callback slack.InteractionCallback) string {
+	values := make([]string, 0)
+	for _, action := range callback.ActionCallback.AttachmentActions {
+		if action.Value != "" {
+			values = append(values, action.Value)
+		}
+		for _, option := range action.SelectedOptions {
+			values = append(values, option.Value)
+		}
+	}
+	for _, action := range callback.ActionCallback.BlockActions {
+		if action.Value != "" {
+			values = append(values, action.Value)
+		}
+		if action.SelectedOption.Value != "" {
+			values = append(values, action.SelectedOption.Value)
+		}
 	}
+	return strings.Join(values, " ")
 }
 
 // Disconnect, you can call many times, checks closed channel first.
 func (bot *Bot) Disconnect() {
+	bot.disconnectLock.Lock()
+	defer bot.disconnectLock.Unlock()
+
+	if bot.disconnected == nil {
+		return
+	}
 	select {
-	case _, ok := <-bot.disconnected:
-		if ok {
-			close(bot.disconnected)
-		}
+	case <-bot.disconnected:
 	default:
+		close(bot.disconnected)
 	}
 }
 
 // GetUser returns a *slack.User by ID, Name, RealName or Email
 func (bot *Bot) GetUser(find string) *slack.User {
-	for _, user := range bot.Users {
-		//log.Printf("Hmmmm, %#v\n", user)
-		if user.Profile.Email == find || user.ID == find || user.Name == find || user.RealName == find {
-			return &user
-		}
-	}
-	return nil
+	return bot.Directory.FindUser(find)
+}
+
+// ChannelMembers returns the IDs of the users in a channel.
+func (bot *Bot) ChannelMembers(channelID string) []string {
+	return bot.Directory.ChannelMembers(channelID)
+}
+
+// UserChannels returns the IDs of the channels a user is in.
+func (bot *Bot) UserChannels(userID string) []string {
+	return bot.Directory.UserChannels(userID)
 }
 
 // GetChannelByName returns a *slack.Channel by Name
 func (bot *Bot) GetChannelByName(name string) *slack.Channel {
-	name = strings.TrimLeft(name, "#")
-	for _, channel := range bot.Channels {
-		if channel.Name == name {
-			return &channel
-		}
-	}
-	return nil
+	return bot.Directory.ChannelByName(name)
 }
 
 func (bot *Bot) AtMention() string {
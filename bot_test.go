@@ -1 +1,311 @@
 package plotbot
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+type fakeAdapter struct {
+	events   chan Event
+	sent     chan *BotReply
+	updated  chan fakeUpdate
+	users    []slack.User
+	channels []slack.Channel
+	fetches  int
+}
+
+type fakeUpdate struct {
+	channelID string
+	timestamp string
+	reply     *BotReply
+}
+
+func newFakeAdapter() *fakeAdapter {
+	return &fakeAdapter{
+		events:  make(chan Event, 10),
+		sent:    make(chan *BotReply, 10),
+		updated: make(chan fakeUpdate, 10),
+	}
+}
+
+func (a *fakeAdapter) Connect() error {
+	a.events <- &ConnectedEvent{Myself: &slack.UserDetails{ID: "UBOT", Name: "plotbot"}}
+	return nil
+}
+
+func (a *fakeAdapter) Disconnect() {}
+
+func (a *fakeAdapter) Events() <-chan Event {
+	return a.events
+}
+
+func (a *fakeAdapter) Send(reply *BotReply) (string, error) {
+	a.sent <- reply
+	return "1234.5678", nil
+}
+
+func (a *fakeAdapter) Update(channelID, timestamp string, reply *BotReply) error {
+	a.updated <- fakeUpdate{channelID, timestamp, reply}
+	return nil
+}
+
+func (a *fakeAdapter) GetUsers() ([]slack.User, error) {
+	return a.users, nil
+}
+
+func (a *fakeAdapter) GetChannels() ([]slack.Channel, error) {
+	return a.channels, nil
+}
+
+func (a *fakeAdapter) GetUser(id string) (*slack.User, error) {
+	a.fetches++
+	for _, user := range a.users {
+		if user.ID == id {
+			return &user, nil
+		}
+	}
+	return nil, errors.New("user_not_found")
+}
+
+func (a *fakeAdapter) GetChannel(id string) (*slack.Channel, error) {
+	a.fetches++
+	for _, channel := range a.channels {
+		if channel.ID == id {
+			return &channel, nil
+		}
+	}
+	return nil, errors.New("channel_not_found")
+}
+
+func newTestBot(adapter *fakeAdapter) *Bot {
+	bot := New("")
+	bot.Adapter = adapter
+	bot.outbox = newOutbox(bot.sendNow, bot.updateNow, testOutboxPolicy)
+	bot.outbox.instrument(bot.Metrics)
+	if err := bot.connectClient(); err != nil {
+		panic(err)
+	}
+	bot.setupHandlers()
+	return bot
+}
+
+func TestAdapterDispatch(t *testing.T) {
+	adapter := newFakeAdapter()
+	adapter.users = []slack.User{{ID: "U1", Name: "hodor"}}
+	adapter.channels = []slack.Channel{{
+		GroupConversation: slack.GroupConversation{
+			Conversation: slack.Conversation{ID: "C1"},
+			Name:         "general",
+		},
+	}}
+	bot := newTestBot(adapter)
+
+	bot.ListenFor(&Conversation{
+		MentionsMeOnly: true,
+		HandlerFunc: func(conv *Conversation, msg *Message) {
+			if msg.FromUser == nil || msg.FromUser.Name != "hodor" {
+				t.Error("expected message from hodor")
+			}
+			conv.Reply(msg, "hodor!")
+		},
+	})
+	time.Sleep(50 * time.Millisecond)
+
+	adapter.events <- &MessageEvent{Msg: slack.Msg{
+		User:    "U1",
+		Channel: "C1",
+		Text:    "<@UBOT> hello",
+	}}
+
+	select {
+	case reply := <-adapter.sent:
+		if reply.To != "C1" || reply.Text != "hodor!" {
+			t.Errorf("unexpected reply %#v", reply)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no reply sent through the adapter")
+	}
+}
+
+func TestAdapterChannelEvents(t *testing.T) {
+	bot := &Bot{Directory: NewDirectory(nil)}
+	bot.Directory.SetChannels([]slack.Channel{{
+		GroupConversation: slack.GroupConversation{
+			Conversation: slack.Conversation{ID: "C1"},
+			Name:         "general",
+		},
+	}})
+
+	bot.handleEvent(&ChannelRenameEvent{ChannelID: "C1", Name: "random"})
+	bot.handleEvent(&ChannelArchiveEvent{ChannelID: "C1", Archived: true})
+
+	channel := bot.Directory.Channel("C1")
+	if channel.Name != "random" {
+		t.Errorf("expected channel to be renamed, got %q", channel.Name)
+	}
+	if !channel.IsArchived {
+		t.Error("expected channel to be archived")
+	}
+	if bot.GetChannelByName("#random") == nil || bot.GetChannelByName("general") != nil {
+		t.Error("expected the name index to follow the rename")
+	}
+
+	bot.handleEvent(&ChannelDeleteEvent{ChannelID: "C1"})
+	if bot.Directory.Channel("C1") != nil {
+		t.Error("expected channel to be deleted")
+	}
+}
+
+func TestSlashCommandAndInteractionDispatch(t *testing.T) {
+	adapter := newFakeAdapter()
+	adapter.users = []slack.User{{ID: "U1", Name: "hodor"}}
+	bot := newTestBot(adapter)
+
+	bot.ListenFor(&Conversation{
+		MentionsMeOnly: true,
+		HandlerFunc: func(conv *Conversation, msg *Message) {
+			conv.Reply(msg, "got "+msg.Text)
+		},
+	})
+	time.Sleep(50 * time.Millisecond)
+
+	adapter.events <- &SlashCommandEvent{Command: slack.SlashCommand{
+		Command:   "/plotbot",
+		Text:      "deploy to stage",
+		ChannelID: "C1",
+		UserID:    "U1",
+	}}
+
+	callback := slack.InteractionCallback{
+		Channel: slack.Channel{GroupConversation: slack.GroupConversation{
+			Conversation: slack.Conversation{ID: "C1"},
+		}},
+		User: slack.User{ID: "U1"},
+	}
+	callback.ActionCallback.AttachmentActions = []*slack.AttachmentAction{{Name: "confirm", Value: "yes"}}
+	adapter.events <- &InteractionEvent{Callback: callback}
+
+	for _, expected := range []string{"got deploy to stage", "got yes"} {
+		select {
+		case reply := <-adapter.sent:
+			if reply.Text != expected {
+				t.Errorf("expected reply %q, got %q", expected, reply.Text)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expected reply %q", expected)
+		}
+	}
+}
+
+func TestEditionsDispatch(t *testing.T) {
+	bot := &Bot{Myself: &slack.UserDetails{ID: "UBOT"}, Directory: NewDirectory(nil)}
+
+	var plain, editions []*Message
+	bot.conversations = []*Conversation{
+		{HandlerFunc: func(conv *Conversation, msg *Message) {
+			plain = append(plain, msg)
+		}},
+		{MatchEditions: true, HandlerFunc: func(conv *Conversation, msg *Message) {
+			editions = append(editions, msg)
+		}},
+	}
+
+	bot.handleEvent(&MessageEditEvent{
+		Msg:          slack.Msg{Channel: "C1", User: "U1", Text: "new", Timestamp: "1355517523.000005"},
+		PreviousText: "old",
+	})
+	bot.handleEvent(&MessageDeleteEvent{
+		Msg: slack.Msg{Channel: "C1", User: "U1", Text: "new", Timestamp: "1355517523.000005"},
+	})
+
+	if len(plain) != 0 {
+		t.Errorf("expected editions not to be dispatched by default, got %d", len(plain))
+	}
+	if len(editions) != 2 {
+		t.Fatalf("expected 2 editions, got %d", len(editions))
+	}
+
+	edit := editions[0]
+	if !edit.IsEdition || edit.Text != "new" || edit.PreviousText != "old" ||
+		edit.Time().Unix() != 1355517523 {
+		t.Errorf("unexpected edition %#v", edit)
+	}
+
+	deleted := editions[1]
+	if !deleted.IsDeletion || deleted.Text != "" || deleted.PreviousText != "new" {
+		t.Errorf("unexpected deletion %#v", deleted)
+	}
+}
+
+type stopperPlugin struct {
+	stopped chan bool
+}
+
+func (p *stopperPlugin) Stop(ctx context.Context) error {
+	if _, ok := ctx.Deadline(); !ok {
+		return errors.New("expected a deadline")
+	}
+	p.stopped <- true
+	return nil
+}
+
+type stuckPlugin struct{}
+
+func (p *stuckPlugin) Stop(ctx context.Context) error {
+	select {}
+}
+
+func TestShutdown(t *testing.T) {
+	stopper := &stopperPlugin{stopped: make(chan bool, 1)}
+
+	adapter := newFakeAdapter()
+	bot := newTestBot(adapter)
+	bot.plugins = []*enabledPlugin{{name: "stopper", plugin: stopper}, {name: "stuck", plugin: &stuckPlugin{}}}
+	bot.ShutdownTimeout = 200 * time.Millisecond
+
+	db, err := leveldb.Open(storage.NewMemStorage(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bot.DB = db
+
+	bot.Notify("C1", "", "bye")
+	bot.Stop()
+	if bot.Context().Err() == nil {
+		t.Error("expected the root context to be cancelled")
+	}
+
+	start := time.Now()
+	bot.shutdown()
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected stuck plugins to be given up on, took %s", elapsed)
+	}
+
+	select {
+	case <-stopper.stopped:
+	default:
+		t.Error("expected the plugin to be stopped")
+	}
+
+	select {
+	case reply := <-adapter.sent:
+		if reply.Text != "bye" {
+			t.Errorf("unexpected reply %q", reply.Text)
+		}
+	default:
+		t.Error("expected pending replies to be flushed")
+	}
+
+	if _, err := db.Get([]byte("key"), nil); err != leveldb.ErrClosed {
+		t.Errorf("expected the database to be closed, got %v", err)
+	}
+
+	// Disconnecting again is harmless
+	bot.Disconnect()
+}
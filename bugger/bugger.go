@@ -3,7 +3,7 @@ package bugger
 import (
 	"bytes"
 	"fmt"
-	"log"
+	"sync"
 	"time"
 
 	"github.com/plotly/plotbot"
@@ -14,15 +14,32 @@ import (
 const dfltReportLength = 7 // days
 
 func init() {
-	plotbot.RegisterPlugin(&Bugger{})
+	plotbot.RegisterPlugin("bugger", &Bugger{})
 }
 
 type Bugger struct {
 	bot      *plotbot.Bot
+	logger   *plotbot.Logger
+	commands *plotbot.Router
+
+	// lock guards ghclient, swapped by `Reconfigure()` while reports
+	// may be compiled.
+	lock     sync.Mutex
 	ghclient github.Client
 }
 
-func (bugger *Bugger) makeBugReporter(days int, repo string) (reporter bugReporter) {
+// client returns the GitHub client to compile a report with.
+func (bugger *Bugger) client() github.Client {
+	bugger.lock.Lock()
+	defer bugger.lock.Unlock()
+	return bugger.ghclient
+}
+
+func (bugger *Bugger) InitLogger(logger *plotbot.Logger) {
+	bugger.logger = logger
+}
+
+func (bugger *Bugger) makeBugReporter(ghclient github.Client, days int, repo string) (reporter bugReporter) {
 
 	query := github.SearchQuery{
 		Repo:        repo,
@@ -30,9 +47,9 @@ func (bugger *Bugger) makeBugReporter(days int, repo string) (reporter bugReport
 		ClosedSince: time.Now().Add(-time.Duration(days) * (24 * time.Hour)).Format("2006-01-02"),
 	}
 
-	issueList, err := bugger.ghclient.DoSearchQuery(query)
+	issueList, err := ghclient.DoSearchQuery(query)
 	if err != nil {
-		log.Print(err)
+		bugger.logger.Error("Error searching the bugs", "repo", repo, "err", err)
 		return
 	}
 
@@ -40,9 +57,9 @@ func (bugger *Bugger) makeBugReporter(days int, repo string) (reporter bugReport
 	 * Get an array of issues matching Filters
 	 */
 	issueChan := make(chan github.IssueItem, 1)
-	go bugger.ghclient.DoEventQuery(issueList, repo, issueChan)
+	go ghclient.DoEventQuery(issueList, repo, issueChan)
 
-	reporter.Git2Chat = bugger.ghclient.Conf.Github2Chat
+	reporter.Git2Chat = ghclient.Conf.Github2Chat
 	reporter.repo_name = repo
 
 	for issue := range issueChan {
@@ -52,32 +69,21 @@ func (bugger *Bugger) makeBugReporter(days int, repo string) (reporter bugReport
 	return
 }
 
-func (bugger *Bugger) aggregateBugReporter(conv *plotbot.Conversation, msg *plotbot.Message) {
-	if len(bugger.ghclient.Conf.Repos) == 0 {
-		log.Println("No repos configured - can't produce a bug report")
+func (bugger *Bugger) aggregateBugReporter(conv *plotbot.Conversation, msg *plotbot.Message, days int, genReport func(reporter bugReporter) string) {
+	ghclient := bugger.client()
+	if len(ghclient.Conf.Repos) == 0 {
+		bugger.logger.Warn("No repos configured - can't produce a bug report")
 		return
 	}
 
-	if !(msg.Contains("bug report")) && !(msg.Contains("bug count")) {
-		return
-	}
-
-	days := util.GetDaysFromQuery(msg.Text)
 	bugger.messageReport(days, msg, conv, func() string {
 
 		var reportsBuffer bytes.Buffer
 
-		for _, repo := range bugger.ghclient.Conf.Repos {
-
-			reporter := bugger.makeBugReporter(days, repo)
+		for _, repo := range ghclient.Conf.Repos {
 
-			if msg.Contains("bug report") {
-				reportsBuffer.WriteString(reporter.printReport(days))
-			}
-
-			if msg.Contains("bug count") {
-				reportsBuffer.WriteString(reporter.printCount(days))
-			}
+			reporter := bugger.makeBugReporter(ghclient, days, repo)
+			reportsBuffer.WriteString(genReport(reporter))
 
 		}
 
@@ -87,6 +93,51 @@ func (bugger *Bugger) aggregateBugReporter(conv *plotbot.Conversation, msg *plot
 
 }
 
+func (bugger *Bugger) setupCommands() {
+	description := "<period> is [last | past] [n] [days | weeks], 7 days by default"
+	var reportCmd, countCmd *plotbot.Command
+
+	bugger.commands = plotbot.NewRouter()
+	reportCmd = bugger.commands.Add(&plotbot.Command{
+		Usage:       "bug report [<period...>]",
+		Description: description,
+		Examples: []string{
+			"please give me a bug report over the last 5 days",
+			"produce a bug report",
+			"I want a bug report from the past 2 weeks",
+			"bug report from the past week",
+		},
+		HandlerFunc: func(conv *plotbot.Conversation, msg *plotbot.Message, args plotbot.CommandArgs) {
+			bugger.reportCommand(conv, msg, args, reportCmd, (*bugReporter).printReport)
+		},
+	})
+	countCmd = bugger.commands.Add(&plotbot.Command{
+		Usage:       "bug count [<period...>]",
+		Description: description,
+		Examples: []string{
+			"please give me a bug count over the last 5 days",
+			"produce a bug count",
+			"I want a bug count from the past 2 weeks",
+			"bug count from the past week",
+		},
+		HandlerFunc: func(conv *plotbot.Conversation, msg *plotbot.Message, args plotbot.CommandArgs) {
+			bugger.reportCommand(conv, msg, args, countCmd, (*bugReporter).printCount)
+		},
+	})
+}
+
+func (bugger *Bugger) reportCommand(conv *plotbot.Conversation, msg *plotbot.Message, args plotbot.CommandArgs, cmd *plotbot.Command, print func(*bugReporter, int) string) {
+	if msg.ContainsAny([]string{"how", "help"}) {
+		conv.Reply(msg, cmd.Help(conv.Bot.AtMention()))
+		return
+	}
+
+	days := util.GetDaysFromQuery(args.String("period"))
+	bugger.aggregateBugReporter(conv, msg, days, func(reporter bugReporter) string {
+		return print(&reporter, days)
+	})
+}
+
 func (bugger *Bugger) InitPlugin(bot *plotbot.Bot) {
 
 	/*
@@ -94,52 +145,49 @@ func (bugger *Bugger) InitPlugin(bot *plotbot.Bot) {
 	 */
 	bugger.bot = bot
 
-	var conf struct {
-		Github github.Conf
+	var conf github.Conf
+	if err := plotbot.DecodeSection(bot.LoadConfig, "Github", &conf); err != nil {
+		bugger.logger.Fatal("Error loading Github config section", "err", err)
 	}
 
-	bot.LoadConfig(&conf)
-
 	bugger.ghclient = github.Client{
-		Conf: conf.Github,
+		Conf: conf,
 	}
 
+	bugger.setupCommands()
+
 	bot.ListenFor(&plotbot.Conversation{
 		HandlerFunc: bugger.ChatHandler,
 	})
 
 }
 
-func (bugger *Bugger) ChatHandler(conv *plotbot.Conversation, msg *plotbot.Message) {
+// Reconfigure takes the new GitHub token and repos.
+func (bugger *Bugger) Reconfigure(load func(config interface{}) error) error {
+	var conf github.Conf
+	if err := plotbot.DecodeSection(load, "Github", &conf); err != nil {
+		return err
+	}
 
-	if !msg.MentionsMe {
-		return
+	bugger.lock.Lock()
+	bugger.ghclient = github.Client{
+		Conf: conf,
 	}
+	bugger.lock.Unlock()
+	return nil
+}
 
-	if msg.ContainsAny([]string{"bug report", "bug count"}) && msg.ContainsAny([]string{"how", "help"}) {
+func (bugger *Bugger) ConfigSchema() (string, interface{}) {
+	return "Github", &github.Conf{}
+}
 
-		var report string
+func (bugger *Bugger) ChatHandler(conv *plotbot.Conversation, msg *plotbot.Message) {
 
-		if msg.Contains("bug report") {
-			report = "bug report"
-		} else {
-			report = "bug count"
-		}
-		mention := bugger.bot.MentionPrefix
-
-		conv.Reply(msg, fmt.Sprintf(
-			`*Usage:* %s [give me a | insert demand]  <%s>  [from the | syntax filler] [last | past] [n] [days | weeks]
-*Examples:*
-• %s please give me a %s over the last 5 days
-• %s produce a %s   (7 day default)
-• %s I want a %s from the past 2 weeks
-• %s %s from the past week`, mention, report, mention, report, mention, report, mention, report, mention, report))
-
-	} else {
-		bugger.aggregateBugReporter(conv, msg)
+	if !msg.MentionsMe {
+		return
 	}
 
-	return
+	bugger.commands.Handle(conv, msg)
 
 }
 
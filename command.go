@@ -0,0 +1,522 @@
+package plotbot
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Command declares a chat command: its grammar, what it does and
+// the function handling it.  Commands are grouped in a `Router`,
+// which parses incoming messages and replies with usage errors.
+//
+// The `Usage` grammar is a list of space-separated elements:
+//
+//	deploy          a literal word, matched case-insensitively
+//	lock|unlock     alternative literal words
+//	<branch>        a named argument, one word
+//	<days:int>      a named argument that must be a number
+//	<env:prod|stage> a named argument restricted to the listed values
+//	<tags...>       a named argument swallowing the rest of the line
+//	[ ... ]         an optional group of elements
+//
+// Commas are words of their own, so `[, tags: <tags...>]` matches
+// "deploy to prod, tags: umwelt".  The grammar must start with a
+// literal word, which must start the message, after an optional
+// "please": a command named in the middle of a sentence is not one.
+// Commands taking arguments must match the whole message, while plain
+// phrases ("lock deployment") can be followed by anything.
+type Command struct {
+	Usage       string
+	Description string
+	Examples    []string
+	HandlerFunc func(*Conversation, *Message, CommandArgs)
+
+	elements []cmdElement
+}
+
+// CommandArgs holds the named arguments matched by a Command.
+// Optional arguments that were not given are absent.
+type CommandArgs map[string]string
+
+func (args CommandArgs) Has(name string) bool {
+	_, ok := args[name]
+	return ok
+}
+
+func (args CommandArgs) String(name string) string {
+	return args[name]
+}
+
+// Int returns the value of an `<name:int>` argument, or 0 when absent.
+func (args CommandArgs) Int(name string) int {
+	i, _ := strconv.Atoi(args[name])
+	return i
+}
+
+// Router dispatches messages to the first matching Command.
+type Router struct {
+	commands []*Command
+}
+
+func NewRouter() *Router {
+	return &Router{}
+}
+
+// Add registers a Command.  It panics if the `Usage` grammar is
+// invalid, much like `regexp.MustCompile`.
+func (r *Router) Add(cmd *Command) *Command {
+	elements, err := parseCommandUsage(cmd.Usage)
+	if err != nil {
+		panic(fmt.Sprintf("plotbot: invalid command usage %q: %s", cmd.Usage, err))
+	}
+	if cmd.HandlerFunc == nil {
+		panic(fmt.Sprintf("plotbot: command %q has no HandlerFunc", cmd.Usage))
+	}
+	cmd.elements = elements
+	r.commands = append(r.commands, cmd)
+	return cmd
+}
+
+func (r *Router) Commands() []*Command {
+	return r.commands
+}
+
+// Handle parses the message and calls the first Command matching it.
+// When no Command matches, but the message starts with all the literal
+// words leading a Command taking arguments, a usage error is replied:
+// "cancel deploy" is none of the business of "cancel reminder <number>".  Handle returns false
+// when the message was left untouched.
+func (r *Router) Handle(conv *Conversation, msg *Message) bool {
+	tokens := tokenizeCommand(msg.TextWithoutMention())
+
+	var usageCmd *Command
+	var usageErr *cmdFailure
+
+	for _, cmd := range r.commands {
+		args, failure := cmd.match(tokens)
+		if failure == nil {
+			cmd.HandlerFunc(conv, msg, args)
+			return true
+		}
+		if usageCmd == nil && failure.keyword && cmd.hasArguments() {
+			usageCmd = cmd
+			usageErr = failure
+		}
+	}
+
+	if usageCmd == nil {
+		return false
+	}
+
+	conv.Reply(msg, fmt.Sprintf("Sorry, %s.\n%s", usageErr.reason,
+		usageCmd.Help(conv.Bot.AtMention())))
+	return true
+}
+
+// Help returns the full help of the first Command, followed by a
+// summary of the others.
+func (r *Router) Help(mention string) string {
+	if len(r.commands) == 0 {
+		return ""
+	}
+
+	help := r.commands[0].Help(mention)
+	if len(r.commands) > 1 {
+		help += "\n*Other commands:*"
+		for _, cmd := range r.commands[1:] {
+			help += fmt.Sprintf("\n• %s", cmd.Summary(mention))
+		}
+	}
+	return help
+}
+
+// Help returns the usage line, description and examples of the
+// Command, with the allowed values of its restricted arguments.
+func (cmd *Command) Help(mention string) string {
+	help := fmt.Sprintf("*Usage:* %s %s", mention, cmd.displayUsage())
+
+	if cmd.Description != "" {
+		help += "\n" + cmd.Description
+	}
+	for _, el := range cmd.arguments() {
+		if len(el.values) > 0 {
+			help += fmt.Sprintf("\n<%s> is %s", el.name, strings.Join(el.values, " or "))
+		}
+	}
+
+	if len(cmd.Examples) > 0 {
+		help += "\n*Examples:*"
+		for _, example := range cmd.Examples {
+			help += fmt.Sprintf("\n• %s %s", mention, example)
+		}
+	}
+	return help
+}
+
+// Summary returns the usage line and first line of description.
+func (cmd *Command) Summary(mention string) string {
+	summary := fmt.Sprintf("%s %s", mention, cmd.displayUsage())
+	if cmd.Description != "" {
+		summary += " - " + strings.SplitN(cmd.Description, "\n", 2)[0]
+	}
+	return summary
+}
+
+func (cmd *Command) displayUsage() string {
+	return displayCommandElements(cmd.elements)
+}
+
+func (cmd *Command) hasArguments() bool {
+	return len(cmd.arguments()) > 0
+}
+
+func (cmd *Command) arguments() []cmdElement {
+	return collectCommandArguments(cmd.elements, nil)
+}
+
+func (cmd *Command) match(tokens []cmdToken) (CommandArgs, *cmdFailure) {
+	start := 0
+	if len(tokens) > 1 && strings.EqualFold(tokens[0].word, "please") {
+		start = 1
+	}
+	if start == len(tokens) || !cmd.elements[0].matchesLiteral(tokens[start].word) {
+		return nil, &cmdFailure{reason: "no match"}
+	}
+
+	m := &cmdMatcher{tokens: tokens, failure: &cmdFailure{pos: -1}}
+	var args CommandArgs
+	ok := m.matchSeq(cmd.elements, start, CommandArgs{}, func(pos int, a CommandArgs) bool {
+		// Phrases without arguments ("lock deployment") can be
+		// followed by anything, other commands must be complete.
+		if pos != len(tokens) && cmd.hasArguments() {
+			m.fail(pos, fmt.Sprintf("I don't understand %q", tokens[pos].word))
+			return false
+		}
+		args = a
+		return true
+	})
+	if ok {
+		return args, nil
+	}
+
+	m.failure.keyword = cmd.matchesKeywords(tokens[start:])
+	return nil, m.failure
+}
+
+// matchesKeywords reports whether the tokens start with the literal
+// words leading the command, up to its first argument.
+func (cmd *Command) matchesKeywords(tokens []cmdToken) bool {
+	for i, el := range cmd.elements {
+		if el.kind != cmdLiteral {
+			return true
+		}
+		if i == len(tokens) || !el.matchesLiteral(tokens[i].word) {
+			return false
+		}
+	}
+	return true
+}
+
+//
+// Grammar parsing
+//
+
+type cmdElementKind int
+
+const (
+	cmdLiteral cmdElementKind = iota
+	cmdWord
+	cmdInt
+	cmdRest
+	cmdOptional
+)
+
+type cmdElement struct {
+	kind     cmdElementKind
+	literals []string
+	name     string
+	values   []string
+	group    []cmdElement
+}
+
+func (el cmdElement) matchesLiteral(word string) bool {
+	if el.kind != cmdLiteral {
+		return false
+	}
+	word = strings.ToLower(word)
+	for _, literal := range el.literals {
+		if literal == word {
+			return true
+		}
+	}
+	return false
+}
+
+func parseCommandUsage(usage string) ([]cmdElement, error) {
+	words := strings.Fields(strings.NewReplacer("[", " [ ", "]", " ] ", ",", " , ").Replace(usage))
+
+	elements, rest, err := parseCommandElements(words)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) > 0 {
+		return nil, fmt.Errorf("unbalanced %q", rest[0])
+	}
+	if len(elements) == 0 || elements[0].kind != cmdLiteral {
+		return nil, fmt.Errorf("must start with a literal word")
+	}
+	return elements, nil
+}
+
+func parseCommandElements(words []string) ([]cmdElement, []string, error) {
+	elements := make([]cmdElement, 0)
+
+	for len(words) > 0 {
+		word := words[0]
+		words = words[1:]
+
+		switch {
+		case word == "[":
+			group, rest, err := parseCommandElements(words)
+			if err != nil {
+				return nil, nil, err
+			}
+			if len(rest) == 0 || rest[0] != "]" {
+				return nil, nil, fmt.Errorf("missing ]")
+			}
+			if len(group) == 0 {
+				return nil, nil, fmt.Errorf("empty optional group")
+			}
+			words = rest[1:]
+			elements = append(elements, cmdElement{kind: cmdOptional, group: group})
+
+		case word == "]":
+			return elements, append([]string{word}, words...), nil
+
+		case strings.HasPrefix(word, "<"):
+			if !strings.HasSuffix(word, ">") {
+				return nil, nil, fmt.Errorf("unterminated argument %q", word)
+			}
+			el, err := parseCommandArgument(word[1 : len(word)-1])
+			if err != nil {
+				return nil, nil, err
+			}
+			if el.kind == cmdRest && len(words) > 0 && words[0] != "]" {
+				return nil, nil, fmt.Errorf("<%s...> must come last", el.name)
+			}
+			elements = append(elements, el)
+
+		default:
+			elements = append(elements, cmdElement{
+				kind:     cmdLiteral,
+				literals: strings.Split(strings.ToLower(word), "|"),
+			})
+		}
+	}
+
+	return elements, nil, nil
+}
+
+func parseCommandArgument(spec string) (cmdElement, error) {
+	if strings.HasSuffix(spec, "...") {
+		return cmdElement{kind: cmdRest, name: strings.TrimSuffix(spec, "...")}, nil
+	}
+
+	parts := strings.SplitN(spec, ":", 2)
+	el := cmdElement{kind: cmdWord, name: parts[0]}
+	if el.name == "" {
+		return el, fmt.Errorf("unnamed argument")
+	}
+
+	if len(parts) == 2 {
+		if parts[1] == "int" {
+			el.kind = cmdInt
+		} else {
+			el.values = strings.Split(parts[1], "|")
+		}
+	}
+	return el, nil
+}
+
+func displayCommandElements(elements []cmdElement) string {
+	words := make([]string, 0, len(elements))
+	for _, el := range elements {
+		switch el.kind {
+		case cmdLiteral:
+			words = append(words, strings.Join(el.literals, "|"))
+		case cmdRest:
+			words = append(words, fmt.Sprintf("<%s>...", el.name))
+		case cmdOptional:
+			words = append(words, fmt.Sprintf("[%s]", displayCommandElements(el.group)))
+		default:
+			words = append(words, fmt.Sprintf("<%s>", el.name))
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+func collectCommandArguments(elements []cmdElement, out []cmdElement) []cmdElement {
+	for _, el := range elements {
+		switch el.kind {
+		case cmdOptional:
+			out = collectCommandArguments(el.group, out)
+		case cmdWord, cmdInt, cmdRest:
+			out = append(out, el)
+		}
+	}
+	return out
+}
+
+//
+// Message tokenizing and matching
+//
+
+type cmdToken struct {
+	word  string
+	start int
+	text  string
+}
+
+// tokenizeCommand splits the text on spaces, with commas as words of
+// their own.  Trailing question and exclamation marks are dropped.
+func tokenizeCommand(text string) []cmdToken {
+	tokens := make([]cmdToken, 0)
+
+	start := -1
+	flush := func(end int) {
+		if start >= 0 {
+			word := strings.TrimRight(text[start:end], "?!")
+			if word != "" {
+				tokens = append(tokens, cmdToken{word: word, start: start, text: text})
+			}
+			start = -1
+		}
+	}
+
+	for i, r := range text {
+		switch {
+		case r == ' ' || r == '\t' || r == '\n':
+			flush(i)
+		case r == ',':
+			flush(i)
+			tokens = append(tokens, cmdToken{word: ",", start: i, text: text})
+		default:
+			if start < 0 {
+				start = i
+			}
+		}
+	}
+	flush(len(text))
+
+	return tokens
+}
+
+type cmdFailure struct {
+	pos     int
+	reason  string
+	invalid bool
+	keyword bool
+}
+
+type cmdMatcher struct {
+	tokens  []cmdToken
+	failure *cmdFailure
+}
+
+// fail records the failure that went the furthest in the message, as
+// it's the most helpful one to report.
+func (m *cmdMatcher) fail(pos int, reason string) {
+	if !m.failure.invalid && pos >= m.failure.pos {
+		m.failure.pos = pos
+		m.failure.reason = reason
+	}
+}
+
+// invalid records a value rejected by a typed argument.  Those always
+// win over other failures: "deploy to staging" is better explained by
+// the bad environment than by the missing one.
+func (m *cmdMatcher) invalid(pos int, reason string) {
+	if !m.failure.invalid || pos >= m.failure.pos {
+		m.failure.pos = pos
+		m.failure.reason = reason
+		m.failure.invalid = true
+	}
+}
+
+// matchSeq matches the elements from token `pos`, and calls `k` with
+// the position reached.  Optional groups are tried first with, then
+// without their content, backtracking when `k` fails.
+func (m *cmdMatcher) matchSeq(elements []cmdElement, pos int, args CommandArgs, k func(int, CommandArgs) bool) bool {
+	if len(elements) == 0 {
+		return k(pos, args)
+	}
+
+	el := elements[0]
+	rest := elements[1:]
+
+	if el.kind == cmdOptional {
+		withGroup := m.matchSeq(el.group, pos, copyCommandArgs(args), func(p int, a CommandArgs) bool {
+			return m.matchSeq(rest, p, a, k)
+		})
+		return withGroup || m.matchSeq(rest, pos, args, k)
+	}
+
+	if pos >= len(m.tokens) {
+		m.fail(pos, fmt.Sprintf("%s is missing", displayCommandElements([]cmdElement{el})))
+		return false
+	}
+	token := m.tokens[pos]
+
+	switch el.kind {
+	case cmdLiteral:
+		if !el.matchesLiteral(token.word) {
+			m.fail(pos, fmt.Sprintf("I expected %q instead of %q", el.literals[0], token.word))
+			return false
+		}
+		return m.matchSeq(rest, pos+1, args, k)
+
+	case cmdRest:
+		args = copyCommandArgs(args)
+		args[el.name] = strings.TrimSpace(token.text[token.start:])
+		return m.matchSeq(rest, len(m.tokens), args, k)
+
+	case cmdInt:
+		if _, err := strconv.Atoi(token.word); err != nil {
+			m.invalid(pos, fmt.Sprintf("<%s> must be a number, not %q", el.name, token.word))
+			return false
+		}
+
+	default:
+		if token.word == "," {
+			m.fail(pos, fmt.Sprintf("<%s> is missing", el.name))
+			return false
+		}
+		if len(el.values) > 0 && !containsString(el.values, token.word) {
+			m.invalid(pos, fmt.Sprintf("<%s> must be %s, not %q", el.name,
+				strings.Join(el.values, " or "), token.word))
+			return false
+		}
+	}
+
+	args = copyCommandArgs(args)
+	args[el.name] = token.word
+	return m.matchSeq(rest, pos+1, args, k)
+}
+
+func copyCommandArgs(args CommandArgs) CommandArgs {
+	out := make(CommandArgs, len(args))
+	for k, v := range args {
+		out[k] = v
+	}
+	return out
+}
+
+func containsString(list []string, s string) bool {
+	for _, el := range list {
+		if el == s {
+			return true
+		}
+	}
+	return false
+}
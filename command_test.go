@@ -0,0 +1,191 @@
+package plotbot
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/slack-go/slack"
+)
+
+type routerTestBot struct {
+	BotLike
+	replies []string
+}
+
+func (bot *routerTestBot) AtMention() string {
+	return "@plotbot:"
+}
+
+func (bot *routerTestBot) Reply(msg *Message, reply string) {
+	bot.replies = append(bot.replies, reply)
+}
+
+func newTestRouter(matched *CommandArgs) *Router {
+	handler := func(conv *Conversation, msg *Message, args CommandArgs) {
+		*matched = args
+	}
+
+	router := NewRouter()
+	router.Add(&Command{
+		Usage:       "deploy [<branch>] to [<service>] <environment:prod|stage> [, tags|tag|tags:|tag: <tags...>]",
+		Description: "<branch> is a git branch",
+		Examples:    []string{"deploy to prod"},
+		HandlerFunc: handler,
+	})
+	router.Add(&Command{
+		Usage:       "lock deploy|deployment",
+		Description: "prevent deployment until it's unlocked",
+		HandlerFunc: handler,
+	})
+	router.Add(&Command{
+		Usage:       "report [last <days:int> days]",
+		HandlerFunc: handler,
+	})
+	return router
+}
+
+func routeTestMessage(router *Router, text string) (*routerTestBot, bool) {
+	bot := &routerTestBot{}
+	conv := &Conversation{Bot: bot}
+	msg := &Message{Msg: &slack.Msg{Text: text}}
+	return bot, router.Handle(conv, msg)
+}
+
+func TestRouterMatches(t *testing.T) {
+	type El struct {
+		text string
+		args CommandArgs
+	}
+	tests := []El{
+		{"<@U123> deploy to stage", CommandArgs{"environment": "stage"}},
+		{"@plotbot: please deploy master to prod", CommandArgs{"branch": "master", "environment": "prod"}},
+		{"deploy to imageserver prod", CommandArgs{"service": "imageserver", "environment": "prod"}},
+		{"deploy thing to imageserver stage, tags: a, b", CommandArgs{
+			"branch": "thing", "service": "imageserver", "environment": "stage", "tags": "a, b"}},
+		{"please lock deployment now", CommandArgs{}},
+		{"report last 3 days", CommandArgs{"days": "3"}},
+	}
+
+	for _, el := range tests {
+		var matched CommandArgs
+		router := newTestRouter(&matched)
+		bot, handled := routeTestMessage(router, el.text)
+
+		if !handled || matched == nil {
+			t.Errorf("expected %q to match, got replies %v", el.text, bot.replies)
+			continue
+		}
+		if len(matched) != len(el.args) {
+			t.Errorf("%q: expected args %v, got %v", el.text, el.args, matched)
+		}
+		for k, v := range el.args {
+			if matched.String(k) != v {
+				t.Errorf("%q: expected %s=%q, got %q", el.text, k, v, matched.String(k))
+			}
+		}
+	}
+}
+
+func TestRouterUsageErrors(t *testing.T) {
+	type El struct {
+		text   string
+		reason string
+	}
+	tests := []El{
+		{"deploy to staging", `<environment> must be prod or stage, not "staging"`},
+		{"deploy whats up?", `I expected "to" instead of "up"`},
+		{"deploy to", "<environment> is missing"},
+		{"report last few days", `<days> must be a number, not "few"`},
+	}
+
+	for _, el := range tests {
+		var matched CommandArgs
+		router := newTestRouter(&matched)
+		bot, handled := routeTestMessage(router, el.text)
+
+		if !handled || matched != nil {
+			t.Errorf("expected %q to be handled with a usage error", el.text)
+			continue
+		}
+		if len(bot.replies) != 1 {
+			t.Fatalf("%q: expected 1 reply, got %d", el.text, len(bot.replies))
+		}
+		if !strings.Contains(bot.replies[0], el.reason) {
+			t.Errorf("%q: expected reply %q to contain %q", el.text, bot.replies[0], el.reason)
+		}
+		if !strings.Contains(bot.replies[0], "*Usage:*") {
+			t.Errorf("%q: expected reply %q to contain usage", el.text, bot.replies[0])
+		}
+	}
+}
+
+func TestRouterIgnoresOtherMessages(t *testing.T) {
+	var matched CommandArgs
+	router := newTestRouter(&matched)
+
+	for _, text := range []string{
+		"how many users do we have?",
+		"@plotbot: can you deploy to prod?",
+		"the deploy whats up?",
+		"please please deploy to prod",
+		"<@U123> should we lock deployment?",
+		"",
+	} {
+		bot, handled := routeTestMessage(router, text)
+		if handled || len(bot.replies) != 0 || matched != nil {
+			t.Errorf("expected %q to be left untouched, got %v", text, bot.replies)
+		}
+	}
+}
+
+func TestRouterUsageErrorsNeedKeywords(t *testing.T) {
+	var matched CommandArgs
+	router := newTestRouter(&matched)
+	router.Add(&Command{
+		Usage:       "cancel reminder <number:int>",
+		HandlerFunc: func(conv *Conversation, msg *Message, args CommandArgs) {},
+	})
+
+	// Another plugin's "cancel deploy" is left alone.
+	if bot, handled := routeTestMessage(router, "cancel deploy"); handled || len(bot.replies) != 0 {
+		t.Errorf("expected \"cancel deploy\" to be left untouched, got %v", bot.replies)
+	}
+	bot, handled := routeTestMessage(router, "cancel reminder soon")
+	if !handled || len(bot.replies) != 1 || !strings.Contains(bot.replies[0], `<number> must be a number, not "soon"`) {
+		t.Errorf("expected a usage error, got %v", bot.replies)
+	}
+}
+
+func TestRouterHelp(t *testing.T) {
+	var matched CommandArgs
+	help := newTestRouter(&matched).Help("@plotbot:")
+
+	expected := `*Usage:* @plotbot: deploy [<branch>] to [<service>] <environment> [, tags|tag|tags:|tag: <tags>...]
+<branch> is a git branch
+<environment> is prod or stage
+*Examples:*
+• @plotbot: deploy to prod
+*Other commands:*
+• @plotbot: lock deploy|deployment - prevent deployment until it's unlocked
+• @plotbot: report [last <days> days]`
+
+	if help != expected {
+		t.Errorf("expected help:\n%s\ngot:\n%s", expected, help)
+	}
+}
+
+func TestRouterInvalidUsage(t *testing.T) {
+	for _, usage := range []string{"<arg> first", "deploy [to", "deploy <rest...> to"} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("expected %q to panic", usage)
+				}
+			}()
+			NewRouter().Add(&Command{
+				Usage:       usage,
+				HandlerFunc: func(*Conversation, *Message, CommandArgs) {},
+			})
+		}()
+	}
+}
@@ -1,8 +1,15 @@
 package plotbot
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io/ioutil"
 	"os"
+	"regexp"
+	"strings"
+	"time"
 )
 
 type SlackConfig struct {
@@ -13,13 +20,77 @@ type SlackConfig struct {
 	GeneralChannel string `json:"general_channel"`
 	TeamDomain     string `json:"team_domain"`
 	TeamID         string `json:"team_id"`
-	ApiToken       string `json:"api_token"`
+	ApiToken       string `json:"api_token" config:"required"`
 	WebBaseURL     string `json:"web_base_url"`
-	Debug          bool
+	// Debug logs at the debug level, with the texts of the messages,
+	// which are redacted otherwise, and the Slack API calls.
+	Debug bool
+
+	// Mode selects how events are received from Slack: "rtm" (the
+	// default), "events" for the HTTP Events API or "socket" for
+	// Socket Mode.
+	Mode string
+	// AppToken is the app-level token (xapp-...) used to open Socket
+	// Mode connections.
+	AppToken string `json:"app_token"`
+	// SigningSecret verifies requests posted by Slack to the Events
+	// API endpoint.
+	SigningSecret string `json:"signing_secret"`
+	// EventsListen is the address the Events API endpoint listens on,
+	// like ":8080".  EventsPath defaults to "/slack/events".
+	EventsListen string `json:"events_listen"`
+	EventsPath   string `json:"events_path"`
+	// InteractivityPath receives the clicks on buttons and menus in
+	// "events" mode, and defaults to "/slack/interactivity".  Socket
+	// Mode gets them through its connection; in "rtm" mode buttons
+	// can't be used.
+	InteractivityPath string `json:"interactivity_path"`
+	// DirectoryRefresh is the number of minutes between two reloads of
+	// all the users and channels, 60 by default.  Events keep them up
+	// to date in between.
+	DirectoryRefresh int `json:"directory_refresh"`
+	// Admins lists the users, by ID, name or email, allowed to manage
+	// the bot from the chat, like reloading this file.
+	Admins []string
+	// TimeZone, like "America/Montreal", is used for the users whose
+	// Slack profile has none, and for the schedules not naming one.
+	// It defaults to the server's time zone.
+	TimeZone string `json:"time_zone"`
+}
+
+// Validate checks that the settings needed by the selected mode are
+// set.
+func (c *SlackConfig) Validate() error {
+	switch c.Mode {
+	case "", "rtm":
+	case "events":
+		if c.SigningSecret == "" || c.EventsListen == "" {
+			return errors.New("signing_secret and events_listen are required in \"events\" mode")
+		}
+	case "socket":
+		if c.AppToken == "" {
+			return errors.New("app_token is required in \"socket\" mode")
+		}
+	default:
+		return fmt.Errorf("unknown mode %q, expected \"rtm\", \"events\" or \"socket\"", c.Mode)
+	}
+	if c.TimeZone != "" {
+		if _, err := LoadLocation(c.TimeZone); err != nil {
+			return fmt.Errorf("time_zone: %s", err)
+		}
+	}
+	return nil
+}
+
+func (c SlackConfig) directoryRefreshInterval() time.Duration {
+	if c.DirectoryRefresh <= 0 {
+		return time.Hour
+	}
+	return time.Duration(c.DirectoryRefresh) * time.Minute
 }
 
 type LevelDBConfig struct {
-	Path string
+	Path string `config:"required"`
 }
 
 type ChatPluginConfig struct {
@@ -40,3 +111,79 @@ func checkPermission(file string) error {
 	}
 	return nil
 }
+
+// envReference matches the `${env:NAME}` references to environment
+// variables in configuration strings.
+var envReference = regexp.MustCompile(`\$\{env:([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// resolveConfig replaces the references to secrets in the
+// configuration file, so tokens don't have to be written in it:
+//
+//	"api_token": "${env:SLACK_TOKEN}"
+//	"authtoken": {"file": "/run/secrets/github_token"}
+//
+// `${env:NAME}` can be part of a longer string, and is replaced by the
+// value of the environment variable.  An object holding only a `file`
+// key is replaced by the content of the file, without its trailing
+// newline.  Referencing an unset variable or an unreadable file is an
+// error.
+func resolveConfig(content []byte) ([]byte, error) {
+	var config interface{}
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.UseNumber()
+	if err := decoder.Decode(&config); err != nil {
+		return nil, err
+	}
+
+	resolved, err := resolveConfigValue(config)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(resolved)
+}
+
+func resolveConfigValue(value interface{}) (interface{}, error) {
+	switch value := value.(type) {
+	case string:
+		return resolveEnvReferences(value)
+
+	case []interface{}:
+		for i, element := range value {
+			resolved, err := resolveConfigValue(element)
+			if err != nil {
+				return nil, err
+			}
+			value[i] = resolved
+		}
+
+	case map[string]interface{}:
+		if path, ok := value["file"].(string); ok && len(value) == 1 {
+			secret, err := ioutil.ReadFile(path)
+			if err != nil {
+				return nil, fmt.Errorf("reading secret: %s", err)
+			}
+			return strings.TrimRight(string(secret), "\r\n"), nil
+		}
+		for key, element := range value {
+			resolved, err := resolveConfigValue(element)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", key, err)
+			}
+			value[key] = resolved
+		}
+	}
+	return value, nil
+}
+
+func resolveEnvReferences(value string) (string, error) {
+	var err error
+	resolved := envReference.ReplaceAllStringFunc(value, func(reference string) string {
+		name := envReference.FindStringSubmatch(reference)[1]
+		env, ok := os.LookupEnv(name)
+		if !ok && err == nil {
+			err = fmt.Errorf("environment variable %s is not set", name)
+		}
+		return env
+	})
+	return resolved, err
+}
@@ -0,0 +1,57 @@
+package plotbot
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolveConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "plotbot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	secret := filepath.Join(dir, "github_token")
+	if err := ioutil.WriteFile(secret, []byte("gh-secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("PLOTBOT_TEST_TOKEN", "xoxb-secret")
+	defer os.Unsetenv("PLOTBOT_TEST_TOKEN")
+
+	path := filepath.Join(dir, "plotbot.conf")
+	writeConfig(t, path, `{
+		"Slack": {"api_token": "${env:PLOTBOT_TEST_TOKEN}", "directory_refresh": 10},
+		"Github": {"authtoken": {"file": "`+secret+`"}, "repos": ["plotly/${env:PLOTBOT_TEST_TOKEN}"]}
+	}`)
+
+	var conf struct {
+		Slack  SlackConfig
+		Github struct {
+			Authtoken string
+			Repos     []string
+		}
+	}
+	if err := New(path).LoadConfig(&conf); err != nil {
+		t.Fatal(err)
+	}
+	if conf.Slack.ApiToken != "xoxb-secret" || conf.Slack.DirectoryRefresh != 10 {
+		t.Errorf("unexpected Slack config %#v", conf.Slack)
+	}
+	if conf.Github.Authtoken != "gh-secret" {
+		t.Errorf("expected the secret file content, got %q", conf.Github.Authtoken)
+	}
+	if len(conf.Github.Repos) != 1 || conf.Github.Repos[0] != "plotly/xoxb-secret" {
+		t.Errorf("expected references within strings to be resolved, got %v", conf.Github.Repos)
+	}
+
+	for _, invalid := range []string{
+		`{"Slack": {"api_token": "${env:PLOTBOT_TEST_UNSET}"}}`,
+		`{"Slack": {"api_token": {"file": "` + filepath.Join(dir, "missing") + `"}}}`,
+	} {
+		if _, err := resolveConfig([]byte(invalid)); err == nil {
+			t.Errorf("expected %s to be rejected", invalid)
+		}
+	}
+}
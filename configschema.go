@@ -0,0 +1,264 @@
+package plotbot
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// PluginConfigSchema is implemented by plugins declaring their section
+// of the configuration file, so it can be checked with `plotbot
+// -check-config` before the bot is started.  `ConfigSchema()` returns
+// the name of the section, and a pointer to a new struct to decode it
+// into.
+//
+// The fields of the struct, and of the structs it holds, can be tagged
+// `config:"required"` to be refused when missing or zero, and
+// `default:"value"` to get a value when missing or zero.  Defaults are
+// written like JSON values, except strings which don't need quotes.  A
+// struct implementing ConfigValidator is also checked with its
+// `Validate()` method.
+type PluginConfigSchema interface {
+	ConfigSchema() (section string, config interface{})
+}
+
+// ConfigValidator is implemented by configuration structs that need
+// checks beyond required fields, see PluginConfigSchema.
+type ConfigValidator interface {
+	Validate() error
+}
+
+// ConfigErrors lists the problems found in a configuration file.
+type ConfigErrors []error
+
+func (errs ConfigErrors) Error() string {
+	messages := make([]string, 0, len(errs))
+	for _, err := range errs {
+		messages = append(messages, err.Error())
+	}
+	return strings.Join(messages, "\n")
+}
+
+// DecodeSection decodes a section of the configuration loaded by
+// `load`, usually `bot.LoadConfig`, into `config`.  Defaults are
+// applied and required fields checked as described in
+// PluginConfigSchema, and all the problems found are returned as
+// ConfigErrors.  A missing section is decoded as an empty one.
+func DecodeSection(load func(config interface{}) error, section string, config interface{}) error {
+	var sections map[string]json.RawMessage
+	if err := load(&sections); err != nil {
+		return err
+	}
+
+	// Match section names case-insensitively, like encoding/json does
+	// with the fields of structs.
+	raw, found := sections[section]
+	if !found {
+		for name, value := range sections {
+			if strings.EqualFold(name, section) {
+				raw, found = value, true
+				break
+			}
+		}
+	}
+
+	if found {
+		if err := json.Unmarshal(raw, config); err != nil {
+			return ConfigErrors{fmt.Errorf("%s: %s", section, describeJSONError(raw, err))}
+		}
+	}
+
+	errs := ConfigErrors{}
+	applySchema(section, reflect.ValueOf(config), &errs)
+	if len(errs) != 0 {
+		return errs
+	}
+	return nil
+}
+
+// CheckConfig validates the configuration file without connecting to
+// Slack: the base sections, the names of the enabled plugins, the
+// commands of the external ones, and the sections of those implementing
+// PluginConfigSchema.  It returns all the problems found.
+func (bot *Bot) CheckConfig() ConfigErrors {
+	errs := ConfigErrors{}
+	if err := checkPermission(bot.configFile); err != nil {
+		errs = append(errs, err)
+	}
+
+	content, err := bot.readConfig()
+	if err != nil {
+		return append(errs, err)
+	}
+	return append(errs, bot.checkConfig(content)...)
+}
+
+// readConfig reads the configuration file, and returns it with the
+// references to other files and the environment resolved.
+func (bot *Bot) readConfig() ([]byte, error) {
+	content, err := ioutil.ReadFile(bot.configFile)
+	if err != nil {
+		return nil, err
+	}
+	var syntax interface{}
+	if err := json.Unmarshal(content, &syntax); err != nil {
+		return nil, fmt.Errorf("%s: %s", bot.configFile, describeJSONError(content, err))
+	}
+	return resolveConfig(content)
+}
+
+// checkConfig checks the sections of the resolved configuration, see
+// `CheckConfig()`.  Once the bot runs, the sections of its plugins
+// implementing both PluginReconfigurer and PluginConfigSchema are
+// checked too, so `ReloadConfig()` can reject them up front.
+func (bot *Bot) checkConfig(content []byte) ConfigErrors {
+	errs := ConfigErrors{}
+	load := func(config interface{}) error {
+		return json.Unmarshal(content, config)
+	}
+	checked := make(map[string]bool)
+	check := func(section string, config interface{}) {
+		checked[strings.ToLower(section)] = true
+		if err := DecodeSection(load, section, config); err != nil {
+			if sectionErrs, ok := err.(ConfigErrors); ok {
+				errs = append(errs, sectionErrs...)
+			} else {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	check("Slack", &SlackConfig{})
+	check("LevelDB", &LevelDBConfig{})
+	check("Metrics", &MetricsConfig{})
+	check("Log", &LogConfig{})
+	check("Audit", &AuditConfig{})
+
+	var pluginsConfig PluginsConfig
+	check("Plugins", &pluginsConfig)
+	plugins, unknown := enablePlugins(pluginsConfig)
+	errs = append(errs, unknown...)
+	for _, enabled := range plugins {
+		if enabled.settings.Command != "" {
+			if _, err := exec.LookPath(enabled.settings.Command); err != nil {
+				errs = append(errs, fmt.Errorf("Plugins.%s.command: %s", enabled.name, err))
+			}
+		}
+		if schema, ok := enabled.plugin.(PluginConfigSchema); ok {
+			check(schema.ConfigSchema())
+		}
+	}
+
+	for _, running := range bot.plugins {
+		schema, ok := running.plugin.(PluginConfigSchema)
+		if _, reconfigurer := running.plugin.(PluginReconfigurer); !ok || !reconfigurer {
+			continue
+		}
+		if section, config := schema.ConfigSchema(); !checked[strings.ToLower(section)] {
+			check(section, config)
+		}
+	}
+	return errs
+}
+
+// applySchema walks a decoded configuration, applying defaults and
+// collecting the missing required fields and invalid structs.
+func applySchema(path string, value reflect.Value, errs *ConfigErrors) {
+	switch value.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if !value.IsNil() {
+			applySchema(path, value.Elem(), errs)
+		}
+
+	case reflect.Struct:
+		valueType := value.Type()
+		for i := 0; i < valueType.NumField(); i++ {
+			field := valueType.Field(i)
+			if field.PkgPath != "" {
+				continue
+			}
+			fieldPath := path + "." + configFieldName(field)
+			fieldValue := value.Field(i)
+
+			if def, ok := field.Tag.Lookup("default"); ok && fieldValue.IsZero() {
+				if err := setDefault(fieldValue, def); err != nil {
+					*errs = append(*errs, fmt.Errorf("%s: invalid default %q: %s", fieldPath, def, err))
+				}
+			}
+			if field.Tag.Get("config") == "required" && fieldValue.IsZero() {
+				*errs = append(*errs, fmt.Errorf("%s is required", fieldPath))
+				continue
+			}
+			applySchema(fieldPath, fieldValue, errs)
+		}
+
+		if value.CanAddr() {
+			if validator, ok := value.Addr().Interface().(ConfigValidator); ok {
+				if err := validator.Validate(); err != nil {
+					*errs = append(*errs, fmt.Errorf("%s: %s", path, err))
+				}
+			}
+		}
+
+	case reflect.Map:
+		keys := value.MapKeys()
+		sort.Slice(keys, func(i, j int) bool {
+			return fmt.Sprint(keys[i].Interface()) < fmt.Sprint(keys[j].Interface())
+		})
+		for _, key := range keys {
+			// Map elements are not addressable, so defaults are
+			// applied to a copy which is stored back.
+			element := reflect.New(value.Type().Elem()).Elem()
+			element.Set(value.MapIndex(key))
+			applySchema(fmt.Sprintf("%s.%v", path, key.Interface()), element, errs)
+			value.SetMapIndex(key, element)
+		}
+
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < value.Len(); i++ {
+			applySchema(fmt.Sprintf("%s[%d]", path, i), value.Index(i), errs)
+		}
+	}
+}
+
+// configFieldName returns the name of a field in the JSON file.
+func configFieldName(field reflect.StructField) string {
+	if name := strings.Split(field.Tag.Get("json"), ",")[0]; name != "" && name != "-" {
+		return name
+	}
+	return field.Name
+}
+
+func setDefault(value reflect.Value, def string) error {
+	if value.Kind() == reflect.String {
+		value.SetString(def)
+		return nil
+	}
+	return json.Unmarshal([]byte(def), value.Addr().Interface())
+}
+
+// describeJSONError locates decoding errors in the decoded text.
+func describeJSONError(content []byte, err error) string {
+	var offset int64
+	switch err := err.(type) {
+	case *json.SyntaxError:
+		offset = err.Offset
+	case *json.UnmarshalTypeError:
+		if err.Field != "" {
+			return fmt.Sprintf("%s: expected %s, got %s", err.Field, err.Type, err.Value)
+		}
+		offset = err.Offset
+	default:
+		return err.Error()
+	}
+
+	before := content[:offset]
+	line := bytes.Count(before, []byte("\n")) + 1
+	column := int(offset) - bytes.LastIndexByte(before, '\n') - 1
+	return fmt.Sprintf("line %d, column %d: %s", line, column, err)
+}
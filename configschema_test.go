@@ -0,0 +1,125 @@
+package plotbot
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type testServiceConfig struct {
+	Path   string `json:"path" config:"required"`
+	Branch string `json:"branch" default:"master"`
+}
+
+type testPluginConfig struct {
+	Room     string `json:"room" config:"required"`
+	Interval int    `json:"interval" default:"60"`
+	Tags     []string
+	Services map[string]testServiceConfig `json:"services"`
+}
+
+func (c *testPluginConfig) Validate() error {
+	if c.Interval < 10 {
+		return errors.New("interval must be at least 10")
+	}
+	return nil
+}
+
+type schemaPlugin struct{}
+
+func (p *schemaPlugin) ConfigSchema() (string, interface{}) {
+	return "Fake", &testPluginConfig{}
+}
+
+func loader(content string) func(interface{}) error {
+	return func(config interface{}) error {
+		return json.Unmarshal([]byte(content), config)
+	}
+}
+
+func TestDecodeSection(t *testing.T) {
+	var conf testPluginConfig
+	err := DecodeSection(loader(`{"fake": {
+		"room": "#ops",
+		"services": {"plotbot": {"path": "/plotbot"}, "streambed": {"path": "/streambed", "branch": "prod"}}
+	}}`), "Fake", &conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.Room != "#ops" || conf.Interval != 60 {
+		t.Errorf("unexpected config %#v", conf)
+	}
+	if conf.Services["plotbot"].Branch != "master" || conf.Services["streambed"].Branch != "prod" {
+		t.Errorf("expected defaults in map elements, got %#v", conf.Services)
+	}
+
+	err = DecodeSection(loader(`{"Fake": {"interval": 5, "services": {"plotbot": {}}}}`), "Fake", &testPluginConfig{})
+	errs, ok := err.(ConfigErrors)
+	if !ok || len(errs) != 3 {
+		t.Fatalf("expected 3 errors, got %v", err)
+	}
+	for i, expected := range []string{
+		"Fake.room is required",
+		"Fake.services.plotbot.path is required",
+		"Fake: interval must be at least 10",
+	} {
+		if errs[i].Error() != expected {
+			t.Errorf("expected %q, got %q", expected, errs[i])
+		}
+	}
+
+	err = DecodeSection(loader(`{"Fake": {"room": 42}}`), "Fake", &testPluginConfig{})
+	if err == nil || !strings.Contains(err.Error(), "room: expected string, got number") {
+		t.Errorf("expected a type error, got %v", err)
+	}
+}
+
+func TestCheckConfig(t *testing.T) {
+	plugins := registeredPlugins
+	defer func() { registeredPlugins = plugins }()
+	registeredPlugins = []registeredPlugin{{"fake", &schemaPlugin{}}}
+
+	dir, err := ioutil.TempDir("", "plotbot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "plotbot.conf")
+	bot := New(path)
+
+	writeConfig(t, path, `{"Slack": {"api_token": "xoxb-1"}, "LevelDB": {"path": "/tmp/db"}, "Fake": {"room": "#ops"}}`)
+	if errs := bot.CheckConfig(); len(errs) != 0 {
+		t.Errorf("expected a valid config, got %v", errs)
+	}
+
+	writeConfig(t, path, `{"Slack": {"mode": "socket"}, "Fake": {}}`)
+	errs := bot.CheckConfig()
+	expected := "Slack.api_token is required\n" +
+		"Slack: app_token is required in \"socket\" mode\n" +
+		"LevelDB.Path is required\n" +
+		"Fake.room is required"
+	if errs.Error() != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, errs)
+	}
+
+	writeConfig(t, path, "{\n  \"Slack\": {\n    \"api_token\": \"xoxb-1\"\n    \"mode\": \"rtm\"\n  }\n}")
+	errs = bot.CheckConfig()
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "line 4, column 5") {
+		t.Errorf("expected the syntax error to be located, got %v", errs)
+	}
+}
+
+func TestSampleConfig(t *testing.T) {
+	content, err := ioutil.ReadFile("plotbot.sample.conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sample interface{}
+	if err := json.Unmarshal(content, &sample); err != nil {
+		t.Fatalf("the sample config is invalid: %s", describeJSONError(content, err))
+	}
+}
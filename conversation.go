@@ -2,7 +2,6 @@ package plotbot
 
 import (
 	"fmt"
-	"log"
 	"time"
 
 	"github.com/slack-go/slack"
@@ -26,6 +25,11 @@ type Conversation struct {
 	// `Room`. This can be mixed and matched with `WithUser`
 	InChannel *slack.Channel
 
+	// InThread filters out messages that are not replies in the
+	// thread started by the message with that timestamp.  See
+	// `Message.ThreadTimestamp`.
+	InThread string
+
 	// PrivateOnly filters out public messages.
 	PrivateOnly bool
 	// PublicOnly filters out private messages.  Mutually exclusive
@@ -49,6 +53,11 @@ type Conversation struct {
 	// himself sent.
 	MatchMyMessages bool
 
+	// MatchEditions also dispatches edited and deleted messages to
+	// the Conversation.  See `Message.IsEdition` and
+	// `Message.IsDeletion`.
+	MatchEditions bool
+
 	// FilterFunc is run with each message to verify whether to call
 	// `HandlerFunc` with the message.  See `defaultFilterFunc`
 	FilterFunc func(*Conversation, *Message) bool
@@ -67,10 +76,23 @@ type Conversation struct {
 	// Conversation.
 	Bot BotLike
 
+	// plugin is the plugin which opened the Conversation, whose
+	// channel settings apply.
+	plugin *enabledPlugin
+
 	resetCh chan bool
 	doneCh  chan bool
 }
 
+// pluginName returns the name of the plugin which opened the
+// Conversation, "plotbot" for the bot's own.
+func (conv *Conversation) pluginName() string {
+	if conv.plugin == nil {
+		return "plotbot"
+	}
+	return conv.plugin.name
+}
+
 func (conv *Conversation) Reply(msg *Message, reply string) {
 	conv.Bot.Reply(msg, reply)
 }
@@ -83,6 +105,14 @@ func (conv *Conversation) ReplyPrivately(msg *Message, reply string) {
 	conv.Bot.ReplyPrivately(msg, reply)
 }
 
+func (conv *Conversation) ReplyRich(msg *Message, rich *RichMessage) {
+	conv.Bot.ReplyRich(msg, rich)
+}
+
+func (conv *Conversation) ReplyInThread(msg *Message, reply string) {
+	conv.Bot.ReplyInThread(msg, reply)
+}
+
 // Close terminates the Conversation management goroutine, and stops
 // any further listening and message handling
 func (conv *Conversation) Close() {
@@ -96,7 +126,7 @@ func (conv *Conversation) Close() {
 func (conv *Conversation) ResetDuration() error {
 	if int64(conv.ListenDuration) == 0 {
 		msg := "Conversation has no ListenDuration"
-		log.Println("ResetDuration() error: ", msg)
+		defaultLogger.Error(msg, "plugin", conv.pluginName())
 		return fmt.Errorf(msg)
 	}
 
@@ -191,6 +221,10 @@ func defaultFilterFunc(conv *Conversation, msg *Message) bool {
 		return false
 	}
 
+	if conv.InThread != "" && msg.ThreadTimestamp != conv.InThread {
+		return false
+	}
+
 	if conv.InChannel != nil {
 		if msg.FromChannel == nil {
 			return false
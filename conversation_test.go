@@ -57,3 +57,27 @@ func TestDefeaultFilter(t *testing.T) {
 		}
 	}
 }
+
+func TestThreadFilter(t *testing.T) {
+	c := &Conversation{InThread: "1355517523.000005"}
+
+	inThread := &Message{Msg: &slack.Msg{
+		Text:            "yes",
+		Timestamp:       "1355517530.000007",
+		ThreadTimestamp: "1355517523.000005",
+	}}
+	if !defaultFilterFunc(c, inThread) {
+		t.Error("expected message in the thread to match")
+	}
+
+	otherThread := &Message{Msg: &slack.Msg{
+		Text:            "yes",
+		ThreadTimestamp: "1355517000.000001",
+	}}
+	topLevel := &Message{Msg: &slack.Msg{Text: "yes"}}
+	for _, m := range []*Message{otherThread, topLevel} {
+		if defaultFilterFunc(c, m) {
+			t.Errorf("expected message %q outside the thread not to match", m.ThreadTimestamp)
+		}
+	}
+}
@@ -1,7 +1,18 @@
 package plotbot
 
-import "time"
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
 
+// NextWeekdayTime returns the next time it is `hour:min` on weekday
+// `w`, in UTC, and how long until then.
+//
+// Deprecated: use a Scheduler job, which handles time zones and
+// survives restarts.
 func NextWeekdayTime(w time.Weekday, hour, min int) (time.Time, time.Duration) {
 	t := time.Now().UTC()
 	nowWeekday := t.Weekday()
@@ -16,7 +27,168 @@ func NextWeekdayTime(w time.Weekday, hour, min int) (time.Time, time.Duration) {
 	return res, res.Sub(t)
 }
 
+// AfterNextWeekdayTime waits for NextWeekdayTime.
+//
+// Deprecated: use a Scheduler job.
 func AfterNextWeekdayTime(w time.Weekday, hour, min int) <-chan time.Time {
 	_, duration := NextWeekdayTime(w, hour, min)
 	return time.After(duration)
 }
+
+// defaultWhenHour is the time of day of dates given without one, like
+// "tomorrow" or "next monday".
+const defaultWhenHour = 9
+
+var (
+	reWhenIn     = regexp.MustCompile(`^in\s+(\d+|an?)\s*(m|mins?|minutes?|h|hrs?|hours?|d|days?|w|weeks?)$`)
+	reWhenDay    = regexp.MustCompile(`^(today|tomorrow|(next\s+|on\s+)?(sunday|monday|tuesday|wednesday|thursday|friday|saturday))(\s+at\s+(.+))?$`)
+	reWhenTime   = regexp.MustCompile(`^at\s+(.+)$`)
+	whenWeekdays = map[string]time.Weekday{
+		"sunday": time.Sunday, "monday": time.Monday, "tuesday": time.Tuesday,
+		"wednesday": time.Wednesday, "thursday": time.Thursday,
+		"friday": time.Friday, "saturday": time.Saturday,
+	}
+)
+
+// ParseWhen parses a date relative to `now`, in the location of `now`:
+//
+//	in 20 minutes, in an hour, in 2h, in 1h30m, in 3 days
+//	at 4pm, at 16:30          today, or tomorrow once past
+//	tomorrow [at 10am]
+//	today at 5pm
+//	[next|on] monday [at 9:30]
+//
+// Days given without a time are at 9am.  "next monday" is never today,
+// while "monday" or "on monday" is today if the time is still to come.
+func ParseWhen(text string, now time.Time) (time.Time, error) {
+	text = strings.ToLower(strings.Join(strings.Fields(text), " "))
+
+	if match := reWhenIn.FindStringSubmatch(text); match != nil {
+		count := 1
+		if match[1] != "a" && match[1] != "an" {
+			count, _ = strconv.Atoi(match[1])
+		}
+		if count <= 0 {
+			return time.Time{}, fmt.Errorf("invalid delay in %q", text)
+		}
+		switch match[2][0] {
+		case 'm':
+			return now.Add(time.Duration(count) * time.Minute), nil
+		case 'h':
+			return now.Add(time.Duration(count) * time.Hour), nil
+		case 'd':
+			return now.AddDate(0, 0, count), nil
+		default:
+			return now.AddDate(0, 0, 7*count), nil
+		}
+	}
+	if strings.HasPrefix(text, "in ") {
+		if delay, err := time.ParseDuration(strings.TrimPrefix(text, "in ")); err == nil && delay > 0 {
+			return now.Add(delay), nil
+		}
+	}
+
+	if match := reWhenTime.FindStringSubmatch(text); match != nil {
+		when, err := whenOn(now, 0, match[1])
+		if err == nil && !when.After(now) {
+			when, err = whenOn(now, 1, match[1])
+		}
+		return when, err
+	}
+
+	match := reWhenDay.FindStringSubmatch(text)
+	if match == nil {
+		return time.Time{}, fmt.Errorf("could not understand when %q is", text)
+	}
+	switch match[1] {
+	case "today":
+		if match[5] == "" {
+			return time.Time{}, fmt.Errorf("today needs a time, like \"today at 5pm\"")
+		}
+		return whenOn(now, 0, match[5])
+	case "tomorrow":
+		return whenOn(now, 1, match[5])
+	}
+
+	days := (int(whenWeekdays[match[3]]) - int(now.Weekday()) + 7) % 7
+	if days == 0 && strings.HasPrefix(match[1], "next") {
+		days = 7
+	}
+	when, err := whenOn(now, days, match[5])
+	if err == nil && !when.After(now) {
+		when, err = whenOn(now, days+7, match[5])
+	}
+	return when, err
+}
+
+// whenOn returns the time `clock` of the day `days` after `now`, or
+// defaultWhenHour when `clock` is empty.
+func whenOn(now time.Time, days int, clock string) (time.Time, error) {
+	minute, hour := 0, defaultWhenHour
+	if clock != "" {
+		var err error
+		if minute, hour, err = parseNaturalTime(clock); err != nil {
+			return time.Time{}, err
+		}
+	}
+	year, month, day := now.Date()
+	return time.Date(year, month, day+days, hour, minute, 0, 0, now.Location()), nil
+}
+
+var (
+	reSinceAgo = regexp.MustCompile(`^(\d+|an?)\s*(m|mins?|minutes?|h|hrs?|hours?|d|days?|w|weeks?)\s+ago$`)
+	reSinceDay = regexp.MustCompile(`^(last\s+)?(sunday|monday|tuesday|wednesday|thursday|friday|saturday)$`)
+)
+
+// ParseSince parses the start of a period in the past, relative to
+// `now`, in the location of `now`:
+//
+//	today, yesterday, last week
+//	[last] monday             the latest monday, today included unless "last"
+//	20 minutes ago, an hour ago, 3 days ago, 2 weeks ago
+//	2020-05-01
+//
+// Days start at midnight.
+func ParseSince(text string, now time.Time) (time.Time, error) {
+	text = strings.ToLower(strings.Join(strings.Fields(text), " "))
+	today := StartOfDay(now)
+
+	switch text {
+	case "today":
+		return today, nil
+	case "yesterday":
+		return today.AddDate(0, 0, -1), nil
+	case "last week":
+		return today.AddDate(0, 0, -7), nil
+	}
+
+	if match := reSinceAgo.FindStringSubmatch(text); match != nil {
+		count := 1
+		if match[1] != "a" && match[1] != "an" {
+			count, _ = strconv.Atoi(match[1])
+		}
+		switch match[2][0] {
+		case 'm':
+			return now.Add(-time.Duration(count) * time.Minute), nil
+		case 'h':
+			return now.Add(-time.Duration(count) * time.Hour), nil
+		case 'd':
+			return now.AddDate(0, 0, -count), nil
+		default:
+			return now.AddDate(0, 0, -7*count), nil
+		}
+	}
+
+	if match := reSinceDay.FindStringSubmatch(text); match != nil {
+		days := (int(now.Weekday()) - int(whenWeekdays[match[2]]) + 7) % 7
+		if days == 0 && match[1] != "" {
+			days = 7
+		}
+		return today.AddDate(0, 0, -days), nil
+	}
+
+	if date, err := time.ParseInLocation("2006-01-02", text, now.Location()); err == nil {
+		return date, nil
+	}
+	return time.Time{}, fmt.Errorf("could not understand when %q is", text)
+}
@@ -0,0 +1,86 @@
+package plotbot
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseWhen(t *testing.T) {
+	montreal, _ := time.LoadLocation("America/Montreal")
+	// A Friday
+	now := time.Date(2020, 5, 1, 10, 17, 0, 0, montreal)
+
+	for _, test := range []struct {
+		text     string
+		expected time.Time
+	}{
+		{"in 20 minutes", now.Add(20 * time.Minute)},
+		{"in an hour", now.Add(time.Hour)},
+		{"in 2h", now.Add(2 * time.Hour)},
+		{"in 1h30m", now.Add(90 * time.Minute)},
+		{"in 3 days", time.Date(2020, 5, 4, 10, 17, 0, 0, montreal)},
+		{"in a week", time.Date(2020, 5, 8, 10, 17, 0, 0, montreal)},
+		{"at 4pm", time.Date(2020, 5, 1, 16, 0, 0, 0, montreal)},
+		{"at 9:30", time.Date(2020, 5, 2, 9, 30, 0, 0, montreal)},
+		{"today at 5pm", time.Date(2020, 5, 1, 17, 0, 0, 0, montreal)},
+		{"tomorrow", time.Date(2020, 5, 2, 9, 0, 0, 0, montreal)},
+		{"Tomorrow at noon", time.Date(2020, 5, 2, 12, 0, 0, 0, montreal)},
+		{"next Monday", time.Date(2020, 5, 4, 9, 0, 0, 0, montreal)},
+		{"on tuesday at 2:15 pm", time.Date(2020, 5, 5, 14, 15, 0, 0, montreal)},
+		{"friday at 11am", time.Date(2020, 5, 1, 11, 0, 0, 0, montreal)},
+		{"friday", time.Date(2020, 5, 8, 9, 0, 0, 0, montreal)},
+		{"next friday at 11am", time.Date(2020, 5, 8, 11, 0, 0, 0, montreal)},
+	} {
+		when, err := ParseWhen(test.text, now)
+		if err != nil {
+			t.Errorf("%q: unexpected error %s", test.text, err)
+			continue
+		}
+		if !when.Equal(test.expected) {
+			t.Errorf("%q: expected %s, got %s", test.text, test.expected, when)
+		}
+	}
+
+	for _, text := range []string{"", "soon", "in 0 minutes", "today", "at 25:00", "next month", "in -2h"} {
+		if when, err := ParseWhen(text, now); err == nil {
+			t.Errorf("%q: expected an error, got %s", text, when)
+		}
+	}
+}
+
+func TestParseSince(t *testing.T) {
+	montreal, _ := time.LoadLocation("America/Montreal")
+	// A Friday
+	now := time.Date(2020, 5, 1, 10, 17, 0, 0, montreal)
+
+	for _, test := range []struct {
+		text     string
+		expected time.Time
+	}{
+		{"today", time.Date(2020, 5, 1, 0, 0, 0, 0, montreal)},
+		{"Yesterday", time.Date(2020, 4, 30, 0, 0, 0, 0, montreal)},
+		{"last week", time.Date(2020, 4, 24, 0, 0, 0, 0, montreal)},
+		{"monday", time.Date(2020, 4, 27, 0, 0, 0, 0, montreal)},
+		{"friday", time.Date(2020, 5, 1, 0, 0, 0, 0, montreal)},
+		{"last friday", time.Date(2020, 4, 24, 0, 0, 0, 0, montreal)},
+		{"20 minutes ago", now.Add(-20 * time.Minute)},
+		{"an hour ago", now.Add(-time.Hour)},
+		{"3 days ago", time.Date(2020, 4, 28, 10, 17, 0, 0, montreal)},
+		{"2020-03-15", time.Date(2020, 3, 15, 0, 0, 0, 0, montreal)},
+	} {
+		since, err := ParseSince(test.text, now)
+		if err != nil {
+			t.Errorf("%q: unexpected error %s", test.text, err)
+			continue
+		}
+		if !since.Equal(test.expected) {
+			t.Errorf("%q: expected %s, got %s", test.text, test.expected, since)
+		}
+	}
+
+	for _, text := range []string{"", "ages ago", "next monday", "in 2h", "2020-13-01"} {
+		if since, err := ParseSince(text, now); err == nil {
+			t.Errorf("%q: expected an error, got %s", text, since)
+		}
+	}
+}
@@ -2,13 +2,15 @@ package deployer
 
 import (
 	"bufio"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
-	"regexp"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/kr/pty"
@@ -18,32 +20,93 @@ import (
 	"github.com/plotly/plotbot/util"
 )
 
-func deployHelp(botName string) string {
-	t := `*Usage:* %[1]s [please|insert reverence] deploy [<branch-or-image>] to <service> <environment> [, tags: <ansible-playbook-tags>, ..., ...]
-<branch-or-image> is a git branch (technically a committish)
+func (dep *Deployer) setupCommands() {
+	tags := "[, tags|tag|tags:|tag: <tags...>]"
+
+	dep.commands = plotbot.NewRouter()
+	dep.commands.Add(&plotbot.Command{
+		Usage: "deploy [<branch-or-image>] to [<service>] <environment:prod|stage> " + tags,
+		Description: `<branch-or-image> is a git branch (technically a committish)
 <service> defaults to streambed. imageserver is also supported.
-<environment> is prod or stage
-*Examples:*
-• %[1]s please deploy to prod
-• %[1]s deploy thing-to-test to stage
-• %[1]s deploy to imageserver prod
-• %[1]s deploy test-branch to imageserver stage
-• %[1]s deploy complicated-thing to stage, tags: updt_streambed, blow_up_the_sun
-*Other commands:*
-• %[1]s what's in the pipe? - show what's waiting to be deployed to prod
-• %[1]s lock deployment - prevent deployment until it's unlocked
-• %[1]s cancel deploy - cancel the currently running deployment
-• %[1]s run help - show help on running specific playbooks in an environment`
-	return fmt.Sprintf(t, botName)
-}
-func runHelp(botName string, services map[string]ServiceConfig) string {
-	t := `*Usage:* %[1]s [please|insert reverence] run [<playbook suffix>] in <service> <environment> [, tags: <ansible-playbook tags>, ..., ...]
-*Examples:*
-• %[1]s run postgres_failover on prod
-• %[1]s run update_plotlyjs on imageserver prod
-• %[1]s run postgres_recovery on streambed stage, tags: everything_is_broken`
-
-	for service, serviceArgs := range services {
+<tags> are ansible-playbook tags, comma-separated`,
+		Examples: []string{
+			"please deploy to prod",
+			"deploy thing-to-test to stage",
+			"deploy to imageserver prod",
+			"deploy test-branch to imageserver stage",
+			"deploy complicated-thing to stage, tags: updt_streambed, blow_up_the_sun",
+		},
+		HandlerFunc: dep.deployCommand,
+	})
+	dep.runCmd = dep.commands.Add(&plotbot.Command{
+		Usage:       "run <playbook> on [<service>] <environment:prod|stage> " + tags,
+		Description: "run a specific playbook in an environment",
+		Examples: []string{
+			"run postgres_failover on prod",
+			"run update_plotlyjs on imageserver prod",
+			"run postgres_recovery on streambed stage, tags: everything_is_broken",
+		},
+		HandlerFunc: dep.runCommand,
+	})
+	dep.commands.Add(&plotbot.Command{
+		Usage:       "cancel deploy",
+		Description: "cancel the currently running deployment",
+		HandlerFunc: dep.cancelCommand,
+	})
+	dep.commands.Add(&plotbot.Command{
+		Usage:       "in the pipe",
+		Description: "show what's waiting to be deployed to prod",
+		HandlerFunc: dep.inThePipeCommand,
+	})
+	dep.commands.Add(&plotbot.Command{
+		Usage:       "unlock deploy|deployment",
+		Description: "allow deployment again",
+		HandlerFunc: dep.unlockCommand,
+	})
+	dep.commands.Add(&plotbot.Command{
+		Usage:       "lock deploy|deployment",
+		Description: "prevent deployment until it's unlocked",
+		HandlerFunc: dep.lockCommand,
+	})
+	dep.commands.Add(&plotbot.Command{
+		Usage:       "deploy help|how",
+		Description: "show this help",
+		HandlerFunc: dep.deployHelpCommand,
+	})
+	dep.commands.Add(&plotbot.Command{
+		Usage:       "push to",
+		Description: "show this help, for those who push rather than deploy",
+		HandlerFunc: dep.deployHelpCommand,
+	})
+	dep.commands.Add(&plotbot.Command{
+		Usage:       "run help|how",
+		Description: "show help on running specific playbooks in an environment",
+		HandlerFunc: dep.runHelpCommand,
+	})
+
+	// The answers to `askConfirmation()`, when typed rather than clicked.
+	dep.confirmCommands = plotbot.NewRouter()
+	dep.confirmCommands.Add(&plotbot.Command{
+		Usage:       "yes",
+		Description: "confirm the job waiting for confirmation",
+		HandlerFunc: func(conv *plotbot.Conversation, msg *plotbot.Message, args plotbot.CommandArgs) {
+			dep.typedConfirmation(msg, true)
+		},
+	})
+	dep.confirmCommands.Add(&plotbot.Command{
+		Usage:       "no",
+		Description: "cancel the job waiting for confirmation",
+		HandlerFunc: func(conv *plotbot.Conversation, msg *plotbot.Message, args plotbot.CommandArgs) {
+			dep.typedConfirmation(msg, false)
+		},
+	})
+}
+
+// runHelp lists the playbooks available to `run` along with its usage.
+func (dep *Deployer) runHelp() string {
+	t := dep.runCmd.Help(dep.bot.AtMention())
+
+	for service, serviceArgs := range dep.currentConfig().Services {
 		playbooks, err := listAllowedPlaybooks(serviceArgs.RepositoryPath)
 		if err == nil && len(playbooks) > 0 {
 			t = t + fmt.Sprintf("\n\n*Available commands for %s:*", service)
@@ -56,54 +119,85 @@ func runHelp(botName string, services map[string]ServiceConfig) string {
 		}
 	}
 
-	return fmt.Sprintf(t, botName)
+	return t
 }
 
 var DEFAULT_CONFIRM_TIMEOUT = 30 * time.Second
 var CONFIRM_PLAYBOOKS = util.Searchable{
 	"postgres_recovery", "postgres_failover"}
 
-var deployFormat = regexp.MustCompile(`deploy(?: ([a-zA-Z0-9_\.-]+))? to (?:([a-z_-]+) )?([a-z_-]+)(?:,\s+tags?:? ?(.+))?`)
-
-var runFormat = regexp.MustCompile(`run\s+([a-zA-Z0-9_\.-]+)\s+on\s+(?:([a-z_-]+)\s+)?([a-z_-]+)(?:,\s+tags?:? ?(.+))?`)
-
 type Deployer struct {
-	runner         Runnable
-	runningJob     *DeployJob
-	bot            plotbot.BotLike
-	confirmJob     *ConfirmJob
-	confirmTimeout time.Duration
-	env            string
-	config         *DeployerConfig
-	progress       chan string
-	internal       *internal.InternalAPI
-	lockedBy       string
+	runner          Runnable
+	runningJob      *DeployJob
+	bot             plotbot.BotLike
+	commands        *plotbot.Router
+	confirmCommands *plotbot.Router
+	runCmd          *plotbot.Command
+	confirmJob      *ConfirmJob
+	confirmTimeout  time.Duration
+	env             string
+	config          *DeployerConfig
+	progress        chan string
+	internal        *internal.InternalAPI
+	lockedBy        string
+	stopping        bool
+	logger          *plotbot.Logger
+	audit           *plotbot.AuditLog
+
+	// lock guards the jobs, `stopping`, `lockedBy`, and the `config`
+	// and `internal` swapped by `Reconfigure()`, shared by the message,
+	// interaction and job goroutines.
+	lock sync.Mutex
+
+	// stopForwarding is closed by `Stop()` to end `forwardProgress()`,
+	// which closes forwardingDone once the last lines are sent.
+	stopForwarding chan bool
+	forwardingDone chan bool
+
+	jobs        *plotbot.Counter
+	jobDuration *plotbot.Histogram
 }
 
+// jobDurationBuckets are the bounds of the deploy durations, in seconds.
+var jobDurationBuckets = []float64{10, 30, 60, 120, 300, 600, 1200, 1800, 3600}
+
 type ServiceConfig struct {
-	RepositoryPath      string   `json:"repository_path"`
+	RepositoryPath      string   `json:"repository_path" config:"required"`
 	DefaultBranch       string   `json:"default_branch"`
 	AllowedProdBranches []string `json:"allowed_prod_branches"`
 	InventoryArgs       []string `json:"inventory_args"`
 }
 
 type DeployerConfig struct {
-	AnnounceRoom string                   `json:"announce_room"`
-	ProgressRoom string                   `json:"progress_room"`
+	AnnounceRoom string                   `json:"announce_room" config:"required"`
+	ProgressRoom string                   `json:"progress_room" config:"required"`
 	Services     map[string]ServiceConfig `json:"services"`
 }
 
+// DeployJob is a deploy or a playbook run, from its launch until it is
+// over, running its processes one after the other: git, ansible and
+// the watch script.
 type DeployJob struct {
-	process *os.Process
-	params  *DeployParams
-	quit    chan bool
+	params *DeployParams
+	// config is the configuration the job started with.
+	config *DeployerConfig
+	// kill is closed to interrupt the job, see `interruptJob()`.
 	kill    chan bool
 	killing bool
+	// done is closed once the job is over.
+	done chan bool
 }
 
+// killDelay is how long an interrupted process has to exit before it
+// is killed.
+const killDelay = 3 * time.Second
+
 type ConfirmJob struct {
 	params *DeployParams
-	done   chan bool
+	// done is closed once the job is confirmed, cancelled or timed out,
+	// see `claimConfirmation()`.
+	done       chan bool
+	callbackID string
 }
 
 type Runnable interface {
@@ -117,18 +211,37 @@ func (r *Runner) Run(cmd string, args ...string) *exec.Cmd {
 }
 
 func init() {
-	plotbot.RegisterPlugin(&Deployer{})
+	plotbot.RegisterPlugin("deployer", &Deployer{})
+}
+
+func (dep *Deployer) InitLogger(logger *plotbot.Logger) {
+	dep.logger = logger
+}
+
+func (dep *Deployer) InitAudit(audit *plotbot.AuditLog) {
+	dep.audit = audit
+}
+
+// InitMetrics registers the counts and durations of the jobs, by
+// service, environment and outcome: success, failure, or aborted before
+// running.
+func (dep *Deployer) InitMetrics(metrics *plotbot.Metrics) {
+	dep.jobs = metrics.Counter("jobs_total",
+		"Deploys and playbook runs, by outcome.", "service", "environment", "outcome")
+	dep.jobDuration = metrics.Histogram("job_duration_seconds",
+		"Duration of the deploys and playbook runs, by outcome.", jobDurationBuckets,
+		"service", "environment", "outcome")
 }
 
 func (dep *Deployer) InitPlugin(bot *plotbot.Bot) {
-	var conf struct {
-		Deployer DeployerConfig
+	var conf DeployerConfig
+	if err := plotbot.DecodeSection(bot.LoadConfig, "Deployer", &conf); err != nil {
+		dep.logger.Fatal("Error loading Deployer config section", "err", err)
 	}
-	bot.LoadConfig(&conf)
 
-	dep.bot = bot
+	dep.bot = bot.PluginBot()
 	dep.progress = make(chan string, 1000)
-	dep.config = &conf.Deployer
+	dep.config = &conf
 	dep.env = os.Getenv("PLOTLY_ENV")
 	dep.runner = &Runner{}
 	dep.confirmTimeout = DEFAULT_CONFIRM_TIMEOUT
@@ -138,8 +251,9 @@ func (dep *Deployer) InitPlugin(bot *plotbot.Bot) {
 	}
 
 	dep.loadInternalAPI()
+	dep.setupCommands()
 
-	go dep.forwardProgress()
+	dep.startForwarding()
 
 	bot.ListenFor(&plotbot.Conversation{
 		HandlerFunc:    dep.ChatHandler,
@@ -151,140 +265,413 @@ func (dep *Deployer) loadInternalAPI() {
 	dep.internal = internal.New(dep.bot.LoadConfig)
 }
 
-func (dep *Deployer) ExtractDeployParams(msg *plotbot.Message) *DeployParams {
+func (dep *Deployer) deployCommand(conv *plotbot.Conversation, msg *plotbot.Message, args plotbot.CommandArgs) {
+	service := args.String("service")
+	if service == "" {
+		service = "streambed"
+	}
+	tags := strings.Replace(args.String("tags"), " ", "", -1)
+	if tags == "" && service == "streambed" {
+		tags = "updt_streambed"
+	}
+	dep.launch(conv, msg, &DeployParams{
+		Service:         service,
+		Environment:     args.String("environment"),
+		Branch:          args.String("branch-or-image"),
+		Tags:            tags,
+		InitiatedBy:     msg.FromUser.RealName,
+		InitiatedByID:   msg.User,
+		From:            "chat",
+		initiatedByChat: msg,
+	})
+}
 
-	if match := deployFormat.FindStringSubmatch(msg.Text); match != nil {
-		service := match[2]
-		if service == "" {
-			service = "streambed"
-		}
-		tags := strings.Replace(match[4], " ", "", -1)
-		if tags == "" && service == "streambed" {
-			tags = "updt_streambed"
-		}
-		return &DeployParams{
-			Service:         service,
-			Environment:     match[3],
-			Branch:          match[1],
-			Tags:            tags,
-			InitiatedBy:     msg.FromUser.RealName,
-			From:            "chat",
-			initiatedByChat: msg,
-		}
+func (dep *Deployer) runCommand(conv *plotbot.Conversation, msg *plotbot.Message, args plotbot.CommandArgs) {
+	service := args.String("service")
+	if service == "" {
+		service = "streambed"
+	}
+	playbook := args.String("playbook")
+	dep.launch(conv, msg, &DeployParams{
+		Service:         service,
+		Playbook:        playbook,
+		Environment:     args.String("environment"),
+		Tags:            args.String("tags"),
+		InitiatedBy:     msg.FromUser.RealName,
+		InitiatedByID:   msg.User,
+		From:            "chat",
+		initiatedByChat: msg,
+		Confirm:         CONFIRM_PLAYBOOKS.Includes(playbook),
+	})
+}
 
-	} else if match := runFormat.FindStringSubmatch(msg.Text); match != nil {
-		service := match[2]
-		if service == "" {
-			service = "streambed"
-		}
-		return &DeployParams{
-			Service:         service,
-			Playbook:        match[1],
-			Environment:     match[3],
-			Tags:            match[4],
-			InitiatedBy:     msg.FromUser.RealName,
-			From:            "chat",
-			initiatedByChat: msg,
-			Confirm:         CONFIRM_PLAYBOOKS.Includes(match[1]),
+func (dep *Deployer) launch(conv *plotbot.Conversation, msg *plotbot.Message, params *DeployParams) {
+	dep.lock.Lock()
+	defer dep.lock.Unlock()
+
+	if dep.stopping {
+		dep.replyPersonnally(params, "I'm shutting down, try again once I'm back.")
+
+	} else if dep.lockedBy != "" {
+		conv.Reply(msg, fmt.Sprintf("Deployment was locked by %s.  "+
+			"Unlock with '%s, unlock deployment' if they're OK with it.",
+			dep.lockedBy, dep.bot.AtMention()))
+
+	} else if dep.runningJob != nil {
+		dep.replyPersonnally(params,
+			fmt.Sprintf("Deploy currently running: %s", dep.runningJob.params))
+
+	} else if dep.confirmJob != nil {
+		m := fmt.Sprintf(
+			"waiting for confirmation from %s", dep.confirmJob.params.InitiatedBy,
+		)
+		dep.replyPersonnally(params, m)
+
+	} else if params.Confirm {
+		dep.confirmJob = &ConfirmJob{
+			params:     params,
+			done:       make(chan bool),
+			callbackID: fmt.Sprintf("deployer-confirm-%d", time.Now().UnixNano()),
 		}
+		dep.bot.HandleInteraction(dep.confirmJob.callbackID, dep.confirmInteraction)
+		dep.askConfirmation(dep.confirmJob)
+		go dep.manageConfirm(dep.confirmJob)
+
+	} else {
+		dep.startJob(params)
 	}
+}
+
+// startJob runs a job with `params` in the background.  The lock must
+// be held.
+func (dep *Deployer) startJob(params *DeployParams) {
+	job := &DeployJob{
+		params: params,
+		config: dep.config,
+		kill:   make(chan bool),
+		done:   make(chan bool),
+	}
+	dep.runningJob = job
+	go dep.handleDeploy(job)
+}
+
+// endJob marks `job` as over, once `handleDeploy()` is done with it.
+func (dep *Deployer) endJob(job *DeployJob) {
+	dep.lock.Lock()
+	dep.runningJob = nil
+	dep.lock.Unlock()
+	close(job.done)
+}
+
+// interruptJob marks the running job as interrupted, and returns it.
+// `first` is false when there's no job, or when it was already
+// interrupted.  Otherwise the caller closes its `kill` channel, which
+// interrupts its process and those it would start next.
+func (dep *Deployer) interruptJob() (job *DeployJob, first bool) {
+	dep.lock.Lock()
+	defer dep.lock.Unlock()
+	job = dep.runningJob
+	if job == nil || job.killing {
+		return job, false
+	}
+	job.killing = true
+	return job, true
+}
+
+// Reconfigure takes the new services and rooms.  A running job keeps
+// the configuration it started with, while a pending one gets the new
+// configuration once confirmed.
+func (dep *Deployer) Reconfigure(load func(config interface{}) error) error {
+	var conf DeployerConfig
+	if err := plotbot.DecodeSection(load, "Deployer", &conf); err != nil {
+		return err
+	}
+	internalAPI := internal.New(load)
 
+	dep.lock.Lock()
+	dep.config = &conf
+	dep.internal = internalAPI
+	dep.lock.Unlock()
 	return nil
 }
 
-func (dep *Deployer) ChatHandler(conv *plotbot.Conversation, msg *plotbot.Message) {
-	bot := conv.Bot
-
-	if params := dep.ExtractDeployParams(msg); params != nil {
-		if dep.lockedBy != "" {
-			conv.Reply(msg, fmt.Sprintf("Deployment was locked by %s.  "+
-				"Unlock with '%s, unlock deployment' if they're OK with it.",
-				dep.lockedBy, dep.bot.AtMention()))
-
-		} else if dep.runningJob != nil {
-			dep.replyPersonnally(params,
-				fmt.Sprintf("Deploy currently running: %s", dep.runningJob.params))
-
-		} else if dep.confirmJob != nil {
-			m := fmt.Sprintf(
-				"waiting for confirmation from %s", dep.confirmJob.params.InitiatedBy,
-			)
-			dep.replyPersonnally(params, m)
-
-		} else if params.Confirm {
-			dep.confirmJob = &ConfirmJob{
-				params: params,
-				done:   make(chan bool, 2),
-			}
-			m := fmt.Sprintf("This job requires confirmation. "+
-				"Confirm with '%s [yes|no]'", dep.bot.AtMention())
-			dep.replyPersonnally(params, m)
-			go dep.manageConfirm()
+// currentConfig returns the configuration, which `Reconfigure()` may
+// swap at any time.
+func (dep *Deployer) currentConfig() *DeployerConfig {
+	dep.lock.Lock()
+	defer dep.lock.Unlock()
+	return dep.config
+}
 
-		} else {
-			go dep.handleDeploy(params)
-		}
+func (dep *Deployer) ConfigSchema() (string, interface{}) {
+	return "Deployer", &DeployerConfig{}
+}
 
-	} else if msg.Contains("cancel deploy") {
-
-		if dep.runningJob == nil {
-			conv.Reply(msg, "No deploy running, sorry friend..")
-		} else {
-			if dep.runningJob.killing == true {
-				conv.Reply(msg,
-					"deploy: Interrupt signal already sent, waiting to die")
-			} else {
-				conv.Reply(msg, "deploy: Sending Interrupt signal...")
-				dep.runningJob.killing = true
-				dep.runningJob.kill <- true
-			}
-		}
-	} else if msg.Contains("in the pipe") {
-		url := dep.getCompareUrl("prod", dep.config.Services["streambed"].DefaultBranch, dep.config.Services["streambed"].RepositoryPath)
-		mention := msg.FromUser.Name
-		if url != "" {
-			conv.Reply(msg,
-				fmt.Sprintf("@%s in %s branch, waiting to reach prod: %s",
-					mention, dep.config.Services["streambed"].DefaultBranch, url))
-		} else {
-			conv.Reply(msg,
-				fmt.Sprintf("@%s couldn't get current revision on prod", mention))
+// Stop lets a running job finish while the bot shuts down, and
+// interrupts it if it is still running when `ctx` is done.  Pending
+// confirmations are cancelled, and no new job nor process is started:
+// a job still pulling its repository is aborted.  Stop returns once
+// the job is over, or once its process had the time to be killed, and
+// its last lines of progress are forwarded.
+func (dep *Deployer) Stop(ctx context.Context) error {
+	dep.lock.Lock()
+	wasStopping := dep.stopping
+	dep.stopping = true
+	confirmJob := dep.takeConfirmation("", "")
+	job := dep.runningJob
+	dep.lock.Unlock()
+
+	if confirmJob != nil {
+		dep.bot.RemoveInteraction(confirmJob.callbackID)
+		dep.replyPersonnally(confirmJob.params, "I'm shutting down, cancelling...")
+	}
+	err := dep.stopJob(ctx, job)
+
+	if dep.stopForwarding != nil && !wasStopping {
+		close(dep.stopForwarding)
+		select {
+		case <-dep.forwardingDone:
+		case <-ctx.Done():
 		}
-	} else if msg.Contains("unlock deploy") {
-		dep.lockedBy = ""
-		conv.Reply(msg, fmt.Sprintf("Deployment is now unlocked."))
-		bot.Notify(dep.config.AnnounceRoom, "#00ff00",
-			fmt.Sprintf("%s has unlocked deployment", msg.FromUser.Name))
+	}
+	return err
+}
 
-	} else if msg.Contains("lock deploy") {
-		dep.lockedBy = msg.FromUser.Name
-		conv.Reply(msg, fmt.Sprintf("Deployment is now locked.  "+
-			"Unlock with '%s, unlock deployment' ASAP!", dep.bot.AtMention()))
-		bot.Notify(dep.config.AnnounceRoom, "#ff0000",
-			fmt.Sprintf("%s has locked deployment", dep.lockedBy))
+// stopJob waits for `job` to be over, and interrupts it when `ctx` is
+// done, see `Stop()`.
+func (dep *Deployer) stopJob(ctx context.Context, job *DeployJob) error {
+	if job == nil {
+		return nil
+	}
 
-	} else if msg.Contains("deploy") || msg.Contains("push to") {
-		conv.Reply(msg, deployHelp(dep.bot.AtMention()))
+	select {
+	case <-job.done:
+		return nil
+	case <-ctx.Done():
+	}
 
-	} else if msg.Contains("run") && msg.ContainsAny([]string{"how", "help"}) {
-		conv.Reply(msg, runHelp(dep.bot.AtMention(), dep.config.Services))
+	if job, first := dep.interruptJob(); first {
+		dep.pubLine("[deployer] Interrupting the job, the bot is shutting down")
+		close(job.kill)
+	}
+	select {
+	case <-job.done:
+	case <-time.After(killDelay + time.Second):
+	}
+	return fmt.Errorf("interrupted %s: %s", job.params, ctx.Err())
+}
 
-	} else if dep.confirmJob != nil {
-		waitingFor := dep.confirmJob.params.InitiatedBy
-		msgFrom := msg.FromUser.RealName
+func (dep *Deployer) cancelCommand(conv *plotbot.Conversation, msg *plotbot.Message, args plotbot.CommandArgs) {
+	job, first := dep.interruptJob()
+	if job == nil {
+		conv.Reply(msg, "No deploy running, sorry friend..")
+	} else if !first {
+		conv.Reply(msg,
+			"deploy: Interrupt signal already sent, waiting to die")
+	} else {
+		record := plotbot.AuditRecordOf(msg, "cancel")
+		record.Params = job.params.auditParams()
+		dep.audit.Record(record)
 
-		if waitingFor == msgFrom && msg.Contains("no") {
-			dep.replyPersonnally(dep.confirmJob.params, "ok cancelling...")
-			dep.confirmJob.done <- true
+		conv.Reply(msg, "deploy: Sending Interrupt signal...")
+		close(job.kill)
+	}
+}
 
-		} else if waitingFor == msgFrom && msg.Contains("yes") {
-			go dep.handleDeploy(dep.confirmJob.params)
-			dep.confirmJob.done <- true
-		}
+func (dep *Deployer) inThePipeCommand(conv *plotbot.Conversation, msg *plotbot.Message, args plotbot.CommandArgs) {
+	streambed := dep.currentConfig().Services["streambed"]
+	url := dep.getCompareUrl("prod", streambed.DefaultBranch, streambed.RepositoryPath)
+	mention := msg.FromUser.Name
+	if url != "" {
+		conv.Reply(msg,
+			fmt.Sprintf("@%s in %s branch, waiting to reach prod: %s",
+				mention, streambed.DefaultBranch, url))
+	} else {
+		conv.Reply(msg,
+			fmt.Sprintf("@%s couldn't get current revision on prod", mention))
 	}
 }
 
-func (dep *Deployer) handleDeploy(params *DeployParams) {
+func (dep *Deployer) unlockCommand(conv *plotbot.Conversation, msg *plotbot.Message, args plotbot.CommandArgs) {
+	dep.lock.Lock()
+	lockedBy := dep.lockedBy
+	dep.lockedBy = ""
+	dep.lock.Unlock()
+
+	record := plotbot.AuditRecordOf(msg, "unlock")
+	if lockedBy != "" {
+		record.Params = map[string]string{"locked_by": lockedBy}
+	}
+	dep.audit.Record(record)
+
+	conv.Reply(msg, fmt.Sprintf("Deployment is now unlocked."))
+	conv.Bot.Notify(dep.currentConfig().AnnounceRoom, "#00ff00",
+		fmt.Sprintf("%s has unlocked deployment", msg.FromUser.Name))
+}
+
+func (dep *Deployer) lockCommand(conv *plotbot.Conversation, msg *plotbot.Message, args plotbot.CommandArgs) {
+	dep.audit.Record(plotbot.AuditRecordOf(msg, "lock"))
+
+	dep.lock.Lock()
+	dep.lockedBy = msg.FromUser.Name
+	dep.lock.Unlock()
+	conv.Reply(msg, fmt.Sprintf("Deployment is now locked.  "+
+		"Unlock with '%s, unlock deployment' ASAP!", dep.bot.AtMention()))
+	conv.Bot.Notify(dep.currentConfig().AnnounceRoom, "#ff0000",
+		fmt.Sprintf("%s has locked deployment", msg.FromUser.Name))
+}
+
+func (dep *Deployer) deployHelpCommand(conv *plotbot.Conversation, msg *plotbot.Message, args plotbot.CommandArgs) {
+	conv.Reply(msg, dep.commands.Help(dep.bot.AtMention()))
+}
+
+func (dep *Deployer) runHelpCommand(conv *plotbot.Conversation, msg *plotbot.Message, args plotbot.CommandArgs) {
+	conv.Reply(msg, dep.runHelp())
+}
+
+func (dep *Deployer) ChatHandler(conv *plotbot.Conversation, msg *plotbot.Message) {
+	if dep.commands.Handle(conv, msg) {
+		return
+	}
+	dep.confirmCommands.Handle(conv, msg)
+}
+
+// typedConfirmation handles a "yes" or "no" typed by the initiator of
+// the job waiting for confirmation.
+func (dep *Deployer) typedConfirmation(msg *plotbot.Message, confirmed bool) {
+	confirmJob := dep.claimConfirmation("", msg.User, confirmed)
+	if confirmJob == nil {
+		return
+	}
+
+	if confirmed {
+		dep.auditConfirmation(plotbot.AuditRecordOf(msg, "confirm"), confirmJob.params, "confirmed")
+	} else {
+		dep.auditConfirmation(plotbot.AuditRecordOf(msg, "confirm"), confirmJob.params, "cancelled")
+		dep.replyPersonnally(confirmJob.params, "ok cancelling...")
+	}
+}
+
+// claimConfirmation ends the confirmation pending for `callbackID`, if
+// it waits for the user with the ID `userID`, and returns it.  Empty
+// values match any confirmation or user.  Clicks, typed answers and the
+// timeout race to end a confirmation: only the caller getting it back
+// acts on it.  With `start`, the job is started right away, before
+// another one can be launched.
+func (dep *Deployer) claimConfirmation(callbackID, userID string, start bool) *ConfirmJob {
+	dep.lock.Lock()
+	confirmJob := dep.takeConfirmation(callbackID, userID)
+	if confirmJob != nil && start {
+		dep.startJob(confirmJob.params)
+	}
+	dep.lock.Unlock()
+
+	if confirmJob != nil {
+		dep.bot.RemoveInteraction(confirmJob.callbackID)
+	}
+	return confirmJob
+}
+
+// takeConfirmation is `claimConfirmation()` without starting the job
+// nor removing its buttons.  The lock must be held.
+func (dep *Deployer) takeConfirmation(callbackID, userID string) *ConfirmJob {
+	confirmJob := dep.confirmJob
+	if confirmJob == nil ||
+		(callbackID != "" && callbackID != confirmJob.callbackID) ||
+		(userID != "" && userID != confirmJob.params.InitiatedByID) {
+		return nil
+	}
+	dep.confirmJob = nil
+	close(confirmJob.done)
+	return confirmJob
+}
+
+// askConfirmation asks the initiator of the job, in a thread, to click
+// yes or no.  Typing the answer works too, see `typedConfirmation()`.
+func (dep *Deployer) askConfirmation(confirmJob *ConfirmJob) {
+	chat := confirmJob.params.initiatedByChat
+	if chat == nil {
+		return
+	}
+
+	m := fmt.Sprintf("This job requires confirmation. "+
+		"Confirm with '%s [yes|no]'", dep.bot.AtMention())
+	rich := plotbot.NewRichMessage(chat.AtMentionIfPublic(m)).
+		InThread().
+		Section(fmt.Sprintf("%s\nThis job requires confirmation: `%s`",
+			chat.AtMentionIfPublic(""), confirmJob.params)).
+		Buttons(confirmJob.callbackID,
+			plotbot.Button{Text: "Yes, run it", Value: "yes", Style: "danger"},
+			plotbot.Button{Text: "No", Value: "no"})
+	dep.bot.ReplyRich(chat, rich)
+}
+
+// confirmInteraction handles the clicks on the buttons of
+// `askConfirmation()`.
+func (dep *Deployer) confirmInteraction(interaction *plotbot.Interaction) {
+	var outcome string
+	switch interaction.Value {
+	case "yes":
+		outcome = "Confirmed"
+	case "no":
+		outcome = "Cancelled"
+	default:
+		return
+	}
+
+	confirmJob := dep.claimConfirmation(interaction.CallbackID, interaction.User.ID, outcome == "Confirmed")
+	if confirmJob == nil {
+		dep.logger.Info("Ignoring confirmation by another user, or already answered",
+			"user", interaction.User.ID, "callback_id", interaction.CallbackID)
+		return
+	}
+	if outcome == "Cancelled" {
+		dep.replyPersonnally(confirmJob.params, "ok cancelling...")
+	}
+	dep.auditConfirmation(plotbot.AuditRecord{
+		User:     interaction.User.ID,
+		UserName: interaction.User.Name,
+		Channel:  interaction.Channel,
+		Action:   "confirm",
+	}, confirmJob.params, strings.ToLower(outcome))
+
+	// The user is not in the directory when it missed them, with only
+	// their ID.
+	who := interaction.User.RealName
+	if who == "" {
+		who = fmt.Sprintf("<@%s>", interaction.User.ID)
+	}
+	text := fmt.Sprintf("%s by %s: `%s`", outcome, who, confirmJob.params)
+	if err := interaction.Update(plotbot.NewRichMessage(text).Section(text)); err != nil {
+		dep.logger.Error("Error updating confirmation message", "err", err)
+	}
+}
+
+// auditConfirmation records the answer to `askConfirmation()`.
+func (dep *Deployer) auditConfirmation(record plotbot.AuditRecord, params *DeployParams, outcome string) {
+	record.Params = params.auditParams()
+	record.Outcome = outcome
+	dep.audit.Record(record)
+}
+
+func (dep *Deployer) handleDeploy(job *DeployJob) {
+	defer dep.endJob(job)
+
+	params := job.params
+	start := time.Now()
+	serviceLabel, outcome := "unknown", "aborted"
+	record := params.auditRecord()
+	record.Outcome = "started"
+	dep.audit.Record(record)
+	defer func() {
+		dep.jobs.Inc(serviceLabel, params.Environment, outcome)
+		dep.jobDuration.Observe(time.Since(start).Seconds(), serviceLabel, params.Environment, outcome)
+
+		record.Outcome = outcome
+		record.Params["duration"] = time.Since(start).Round(time.Second).String()
+		dep.audit.Record(record)
+	}()
+
 	// primary deployer syntax
 	playbookFile := fmt.Sprintf("playbook_%s.yml", params.Environment)
 	if params.Playbook != "" {
@@ -299,8 +686,7 @@ func (dep *Deployer) handleDeploy(params *DeployParams) {
 		playbookFile = fmt.Sprintf("playbook_%s.yml", params.Environment)
 	}
 
-	service := params.Service
-	serviceArgs, found := dep.config.Services[service]
+	serviceArgs, found := job.config.Services[params.Service]
 
 	if !found {
 		errorMsg := fmt.Sprintf("%s is not a valid service.  Aborting.", params.Service)
@@ -308,6 +694,10 @@ func (dep *Deployer) handleDeploy(params *DeployParams) {
 		dep.replyPersonnally(params, errorMsg)
 		return
 	}
+	// Label the metrics with known services only, not with whatever was
+	// asked.
+	service := params.Service
+	serviceLabel = service
 
 	cmdArgs := make([]string, 0)
 	cmdArgs = append(cmdArgs, "ansible-playbook")
@@ -341,7 +731,7 @@ func (dep *Deployer) handleDeploy(params *DeployParams) {
 		cmdArgs = append(cmdArgs, "-e", pr)
 	}
 
-	if err := dep.pullRepo(branch, serviceArgs.RepositoryPath); err != nil {
+	if err := dep.pullRepo(job, branch, serviceArgs.RepositoryPath); err != nil {
 		errorMsg := fmt.Sprintf("Unable to pull from repo: %s. Aborting.", err)
 		dep.pubLine(fmt.Sprintf("[deployer] %s", errorMsg))
 		dep.replyPersonnally(params, errorMsg)
@@ -352,9 +742,7 @@ func (dep *Deployer) handleDeploy(params *DeployParams) {
 	}
 
 	bot := dep.bot
-	bot.Notify(dep.config.AnnounceRoom, "#447bdc",
-		fmt.Sprintf("[deployer] Launching: %s, monitor in %s",
-			params, dep.config.ProgressRoom))
+	bot.NotifyRich(job.config.AnnounceRoom, dep.launchAnnouncement(job, branch))
 	dep.replyPersonnally(params, bot.WithMood(
 		"deploying, my friend", "deploying, yyaaahhhOooOOO!"))
 
@@ -375,9 +763,10 @@ func (dep *Deployer) handleDeploy(params *DeployParams) {
 	}
 	cmd.Env = env
 
-	err := dep.runWithOutput(cmd, params)
+	err := dep.runWithOutput(job, cmd)
 
 	if err != nil {
+		outcome = "failure"
 		dep.pubLine(fmt.Sprintf("[deployer] terminated with error: %s", err))
 		dep.replyPersonnally(params, fmt.Sprintf("your deploy failed: %s", err))
 
@@ -389,9 +778,10 @@ func (dep *Deployer) handleDeploy(params *DeployParams) {
 		cmd = dep.runner.Run(wd)
 		cmd.Dir = serviceArgs.RepositoryPath
 
-		err := dep.runWithOutput(cmd, params)
+		err := dep.runWithOutput(job, cmd)
 
 		if err != nil {
+			outcome = "failure"
 			dep.pubLine(fmt.Sprintf("[deployer] terminated with error: %s", err))
 			dep.replyPersonnally(params, fmt.Sprintf("your deploy failed: %s", err))
 
@@ -399,6 +789,7 @@ func (dep *Deployer) handleDeploy(params *DeployParams) {
 		}
 	}
 
+	outcome = "success"
 	dep.pubLine("[deployer] terminated successfully")
 	dep.replyPersonnally(params,
 		bot.WithMood("your deploy was successful",
@@ -406,108 +797,192 @@ func (dep *Deployer) handleDeploy(params *DeployParams) {
 	return
 }
 
-func (dep *Deployer) runWithOutput(cmd *exec.Cmd, params *DeployParams) error {
-	f, err := pty.Start(cmd)
-
+// runWithOutput runs `cmd` as a process of `job`, forwarding its
+// output to the progress room.
+func (dep *Deployer) runWithOutput(job *DeployJob, cmd *exec.Cmd) error {
+	var f *os.File
+	exited, err := dep.startProcess(job, cmd, func() (err error) {
+		f, err = pty.Start(cmd)
+		return err
+	})
 	if err != nil {
 		return err
 	}
+	defer f.Close()
 
-	dep.runningJob = &DeployJob{
-		process: cmd.Process,
-		params:  params,
-		quit:    make(chan bool, 2),
-		kill:    make(chan bool, 2),
-	}
-
-	go dep.manageDeployIo(f)
-	go dep.manageKillProcess(f)
+	output := make(chan bool)
+	go dep.manageDeployIo(f, output)
 
 	err = cmd.Wait()
+	close(exited)
 
-	dep.runningJob.quit <- true
-	dep.runningJob = nil
+	// Forward the last lines of output before telling how it ended.
+	select {
+	case <-output:
+	case <-time.After(time.Second):
+	}
+	return err
+}
 
+// run runs `cmd` as a process of `job`, without forwarding its output.
+func (dep *Deployer) run(job *DeployJob, cmd *exec.Cmd) error {
+	exited, err := dep.startProcess(job, cmd, cmd.Start)
 	if err != nil {
 		return err
 	}
+	err = cmd.Wait()
+	close(exited)
+	return err
+}
 
-	return nil
+// startProcess starts `cmd` with `start`, unless the job was
+// interrupted or the bot is stopping.  Interrupting the job then
+// interrupts the process, until `exited` is closed.
+func (dep *Deployer) startProcess(job *DeployJob, cmd *exec.Cmd, start func() error) (exited chan bool, err error) {
+	dep.lock.Lock()
+	defer dep.lock.Unlock()
+	if job.killing {
+		return nil, errors.New("interrupted")
+	}
+	if dep.stopping {
+		return nil, errors.New("the bot is shutting down")
+	}
+	if err := start(); err != nil {
+		return nil, err
+	}
+
+	exited = make(chan bool)
+	go dep.manageKillProcess(job, cmd.Process, exited)
+	return exited, nil
 }
 
-func (dep *Deployer) pullRepo(branch, path string) error {
+func (dep *Deployer) pullRepo(job *DeployJob, branch, path string) error {
 	cmd := dep.runner.Run("git", "fetch")
 	cmd.Dir = path
-	err := cmd.Run()
+	err := dep.run(job, cmd)
 	if err != nil {
 		return fmt.Errorf("Error executing git fetch: %s", err)
 	}
 	cmd = dep.runner.Run("git", "checkout", fmt.Sprintf("origin/%s", branch))
 	cmd.Dir = path
-	return cmd.Run()
+	return dep.run(job, cmd)
 }
 
 func (dep *Deployer) pubLine(str string) {
 	dep.progress <- str
 }
 
-func (dep *Deployer) manageKillProcess(pty *os.File) {
-	runningJob := dep.runningJob
+// manageKillProcess interrupts `process` when `job` is, and kills it if
+// it has not exited after `killDelay`.
+func (dep *Deployer) manageKillProcess(job *DeployJob, process *os.Process, exited chan bool) {
 	select {
-	case <-runningJob.quit:
-		return
-	case <-runningJob.kill:
-		dep.runningJob.process.Signal(os.Interrupt)
-		time.Sleep(3 * time.Second)
-		if dep.runningJob != nil {
-			dep.runningJob.process.Kill()
+	case <-exited:
+	case <-job.kill:
+		process.Signal(os.Interrupt)
+		select {
+		case <-exited:
+		case <-time.After(killDelay):
+			process.Kill()
 		}
 	}
 }
 
-func (dep *Deployer) manageConfirm() {
-	confirmJob := dep.confirmJob
+func (dep *Deployer) manageConfirm(confirmJob *ConfirmJob) {
 	select {
 	case <-confirmJob.done:
-		dep.confirmJob = nil
 	case <-time.After(dep.confirmTimeout):
+		if dep.claimConfirmation(confirmJob.callbackID, "", false) == nil {
+			return
+		}
 		m := fmt.Sprintf("Did not receive confirmation in time. "+
 			"Cancelling job %s", confirmJob.params)
-		dep.replyPersonnally(confirmJob.params, m)
-		dep.confirmJob = nil
+		dep.replyInThread(confirmJob.params, m)
 	}
 }
 
+// startForwarding runs `forwardProgress()` until `Stop()`.
+func (dep *Deployer) startForwarding() {
+	dep.stopForwarding = make(chan bool)
+	dep.forwardingDone = make(chan bool)
+	go dep.forwardProgress()
+}
+
 func (dep *Deployer) forwardProgress() {
+	defer close(dep.forwardingDone)
 	lines := ""
+	flush := func() {
+		if lines != "" {
+			escapedLines := fmt.Sprintf("```%s```", lines)
+			dep.bot.SendToChannel(dep.currentConfig().ProgressRoom, escapedLines)
+			lines = ""
+		}
+	}
+	add := func(msg string) {
+		if msg != "" {
+			lines += fmt.Sprintf("%s", msg)
+		}
+		lines += "\n"
+	}
 
 	for {
 		select {
 		case msg := <-dep.progress:
-			if msg != "" {
-				lines += fmt.Sprintf("%s", msg)
-			}
-			lines += "\n"
+			add(msg)
 		case <-time.After(2 * time.Second):
-			if lines != "" {
-				escapedLines := fmt.Sprintf("```%s```", lines)
-				dep.bot.SendToChannel(dep.config.ProgressRoom, escapedLines)
-				lines = ""
+			flush()
+		case <-dep.stopForwarding:
+			for {
+				select {
+				case msg := <-dep.progress:
+					add(msg)
+				default:
+					flush()
+					return
+				}
 			}
 		}
 	}
 }
 
-func (dep *Deployer) manageDeployIo(reader io.Reader) {
+// manageDeployIo forwards the lines read to the progress room, and
+// closes `done` at the end.
+func (dep *Deployer) manageDeployIo(reader io.Reader, done chan bool) {
+	defer close(done)
 	scanner := bufio.NewScanner(reader)
 	for scanner.Scan() {
-		if dep.runningJob == nil {
-			continue
-		}
 		dep.progress <- scanner.Text()
 	}
 }
 
+// launchAnnouncement describes the deploy being launched, for the
+// announce room.
+func (dep *Deployer) launchAnnouncement(job *DeployJob, branch string) *plotbot.RichMessage {
+	params := job.params
+	text := fmt.Sprintf("[deployer] Launching: %s, monitor in %s",
+		params, job.config.ProgressRoom)
+
+	what := "deploy"
+	if params.Playbook != "" {
+		what = fmt.Sprintf("playbook `%s`", params.Playbook)
+	}
+
+	fields := []string{
+		"*Service*\n" + params.Service,
+		"*Environment*\n" + params.Environment,
+		"*Branch*\n" + branch,
+		"*Initiated by*\n" + params.InitiatedBy,
+	}
+	if params.Tags != "" {
+		fields = append(fields, "*Tags*\n"+params.Tags)
+	}
+
+	return plotbot.NewRichMessage(text).
+		WithColor("#447bdc").
+		Section(fmt.Sprintf("*Launching %s*", what)).
+		Fields(fields...).
+		Context(fmt.Sprintf("Monitor in %s", job.config.ProgressRoom))
+}
+
 func (dep *Deployer) replyPersonnally(params *DeployParams, msg string) {
 	if params.initiatedByChat == nil {
 		return
@@ -515,13 +990,27 @@ func (dep *Deployer) replyPersonnally(params *DeployParams, msg string) {
 	dep.bot.ReplyMention(params.initiatedByChat, msg)
 }
 
+// replyInThread is like replyPersonnally, but keeps the exchange in a
+// thread under the initiating message.
+func (dep *Deployer) replyInThread(params *DeployParams, msg string) {
+	if params.initiatedByChat == nil {
+		return
+	}
+	chat := params.initiatedByChat
+	dep.bot.ReplyInThread(chat, chat.AtMentionIfPublic(msg))
+}
+
 func (dep *Deployer) getCompareUrl(env, branch, path string) string {
 	itp := filepath.Join(path, "tools/in_the_pipe")
 	if _, err := os.Stat(itp); os.IsNotExist(err) {
 		return ""
 	}
 
-	intConf, exists := (*dep.internal.Config)[env]
+	dep.lock.Lock()
+	internalAPI := dep.internal
+	dep.lock.Unlock()
+
+	intConf, exists := (*internalAPI.Config)[env]
 	if !exists {
 		return ""
 	}
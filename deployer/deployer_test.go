@@ -1,19 +1,25 @@
 package deployer
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path"
 	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/plotly/plotbot"
 	"github.com/plotly/plotbot/internal"
+	"github.com/plotly/plotbot/reminders"
 	"github.com/plotly/plotbot/testutils"
 	"github.com/plotly/plotbot/util"
+	"github.com/slack-go/slack"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -61,7 +67,7 @@ func newTestDep(dconf DeployerConfig, bot plotbot.BotLike, runner Runnable) *Dep
 		Config: &iconf,
 	}
 
-	return &Deployer{
+	dep := &Deployer{
 		config:         &defaultdconf,
 		bot:            bot,
 		runner:         runner,
@@ -69,6 +75,8 @@ func newTestDep(dconf DeployerConfig, bot plotbot.BotLike, runner Runnable) *Dep
 		confirmTimeout: TEST_CONFIRM_TIMEOUT,
 		internal:       &iapi,
 	}
+	dep.setupCommands()
+	return dep
 }
 
 func defaultTestDep(cmdDelay time.Duration) *Deployer {
@@ -90,34 +98,63 @@ func defaultTestDep(cmdDelay time.Duration) *Deployer {
 		})
 }
 
+// captureProgress returns the progress of the running job once it is
+// over, or of the job that just ended.  It fails if no job ends before
+// `waitTime`.
 func captureProgress(dep *Deployer, waitTime time.Duration) (util.Searchable, error) {
-
 	timer := time.NewTimer(waitTime)
-	done := make(chan bool, 2)
+	defer timer.Stop()
+
+	dep.lock.Lock()
+	job := dep.runningJob
+	dep.lock.Unlock()
+
+	var done chan bool
+	if job != nil {
+		done = job.done
+	} else if progress := readProgress(dep, util.Searchable{}); len(progress) > 0 {
+		return progress, nil
+	}
+
 	progress := util.Searchable{}
 	for {
 		select {
 		case <-timer.C:
 			return progress, fmt.Errorf("timer expired without progress")
 		case <-done:
-			return progress, nil
+			return readProgress(dep, progress), nil
 		case p := <-dep.progress:
 			progress = append(progress, p)
+		}
+	}
+}
 
-			// if we get some progress we can assume runningJob is active
-			// and if it subsequently becomes nil we can assume the job is
-			// complete and we can finish waiting for progress.
-			if len(progress) == 1 {
-				go func() {
-					ticker := time.NewTicker(time.Millisecond * 100)
-					for _ = range ticker.C {
-						if dep.runningJob == nil {
-							ticker.Stop()
-							done <- true
-						}
-					}
-				}()
+// readProgress appends the progress already sent to `progress`.
+func readProgress(dep *Deployer, progress util.Searchable) util.Searchable {
+	for {
+		select {
+		case p := <-dep.progress:
+			progress = append(progress, p)
+		default:
+			return progress
+		}
+	}
+}
+
+// waitForProgress reads the progress up to a line containing `text`,
+// and returns the lines read.
+func waitForProgress(t *testing.T, dep *Deployer, text string) util.Searchable {
+	timeout := time.After(5 * time.Second)
+	progress := util.Searchable{}
+	for {
+		select {
+		case p := <-dep.progress:
+			progress = append(progress, p)
+			if strings.Contains(p, text) {
+				return progress
 			}
+		case <-timeout:
+			t.Fatalf("expected progress with %q, got %s", text, progress.String())
 		}
 	}
 }
@@ -134,12 +171,7 @@ func TestCmdProcess(t *testing.T) {
 		return
 	}
 
-	delay := os.Getenv("GO_CMD_PROCESS_DELAY")
-	i, err := strconv.Atoi(delay)
-	if err == nil {
-		time.Sleep(time.Second * time.Duration(i))
-	}
-
+	// Tests wait for the working directory to know the process runs.
 	cwd, err := os.Getwd()
 	if err == nil {
 		fmt.Printf("GO_CMD_WD=%s\n", cwd)
@@ -147,6 +179,12 @@ func TestCmdProcess(t *testing.T) {
 		fmt.Printf("Error determining working directory: %s\n", err)
 	}
 
+	delay := os.Getenv("GO_CMD_PROCESS_DELAY")
+	i, err := strconv.Atoi(delay)
+	if err == nil {
+		time.Sleep(time.Second * time.Duration(i))
+	}
+
 	output := os.Getenv("GO_CMD_PROCESS_OUTPUT")
 	if output != "" {
 		fmt.Println(output)
@@ -228,6 +266,74 @@ func TestStageDeploy(t *testing.T) {
 	if actual != expected {
 		t.Errorf("expected '%s' but found '%s'", expected, actual)
 	}
+
+	if len(bot.TestRichNotifies) != 1 {
+		t.Fatalf("expected 1 announcement found %d", len(bot.TestRichNotifies))
+	}
+	announce := bot.TestRichNotifies[0]
+	if !strings.Contains(announce.Text, "[deployer] Launching: service=streambed env=stage") {
+		t.Errorf("unexpected announcement fallback text '%s'", announce.Text)
+	}
+	if len(announce.Blocks) != 3 || announce.Color != "#447bdc" {
+		t.Errorf("expected a colored announcement with 3 blocks, got %d", len(announce.Blocks))
+	}
+}
+
+func TestDeployMetrics(t *testing.T) {
+	dep := defaultTestDep(time.Second)
+	metrics := plotbot.NewMetrics()
+	dep.InitMetrics(metrics.Namespace("deployer"))
+
+	for _, text := range []string{"deploy to stage", "deploy to invalid stage"} {
+		dep.ChatHandler(&plotbot.Conversation{Bot: dep.bot}, testutils.ToBotMsg(dep.bot, text))
+		if _, err := captureProgress(dep, time.Second*2); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected := []string{
+		`plotbot_deployer_jobs_total{service="streambed",environment="stage",outcome="success"} 1`,
+		`plotbot_deployer_jobs_total{service="unknown",environment="stage",outcome="aborted"} 1`,
+		`plotbot_deployer_job_duration_seconds_count{service="streambed",environment="stage",outcome="success"} 1`,
+	}
+	// The jobs are counted before they are over.
+	var text bytes.Buffer
+	metrics.WriteTo(&text)
+	if !util.Searchable(strings.Split(text.String(), "\n")).ContainsAll(expected...) {
+		t.Errorf("expected the jobs to be counted, got:\n%s", text.String())
+	}
+}
+
+func TestDeployAudit(t *testing.T) {
+	dep := defaultTestDep(time.Second)
+	audit, err := plotbot.NewAuditLog(plotbot.NewMemoryStore(), plotbot.AuditConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dep.InitAudit(audit.For("deployer"))
+
+	for _, text := range []string{"lock deployment", "unlock deployment", "deploy to stage"} {
+		dep.ChatHandler(&plotbot.Conversation{Bot: dep.bot}, testutils.ToBotMsg(dep.bot, text))
+	}
+	if _, err := captureProgress(dep, time.Second*2); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"deployer lock",
+		"deployer unlock locked_by=" + testutils.DefaultFromUser,
+		"deployer deploy (started) environment=stage service=streambed tags=updt_streambed",
+		"deployer deploy (success) duration=",
+	}
+	// The outcome of the job is recorded before it is over.
+	records, err := audit.Query(plotbot.AuditQuery{})
+	actual := util.Searchable{}
+	for _, record := range records {
+		actual = append(actual, record.String())
+	}
+	if err != nil || len(actual) != len(expected) || !actual.ContainsAll(expected...) {
+		t.Errorf("expected the privileged actions to be recorded, got %s, %v", actual.String(), err)
+	}
 }
 
 func TestProdDeployWithTags(t *testing.T) {
@@ -418,6 +524,127 @@ func TestLockUnlock(t *testing.T) {
 	}
 }
 
+func TestStopInterruptsRunningJob(t *testing.T) {
+	dep := defaultTestDep(time.Second * 5)
+
+	dep.ChatHandler(&plotbot.Conversation{Bot: dep.bot},
+		testutils.ToBotMsg(dep.bot, "deploy to stage"))
+	waitForProgress(t, dep, "GO_CMD_WD")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if err := dep.Stop(ctx); err == nil {
+		t.Error("expected Stop to report the interrupted job")
+	}
+
+	progress, err := captureProgress(dep, time.Second*4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectContain := util.Searchable{
+		"Interrupting the job, the bot is shutting down",
+		"terminated with error: signal: interrupt",
+	}
+	if !progress.ContainsAll(expectContain...) {
+		t.Errorf("expected progress %s to contain all of %s", progress.String(),
+			expectContain.String())
+	}
+
+	// No new job is started while stopping
+	bot := dep.bot.(*testutils.MockBot)
+	testutils.ClearMockBot(bot)
+	dep.ChatHandler(&plotbot.Conversation{Bot: dep.bot},
+		testutils.ToBotMsg(dep.bot, "deploy to stage"))
+
+	if len(bot.TestReplies) != 1 || !strings.Contains(bot.TestReplies[0].Text, "shutting down") {
+		t.Errorf("expected the deploy to be refused, got %d replies", len(bot.TestReplies))
+	}
+}
+
+func TestStopWaitsForRunningJob(t *testing.T) {
+	dep := defaultTestDep(time.Second)
+
+	dep.ChatHandler(&plotbot.Conversation{Bot: dep.bot},
+		testutils.ToBotMsg(dep.bot, "deploy to stage"))
+	waitForProgress(t, dep, "GO_CMD_WD")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	if err := dep.Stop(ctx); err != nil {
+		t.Error(err)
+	}
+
+	progress, err := captureProgress(dep, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !progress.ContainsAll("terminated successfully") {
+		t.Errorf("expected progress %s to show the job finished", progress.String())
+	}
+}
+
+func TestStopAbortsPullingJob(t *testing.T) {
+	dep := newTestDep(
+		DeployerConfig{},
+		testutils.NewDefaultMockBot(),
+		&testutils.MockRunner{
+			ParseVars: func(c string, s ...string) []string {
+				if c == "git" {
+					return []string{"GO_CMD_PROCESS_DELAY=1"}
+				}
+				return []string{}
+			},
+		})
+
+	dep.ChatHandler(&plotbot.Conversation{Bot: dep.bot},
+		testutils.ToBotMsg(dep.bot, "deploy to stage"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := dep.Stop(ctx); err != nil {
+		t.Error(err)
+	}
+
+	// Stop returns once the job is over, without starting ansible.
+	progress, err := captureProgress(dep, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !progress.ContainsAll("the bot is shutting down. Aborting.") {
+		t.Errorf("expected progress %s to show the job aborted", progress.String())
+	}
+	runner := dep.runner.(*testutils.MockRunner)
+	for _, job := range runner.Jobs {
+		if job.Contains("ansible-playbook") {
+			t.Errorf("expected ansible not to run, got %s", job.String())
+		}
+	}
+}
+
+func TestStopEndsForwarding(t *testing.T) {
+	dep := defaultTestDep(time.Second * 0)
+	dep.startForwarding()
+	dep.pubLine("[deployer] last words")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := dep.Stop(ctx); err != nil {
+		t.Error(err)
+	}
+
+	select {
+	case <-dep.forwardingDone:
+	default:
+		t.Fatal("expected the progress forwarding to be over")
+	}
+	bot := dep.bot.(*testutils.MockBot)
+	if len(bot.TestReplies) != 1 || bot.TestReplies[0].To != "#deploy" ||
+		!strings.Contains(bot.TestReplies[0].Text, "last words") {
+		t.Errorf("expected the last progress to be forwarded, got %v", bot.TestReplies)
+	}
+}
+
 func TestCancelDeploy(t *testing.T) {
 
 	// set up for long running deploy
@@ -426,16 +653,18 @@ func TestCancelDeploy(t *testing.T) {
 	dep.ChatHandler(&plotbot.Conversation{Bot: dep.bot},
 		testutils.ToBotMsg(dep.bot, "deploy to stage"))
 
-	time.Sleep(time.Millisecond * 500)
+	// wait for ansible to run
+	progress := waitForProgress(t, dep, "GO_CMD_WD")
 
 	fromUser := "rodoh"
 	dep.ChatHandler(&plotbot.Conversation{Bot: dep.bot},
 		testutils.ToBotMsgFromUser(dep.bot, "cancel deploy", fromUser))
 
-	progress, err := captureProgress(dep, time.Second*4)
+	rest, err := captureProgress(dep, time.Second*4)
 	if err != nil {
 		t.Fatal(err)
 	}
+	progress = append(progress, rest...)
 
 	expectContain := util.Searchable{
 		"ansible-playbook",
@@ -518,6 +747,37 @@ func TestJobAlreadyRunning(t *testing.T) {
 	}
 }
 
+func TestLockWhileDeploying(t *testing.T) {
+	dep := defaultTestDep(time.Second * 0)
+
+	// Run with -race: messages and interactions are handled on their
+	// own goroutines.
+	var wg sync.WaitGroup
+	for _, text := range []string{"lock deployment", "deploy to stage", "unlock deployment"} {
+		wg.Add(1)
+		go func(text string) {
+			defer wg.Done()
+			dep.ChatHandler(&plotbot.Conversation{Bot: dep.bot},
+				testutils.ToBotMsg(dep.bot, text))
+		}(text)
+	}
+	wg.Wait()
+
+	dep.lock.Lock()
+	job, lockedBy := dep.runningJob, dep.lockedBy
+	dep.lock.Unlock()
+	if job != nil {
+		select {
+		case <-job.done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("expected the job to end")
+		}
+	}
+	if lockedBy != "" && lockedBy != testutils.DefaultFromUser {
+		t.Errorf("unexpected lock by %q", lockedBy)
+	}
+}
+
 func TestHelp(t *testing.T) {
 	dep := defaultTestDep(time.Second)
 
@@ -540,6 +800,80 @@ func TestHelp(t *testing.T) {
 	}
 }
 
+func TestPushToHelp(t *testing.T) {
+	dep := defaultTestDep(time.Second)
+
+	dep.ChatHandler(&plotbot.Conversation{Bot: dep.bot},
+		testutils.ToBotMsg(dep.bot, "push to prod"))
+
+	bot := dep.bot.(*testutils.MockBot)
+	if len(bot.TestReplies) != 1 || !strings.Contains(bot.TestReplies[0].Text, "*Usage:* @mockbot: deploy") {
+		t.Errorf("expected the deploy help, got %v", bot.TestReplies)
+	}
+}
+
+func TestIgnoresOtherMentions(t *testing.T) {
+	dep := defaultTestDep(time.Second)
+
+	for _, text := range []string{
+		"standup is at 10",
+		"can you run the tests?",
+		"remind me in 2h to deploy the fix",
+		"did the deploy to prod work?",
+		"what runs on stage?",
+	} {
+		dep.ChatHandler(&plotbot.Conversation{Bot: dep.bot},
+			testutils.ToBotMsg(dep.bot, text))
+	}
+
+	bot := dep.bot.(*testutils.MockBot)
+	if len(bot.TestReplies) != 0 {
+		t.Errorf("expected no reply, got %q", bot.TestReplies[0].Text)
+	}
+	if dep.runningJob != nil {
+		t.Error("expected no job to start")
+	}
+}
+
+func TestCommandsWithReminders(t *testing.T) {
+	dep := defaultTestDep(time.Second)
+
+	bot := plotbot.New("")
+	scheduler, err := plotbot.NewScheduler(plotbot.NewMemoryStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	bot.Scheduler = scheduler
+	rem := &reminders.Reminders{}
+	rem.InitStore(plotbot.NewMemoryStore())
+	rem.InitPlugin(bot)
+
+	// Both plugins see every mention: only the one owning the command
+	// replies.
+	mock := dep.bot.(*testutils.MockBot)
+	for _, test := range []struct{ text, expected string }{
+		{"cancel deploy", "No deploy running"},
+		{"cancel reminder 1", "you have no reminder 1"},
+		{"cancel reminder soon", `<number> must be a number, not "soon"`},
+		{"remind me in 2h to deploy the fix", "OK, I'll remind you"},
+		{"remind me tomorrow to run the tests", "OK, I'll remind you"},
+	} {
+		testutils.ClearMockBot(mock)
+		conv := &plotbot.Conversation{Bot: dep.bot}
+		msg := testutils.ToBotMsg(dep.bot, test.text)
+		dep.ChatHandler(conv, msg)
+		rem.ChatHandler(conv, msg)
+
+		if len(mock.TestReplies) != 1 || !strings.Contains(mock.TestReplies[0].Text, test.expected) {
+			texts := make([]string, 0)
+			for _, reply := range mock.TestReplies {
+				texts = append(texts, reply.Text)
+			}
+			t.Errorf("%q: expected one reply with %q, got %q", test.text, test.expected, texts)
+		}
+	}
+}
+
 func TestAllowedProdBranches(t *testing.T) {
 	dep := defaultTestDep(time.Second * 0)
 
@@ -731,27 +1065,33 @@ func TestRunPlaybookConfirmationBlockingAndTimeout(t *testing.T) {
 		t.Fatalf("expected 0 job found %d", len(runner.Jobs))
 	}
 
+	// The timeout is replied once the confirmation is over.
 	bot := dep.bot.(*testutils.MockBot)
-	if len(bot.TestReplies) != 3 {
-		t.Fatalf("expected 3 replies found %d", len(bot.TestReplies))
+	replies := bot.WaitForReplies(3, 5*time.Second)
+	if len(replies) != 3 {
+		t.Fatalf("expected 3 replies found %d", len(replies))
 	}
 
-	actual := bot.TestReplies[0].Text
+	actual := replies[0].Text
 	expected := fmt.Sprintf("<@%s> This job requires confirmation. "+
 		"Confirm with '@%s: [yes|no]'",
 		testutils.DefaultFromUser, bot.Config.Nickname)
 	if !strings.Contains(actual, expected) {
 		t.Errorf("expected '%s' to contain '%s'", expected, actual)
 	}
+	if replies[0].ThreadTimestamp != testutils.DefaultTimestamp {
+		t.Errorf("expected confirmation to be asked in a thread, got %q",
+			replies[0].ThreadTimestamp)
+	}
 
-	actual = bot.TestReplies[1].Text
+	actual = replies[1].Text
 	expected = fmt.Sprintf("<@%s> waiting for confirmation from %s",
 		otherUser, testutils.DefaultFromUser)
 	if !strings.Contains(actual, expected) {
 		t.Errorf("expected '%s' to contain '%s'", expected, actual)
 	}
 
-	actual = bot.TestReplies[2].Text
+	actual = replies[2].Text
 	expected = fmt.Sprintf("<@%s> Did not receive confirmation in time. "+
 		"Cancelling job", testutils.DefaultFromUser)
 	if !strings.Contains(actual, expected) {
@@ -798,12 +1138,14 @@ func TestRunPlaybookConfirmationSuccess(t *testing.T) {
 			expectContain.String())
 	}
 
+	// The timeout is replied once the confirmation is over.
 	bot := dep.bot.(*testutils.MockBot)
-	if len(bot.TestReplies) != 3 {
-		t.Fatalf("expected 3 replies found %d", len(bot.TestReplies))
+	replies := bot.WaitForReplies(3, 5*time.Second)
+	if len(replies) != 3 {
+		t.Fatalf("expected 3 replies found %d", len(replies))
 	}
 
-	actual := bot.TestReplies[0].Text
+	actual := replies[0].Text
 	expected := fmt.Sprintf("<@%s> This job requires confirmation. "+
 		"Confirm with '@%s: [yes|no]'",
 		testutils.DefaultFromUser, bot.Config.Nickname)
@@ -825,6 +1167,117 @@ func TestRunPlaybookConfirmationSuccess(t *testing.T) {
 	}
 }
 
+func TestRunPlaybookConfirmationButtons(t *testing.T) {
+	dep := defaultTestDep(time.Second * 0)
+	playbook := CONFIRM_PLAYBOOKS[0]
+
+	dep.ChatHandler(&plotbot.Conversation{Bot: dep.bot},
+		testutils.ToBotMsg(dep.bot,
+			fmt.Sprintf("run %s on stage", playbook)))
+
+	time.Sleep(50 * time.Millisecond)
+
+	bot := dep.bot.(*testutils.MockBot)
+	callbackIDs := bot.CallbackIDs()
+	if len(callbackIDs) != 1 {
+		t.Fatalf("expected 1 interaction handler found %d", len(callbackIDs))
+	}
+	if len(bot.TestReplies) != 1 || len(bot.TestReplies[0].Blocks) != 2 {
+		t.Fatalf("expected the confirmation to come with buttons")
+	}
+
+	// a click by someone else is ignored
+	bot.Click(callbackIDs[0], "yes", &slack.User{ID: "rodoh", RealName: "rodoh"})
+	if len(bot.TestUpdates) != 0 {
+		t.Fatalf("expected no update found %d", len(bot.TestUpdates))
+	}
+
+	bot.Click(callbackIDs[0], "no",
+		&slack.User{ID: testutils.DefaultFromUser, RealName: testutils.DefaultFromUser})
+
+	_, err := captureProgress(dep, 500*time.Millisecond)
+	if err == nil {
+		t.Fatal("expected timeout error as we are expecting no progress")
+	}
+
+	if len(bot.TestUpdates) != 1 {
+		t.Fatalf("expected 1 update found %d", len(bot.TestUpdates))
+	}
+	actual := bot.TestUpdates[0].Text
+	expected := fmt.Sprintf("Cancelled by %s", testutils.DefaultFromUser)
+	if !strings.Contains(actual, expected) {
+		t.Errorf("expected '%s' to contain '%s'", actual, expected)
+	}
+
+	actual = bot.TestReplies[1].Text
+	expected = "ok cancelling..."
+	if !strings.Contains(actual, expected) {
+		t.Errorf("expected '%s' to contain '%s'", actual, expected)
+	}
+
+	if len(bot.CallbackIDs()) != 0 || dep.confirmJob != nil {
+		t.Error("expected the confirmation to be over")
+	}
+}
+
+func TestRunPlaybookConfirmedOnce(t *testing.T) {
+	dep := defaultTestDep(time.Second * 0)
+	playbook := CONFIRM_PLAYBOOKS[0]
+
+	dep.ChatHandler(&plotbot.Conversation{Bot: dep.bot},
+		testutils.ToBotMsg(dep.bot,
+			fmt.Sprintf("run %s on stage", playbook)))
+
+	bot := dep.bot.(*testutils.MockBot)
+	callbackIDs := bot.CallbackIDs()
+	if len(callbackIDs) != 1 {
+		t.Fatalf("expected 1 interaction handler found %d", len(callbackIDs))
+	}
+
+	// "no" is a word of its own, not part of another one
+	dep.ChatHandler(&plotbot.Conversation{Bot: dep.bot},
+		testutils.ToBotMsg(dep.bot, "I do not know"))
+	if len(bot.TestReplies) != 1 || len(bot.CallbackIDs()) != 1 {
+		t.Fatal("expected the confirmation to be still pending")
+	}
+
+	// Clicks and typed answers racing to confirm launch a single job.
+	// The user of a click may be missing from the directory, with only
+	// their ID.
+	var clicks sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		clicks.Add(1)
+		go func() {
+			defer clicks.Done()
+			bot.Click(callbackIDs[0], "yes", &slack.User{ID: testutils.DefaultFromUser})
+		}()
+	}
+	dep.ChatHandler(&plotbot.Conversation{Bot: dep.bot},
+		testutils.ToBotMsg(dep.bot, "yes"))
+	clicks.Wait()
+
+	if _, err := captureProgress(dep, time.Second*2); err != nil {
+		t.Fatal(err)
+	}
+
+	runner := dep.runner.(*testutils.MockRunner)
+	if len(runner.Jobs) != 3 {
+		t.Fatalf("expected 3 jobs found %d", len(runner.Jobs))
+	}
+	deploying := 0
+	for _, reply := range bot.TestReplies {
+		if strings.Contains(reply.Text, "deploying") {
+			deploying++
+		}
+	}
+	if deploying != 1 {
+		t.Errorf("expected a single deploy, got %d", deploying)
+	}
+	if len(bot.TestUpdates) > 1 {
+		t.Errorf("expected at most one click to be answered, got %d", len(bot.TestUpdates))
+	}
+}
+
 func TestRunHelp(t *testing.T) {
 	dep := defaultTestDep(time.Second)
 
@@ -915,3 +1368,43 @@ func TestGetCompareUrl(t *testing.T) {
 
 	assert.Equal(t, "https://pipeurl", dep.getCompareUrl("prod", "master", dir), "compare URL incorrect")
 }
+
+func TestReconfigure(t *testing.T) {
+	dep := defaultTestDep(time.Second)
+	load := func(config interface{}) error {
+		return json.Unmarshal([]byte(`{"Deployer": {
+			"announce_room": "#announce",
+			"progress_room": "#progress",
+			"services": {"plotbot": {"repository_path": "/plotbot"}}
+		}, "PlotlyInternalEndpoint": {"prod": {"base_url": "https://reloaded/"}}}`), config)
+	}
+
+	dep.ChatHandler(&plotbot.Conversation{Bot: dep.bot},
+		testutils.ToBotMsg(dep.bot, "deploy to stage"))
+	if err := dep.Reconfigure(load); err != nil {
+		t.Fatal(err)
+	}
+	config := dep.currentConfig()
+	if config.AnnounceRoom != "#announce" || config.Services["plotbot"].RepositoryPath != "/plotbot" {
+		t.Errorf("unexpected configuration %#v", config)
+	}
+	dep.lock.Lock()
+	internalConfig := *dep.internal.Config
+	dep.lock.Unlock()
+	if internalConfig["prod"] == nil || internalConfig["prod"].BaseURL != "https://reloaded/" {
+		t.Errorf("expected the internal API to be reloaded from the same configuration, got %#v", internalConfig)
+	}
+
+	// The running job keeps its configuration, where streambed is known.
+	progress, err := captureProgress(dep, 2*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !progress.Contains("terminated successfully") {
+		t.Errorf("expected the job to succeed, got %s", progress.String())
+	}
+	bot := dep.bot.(*testutils.MockBot)
+	if len(bot.TestNotifies) != 1 || bot.TestNotifies[0][0] != "#streambed" {
+		t.Errorf("expected the job to be announced in its room, got %v", bot.TestNotifies)
+	}
+}
@@ -17,6 +17,7 @@ type DeployParams struct {
 	Branch          string
 	Tags            string
 	InitiatedBy     string
+	InitiatedByID   string
 	From            string
 	initiatedByChat *plotbot.Message
 	Confirm         bool
@@ -35,6 +36,40 @@ func (p *DeployParams) String() string {
 	return str
 }
 
+// auditRecord returns the record of the job for the audit log: a
+// "deploy", or a "run" of a playbook, by whom launched it.
+func (p *DeployParams) auditRecord() plotbot.AuditRecord {
+	action := "deploy"
+	if p.Playbook != "" {
+		action = "run"
+	}
+	record := plotbot.AuditRecord{UserName: p.InitiatedBy, Action: action}
+	if p.initiatedByChat != nil {
+		record = plotbot.AuditRecordOf(p.initiatedByChat, action)
+	}
+	record.Params = p.auditParams()
+	return record
+}
+
+// auditParams returns the parameters of the job, for the audit log.
+// Playbooks asking for confirmation are marked dangerous.
+func (p *DeployParams) auditParams() map[string]string {
+	params := map[string]string{"service": p.Service, "environment": p.Environment}
+	if p.Playbook != "" {
+		params["playbook"] = p.Playbook
+	}
+	if p.Branch != "" {
+		params["branch"] = p.Branch
+	}
+	if p.Tags != "" {
+		params["tags"] = p.Tags
+	}
+	if p.Confirm {
+		params["dangerous"] = "true"
+	}
+	return params
+}
+
 var playbookRegex = regexp.MustCompile(`^playbook_(stage|prod)_(.*).yml$`)
 
 type Playbook struct {
@@ -0,0 +1,506 @@
+package plotbot
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// DirectorySource fetches users and channels for a Directory.  Every
+// Adapter is one.
+type DirectorySource interface {
+	GetUsers() ([]slack.User, error)
+	GetChannels() ([]slack.Channel, error)
+	GetUser(id string) (*slack.User, error)
+	GetChannel(id string) (*slack.Channel, error)
+}
+
+// missRetryDelay is how long an ID that could not be fetched is
+// considered unknown, so lookups of bots or stale IDs don't hit the API
+// on every message.
+const missRetryDelay = time.Minute
+
+// Directory caches the team's users and channels, indexed by ID, name,
+// email and real name.  It is safe for concurrent use: the Bot updates
+// it from incoming events, while plugins read it from their own
+// goroutines.
+//
+// Lookups by ID fetch unknown users and channels from the source, and
+// `Refresh()` reloads everything.  Returned values are copies.
+//
+// Channel memberships start from the `Members` of the channels, and
+// are then kept up to date with `AddMember()` and `RemoveMember()`.
+// The memberships recorded while `Refresh()` fetches the channels are
+// replayed over the fetched ones, which may predate them.
+type Directory struct {
+	mu     sync.RWMutex
+	source DirectorySource
+	logger *Logger
+
+	users           map[string]slack.User
+	usersByName     map[string]string
+	usersByEmail    map[string]string
+	usersByRealName map[string]string
+
+	channels       map[string]slack.Channel
+	channelsByName map[string]string
+	userChannels   map[string]map[string]bool
+
+	// refreshing counts the refreshes fetching channels, during which
+	// the membership changes are journaled.
+	refreshing int
+	journal    []memberChange
+
+	misses map[string]time.Time
+}
+
+// memberChange is a user joining or leaving a channel.
+type memberChange struct {
+	channelID string
+	userID    string
+	joined    bool
+}
+
+func NewDirectory(source DirectorySource) *Directory {
+	d := &Directory{source: source, logger: defaultLogger.With("component", "directory")}
+	d.SetUsers(nil)
+	d.SetChannels(nil)
+	return d
+}
+
+// SetSource changes where unknown users and channels are fetched from.
+func (d *Directory) SetSource(source DirectorySource) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.source = source
+}
+
+// Refresh reloads all users and channels from the source.  A list that
+// fails to load is kept as it was.
+func (d *Directory) Refresh() error {
+	source := d.getSource()
+	if source == nil {
+		return nil
+	}
+
+	users, userErr := source.GetUsers()
+	if userErr != nil {
+		d.logger.Error("Error fetching users", "err", userErr)
+	} else {
+		d.SetUsers(users)
+	}
+
+	d.mu.Lock()
+	d.refreshing++
+	d.mu.Unlock()
+
+	channels, err := source.GetChannels()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.refreshing--
+	if err == nil {
+		d.setChannels(channels)
+		for _, change := range d.journal {
+			d.changeMember(change)
+		}
+	}
+	if d.refreshing == 0 {
+		d.journal = nil
+	}
+
+	if err != nil {
+		d.logger.Error("Error fetching channels", "err", err)
+		return err
+	}
+	return userErr
+}
+
+// RefreshEvery calls `Refresh()` every `interval`, until `ctx` is done.
+func (d *Directory) RefreshEvery(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			d.Refresh()
+		}
+	}
+}
+
+// SetUsers replaces all the cached users.
+func (d *Directory) SetUsers(users []slack.User) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.users = make(map[string]slack.User, len(users))
+	d.usersByName = make(map[string]string, len(users))
+	d.usersByEmail = make(map[string]string, len(users))
+	d.usersByRealName = make(map[string]string, len(users))
+	for _, user := range users {
+		d.putUser(user)
+	}
+}
+
+// SetChannels replaces all the cached channels.
+func (d *Directory) SetChannels(channels []slack.Channel) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.setChannels(channels)
+}
+
+func (d *Directory) setChannels(channels []slack.Channel) {
+	d.channels = make(map[string]slack.Channel, len(channels))
+	d.channelsByName = make(map[string]string, len(channels))
+	d.userChannels = make(map[string]map[string]bool)
+	for _, channel := range channels {
+		d.putChannel(channel)
+	}
+}
+
+// PutUser adds or replaces a user.
+func (d *Directory) PutUser(user slack.User) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.putUser(user)
+}
+
+// PutChannel adds or replaces a channel.
+func (d *Directory) PutChannel(channel slack.Channel) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.putChannel(channel)
+}
+
+// UpdateUser applies `update` to a cached user, and reports whether it
+// was found.
+func (d *Directory) UpdateUser(id string, update func(*slack.User)) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	user, ok := d.users[id]
+	if !ok {
+		return false
+	}
+	update(&user)
+	d.putUser(user)
+	return true
+}
+
+// UpdateChannel applies `update` to a cached channel, and reports
+// whether it was found.
+func (d *Directory) UpdateChannel(id string, update func(*slack.Channel)) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	channel, ok := d.channels[id]
+	if !ok {
+		return false
+	}
+	update(&channel)
+	d.putChannel(channel)
+	return true
+}
+
+// DeleteChannel forgets a channel.
+func (d *Directory) DeleteChannel(id string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.unindexChannel(id)
+	delete(d.channels, id)
+}
+
+// AddMember records that a user joined a channel.  Unknown channels
+// are fetched first.
+func (d *Directory) AddMember(channelID, userID string) {
+	change := memberChange{channelID: channelID, userID: userID, joined: true}
+	if !d.recordMember(change) && d.Channel(channelID) != nil {
+		d.recordMember(change)
+	}
+}
+
+// RemoveMember records that a user left a channel.
+func (d *Directory) RemoveMember(channelID, userID string) {
+	d.recordMember(memberChange{channelID: channelID, userID: userID})
+}
+
+// recordMember applies a membership change, journaling it during
+// refreshes, and reports whether the channel was found.
+func (d *Directory) recordMember(change memberChange) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.refreshing > 0 {
+		d.journal = append(d.journal, change)
+	}
+	return d.changeMember(change)
+}
+
+// ChannelMembers returns the IDs of the members of a channel, sorted.
+func (d *Directory) ChannelMembers(channelID string) []string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	members := append([]string{}, d.channels[channelID].Members...)
+	sort.Strings(members)
+	return members
+}
+
+// UserChannels returns the IDs of the channels a user is a member of,
+// sorted.
+func (d *Directory) UserChannels(userID string) []string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	channels := make([]string, 0, len(d.userChannels[userID]))
+	for channelID := range d.userChannels[userID] {
+		channels = append(channels, channelID)
+	}
+	sort.Strings(channels)
+	return channels
+}
+
+// IsMember reports whether a user is a member of a channel.
+func (d *Directory) IsMember(channelID, userID string) bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.userChannels[userID][channelID]
+}
+
+// User returns a user by ID, fetching it if it isn't known yet.
+func (d *Directory) User(id string) *slack.User {
+	if user := d.cachedUser(id); user != nil {
+		return user
+	}
+
+	source := d.getSource()
+	if source == nil || !d.shouldFetch(id) {
+		return nil
+	}
+	user, err := source.GetUser(id)
+	if err != nil || user == nil {
+		d.logger.Warn("Error fetching user", "user", id, "err", err)
+		d.miss(id)
+		return nil
+	}
+	d.PutUser(*user)
+	return d.cachedUser(id)
+}
+
+// Channel returns a channel by ID, fetching it if it isn't known yet.
+func (d *Directory) Channel(id string) *slack.Channel {
+	if channel := d.cachedChannel(id); channel != nil {
+		return channel
+	}
+
+	source := d.getSource()
+	if source == nil || !d.shouldFetch(id) {
+		return nil
+	}
+	channel, err := source.GetChannel(id)
+	if err != nil || channel == nil {
+		d.logger.Warn("Error fetching channel", "channel", id, "err", err)
+		d.miss(id)
+		return nil
+	}
+	d.PutChannel(*channel)
+	return d.cachedChannel(id)
+}
+
+// FindUser returns a user by ID, Name, RealName or Email.  Emails are
+// matched regardless of case.
+func (d *Directory) FindUser(find string) *slack.User {
+	d.mu.RLock()
+	id, ok := d.usersByEmail[strings.ToLower(find)]
+	if !ok {
+		id, ok = d.usersByName[find]
+	}
+	if !ok {
+		id, ok = d.usersByRealName[find]
+	}
+	d.mu.RUnlock()
+
+	if ok {
+		return d.cachedUser(id)
+	}
+	return d.User(find)
+}
+
+// ChannelByName returns a channel by name, with or without its `#`.
+func (d *Directory) ChannelByName(name string) *slack.Channel {
+	d.mu.RLock()
+	id, ok := d.channelsByName[strings.TrimLeft(name, "#")]
+	d.mu.RUnlock()
+
+	if !ok {
+		return nil
+	}
+	return d.cachedChannel(id)
+}
+
+// Users returns all the cached users.
+func (d *Directory) Users() []slack.User {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	users := make([]slack.User, 0, len(d.users))
+	for _, user := range d.users {
+		users = append(users, user)
+	}
+	return users
+}
+
+// Channels returns all the cached channels.
+func (d *Directory) Channels() []slack.Channel {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	channels := make([]slack.Channel, 0, len(d.channels))
+	for _, channel := range d.channels {
+		channels = append(channels, channel)
+	}
+	return channels
+}
+
+func (d *Directory) getSource() DirectorySource {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.source
+}
+
+func (d *Directory) cachedUser(id string) *slack.User {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	user, ok := d.users[id]
+	if !ok {
+		return nil
+	}
+	return &user
+}
+
+func (d *Directory) cachedChannel(id string) *slack.Channel {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	channel, ok := d.channels[id]
+	if !ok {
+		return nil
+	}
+	return &channel
+}
+
+func (d *Directory) shouldFetch(id string) bool {
+	if id == "" {
+		return false
+	}
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return time.Since(d.misses[id]) > missRetryDelay
+}
+
+func (d *Directory) miss(id string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.misses == nil {
+		d.misses = make(map[string]time.Time)
+	}
+	d.misses[id] = time.Now()
+}
+
+// changeMember must be called with the lock held, like `setChannels()`
+// and the other index maintenance methods below.
+func (d *Directory) changeMember(change memberChange) bool {
+	channel, ok := d.channels[change.channelID]
+	if !ok {
+		return false
+	}
+	// Copy, as the slice is shared with the channels handed out
+	members := make([]string, 0, len(channel.Members)+1)
+	for _, member := range channel.Members {
+		if member != change.userID {
+			members = append(members, member)
+		}
+	}
+	if change.joined {
+		members = append(members, change.userID)
+	}
+	channel.Members = members
+	channel.NumMembers = len(members)
+	d.putChannel(channel)
+	return true
+}
+
+// putUser must be called with the lock held, like the other index
+// maintenance methods below.
+func (d *Directory) putUser(user slack.User) {
+	d.unindexUser(user.ID)
+	d.users[user.ID] = user
+	delete(d.misses, user.ID)
+
+	if user.Name != "" {
+		d.usersByName[user.Name] = user.ID
+	}
+	if user.RealName != "" {
+		d.usersByRealName[user.RealName] = user.ID
+	}
+	if user.Profile.Email != "" {
+		d.usersByEmail[strings.ToLower(user.Profile.Email)] = user.ID
+	}
+}
+
+func (d *Directory) unindexUser(id string) {
+	old, ok := d.users[id]
+	if !ok {
+		return
+	}
+	if d.usersByName[old.Name] == id {
+		delete(d.usersByName, old.Name)
+	}
+	if d.usersByRealName[old.RealName] == id {
+		delete(d.usersByRealName, old.RealName)
+	}
+	email := strings.ToLower(old.Profile.Email)
+	if d.usersByEmail[email] == id {
+		delete(d.usersByEmail, email)
+	}
+}
+
+func (d *Directory) putChannel(channel slack.Channel) {
+	d.unindexChannel(channel.ID)
+	d.channels[channel.ID] = channel
+	delete(d.misses, channel.ID)
+
+	if channel.Name != "" {
+		d.channelsByName[channel.Name] = channel.ID
+	}
+	for _, member := range channel.Members {
+		if d.userChannels[member] == nil {
+			d.userChannels[member] = make(map[string]bool)
+		}
+		d.userChannels[member][channel.ID] = true
+	}
+}
+
+func (d *Directory) unindexChannel(id string) {
+	old, ok := d.channels[id]
+	if !ok {
+		return
+	}
+	if d.channelsByName[old.Name] == id {
+		delete(d.channelsByName, old.Name)
+	}
+	for _, member := range old.Members {
+		delete(d.userChannels[member], id)
+		if len(d.userChannels[member]) == 0 {
+			delete(d.userChannels, member)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package plotbot
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/slack-go/slack"
+)
+
+func TestDirectoryIndexes(t *testing.T) {
+	d := NewDirectory(nil)
+	user := slack.User{ID: "U1", Name: "hodor", RealName: "Hodor Hodor"}
+	user.Profile.Email = "Hodor@plot.ly"
+	d.SetUsers([]slack.User{user})
+
+	for _, find := range []string{"U1", "hodor", "Hodor Hodor", "hodor@plot.ly"} {
+		if found := d.FindUser(find); found == nil || found.ID != "U1" {
+			t.Errorf("expected to find U1 with %q, got %v", find, found)
+		}
+	}
+
+	d.UpdateUser("U1", func(user *slack.User) {
+		user.Name = "wylis"
+	})
+	if d.FindUser("hodor") != nil {
+		t.Error("expected the old name to be unindexed")
+	}
+	if found := d.FindUser("wylis"); found == nil || found.ID != "U1" {
+		t.Error("expected the new name to be indexed")
+	}
+
+	// Returned users are copies
+	d.FindUser("U1").Name = "changed"
+	if d.User("U1").Name != "wylis" {
+		t.Error("expected the cache to be left untouched")
+	}
+}
+
+func TestDirectoryLazyFetch(t *testing.T) {
+	adapter := newFakeAdapter()
+	adapter.users = []slack.User{{ID: "U1", Name: "hodor"}}
+	adapter.channels = []slack.Channel{{
+		GroupConversation: slack.GroupConversation{
+			Conversation: slack.Conversation{ID: "C1"},
+			Name:         "general",
+		},
+	}}
+	d := NewDirectory(adapter)
+
+	if user := d.User("U1"); user == nil || user.Name != "hodor" {
+		t.Fatalf("expected U1 to be fetched, got %v", user)
+	}
+	if channel := d.Channel("C1"); channel == nil || channel.Name != "general" {
+		t.Fatalf("expected C1 to be fetched, got %v", channel)
+	}
+	d.User("U1")
+	d.User("U2")
+	d.User("U2")
+	if adapter.fetches != 3 {
+		t.Errorf("expected 3 fetches, got %d", adapter.fetches)
+	}
+	if d.ChannelByName("general") == nil {
+		t.Error("expected fetched channels to be indexed")
+	}
+
+	adapter.users = append(adapter.users, slack.User{ID: "U2", Name: "bran"})
+	if err := d.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if d.FindUser("bran") == nil || len(d.Users()) != 2 {
+		t.Error("expected refresh to load all users")
+	}
+}
+
+func TestDirectoryConcurrentAccess(t *testing.T) {
+	d := NewDirectory(nil)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			d.PutUser(slack.User{ID: "U1", Name: "hodor"})
+			d.UpdateUser("U1", func(user *slack.User) { user.Presence = "away" })
+		}()
+		go func() {
+			defer wg.Done()
+			d.FindUser("hodor")
+			d.Users()
+		}()
+	}
+	wg.Wait()
+}
+
+func TestDirectoryMemberships(t *testing.T) {
+	bot := &Bot{Directory: NewDirectory(nil)}
+	bot.Directory.SetChannels([]slack.Channel{{
+		GroupConversation: slack.GroupConversation{
+			Conversation: slack.Conversation{ID: "C1"},
+			Name:         "general",
+			Members:      []string{"U2", "U1"},
+		},
+	}, {
+		GroupConversation: slack.GroupConversation{
+			Conversation: slack.Conversation{ID: "C2"},
+			Name:         "ops",
+			Members:      []string{"U1"},
+		},
+	}})
+
+	if members := bot.ChannelMembers("C1"); !reflect.DeepEqual(members, []string{"U1", "U2"}) {
+		t.Errorf("unexpected members %v", members)
+	}
+	if channels := bot.UserChannels("U1"); !reflect.DeepEqual(channels, []string{"C1", "C2"}) {
+		t.Errorf("unexpected channels %v", channels)
+	}
+
+	bot.handleEvent(&MemberJoinedEvent{ChannelID: "C2", UserID: "U3"})
+	bot.handleEvent(&MemberJoinedEvent{ChannelID: "C2", UserID: "U3"})
+	bot.handleEvent(&MemberLeftEvent{ChannelID: "C1", UserID: "U1"})
+
+	if members := bot.ChannelMembers("C2"); !reflect.DeepEqual(members, []string{"U1", "U3"}) {
+		t.Errorf("unexpected members after join %v", members)
+	}
+	if channels := bot.UserChannels("U1"); !reflect.DeepEqual(channels, []string{"C2"}) {
+		t.Errorf("unexpected channels after leave %v", channels)
+	}
+	if !bot.Directory.IsMember("C2", "U3") || bot.Directory.IsMember("C1", "U1") {
+		t.Error("unexpected memberships")
+	}
+
+	// Renames keep the memberships
+	bot.handleEvent(&ChannelRenameEvent{ChannelID: "C2", Name: "devops"})
+	if !bot.Directory.IsMember("C2", "U3") {
+		t.Error("expected memberships to survive a rename")
+	}
+
+	bot.handleEvent(&ChannelDeleteEvent{ChannelID: "C2"})
+	if channels := bot.UserChannels("U3"); len(channels) != 0 {
+		t.Errorf("expected no channels left, got %v", channels)
+	}
+}
+
+// slowSource returns channels fetched before it is told to return them.
+type slowSource struct {
+	*fakeAdapter
+	fetching chan bool
+	proceed  chan bool
+}
+
+func (s *slowSource) GetChannels() ([]slack.Channel, error) {
+	channels := append([]slack.Channel{}, s.channels...)
+	s.fetching <- true
+	<-s.proceed
+	return channels, nil
+}
+
+func TestDirectoryMembershipsAndRefresh(t *testing.T) {
+	adapter := newFakeAdapter()
+	general := slack.Channel{}
+	general.ID, general.Name, general.Members = "C1", "general", []string{"U1"}
+	ops := slack.Channel{}
+	ops.ID, ops.Name, ops.Members = "C2", "ops", []string{"U1"}
+	adapter.channels = []slack.Channel{general}
+	source := &slowSource{adapter, make(chan bool), make(chan bool)}
+	bot := &Bot{Directory: NewDirectory(source)}
+	bot.Directory.SetChannels(adapter.channels)
+
+	// Joining a channel the bot doesn't know yet fetches it.
+	adapter.channels = append(adapter.channels, ops)
+	bot.handleEvent(&MemberJoinedEvent{ChannelID: "C2", UserID: "U2"})
+	if members := bot.ChannelMembers("C2"); !reflect.DeepEqual(members, []string{"U1", "U2"}) {
+		t.Errorf("expected the channel to be fetched, got members %v", members)
+	}
+
+	// Events recorded while a refresh fetches the channels are kept over
+	// the fetched channels, which don't have them yet.
+	done := make(chan bool)
+	go func() {
+		bot.Directory.Refresh()
+		close(done)
+	}()
+	<-source.fetching
+	bot.handleEvent(&MemberJoinedEvent{ChannelID: "C1", UserID: "U3"})
+	bot.handleEvent(&MemberLeftEvent{ChannelID: "C2", UserID: "U1"})
+	close(source.proceed)
+	<-done
+
+	if members := bot.ChannelMembers("C1"); !reflect.DeepEqual(members, []string{"U1", "U3"}) {
+		t.Errorf("expected the join to survive the refresh, got members %v", members)
+	}
+	if channels := bot.UserChannels("U1"); !reflect.DeepEqual(channels, []string{"C1"}) {
+		t.Errorf("expected the leave to survive the refresh, got channels %v", channels)
+	}
+}
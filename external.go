@@ -0,0 +1,538 @@
+package plotbot
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"sync"
+	"time"
+)
+
+//
+// External plugins
+//
+// An external plugin is an executable listed with a `command` in the
+// Plugins section.  The bot starts it, and they exchange JSON-RPC 2.0
+// messages, one per line, on the plugin's stdin and stdout.  Its
+// stderr goes to the bot's log.
+//
+// The bot sends these notifications:
+//
+//	{"jsonrpc": "2.0", "method": "init", "params": {"name": "jira", "config": {...}}}
+//	{"jsonrpc": "2.0", "method": "message", "params": {"channel": "C024BE91L",
+//	  "channel_name": "general", "user": "U024BE7LH", "user_name": "hodor",
+//	  "text": "<@U0BOT> hello", "timestamp": "1234.5678", "mentions_me": true,
+//	  "private": false}}
+//
+// `init` comes first after every start, with the plugin's `config`
+// setting.  `message` carries every message posted where the plugin is
+// allowed to listen, except the bot's own.
+//
+// The plugin calls these methods, as requests with an `id` to get a
+// response, or as notifications without one:
+//
+//	reply         {"channel": "C024BE91L", "text": "hi", "thread_timestamp": "", "color": ""}
+//	notify        {"channel": "#general", "text": "hi", "color": "good"}
+//	store.get     {"key": "last-seen"}           returns {"value": ...}
+//	store.put     {"key": "last-seen", "value": ...}
+//	store.delete  {"key": "last-seen"}
+//
+// `notify` takes channel names, with their `#`, or IDs.  Both methods
+// are refused with an invalid params error in channels the plugin may
+// not listen to.  The store keeps JSON values in the plugin's own
+// Store.
+//
+// The plugin is restarted when it exits, after a delay doubling with
+// each quick exit.  On shutdown its stdin is closed, and it is killed if
+// it didn't exit within the bot's `ShutdownTimeout`.
+
+// externalPolicy tunes the supervision of external plugins.
+type externalPolicy struct {
+	// restartDelay is the delay before restarting a plugin that
+	// exited, doubled after each restart up to maxRestartDelay.
+	restartDelay    time.Duration
+	maxRestartDelay time.Duration
+	// stableAfter is how long a plugin must run for the delay to be
+	// reset.
+	stableAfter time.Duration
+	// queueSize is the number of messages waiting for a plugin that is
+	// busy or restarting.  Further messages are dropped.
+	queueSize int
+}
+
+var defaultExternalPolicy = externalPolicy{
+	restartDelay:    time.Second,
+	maxRestartDelay: time.Minute,
+	stableAfter:     time.Minute,
+	queueSize:       100,
+}
+
+// maxExternalLine is the size of the longest line read from a plugin.
+const maxExternalLine = 1024 * 1024
+
+// JSON-RPC error codes.
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcInternalError  = -32603
+)
+
+// rpcMessage is a line of the protocol: a request, or a notification
+// without ID, when it has a Method, and a response otherwise.
+type rpcMessage struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  interface{}     `json:"params,omitempty"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (err *rpcError) Error() string {
+	return err.Message
+}
+
+// rpcRequest is an incoming rpcMessage, with its params left to decode.
+type rpcRequest struct {
+	ID     json.RawMessage `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+}
+
+// externalInit is sent to a plugin when it starts.
+type externalInit struct {
+	Name   string          `json:"name"`
+	Config json.RawMessage `json:"config,omitempty"`
+}
+
+// externalMessage is a chat message sent to a plugin.
+type externalMessage struct {
+	Channel         string `json:"channel"`
+	ChannelName     string `json:"channel_name,omitempty"`
+	User            string `json:"user"`
+	UserName        string `json:"user_name,omitempty"`
+	Text            string `json:"text"`
+	Timestamp       string `json:"timestamp"`
+	ThreadTimestamp string `json:"thread_timestamp,omitempty"`
+	MentionsMe      bool   `json:"mentions_me"`
+	Private         bool   `json:"private"`
+}
+
+// externalPost is the params of the `reply` and `notify` methods.
+type externalPost struct {
+	Channel         string `json:"channel"`
+	Text            string `json:"text"`
+	ThreadTimestamp string `json:"thread_timestamp"`
+	Color           string `json:"color"`
+}
+
+// externalStoreParams is the params of the `store.*` methods.
+type externalStoreParams struct {
+	Key   string          `json:"key"`
+	Value json.RawMessage `json:"value"`
+}
+
+// externalPlugin runs and supervises the process of an external
+// plugin.
+type externalPlugin struct {
+	name     string
+	settings PluginSettings
+	policy   externalPolicy
+	bot      *Bot
+	store    Store
+	logger   *Logger
+
+	queue  chan *rpcMessage
+	stopCh chan bool
+	done   chan bool
+
+	lock   sync.Mutex
+	conn   *externalConn
+	starts int
+	// channels holds the channel settings, which are reloaded with the
+	// configuration while the plugin runs.
+	channels PluginSettings
+}
+
+func newExternalPlugin(name string, settings PluginSettings) *externalPlugin {
+	return &externalPlugin{
+		name:     name,
+		settings: settings,
+		channels: settings,
+		policy:   defaultExternalPolicy,
+		logger:   defaultLogger.With("plugin", name),
+		stopCh:   make(chan bool),
+		done:     make(chan bool),
+	}
+}
+
+func (p *externalPlugin) InitPlugin(bot *Bot) {
+	p.bot = bot
+	p.queue = make(chan *rpcMessage, p.policy.queueSize)
+
+	bot.ListenFor(&Conversation{
+		HandlerFunc: func(conv *Conversation, msg *Message) {
+			p.handleMessage(msg)
+		},
+	})
+	go p.supervise()
+}
+
+func (p *externalPlugin) InitStore(store Store) {
+	p.store = store
+}
+
+// Stop closes the plugin's stdin, and kills it if it doesn't exit
+// before `ctx` is done.
+func (p *externalPlugin) Stop(ctx context.Context) error {
+	if p.bot == nil {
+		return nil
+	}
+
+	p.lock.Lock()
+	select {
+	case <-p.stopCh:
+	default:
+		close(p.stopCh)
+	}
+	if p.conn != nil {
+		p.conn.stdin.Close()
+	}
+	p.lock.Unlock()
+
+	select {
+	case <-p.done:
+		return nil
+	case <-ctx.Done():
+	}
+
+	p.lock.Lock()
+	if p.conn != nil {
+		p.conn.cmd.Process.Kill()
+	}
+	p.lock.Unlock()
+	<-p.done
+	return ctx.Err()
+}
+
+// handleMessage queues a message for the plugin.  It never blocks the
+// bot: messages are dropped when the queue is full.
+func (p *externalPlugin) handleMessage(msg *Message) {
+	params := &externalMessage{
+		Channel:         msg.Channel,
+		User:            msg.User,
+		Text:            msg.Text,
+		Timestamp:       msg.Timestamp,
+		ThreadTimestamp: msg.ThreadTimestamp,
+		MentionsMe:      msg.MentionsMe,
+		Private:         msg.IsPrivate(),
+	}
+	if msg.FromChannel != nil {
+		params.ChannelName = msg.FromChannel.Name
+	}
+	if msg.FromUser != nil {
+		params.UserName = msg.FromUser.Name
+	}
+
+	select {
+	case p.queue <- &rpcMessage{JSONRPC: "2.0", Method: "message", Params: params}:
+	default:
+		p.logger.Warn("External plugin is not keeping up, dropping message", "ts", msg.Timestamp)
+	}
+}
+
+// supervise runs the plugin until it is stopped, restarting it each
+// time it exits.
+func (p *externalPlugin) supervise() {
+	defer close(p.done)
+
+	delay := p.policy.restartDelay
+	for {
+		started := time.Now()
+		err := p.run()
+
+		select {
+		case <-p.stopCh:
+			p.logger.Info("External plugin stopped")
+			return
+		default:
+		}
+
+		if time.Since(started) >= p.policy.stableAfter {
+			delay = p.policy.restartDelay
+		}
+		p.logger.Warn("External plugin exited, restarting", "err", err, "delay", delay)
+
+		select {
+		case <-time.After(delay):
+		case <-p.stopCh:
+			return
+		}
+		delay *= 2
+		if delay > p.policy.maxRestartDelay {
+			delay = p.policy.maxRestartDelay
+		}
+	}
+}
+
+// run starts the plugin's process, and serves it until it exits.
+func (p *externalPlugin) run() error {
+	cmd := exec.Command(p.settings.Command, p.settings.Args...)
+	cmd.Env = os.Environ()
+	names := make([]string, 0, len(p.settings.Env))
+	for name := range p.settings.Env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		cmd.Env = append(cmd.Env, name+"="+p.settings.Env[name])
+	}
+	cmd.Stderr = p.logger.Writer(LevelInfo)
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	conn := &externalConn{plugin: p, cmd: cmd, stdin: stdin}
+	p.lock.Lock()
+	p.conn = conn
+	p.starts++
+	select {
+	case <-p.stopCh:
+		stdin.Close()
+	default:
+	}
+	p.lock.Unlock()
+	p.logger.Info("External plugin started", "pid", cmd.Process.Pid)
+
+	conn.send(&rpcMessage{JSONRPC: "2.0", Method: "init", Params: &externalInit{
+		Name:   p.name,
+		Config: p.settings.Config,
+	}})
+
+	exited := make(chan bool)
+	go conn.forward(p.queue, exited)
+
+	if err := conn.serve(stdout); err != nil {
+		p.logger.Error("External plugin error", "err", err)
+		cmd.Process.Kill()
+	}
+	close(exited)
+	stdin.Close()
+	err = cmd.Wait()
+
+	p.lock.Lock()
+	p.conn = nil
+	p.lock.Unlock()
+	return err
+}
+
+// startCount returns how many times the plugin was started.
+func (p *externalPlugin) startCount() int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.starts
+}
+
+// externalConn is the connection with one run of a plugin.
+type externalConn struct {
+	plugin    *externalPlugin
+	cmd       *exec.Cmd
+	stdin     io.WriteCloser
+	writeLock sync.Mutex
+}
+
+func (conn *externalConn) send(msg *rpcMessage) error {
+	line, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	conn.writeLock.Lock()
+	defer conn.writeLock.Unlock()
+	_, err = conn.stdin.Write(append(line, '\n'))
+	return err
+}
+
+// forward writes the queued messages until the plugin exits.
+func (conn *externalConn) forward(queue chan *rpcMessage, exited chan bool) {
+	for {
+		select {
+		case msg := <-queue:
+			if err := conn.send(msg); err != nil {
+				conn.plugin.logger.Error("Error sending to external plugin", "err", err)
+				return
+			}
+		case <-exited:
+			return
+		}
+	}
+}
+
+// serve handles the lines written by the plugin until its stdout is
+// closed.
+func (conn *externalConn) serve(stdout io.Reader) error {
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64*1024), maxExternalLine)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		if response := conn.plugin.handleLine(line); response != nil {
+			if err := conn.send(response); err != nil {
+				return err
+			}
+		}
+	}
+	return scanner.Err()
+}
+
+// handleLine handles a line written by the plugin, and returns the
+// response to write back, if any.
+func (p *externalPlugin) handleLine(line []byte) *rpcMessage {
+	var request rpcRequest
+	if err := json.Unmarshal(line, &request); err != nil {
+		return &rpcMessage{JSONRPC: "2.0", ID: json.RawMessage("null"),
+			Error: &rpcError{rpcParseError, err.Error()}}
+	}
+	if request.Method == "" {
+		if request.ID == nil {
+			return &rpcMessage{JSONRPC: "2.0", ID: json.RawMessage("null"),
+				Error: &rpcError{rpcInvalidRequest, "missing method"}}
+		}
+		// The bot sends no requests, so there are no responses to
+		// expect.
+		return nil
+	}
+
+	result, err := p.call(request.Method, request.Params)
+	if request.ID == nil {
+		if err != nil {
+			p.logger.Error("External plugin call failed", "method", request.Method, "err", err)
+		}
+		return nil
+	}
+
+	response := &rpcMessage{JSONRPC: "2.0", ID: request.ID, Result: result}
+	if err != nil {
+		response.Result = nil
+		response.Error = &rpcError{rpcInternalError, err.Error()}
+		if rpcErr, ok := err.(*rpcError); ok {
+			response.Error = rpcErr
+		}
+	}
+	return response
+}
+
+// call runs a method called by the plugin.
+func (p *externalPlugin) call(method string, params json.RawMessage) (interface{}, error) {
+	switch method {
+	case "reply", "notify":
+		var post externalPost
+		if err := decodeParams(params, &post); err != nil {
+			return nil, err
+		}
+		if post.Channel == "" || post.Text == "" {
+			return nil, &rpcError{rpcInvalidParams, "channel and text are required"}
+		}
+		if !p.allowsPost(post.Channel) {
+			return nil, &rpcError{rpcInvalidParams, fmt.Sprintf("channel %s is not allowed", post.Channel)}
+		}
+
+		reply := &BotReply{To: post.Channel, Text: post.Text, Color: post.Color}
+		if method == "reply" {
+			reply.ThreadTimestamp = post.ThreadTimestamp
+		} else if channel := p.bot.GetChannelByName(post.Channel); channel != nil {
+			reply.To = channel.ID
+		}
+		p.bot.Send(reply)
+		return struct{}{}, nil
+
+	case "store.get", "store.put", "store.delete":
+		var store externalStoreParams
+		if err := decodeParams(params, &store); err != nil {
+			return nil, err
+		}
+		if store.Key == "" {
+			return nil, &rpcError{rpcInvalidParams, "key is required"}
+		}
+		if p.store == nil {
+			return nil, fmt.Errorf("no database")
+		}
+		return p.callStore(method, store)
+	}
+
+	return nil, &rpcError{rpcMethodNotFound, fmt.Sprintf("unknown method %q", method)}
+}
+
+// setChannels applies the channel settings of a reloaded configuration.
+func (p *externalPlugin) setChannels(settings PluginSettings) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.channels = settings
+}
+
+// allowsPost reports whether the plugin may post to `name`, a channel
+// ID or name, like it may listen there.  See `Bot.destination()`.
+func (p *externalPlugin) allowsPost(name string) bool {
+	channel := p.bot.destination(name)
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.channels.allowsChannel(channel)
+}
+
+func (p *externalPlugin) callStore(method string, params externalStoreParams) (interface{}, error) {
+	switch method {
+	case "store.get":
+		var value json.RawMessage
+		err := p.store.Get(params.Key, &value)
+		if err == ErrNotFound {
+			return map[string]interface{}{"value": nil}, nil
+		} else if err != nil {
+			return nil, err
+		}
+		return map[string]json.RawMessage{"value": value}, nil
+
+	case "store.put":
+		if params.Value == nil || !json.Valid(params.Value) {
+			return nil, &rpcError{rpcInvalidParams, "value must be JSON"}
+		}
+		return struct{}{}, p.store.Put(params.Key, params.Value)
+
+	default:
+		return struct{}{}, p.store.Delete(params.Key)
+	}
+}
+
+func decodeParams(params json.RawMessage, v interface{}) error {
+	if params == nil {
+		return &rpcError{rpcInvalidParams, "missing params"}
+	}
+	if err := json.Unmarshal(params, v); err != nil {
+		return &rpcError{rpcInvalidParams, err.Error()}
+	}
+	return nil
+}
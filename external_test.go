@@ -0,0 +1,205 @@
+package plotbot
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+// TestExternalHelperProcess is the external plugin run by
+// TestExternalPlugin: it is not a real test.
+func TestExternalHelperProcess(t *testing.T) {
+	if os.Getenv("PLOTBOT_EXTERNAL_HELPER") != "1" {
+		return
+	}
+
+	var greeting string
+	input := bufio.NewScanner(os.Stdin)
+	call := func(id int, method string, params interface{}) {
+		line, _ := json.Marshal(map[string]interface{}{
+			"jsonrpc": "2.0", "id": id, "method": method, "params": params,
+		})
+		fmt.Println(string(line))
+	}
+	response := func() string {
+		input.Scan()
+		return input.Text()
+	}
+	reply := func(channel, text string) {
+		line, _ := json.Marshal(map[string]interface{}{
+			"jsonrpc": "2.0", "method": "reply",
+			"params": map[string]string{"channel": channel, "text": text},
+		})
+		fmt.Println(string(line))
+	}
+
+	for input.Scan() {
+		var msg struct {
+			Method string
+			Params struct {
+				Config  struct{ Greeting string }
+				Channel string
+				Text    string
+			}
+		}
+		if err := json.Unmarshal(input.Bytes(), &msg); err != nil {
+			fmt.Fprintln(os.Stderr, "bad line:", err)
+			os.Exit(2)
+		}
+
+		channel := msg.Params.Channel
+		switch text := msg.Params.Text; {
+		case msg.Method == "init":
+			greeting = msg.Params.Config.Greeting
+		case text == "crash":
+			os.Exit(3)
+		case strings.HasPrefix(text, "remember "):
+			call(1, "store.put", map[string]string{"key": "last", "value": text[len("remember "):]})
+			reply(channel, "stored "+response())
+		case text == "recall":
+			call(2, "store.get", map[string]string{"key": "last"})
+			reply(channel, response())
+		case text == "bogus":
+			call(3, "frobnicate", nil)
+			reply(channel, response())
+		default:
+			reply(channel, greeting+" "+text)
+		}
+	}
+	os.Exit(0)
+}
+
+func TestExternalPlugin(t *testing.T) {
+	adapter := newFakeAdapter()
+	adapter.users = []slack.User{{ID: "U1", Name: "hodor"}}
+	bot := newTestBot(adapter)
+	defer bot.Disconnect()
+	db, err := leveldb.Open(storage.NewMemStorage(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	bot.Store = NewLevelDBStore(db)
+
+	settings := PluginSettings{
+		Command: os.Args[0],
+		Args:    []string{"-test.run=TestExternalHelperProcess"},
+		Env:     map[string]string{"PLOTBOT_EXTERNAL_HELPER": "1"},
+		Config:  json.RawMessage(`{"greeting": "hi"}`),
+	}
+	plugin := newExternalPlugin("helper", settings)
+	plugin.policy.restartDelay = 10 * time.Millisecond
+	bot.plugins = []*enabledPlugin{{name: "helper", plugin: plugin, settings: settings}}
+	initChatPlugins(bot)
+	time.Sleep(50 * time.Millisecond)
+
+	say := func(text, expected string) {
+		t.Helper()
+		adapter.events <- &MessageEvent{Msg: slack.Msg{User: "U1", Channel: "C1", Text: text}}
+		select {
+		case reply := <-adapter.sent:
+			if reply.To != "C1" || reply.Text != expected {
+				t.Errorf("expected %q in C1 after %q, got %q in %s", expected, text, reply.Text, reply.To)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expected %q after %q, got nothing", expected, text)
+		}
+	}
+
+	say("hello", "hi hello")
+	say("remember blue", `stored {"jsonrpc":"2.0","id":1,"result":{}}`)
+	say("recall", `{"jsonrpc":"2.0","id":2,"result":{"value":"blue"}}`)
+	say("bogus", `{"jsonrpc":"2.0","id":3,"error":{"code":-32601,"message":"unknown method \"frobnicate\""}}`)
+
+	value, err := db.Get([]byte("helper:last"), nil)
+	if err != nil || string(value) != `"blue"` {
+		t.Errorf("expected the value in the plugin's namespace, got %q, %v", value, err)
+	}
+
+	adapter.events <- &MessageEvent{Msg: slack.Msg{User: "U1", Channel: "C1", Text: "crash"}}
+	for i := 0; plugin.startCount() < 2; i++ {
+		if i == 500 {
+			t.Fatal("expected the plugin to be restarted")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	say("hello again", "hi hello again")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := plugin.Stop(ctx); err != nil {
+		t.Errorf("expected the plugin to exit when its stdin is closed, got %s", err)
+	}
+}
+
+func TestExternalPluginSettings(t *testing.T) {
+	plugins := registeredPlugins
+	defer func() { registeredPlugins = plugins }()
+	registeredPlugins = nil
+	RegisterPlugin("echo", &echoPlugin{})
+
+	enabled, errs := enablePlugins(PluginsConfig{
+		"echo":  {Command: "/bin/echo"},
+		"jira":  {Command: "/usr/local/bin/plotbot-jira"},
+		"cat":   {Command: "/bin/cat"},
+		"later": {Command: "/bin/true", Disabled: true},
+	})
+	names := make([]string, 0)
+	for _, plugin := range enabled {
+		names = append(names, plugin.name)
+	}
+	if strings.Join(names, ",") != "echo,cat,jira" {
+		t.Errorf("expected the external plugins after the compiled-in ones, got %v", names)
+	}
+	if _, ok := enabled[1].plugin.(*externalPlugin); !ok {
+		t.Errorf("expected an external plugin, got %T", enabled[1].plugin)
+	}
+	if len(errs) != 1 || errs[0].Error() != `plugin "echo" is compiled in, and can't have a command` {
+		t.Errorf("expected an error for the compiled-in plugin, got %v", errs)
+	}
+}
+
+func TestExternalPluginPostChannels(t *testing.T) {
+	adapter := newFakeAdapter()
+	bot := newTestBot(adapter)
+	defer bot.Disconnect()
+	general, random := slack.Channel{}, slack.Channel{}
+	general.ID, general.Name = "C1", "general"
+	random.ID, random.Name = "C2", "random"
+	bot.Directory.SetChannels([]slack.Channel{general, random})
+
+	plugin := newExternalPlugin("helper", PluginSettings{AllowChannels: []string{"#general"}})
+	plugin.bot = bot
+
+	post := func(method, channel string, allowed bool) {
+		t.Helper()
+		params, _ := json.Marshal(map[string]string{"channel": channel, "text": "hi"})
+		_, err := plugin.call(method, params)
+		rpcErr, refused := err.(*rpcError)
+		if allowed && err != nil {
+			t.Errorf("expected %s to %s to be allowed, got %v", method, channel, err)
+		} else if !allowed && (!refused || rpcErr.Code != rpcInvalidParams) {
+			t.Errorf("expected %s to %s to be refused, got %v", method, channel, err)
+		}
+	}
+	post("reply", "C1", true)
+	post("notify", "#general", true)
+	post("reply", "D1", true)
+	post("reply", "C2", false)
+	post("notify", "#random", false)
+	post("notify", "#unknown", false)
+
+	// The settings of a reloaded configuration apply right away.
+	plugin.setChannels(PluginSettings{DenyChannels: []string{"general"}})
+	post("notify", "#general", false)
+	post("reply", "C2", true)
+}
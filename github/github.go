@@ -44,7 +44,7 @@ type IssueEvent struct {
 }
 
 type Conf struct {
-	Authtoken   string
+	Authtoken   string `config:"required"`
 	Repos       []string
 	Github2Chat map[string]string
 }
@@ -8,6 +8,7 @@ require (
 	github.com/gorilla/mux v0.0.0-20140926153814-e444e69cbd2e
 	github.com/gorilla/securecookie v0.0.0-20140409111100-1b0c7f6e9ab3 // indirect
 	github.com/gorilla/sessions v0.0.0-20140613194357-aa5e036e6c44
+	github.com/gorilla/websocket v1.2.0
 	github.com/jmcvetta/napping v3.1.2-0.20160715192702-9bfcafb412a9+incompatible
 	github.com/jmcvetta/randutil v0.0.0-20150817122601-2bb1b664bcff // indirect
 	github.com/kr/pty v0.0.0-20160716204620-ce7fa45920dc
@@ -0,0 +1,119 @@
+package plotbot
+
+import (
+	"fmt"
+
+	"github.com/slack-go/slack"
+)
+
+// Button is a button attached to a RichMessage with `Buttons()`.
+// `Style` is empty, "primary" or "danger".
+type Button struct {
+	Text  string
+	Value string
+	Style string
+}
+
+// MenuOption is an option of a menu attached to a RichMessage with
+// `Menu()`.
+type MenuOption struct {
+	Text  string
+	Value string
+}
+
+// Interaction is a click on a button, or a pick in a menu, attached to
+// one of the bot's messages.
+type Interaction struct {
+	Bot BotLike
+
+	// CallbackID is the ID the buttons or menu were attached with.
+	CallbackID string
+	// Value is the value of the clicked button or picked option.
+	Value string
+	// User is the user who clicked.
+	User *slack.User
+	// Channel and MessageTimestamp locate the message holding the
+	// buttons, see `Update()`.
+	Channel          string
+	MessageTimestamp string
+
+	// Callback is the raw payload sent by Slack.
+	Callback slack.InteractionCallback
+}
+
+// InteractionHandler is called with the clicks on the buttons and
+// menus of a given callback ID.  See `BotLike.HandleInteraction()`.
+type InteractionHandler func(*Interaction)
+
+// Update replaces the message holding the buttons, typically to
+// remove them and show the outcome of the click.
+func (i *Interaction) Update(rich *RichMessage) error {
+	return i.Bot.UpdateMessage(i.Channel, i.MessageTimestamp, rich)
+}
+
+// Buttons adds a row of buttons.  Clicks are handed to the handler
+// registered for `callbackID` with `HandleInteraction()`.
+func (m *RichMessage) Buttons(callbackID string, buttons ...Button) *RichMessage {
+	elements := make([]slack.BlockElement, 0, len(buttons))
+	for i, button := range buttons {
+		text := slack.NewTextBlockObject(slack.PlainTextType, button.Text, false, false)
+		element := slack.NewButtonBlockElement(actionID(callbackID, i), button.Value, text)
+		if button.Style != "" {
+			element.WithStyle(slack.Style(button.Style))
+		}
+		elements = append(elements, element)
+	}
+	return m.add(slack.NewActionBlock(callbackID, elements...))
+}
+
+// Menu adds a drop-down menu.  Picks are handed to the handler
+// registered for `callbackID` with `HandleInteraction()`.
+func (m *RichMessage) Menu(callbackID, placeholder string, options ...MenuOption) *RichMessage {
+	objects := make([]*slack.OptionBlockObject, 0, len(options))
+	for _, option := range options {
+		text := slack.NewTextBlockObject(slack.PlainTextType, option.Text, false, false)
+		objects = append(objects, slack.NewOptionBlockObject(option.Value, text))
+	}
+	text := slack.NewTextBlockObject(slack.PlainTextType, placeholder, false, false)
+	menu := slack.NewOptionsSelectBlockElement(slack.OptTypeStatic, text, actionID(callbackID, 0), objects...)
+	return m.add(slack.NewActionBlock(callbackID, menu))
+}
+
+// actionID builds action IDs unique within a message, as Slack requires.
+func actionID(callbackID string, index int) string {
+	return fmt.Sprintf("%s-%d", callbackID, index)
+}
+
+// newInteraction extracts the callback ID and the value of the first
+// action of a callback.  Legacy attachment actions are routed on the
+// attachment's `callback_id`, block actions on their `block_id`.
+func newInteraction(bot BotLike, callback slack.InteractionCallback) *Interaction {
+	interaction := &Interaction{
+		Bot:              bot,
+		CallbackID:       callback.CallbackID,
+		User:             &callback.User,
+		Channel:          callback.Channel.ID,
+		MessageTimestamp: callback.MessageTs,
+		Callback:         callback,
+	}
+	if interaction.MessageTimestamp == "" {
+		interaction.MessageTimestamp = callback.Message.Timestamp
+	}
+
+	for _, action := range callback.ActionCallback.BlockActions {
+		interaction.CallbackID = action.BlockID
+		interaction.Value = action.Value
+		if action.SelectedOption.Value != "" {
+			interaction.Value = action.SelectedOption.Value
+		}
+		return interaction
+	}
+	for _, action := range callback.ActionCallback.AttachmentActions {
+		interaction.Value = action.Value
+		if len(action.SelectedOptions) > 0 {
+			interaction.Value = action.SelectedOptions[0].Value
+		}
+		return interaction
+	}
+	return interaction
+}
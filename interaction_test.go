@@ -0,0 +1,143 @@
+package plotbot
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+const blockActionsPayload = `{
+  "type": "block_actions",
+  "team": {"id": "T9TK3CUKW", "domain": "example"},
+  "user": {"id": "U1", "username": "hodor", "team_id": "T9TK3CUKW"},
+  "api_app_id": "AABA1ABCD",
+  "token": "9s8d9as89d8as9d8as989",
+  "container": {"type": "message", "message_ts": "1548261231.000200", "channel_id": "C1", "is_ephemeral": false},
+  "trigger_id": "12321423423.333649436676.d8c1bb837935619ccad0f624c448ffb3",
+  "channel": {"id": "C1", "name": "general"},
+  "message": {"type": "message", "user": "UBOT", "ts": "1548261231.000200", "text": "This job requires confirmation."},
+  "response_url": "https://hooks.slack.com/actions/AABA1ABCD/1232321423432/D09sSasdasdAS9091209",
+  "actions": [
+    {
+      "action_id": "confirm-1-0",
+      "block_id": "confirm-1",
+      "text": {"type": "plain_text", "text": "Yes", "emoji": true},
+      "value": "yes",
+      "type": "button",
+      "action_ts": "1548426417.840180"
+    }
+  ]
+}`
+
+func TestSlackEventsInteractivity(t *testing.T) {
+	adapter := NewSlackEvents(SlackConfig{SigningSecret: testSigningSecret})
+	server := httptest.NewServer(http.HandlerFunc(adapter.ServeInteractivity))
+	defer server.Close()
+
+	form := url.Values{"payload": {blockActionsPayload}}.Encode()
+	res := postSigned(t, server.URL, testSigningSecret, "application/x-www-form-urlencoded", form)
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", res.StatusCode)
+	}
+
+	var event Event
+	select {
+	case event = <-adapter.Events():
+	case <-time.After(time.Second):
+		t.Fatal("expected an interaction event")
+	}
+	ev, ok := event.(*InteractionEvent)
+	if !ok {
+		t.Fatalf("expected an InteractionEvent, got %#v", event)
+	}
+
+	interaction := newInteraction(nil, ev.Callback)
+	if interaction.CallbackID != "confirm-1" || interaction.Value != "yes" {
+		t.Errorf("unexpected callback %q and value %q", interaction.CallbackID, interaction.Value)
+	}
+	if interaction.User.ID != "U1" || interaction.Channel != "C1" ||
+		interaction.MessageTimestamp != "1548261231.000200" {
+		t.Errorf("unexpected interaction %#v", interaction)
+	}
+
+	res = postSigned(t, server.URL, "bad secret", "application/x-www-form-urlencoded", form)
+	res.Body.Close()
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected status 401 with a bad signature, got %d", res.StatusCode)
+	}
+}
+
+func TestInteractionHandler(t *testing.T) {
+	adapter := newFakeAdapter()
+	adapter.users = []slack.User{{ID: "U1", Name: "hodor"}}
+	bot := newTestBot(adapter)
+
+	clicks := make(chan *Interaction, 1)
+	bot.HandleInteraction("confirm-1", func(interaction *Interaction) {
+		clicks <- interaction
+		interaction.Update(NewRichMessage("Confirmed by " + interaction.User.Name))
+	})
+
+	callback := slack.InteractionCallback{
+		Channel: slack.Channel{GroupConversation: slack.GroupConversation{
+			Conversation: slack.Conversation{ID: "C1"},
+		}},
+		User:      slack.User{ID: "U1"},
+		MessageTs: "1548261231.000200",
+	}
+	callback.ActionCallback.BlockActions = []*slack.BlockAction{{BlockID: "confirm-1", Value: "yes"}}
+	adapter.events <- &InteractionEvent{Callback: callback}
+
+	select {
+	case interaction := <-clicks:
+		if interaction.Value != "yes" || interaction.User.Name != "hodor" {
+			t.Errorf("unexpected interaction %#v", interaction)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the handler to be called")
+	}
+
+	select {
+	case update := <-adapter.updated:
+		if update.channelID != "C1" || update.timestamp != "1548261231.000200" ||
+			update.reply.Text != "Confirmed by hodor" {
+			t.Errorf("unexpected update %#v", update)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the message to be updated")
+	}
+
+	bot.RemoveInteraction("confirm-1")
+	adapter.events <- &InteractionEvent{Callback: callback}
+	select {
+	case <-clicks:
+		t.Error("expected the removed handler not to be called")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestRichMessageButtons(t *testing.T) {
+	msg := NewRichMessage("pick").
+		Buttons("confirm-1", Button{Text: "Yes", Value: "yes", Style: "primary"}, Button{Text: "No", Value: "no"}).
+		Menu("env-1", "Environment", MenuOption{Text: "Production", Value: "prod"})
+
+	buttons := msg.Blocks[0].(*slack.ActionBlock)
+	if buttons.BlockID != "confirm-1" || len(buttons.Elements.ElementSet) != 2 {
+		t.Fatalf("unexpected buttons block %#v", buttons)
+	}
+	yes := buttons.Elements.ElementSet[0].(*slack.ButtonBlockElement)
+	no := buttons.Elements.ElementSet[1].(*slack.ButtonBlockElement)
+	if yes.Value != "yes" || yes.Style != "primary" || yes.ActionID == no.ActionID {
+		t.Errorf("unexpected buttons %#v and %#v", yes, no)
+	}
+
+	menu := msg.Blocks[1].(*slack.ActionBlock)
+	if menu.BlockID != "env-1" {
+		t.Errorf("unexpected menu block %#v", menu)
+	}
+}
@@ -0,0 +1,290 @@
+package plotbot
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// Logger writes leveled, structured log lines: a message followed by
+// key/value pairs, as text or as JSON objects, one per line, depending
+// on the Log section of the configuration:
+//
+//	"Log": {"level": "info", "format": "json"}
+//
+// Pairs are passed after the message, keys first:
+//
+//	logger.Info("Deploy started", "service", service, "environment", env)
+//	logger.Error("Could not save the reminder", "err", err)
+//
+// `With()` returns a child logger adding its pairs to every line.
+// Plugins implementing PluginLoggerInitializer get one with their name.
+//
+// What people say is kept out of the logs: message texts are logged as
+// a `Body`, which is only written when `debug` is set in the Slack
+// section.  A nil *Logger writes to the bot's logger.
+type Logger struct {
+	sink   *logSink
+	fields []interface{}
+}
+
+// PluginLoggerInitializer is implemented by plugins which log.  They
+// get a logger adding `plugin=<name>` to their lines before
+// `InitPlugin()`.
+type PluginLoggerInitializer interface {
+	InitLogger(logger *Logger)
+}
+
+// LogConfig is the Log section of the configuration.
+type LogConfig struct {
+	// Level is the lowest level logged: "debug", "info", "warn" or
+	// "error".  `debug` in the Slack section forces "debug".
+	Level string `json:"level" default:"info"`
+	// Format is "text", or "json" for log collectors.
+	Format string `json:"format" default:"text"`
+}
+
+// Validate checks the level and format names.
+func (c *LogConfig) Validate() error {
+	if _, err := ParseLevel(c.Level); err != nil {
+		return err
+	}
+	if c.Format != "text" && c.Format != "json" {
+		return fmt.Errorf("unknown log format %q, expected \"text\" or \"json\"", c.Format)
+	}
+	return nil
+}
+
+// Level is the severity of a log line.
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (level Level) String() string {
+	if level < LevelDebug || level > LevelError {
+		return fmt.Sprintf("level(%d)", int(level))
+	}
+	return levelNames[level]
+}
+
+// ParseLevel returns the Level called `name`, like "info".
+func ParseLevel(name string) (Level, error) {
+	for level, levelName := range levelNames {
+		if strings.EqualFold(name, levelName) {
+			return Level(level), nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("unknown log level %q, expected one of %s", name, strings.Join(levelNames, ", "))
+}
+
+// Body is the text of a message, or of a reply, in log pairs.  It is
+// replaced by its length unless `debug` is set in the Slack section.
+type Body string
+
+// defaultLogger is the bot's logger, which the parts of the bot without
+// a logger of their own write to.  Its configuration applies to all its
+// children.
+var defaultLogger = NewLogger(os.Stderr)
+
+// logSink is shared by a logger and its children.
+type logSink struct {
+	lock   sync.Mutex
+	out    io.Writer
+	level  Level
+	json   bool
+	bodies bool
+	now    func() time.Time
+}
+
+// NewLogger returns a logger writing text lines of level info and
+// above to `out`, until configured otherwise.
+func NewLogger(out io.Writer) *Logger {
+	return &Logger{sink: &logSink{out: out, level: LevelInfo, now: time.Now}}
+}
+
+// Configure applies the Log section, and the `debug` setting of the
+// Slack section, to the logger and all its children.
+func (l *Logger) Configure(config LogConfig, debug bool) error {
+	if err := config.Validate(); err != nil {
+		return err
+	}
+	level, _ := ParseLevel(config.Level)
+	if debug {
+		level = LevelDebug
+	}
+
+	sink := l.logger().sink
+	sink.lock.Lock()
+	defer sink.lock.Unlock()
+	sink.level = level
+	sink.json = config.Format == "json"
+	sink.bodies = debug
+	return nil
+}
+
+// With returns a child logger adding the key/value pairs to its lines.
+func (l *Logger) With(keyvals ...interface{}) *Logger {
+	l = l.logger()
+	fields := make([]interface{}, 0, len(l.fields)+len(keyvals))
+	fields = append(append(fields, l.fields...), keyvals...)
+	return &Logger{sink: l.sink, fields: fields}
+}
+
+// Enabled tells whether lines of `level` are written, to skip building
+// costly debug pairs.
+func (l *Logger) Enabled(level Level) bool {
+	sink := l.logger().sink
+	sink.lock.Lock()
+	defer sink.lock.Unlock()
+	return level >= sink.level
+}
+
+func (l *Logger) Debug(msg string, keyvals ...interface{}) {
+	l.logger().log(LevelDebug, msg, keyvals)
+}
+
+func (l *Logger) Info(msg string, keyvals ...interface{}) {
+	l.logger().log(LevelInfo, msg, keyvals)
+}
+
+func (l *Logger) Warn(msg string, keyvals ...interface{}) {
+	l.logger().log(LevelWarn, msg, keyvals)
+}
+
+func (l *Logger) Error(msg string, keyvals ...interface{}) {
+	l.logger().log(LevelError, msg, keyvals)
+}
+
+// Fatal logs an error and exits.
+func (l *Logger) Fatal(msg string, keyvals ...interface{}) {
+	l.logger().log(LevelError, msg, keyvals)
+	os.Exit(1)
+}
+
+// Writer returns a writer logging each line written to it at `level`,
+// for the standard library's logger or the stderr of a process.
+func (l *Logger) Writer(level Level) io.Writer {
+	return &lineLogger{logger: l.logger(), level: level}
+}
+
+func (l *Logger) logger() *Logger {
+	if l == nil {
+		return defaultLogger
+	}
+	return l
+}
+
+func (l *Logger) log(level Level, msg string, keyvals []interface{}) {
+	sink := l.sink
+	sink.lock.Lock()
+	defer sink.lock.Unlock()
+	if level < sink.level {
+		return
+	}
+
+	var line bytes.Buffer
+	pairs := append(append([]interface{}{}, l.fields...), keyvals...)
+	if sink.json {
+		sink.writeJSON(&line, level, msg, pairs)
+	} else {
+		sink.writeText(&line, level, msg, pairs)
+	}
+	line.WriteByte('\n')
+	sink.out.Write(line.Bytes())
+}
+
+func (sink *logSink) writeText(line *bytes.Buffer, level Level, msg string, pairs []interface{}) {
+	fmt.Fprintf(line, "%s %-5s %s", sink.now().Format("2006/01/02 15:04:05"), strings.ToUpper(level.String()), msg)
+	for i := 0; i < len(pairs); i += 2 {
+		key, value := logPair(pairs, i)
+		text := fmt.Sprint(sink.value(value))
+		if text == "" || strings.IndexFunc(text, needsQuoting) >= 0 {
+			text = strconv.Quote(text)
+		}
+		fmt.Fprintf(line, " %s=%s", key, text)
+	}
+}
+
+func (sink *logSink) writeJSON(line *bytes.Buffer, level Level, msg string, pairs []interface{}) {
+	fmt.Fprintf(line, `{"time":%q,"level":%q,"msg":%s`,
+		sink.now().Format(time.RFC3339Nano), level.String(), marshalLogValue(msg))
+	for i := 0; i < len(pairs); i += 2 {
+		key, value := logPair(pairs, i)
+		fmt.Fprintf(line, ",%s:%s", marshalLogValue(key), marshalLogValue(sink.value(value)))
+	}
+	line.WriteByte('}')
+}
+
+// value prepares a value for writing, redacting the bodies.
+func (sink *logSink) value(value interface{}) interface{} {
+	switch value := value.(type) {
+	case Body:
+		if sink.bodies {
+			return string(value)
+		}
+		return fmt.Sprintf("[redacted, %d bytes]", len(value))
+	case error:
+		return value.Error()
+	case fmt.Stringer:
+		return value.String()
+	}
+	return value
+}
+
+// logPair returns the pair starting at `i`.  A value without a key is
+// logged as "!BADKEY".
+func logPair(pairs []interface{}, i int) (string, interface{}) {
+	if i == len(pairs)-1 {
+		return "!BADKEY", pairs[i]
+	}
+	return fmt.Sprint(pairs[i]), pairs[i+1]
+}
+
+func marshalLogValue(value interface{}) []byte {
+	data, err := json.Marshal(value)
+	if err != nil {
+		data, _ = json.Marshal(fmt.Sprint(value))
+	}
+	return data
+}
+
+func needsQuoting(r rune) bool {
+	return unicode.IsSpace(r) || r == '"' || r == '=' || !unicode.IsPrint(r)
+}
+
+// lineLogger logs what is written to it, line by line.
+type lineLogger struct {
+	logger *Logger
+	level  Level
+	lock   sync.Mutex
+	buf    []byte
+}
+
+func (w *lineLogger) Write(p []byte) (int, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		w.logger.log(w.level, string(w.buf[:i]), nil)
+		w.buf = w.buf[i+1:]
+	}
+	return len(p), nil
+}
@@ -0,0 +1,79 @@
+package plotbot
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func newTestLogger() (*Logger, *bytes.Buffer) {
+	var buf bytes.Buffer
+	logger := NewLogger(&buf)
+	logger.sink.now = func() time.Time { return time.Date(2020, 5, 1, 10, 17, 0, 0, time.UTC) }
+	return logger, &buf
+}
+
+func TestLogger(t *testing.T) {
+	logger, buf := newTestLogger()
+	plugin := logger.With("plugin", "deployer")
+
+	logger.Debug("Not written")
+	plugin.Info("Deploy started", "service", "streambed", "branch", "fix this", "text", Body("deploy please"))
+	plugin.Error("Deploy failed", "err", errors.New("exit status 1"), "dangling")
+
+	expected := `2020/05/01 10:17:00 INFO  Deploy started plugin=deployer service=streambed branch="fix this" text="[redacted, 13 bytes]"
+2020/05/01 10:17:00 ERROR Deploy failed plugin=deployer err="exit status 1" !BADKEY=dangling
+`
+	if buf.String() != expected {
+		t.Errorf("unexpected text logs:\n%s", buf.String())
+	}
+
+	buf.Reset()
+	if err := logger.Configure(LogConfig{Level: "debug", Format: "json"}, true); err != nil {
+		t.Fatal(err)
+	}
+	plugin.Debug("Incoming message", "text", Body("hello \"bot\""), "delay", 2*time.Second, "count", 3)
+
+	var line map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("invalid JSON line %q: %s", buf.String(), err)
+	}
+	if fmt.Sprint(line) != `map[count:3 delay:2s level:debug msg:Incoming message plugin:deployer text:hello "bot" time:2020-05-01T10:17:00Z]` {
+		t.Errorf("unexpected JSON line: %s", buf.String())
+	}
+
+	for _, config := range []LogConfig{{Level: "verbose", Format: "text"}, {Level: "info", Format: "xml"}} {
+		if err := logger.Configure(config, false); err == nil {
+			t.Errorf("expected %#v to be rejected", config)
+		}
+	}
+}
+
+func TestLoggerLevels(t *testing.T) {
+	logger, buf := newTestLogger()
+	if err := logger.Configure(LogConfig{Level: "warn", Format: "text"}, false); err != nil {
+		t.Fatal(err)
+	}
+	logger.Info("Not written")
+	if logger.Enabled(LevelInfo) || !logger.Enabled(LevelError) {
+		t.Error("expected only warnings and errors to be enabled")
+	}
+
+	fmt.Fprint(logger.Writer(LevelWarn), "first line\nsecond ")
+	fmt.Fprint(logger.Writer(LevelInfo), "ignored\n")
+	if buf.String() != "2020/05/01 10:17:00 WARN  first line\n" {
+		t.Errorf("unexpected logs %q", buf.String())
+	}
+
+	if level, err := ParseLevel("ERROR"); err != nil || level != LevelError || level.String() != "error" {
+		t.Errorf("expected the error level, got %s, %v", level, err)
+	}
+
+	var nilLogger *Logger
+	if nilLogger.With("plugin", "test").sink != defaultLogger.sink {
+		t.Error("expected a nil logger to write to the default one")
+	}
+}
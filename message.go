@@ -3,24 +3,45 @@ package plotbot
 import (
 	"fmt"
 	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/slack-go/slack"
 )
 
 type BotReply struct {
-	To   string
-	Text string
+	To    string
+	Text  string
+	Color string
+	// ThreadTimestamp posts the reply in the thread started by that
+	// message, instead of at the channel's top level.
+	ThreadTimestamp string
+	// Blocks, when set, make up the body of the message, and `Text`
+	// is only used as fallback.  See `RichMessage`.
+	Blocks []slack.Block
+
+	// OnDelivery, when set, is called once the reply is posted, with
+	// its timestamp, or once delivery was given up, with the error.
+	OnDelivery func(timestamp string, err error)
 }
 
 type Message struct {
 	*slack.Msg
 	SubMessage  *slack.Msg
 	MentionsMe  bool
-	IsEdition   bool
 	FromMe      bool
 	FromUser    *slack.User
 	FromChannel *slack.Channel
+
+	// IsEdition and IsDeletion are set on edited and deleted
+	// messages, which are only dispatched to the Conversations with
+	// `MatchEditions`.  `Timestamp` is the one of the original
+	// message, `Text` the new text (empty when deleted), and
+	// `PreviousText` the text before the change.
+	IsEdition    bool
+	IsDeletion   bool
+	PreviousText string
 }
 
 func (msg *Message) IsPrivate() bool {
@@ -77,9 +98,44 @@ func (msg *Message) HasPrefix(prefix string) bool {
 	return strings.HasPrefix(msg.Text, prefix)
 }
 
+// TextWithoutMention returns the text stripped of the leading
+// @mention, as in "<@U024BE7LH> deploy to prod" or "@plotbot: deploy
+// to prod".
+func (msg *Message) TextWithoutMention() string {
+	text := strings.TrimSpace(msg.Text)
+	if loc := reAtMention.FindStringIndex(text); loc != nil && loc[0] == 0 {
+		text = text[loc[1]:]
+	} else if strings.HasPrefix(text, "@") {
+		fields := strings.SplitN(text, " ", 2)
+		if len(fields) == 1 {
+			return ""
+		}
+		text = fields[1]
+	}
+	return strings.TrimLeft(text, " :,")
+}
+
+// Time returns when the message was originally posted, from its
+// `Timestamp`.
+func (msg *Message) Time() time.Time {
+	seconds, err := strconv.ParseFloat(msg.Timestamp, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(int64(seconds), 0)
+}
+
+// InThread returns whether the message was posted in a thread.
+func (msg *Message) InThread() bool {
+	return msg.ThreadTimestamp != ""
+}
+
+// Reply answers where the message was sent, in the same thread if it
+// was posted in one.
 func (msg *Message) Reply(s string) *BotReply {
 	rep := &BotReply{
-		Text: s,
+		Text:            s,
+		ThreadTimestamp: msg.ThreadTimestamp,
 	}
 	if msg.Channel != "" {
 		rep.To = msg.Channel
@@ -89,6 +145,29 @@ func (msg *Message) Reply(s string) *BotReply {
 	return rep
 }
 
+// ReplyRich answers where the message was sent with a structured
+// message, in the same thread if it was posted in one, or in a new
+// thread if `rich.Thread` is set.
+func (msg *Message) ReplyRich(rich *RichMessage) *BotReply {
+	rep := msg.Reply(rich.Text)
+	if rich.Thread {
+		rep = msg.ReplyInThread(rich.Text)
+	}
+	rep.Color = rich.Color
+	rep.Blocks = rich.Blocks
+	return rep
+}
+
+// ReplyInThread answers in the message's thread, starting one under
+// the message if it is not part of a thread yet.
+func (msg *Message) ReplyInThread(s string) *BotReply {
+	rep := msg.Reply(s)
+	if rep.ThreadTimestamp == "" {
+		rep.ThreadTimestamp = msg.Timestamp
+	}
+	return rep
+}
+
 func (msg *Message) ReplyPrivately(s string) *BotReply {
 	return &BotReply{
 		To:   msg.User,
@@ -96,8 +175,10 @@ func (msg *Message) ReplyPrivately(s string) *BotReply {
 	}
 }
 
+// String identifies the message, without its text, which is only
+// logged as a `Body`.
 func (msg *Message) String() string {
-	return fmt.Sprintf("%#v", msg)
+	return fmt.Sprintf("message %s from %s in %s", msg.Timestamp, msg.User, msg.Channel)
 }
 
 func (msg *Message) applyMentionsMe(bot *Bot) {
@@ -0,0 +1,39 @@
+package plotbot
+
+import (
+	"testing"
+
+	"github.com/slack-go/slack"
+)
+
+func TestReplyThreading(t *testing.T) {
+	topLevel := &Message{Msg: &slack.Msg{
+		Channel:   "C1",
+		Timestamp: "1355517523.000005",
+	}}
+	inThread := &Message{Msg: &slack.Msg{
+		Channel:         "C1",
+		Timestamp:       "1355517530.000007",
+		ThreadTimestamp: "1355517523.000005",
+	}}
+
+	type El struct {
+		reply    *BotReply
+		threadTs string
+	}
+	tests := []El{
+		{topLevel.Reply("hi"), ""},
+		{topLevel.ReplyInThread("hi"), "1355517523.000005"},
+		{inThread.Reply("hi"), "1355517523.000005"},
+		{inThread.ReplyInThread("hi"), "1355517523.000005"},
+	}
+
+	for i, el := range tests {
+		if el.reply.To != "C1" {
+			t.Errorf("index %d: expected reply to C1, got %q", i, el.reply.To)
+		}
+		if el.reply.ThreadTimestamp != el.threadTs {
+			t.Errorf("index %d: expected thread %q, got %q", i, el.threadTs, el.reply.ThreadTimestamp)
+		}
+	}
+}
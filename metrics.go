@@ -0,0 +1,432 @@
+package plotbot
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Metrics is a registry of counters, gauges and histograms, exposed in
+// the Prometheus text format by `ServeHTTP()`, on the `/metrics`
+// endpoint configured in the Metrics section:
+//
+//	"Metrics": {"listen": ":9090"}
+//
+// The bot's registry names its metrics "plotbot_...".  Plugins
+// implementing PluginMetricsInitializer get a registry of their own,
+// whose metrics are named "plotbot_<plugin>_...".
+//
+// Metrics are registered once, usually upon init, and updated with the
+// values of their labels, in the order they were declared:
+//
+//	deploys := metrics.Counter("deploys_total", "Deploys, by environment.", "environment")
+//	deploys.Inc("prod")
+//
+// Registering a metric again with the same type and labels returns the
+// existing one.  Nil metrics ignore updates, so code can be exercised
+// without a registry, in tests.
+type Metrics struct {
+	prefix   string
+	registry *metricsRegistry
+}
+
+// PluginMetricsInitializer is implemented by plugins exposing metrics.
+// They get a registry namespaced with their name before `InitPlugin()`.
+type PluginMetricsInitializer interface {
+	InitMetrics(metrics *Metrics)
+}
+
+// MetricsConfig is the Metrics section of the configuration.  The
+// endpoint is only served when `Listen` is set.
+type MetricsConfig struct {
+	// Listen is the address of the endpoint, like ":9090".
+	Listen string `json:"listen"`
+	Path   string `json:"path" default:"/metrics"`
+}
+
+// DefaultBuckets are the histogram buckets of durations in seconds, from
+// 5ms to 10s.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type metricsRegistry struct {
+	lock     sync.Mutex
+	families map[string]*metricFamily
+}
+
+type metricFamily struct {
+	name    string
+	help    string
+	kind    string
+	labels  []string
+	buckets []float64
+	// fn computes the value of gauges registered with `GaugeFunc()`.
+	fn func() float64
+
+	lock   sync.Mutex
+	series map[string]*metricSeries
+}
+
+type metricSeries struct {
+	labelValues []string
+	value       float64
+	// Histograms count the observations in each bucket, and the ones
+	// above the last.
+	counts []uint64
+	count  uint64
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		prefix:   "plotbot_",
+		registry: &metricsRegistry{families: make(map[string]*metricFamily)},
+	}
+}
+
+var (
+	reMetricName    = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+	reMetricLabel   = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+	reMetricInvalid = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+)
+
+// Namespace returns a registry whose metrics are named with `name` and
+// "_" after the prefix of this one.
+func (m *Metrics) Namespace(name string) *Metrics {
+	return &Metrics{
+		prefix:   m.prefix + reMetricInvalid.ReplaceAllString(name, "_") + "_",
+		registry: m.registry,
+	}
+}
+
+// Counter registers a counter, which only goes up.  Name it with a
+// "_total" suffix.
+func (m *Metrics) Counter(name, help string, labels ...string) *Counter {
+	return &Counter{m.register(&metricFamily{name: name, help: help, kind: "counter", labels: labels})}
+}
+
+// Gauge registers a gauge, which can go up and down.
+func (m *Metrics) Gauge(name, help string, labels ...string) *Gauge {
+	return &Gauge{m.register(&metricFamily{name: name, help: help, kind: "gauge", labels: labels})}
+}
+
+// GaugeFunc registers a gauge without labels, whose value is computed
+// by `fn` when the metrics are read.  `fn` must be safe to call from
+// any goroutine.  Registering it again replaces the function.
+func (m *Metrics) GaugeFunc(name, help string, fn func() float64) {
+	family := m.register(&metricFamily{name: name, help: help, kind: "gauge", fn: fn})
+	family.lock.Lock()
+	family.fn = fn
+	family.lock.Unlock()
+}
+
+// Histogram registers a histogram counting observations in `buckets`,
+// their upper bounds in increasing order.  See DefaultBuckets.
+func (m *Metrics) Histogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	if !sort.Float64sAreSorted(buckets) || len(buckets) == 0 {
+		panic(fmt.Sprintf("plotbot: histogram %q needs increasing buckets", name))
+	}
+	return &Histogram{m.register(&metricFamily{name: name, help: help, kind: "histogram", labels: labels, buckets: buckets})}
+}
+
+// register adds a family to the registry, or returns the existing one.
+// It panics on invalid or conflicting names, much like `Router.Add()`.
+func (m *Metrics) register(family *metricFamily) *metricFamily {
+	family.name = m.prefix + family.name
+	family.labels = append([]string(nil), family.labels...)
+	if !reMetricName.MatchString(family.name) {
+		panic(fmt.Sprintf("plotbot: invalid metric name %q", family.name))
+	}
+	for _, label := range family.labels {
+		if !reMetricLabel.MatchString(label) || strings.HasPrefix(label, "__") || label == "le" {
+			panic(fmt.Sprintf("plotbot: metric %q has invalid label %q", family.name, label))
+		}
+	}
+
+	m.registry.lock.Lock()
+	defer m.registry.lock.Unlock()
+
+	if existing, ok := m.registry.families[family.name]; ok {
+		if existing.kind != family.kind || (existing.fn == nil) != (family.fn == nil) ||
+			strings.Join(existing.labels, ",") != strings.Join(family.labels, ",") {
+			panic(fmt.Sprintf("plotbot: metric %q is already registered differently", family.name))
+		}
+		return existing
+	}
+	family.series = make(map[string]*metricSeries)
+	m.registry.families[family.name] = family
+	return family
+}
+
+// with calls `fn` with the series of the label values, under the lock
+// of the family.
+func (family *metricFamily) with(labelValues []string, fn func(series *metricSeries)) {
+	if len(labelValues) != len(family.labels) {
+		panic(fmt.Sprintf("plotbot: metric %q takes %d label values, got %d",
+			family.name, len(family.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+
+	family.lock.Lock()
+	defer family.lock.Unlock()
+
+	series, ok := family.series[key]
+	if !ok {
+		series = &metricSeries{labelValues: append([]string(nil), labelValues...)}
+		if family.kind == "histogram" {
+			series.counts = make([]uint64, len(family.buckets)+1)
+		}
+		family.series[key] = series
+	}
+	fn(series)
+}
+
+// Counter is a metric that only goes up, see `Metrics.Counter()`.
+type Counter struct {
+	family *metricFamily
+}
+
+// Inc adds one to the counter of the label values.
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds `value` to the counter of the label values.  Negative values
+// are ignored.
+func (c *Counter) Add(value float64, labelValues ...string) {
+	if c == nil || value < 0 {
+		return
+	}
+	c.family.with(labelValues, func(series *metricSeries) { series.value += value })
+}
+
+// Gauge is a metric that goes up and down, see `Metrics.Gauge()`.
+type Gauge struct {
+	family *metricFamily
+}
+
+// Set sets the gauge of the label values.
+func (g *Gauge) Set(value float64, labelValues ...string) {
+	if g == nil {
+		return
+	}
+	g.family.with(labelValues, func(series *metricSeries) { series.value = value })
+}
+
+// Add adds `value`, which can be negative, to the gauge of the label
+// values.
+func (g *Gauge) Add(value float64, labelValues ...string) {
+	if g == nil {
+		return
+	}
+	g.family.with(labelValues, func(series *metricSeries) { series.value += value })
+}
+
+// Histogram counts observations in buckets, see `Metrics.Histogram()`.
+type Histogram struct {
+	family *metricFamily
+}
+
+// Observe records a value, like a duration in seconds, for the label
+// values.
+func (h *Histogram) Observe(value float64, labelValues ...string) {
+	if h == nil {
+		return
+	}
+	bucket := sort.SearchFloat64s(h.family.buckets, value)
+	h.family.with(labelValues, func(series *metricSeries) {
+		series.counts[bucket]++
+		series.count++
+		series.value += value
+	})
+}
+
+// WriteTo writes all the metrics of the registry, whatever its
+// namespace, in the Prometheus text format.
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	m.registry.lock.Lock()
+	families := make([]*metricFamily, 0, len(m.registry.families))
+	for _, family := range m.registry.families {
+		families = append(families, family)
+	}
+	m.registry.lock.Unlock()
+	sort.Slice(families, func(i, j int) bool { return families[i].name < families[j].name })
+
+	counter := &countingWriter{w: w}
+	buf := bufio.NewWriter(counter)
+	for _, family := range families {
+		family.write(buf)
+	}
+	err := buf.Flush()
+	return counter.count, err
+}
+
+func (family *metricFamily) write(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", family.name, escapeMetricHelp(family.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", family.name, family.kind)
+
+	family.lock.Lock()
+	defer family.lock.Unlock()
+
+	if family.fn != nil {
+		fmt.Fprintf(w, "%s %s\n", family.name, formatMetricValue(family.fn()))
+		return
+	}
+
+	keys := make([]string, 0, len(family.series))
+	for key := range family.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		series := family.series[key]
+		labels := formatMetricLabels(family.labels, series.labelValues)
+		if family.kind != "histogram" {
+			fmt.Fprintf(w, "%s%s %s\n", family.name, labels, formatMetricValue(series.value))
+			continue
+		}
+
+		// Buckets have an "le" label, for their upper bound, and count
+		// the observations below it.
+		names := append(append([]string(nil), family.labels...), "le")
+		values := append(append([]string(nil), series.labelValues...), "+Inf")
+		cumulative := uint64(0)
+		for i, bound := range family.buckets {
+			cumulative += series.counts[i]
+			values[len(values)-1] = formatMetricValue(bound)
+			fmt.Fprintf(w, "%s_bucket%s %d\n", family.name, formatMetricLabels(names, values), cumulative)
+		}
+		values[len(values)-1] = "+Inf"
+		fmt.Fprintf(w, "%s_bucket%s %d\n", family.name, formatMetricLabels(names, values), series.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", family.name, labels, formatMetricValue(series.value))
+		fmt.Fprintf(w, "%s_count%s %d\n", family.name, labels, series.count)
+	}
+}
+
+// ServeHTTP serves the metrics, for Prometheus to scrape.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if _, err := m.WriteTo(w); err != nil {
+		defaultLogger.Error("Error writing metrics", "err", err)
+	}
+}
+
+func formatMetricLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	pairs := make([]string, 0, len(names))
+	for i, name := range names {
+		pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", name, escapeMetricLabel(values[i])))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatMetricValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+var (
+	metricHelpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	metricLabelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeMetricHelp(help string) string {
+	return metricHelpEscaper.Replace(help)
+}
+
+func escapeMetricLabel(value string) string {
+	return metricLabelEscaper.Replace(value)
+}
+
+type countingWriter struct {
+	w     io.Writer
+	count int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.count += int64(n)
+	return n, err
+}
+
+// botMetrics are the metrics of the bot's internals.  The outbox has
+// its own, see `outbox.instrument()`.
+type botMetrics struct {
+	messages        *Counter
+	conversations   *Gauge
+	handlerDuration *Histogram
+	reconnects      *Counter
+}
+
+func newBotMetrics(metrics *Metrics) botMetrics {
+	return botMetrics{
+		messages: metrics.Counter("messages_received_total",
+			"Messages received, by type of channel: public, private, im or mpim.", "channel_type"),
+		conversations: metrics.Gauge("conversations_active",
+			"Conversations listening for messages."),
+		handlerDuration: metrics.Histogram("handler_duration_seconds",
+			"Time spent handling a message, by plugin.", DefaultBuckets, "plugin"),
+		reconnects: metrics.Counter("reconnects_total",
+			"Connection attempts to Slack after the first one."),
+	}
+}
+
+// channelType returns the type of the channel of a message, for the
+// labels of metrics.
+func channelType(msg *Message) string {
+	if channel := msg.FromChannel; channel != nil {
+		switch {
+		case channel.IsIM:
+			return "im"
+		case channel.IsMpIM:
+			return "mpim"
+		case channel.IsPrivate || channel.IsGroup:
+			return "private"
+		}
+		return "public"
+	}
+	switch {
+	case msg.IsPrivate() || strings.HasPrefix(msg.Channel, "D"):
+		return "im"
+	case strings.HasPrefix(msg.Channel, "G"):
+		return "private"
+	}
+	return "public"
+}
+
+// serveMetrics serves the metrics on the address of the Metrics
+// section, if any, until the bot shuts down.
+func (bot *Bot) serveMetrics() {
+	config := bot.MetricsConfig
+	if config.Listen == "" {
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(config.Path, bot.Metrics)
+	server := &http.Server{Addr: config.Listen, Handler: mux}
+	go func() {
+		<-bot.ctx.Done()
+		server.Close()
+	}()
+
+	bot.Logger.Info("Serving metrics", "listen", config.Listen, "path", config.Path)
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		bot.Logger.Error("Metrics server error", "err", err)
+	}
+}
@@ -0,0 +1,132 @@
+package plotbot
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+func metricsText(t *testing.T, metrics *Metrics) string {
+	var buf bytes.Buffer
+	if _, err := metrics.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestMetrics(t *testing.T) {
+	metrics := NewMetrics()
+	messages := metrics.Counter("messages_total", "Messages, by channel.", "channel")
+	messages.Inc("im")
+	messages.Add(2, "public")
+	messages.Add(-1, "public")
+	metrics.Counter("messages_total", "Messages, by channel.", "channel").Inc("im")
+
+	queued := metrics.Gauge("queued", "Queued \\ replies.\nReally.")
+	queued.Set(3)
+	queued.Add(-1)
+	metrics.GaugeFunc("answer", "The answer.", func() float64 { return 42 })
+
+	plugin := metrics.Namespace("my-plugin")
+	durations := plugin.Histogram("duration_seconds", "Durations.", []float64{0.1, 1}, "outcome")
+	durations.Observe(0.05, `say "hi"`)
+	durations.Observe(0.5, `say "hi"`)
+	durations.Observe(2, `say "hi"`)
+
+	expected := `# HELP plotbot_answer The answer.
+# TYPE plotbot_answer gauge
+plotbot_answer 42
+# HELP plotbot_messages_total Messages, by channel.
+# TYPE plotbot_messages_total counter
+plotbot_messages_total{channel="im"} 2
+plotbot_messages_total{channel="public"} 2
+# HELP plotbot_my_plugin_duration_seconds Durations.
+# TYPE plotbot_my_plugin_duration_seconds histogram
+plotbot_my_plugin_duration_seconds_bucket{outcome="say \"hi\"",le="0.1"} 1
+plotbot_my_plugin_duration_seconds_bucket{outcome="say \"hi\"",le="1"} 2
+plotbot_my_plugin_duration_seconds_bucket{outcome="say \"hi\"",le="+Inf"} 3
+plotbot_my_plugin_duration_seconds_sum{outcome="say \"hi\""} 2.55
+plotbot_my_plugin_duration_seconds_count{outcome="say \"hi\""} 3
+# HELP plotbot_queued Queued \\ replies.\nReally.
+# TYPE plotbot_queued gauge
+plotbot_queued 2
+`
+	if text := metricsText(t, metrics); text != expected {
+		t.Errorf("unexpected metrics:\n%s", text)
+	}
+
+	var nilCounter *Counter
+	nilCounter.Inc("ignored")
+
+	for name, register := range map[string]func(){
+		"conflicting type":   func() { metrics.Gauge("messages_total", "") },
+		"conflicting func":   func() { metrics.GaugeFunc("queued", "", func() float64 { return 0 }) },
+		"conflicting labels": func() { metrics.Counter("messages_total", "", "user") },
+		"invalid name":       func() { metrics.Counter("messages-total", "") },
+		"reserved label":     func() { metrics.Counter("other_total", "", "le") },
+		"wrong label count":  func() { messages.Inc("im", "extra") },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: expected a panic", name)
+				}
+			}()
+			register()
+		}()
+	}
+
+	recorder := httptest.NewRecorder()
+	metrics.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.HasPrefix(recorder.Header().Get("Content-Type"), "text/plain; version=0.0.4") ||
+		!strings.Contains(recorder.Body.String(), "plotbot_answer 42\n") {
+		t.Errorf("unexpected response %q: %s", recorder.Header().Get("Content-Type"), recorder.Body.String())
+	}
+}
+
+func TestBotMetrics(t *testing.T) {
+	adapter := newFakeAdapter()
+	adapter.channels = []slack.Channel{{
+		GroupConversation: slack.GroupConversation{
+			Conversation: slack.Conversation{ID: "C1"},
+			Name:         "general",
+		},
+	}}
+	bot := newTestBot(adapter)
+
+	bot.ListenFor(&Conversation{
+		HandlerFunc: func(conv *Conversation, msg *Message) {
+			conv.Reply(msg, "hodor!")
+		},
+	})
+	time.Sleep(50 * time.Millisecond)
+
+	adapter.events <- &MessageEvent{Msg: slack.Msg{User: "U1", Channel: "C1", Text: "hello"}}
+	adapter.events <- &MessageEvent{Msg: slack.Msg{User: "U1", Channel: "D1", Text: "hello"}}
+	for i := 0; i < 2; i++ {
+		select {
+		case <-adapter.sent:
+		case <-time.After(time.Second):
+			t.Fatal("no reply sent through the adapter")
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	text := metricsText(t, bot.Metrics)
+	for _, expected := range []string{
+		`plotbot_messages_received_total{channel_type="im"} 1`,
+		`plotbot_messages_received_total{channel_type="public"} 1`,
+		`plotbot_conversations_active 1`,
+		`plotbot_handler_duration_seconds_count{plugin="plotbot"} 2`,
+		`plotbot_replies_sent_total 2`,
+		`plotbot_outbox_queued 0`,
+	} {
+		if !strings.Contains(text, expected+"\n") {
+			t.Errorf("expected %q in the metrics:\n%s", expected, text)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package plotbot
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Migration upgrades the stored data of a plugin to a schema version.
+// See PluginMigrator.
+type Migration struct {
+	Version     int
+	Description string
+	// Migrate rewrites the data, in the plugin's Store.  It can be nil
+	// for the first version, to only record it.
+	Migrate func(store Store) error
+}
+
+// PluginMigrator is implemented by plugins whose stored data changes
+// format.  The schema version of each plugin's namespace is recorded,
+// and the migrations with a higher Version run in order at startup,
+// before `InitStore()`.  Each one that succeeds bumps the recorded
+// version, so a failed migration is retried upon the next start.
+//
+// The bot refuses to start when a migration fails, or when the data
+// was written by a newer version of the plugin.
+type PluginMigrator interface {
+	Migrations() []Migration
+}
+
+// schemaNamespace holds the schema version of each namespace.
+const schemaNamespace = "_schema"
+
+// SchemaVersion returns the schema version of a namespace of `root`,
+// zero if it was never migrated.
+func SchemaVersion(root Store, namespace string) (int, error) {
+	var version int
+	err := root.Namespace(schemaNamespace).Get(namespace, &version)
+	if err == ErrNotFound {
+		return 0, nil
+	}
+	return version, err
+}
+
+// migratePlugins runs the pending migrations of the PluginMigrators.
+func migratePlugins(root Store, plugins []*enabledPlugin) error {
+	for _, enabled := range plugins {
+		migrator, ok := enabled.plugin.(PluginMigrator)
+		if !ok {
+			continue
+		}
+		if err := migrateNamespace(root, enabled.name, migrator.Migrations()); err != nil {
+			return fmt.Errorf("migrating %s: %s", enabled.name, err)
+		}
+	}
+	return nil
+}
+
+// migrateNamespace brings a namespace of `root` to the latest version
+// of `migrations`.
+func migrateNamespace(root Store, namespace string, migrations []Migration) error {
+	migrations = append([]Migration{}, migrations...)
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	for i, migration := range migrations {
+		if migration.Version <= 0 || (i > 0 && migration.Version == migrations[i-1].Version) {
+			return fmt.Errorf("invalid migration version %d", migration.Version)
+		}
+	}
+
+	current, err := SchemaVersion(root, namespace)
+	if err != nil {
+		return err
+	}
+	latest := 0
+	if len(migrations) != 0 {
+		latest = migrations[len(migrations)-1].Version
+	}
+	if current > latest {
+		return fmt.Errorf("data is at schema version %d, newer than the latest known %d", current, latest)
+	}
+
+	store := root.Namespace(namespace)
+	versions := root.Namespace(schemaNamespace)
+	for _, migration := range migrations {
+		if migration.Version <= current {
+			continue
+		}
+		defaultLogger.Info("Migrating", "namespace", namespace, "version", migration.Version,
+			"description", migration.Description)
+		if migration.Migrate != nil {
+			if err := migration.Migrate(store); err != nil {
+				return fmt.Errorf("version %d: %s", migration.Version, err)
+			}
+		}
+		if err := versions.Put(namespace, migration.Version); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package plotbot
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+type migratingPlugin struct {
+	migrations []Migration
+}
+
+func (p *migratingPlugin) Migrations() []Migration {
+	return p.migrations
+}
+
+func TestMigratePlugins(t *testing.T) {
+	root := NewMemoryStore()
+	root.Namespace("notes").Put("note:1", "old format")
+
+	ran := make([]string, 0)
+	rewrite := func(version int) func(store Store) error {
+		return func(store Store) error {
+			ran = append(ran, fmt.Sprint(version))
+			var note string
+			if err := store.Get("note:1", &note); err != nil {
+				return err
+			}
+			return store.Put("note:1", fmt.Sprintf("%s, v%d", note, version))
+		}
+	}
+	plugin := &migratingPlugin{[]Migration{
+		{Version: 2, Description: "second", Migrate: rewrite(2)},
+		{Version: 1, Description: "initial"},
+	}}
+	plugins := []*enabledPlugin{{name: "notes", plugin: plugin}, {name: "echo", plugin: &echoPlugin{}}}
+
+	if err := migratePlugins(root, plugins); err != nil {
+		t.Fatal(err)
+	}
+	if version, _ := SchemaVersion(root, "notes"); version != 2 {
+		t.Errorf("expected schema version 2, got %d", version)
+	}
+
+	plugin.migrations = append(plugin.migrations,
+		Migration{Version: 3, Migrate: rewrite(3)},
+		Migration{Version: 4, Migrate: func(Store) error { return fmt.Errorf("disk full") }},
+		Migration{Version: 5, Migrate: rewrite(5)},
+	)
+	err := migratePlugins(root, plugins)
+	if err == nil || err.Error() != "migrating notes: version 4: disk full" {
+		t.Errorf("expected the failed migration, got %v", err)
+	}
+	if version, _ := SchemaVersion(root, "notes"); version != 3 {
+		t.Errorf("expected the version of the last successful migration, got %d", version)
+	}
+	if strings.Join(ran, ",") != "2,3" {
+		t.Errorf("expected each migration to run once, in order, got %v", ran)
+	}
+	var note string
+	root.Namespace("notes").Get("note:1", &note)
+	if note != "old format, v2, v3" {
+		t.Errorf("expected the data to be migrated, got %q", note)
+	}
+
+	plugin.migrations = plugin.migrations[:2]
+	if err := migratePlugins(root, plugins); err == nil || !strings.Contains(err.Error(), "newer than the latest known 2") {
+		t.Errorf("expected data from a newer version to be refused, got %v", err)
+	}
+
+	plugin.migrations = []Migration{{Version: 1}, {Version: 1}}
+	if err := migratePlugins(root, plugins); err == nil {
+		t.Error("expected duplicate versions to be refused")
+	}
+}
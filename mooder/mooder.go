@@ -8,41 +8,57 @@ import (
 )
 
 type Mooder struct {
-	bot *plotbot.Bot
+	bot    *plotbot.Bot
+	logger *plotbot.Logger
 }
 
 func init() {
-	plotbot.RegisterPlugin(&Mooder{})
+	plotbot.RegisterPlugin("mooder", &Mooder{})
 }
 
-func (mooder *Mooder) InitPlugin(bot *plotbot.Bot) {
-	mooder.bot = bot
-	go mooder.SetupMoodChanger()
+func (mooder *Mooder) InitLogger(logger *plotbot.Logger) {
+	mooder.logger = logger
 }
 
-func (mooder *Mooder) SetupMoodChanger() {
-	bot := mooder.bot
-	for {
-		time.Sleep(10 * time.Second)
-		newMood := plotbot.Happy
+// moodSchedule is when the mood changes, like it used to: at noon UTC
+// on weekdays.
+const moodSchedule = "every weekday at 12:00 in UTC"
 
-		rand.Seed(time.Now().UTC().UnixNano())
-
-		happyChances := rand.Int() % 10
-		if happyChances > 6 {
-			newMood = plotbot.Hyper
-		}
-
-		bot.SetMood(newMood)
+func (mooder *Mooder) InitPlugin(bot *plotbot.Bot) {
+	mooder.bot = bot
+	rand.Seed(time.Now().UTC().UnixNano())
 
-		//bot.SendToChannel(bot.Config.GeneralChannel, bot.WithMood("I'm quite happy today.", "I can haz!! It's going to be a great one today!!"))
+	bot.Scheduler.Handle("mooder", func(job plotbot.Job, scheduledAt time.Time) {
+		mooder.changeMood()
+	})
+	_, err := bot.Scheduler.Schedule(plotbot.Job{
+		ID:      "mooder:daily",
+		Handler: "mooder",
+		Spec:    moodSchedule,
+		Missed:  plotbot.SkipMissed,
+	})
+	if err != nil {
+		mooder.logger.Error("Could not schedule the mood changes", "err", err)
+	}
 
+	go func() {
 		select {
-		case <-plotbot.AfterNextWeekdayTime(time.Monday, 12, 0):
-		case <-plotbot.AfterNextWeekdayTime(time.Tuesday, 12, 0):
-		case <-plotbot.AfterNextWeekdayTime(time.Wednesday, 12, 0):
-		case <-plotbot.AfterNextWeekdayTime(time.Thursday, 12, 0):
-		case <-plotbot.AfterNextWeekdayTime(time.Friday, 12, 0):
+		case <-time.After(10 * time.Second):
+			mooder.changeMood()
+		case <-bot.Context().Done():
 		}
+	}()
+}
+
+func (mooder *Mooder) changeMood() {
+	newMood := plotbot.Happy
+
+	happyChances := rand.Int() % 10
+	if happyChances > 6 {
+		newMood = plotbot.Hyper
 	}
+
+	mooder.bot.SetMood(newMood)
+
+	//bot.SendToChannel(bot.Config.GeneralChannel, bot.WithMood("I'm quite happy today.", "I can haz!! It's going to be a great one today!!"))
 }
@@ -0,0 +1,264 @@
+package plotbot
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// outboxPolicy tunes the delivery of outgoing messages.
+type outboxPolicy struct {
+	// interval is the minimum delay between two messages posted to
+	// the same channel.  Slack allows about one per second.
+	interval time.Duration
+	// maxAttempts is the number of tries before a message is
+	// dead-lettered.
+	maxAttempts int
+	// backoff is the delay before the first retry, doubled after each
+	// failure up to maxBackoff.  Rate limited posts wait for the
+	// `Retry-After` delay given by Slack instead.
+	backoff    time.Duration
+	maxBackoff time.Duration
+	// maxAge drops messages that could not be delivered in time, as
+	// they'd arrive out of context.
+	maxAge time.Duration
+	// maxDeadLetters is the number of failed deliveries kept for
+	// inspection.
+	maxDeadLetters int
+}
+
+var defaultOutboxPolicy = outboxPolicy{
+	interval:       time.Second,
+	maxAttempts:    5,
+	backoff:        time.Second,
+	maxBackoff:     30 * time.Second,
+	maxAge:         5 * time.Minute,
+	maxDeadLetters: 100,
+}
+
+// permanentSendErrors are the Slack errors retrying won't fix.
+var permanentSendErrors = map[string]bool{
+	"channel_not_found": true,
+	"not_in_channel":    true,
+	"is_archived":       true,
+	"msg_too_long":      true,
+	"no_text":           true,
+	"invalid_auth":      true,
+	"account_inactive":  true,
+	"invalid_blocks":    true,
+
+	"message_not_found":   true,
+	"cant_update_message": true,
+	"edit_window_closed":  true,
+}
+
+// DeadLetter is an outgoing message that could not be delivered.
+type DeadLetter struct {
+	Reply *BotReply
+	// Updates is the timestamp of the message the reply was to
+	// replace, for updates.
+	Updates  string
+	Err      error
+	Attempts int
+	At       time.Time
+}
+
+// outbox queues outgoing messages, and posts them through the Adapter
+// from one goroutine per channel, so a slow or rate limited channel
+// doesn't hold the others back.  Updates of posted messages go through
+// the same queues.  Queuing never blocks.
+type outbox struct {
+	send   func(*BotReply) (string, error)
+	update func(channelID, timestamp string, reply *BotReply) error
+	policy outboxPolicy
+	logger *Logger
+
+	mu          sync.Mutex
+	queues      map[string][]*outboxItem
+	deadLetters []DeadLetter
+
+	sent, failed *Counter
+}
+
+type outboxItem struct {
+	reply *BotReply
+	// updates is the timestamp of the message the reply replaces,
+	// for updates.
+	updates  string
+	queuedAt time.Time
+}
+
+func newOutbox(send func(*BotReply) (string, error), update func(string, string, *BotReply) error,
+	policy outboxPolicy) *outbox {
+	return &outbox{
+		send:   send,
+		update: update,
+		policy: policy,
+		logger: defaultLogger.With("component", "outbox"),
+		queues: make(map[string][]*outboxItem),
+	}
+}
+
+// instrument registers the metrics of the outbox.
+func (o *outbox) instrument(metrics *Metrics) {
+	o.sent = metrics.Counter("replies_sent_total", "Replies, notifications and updates posted.")
+	o.failed = metrics.Counter("replies_failed_total", "Replies, notifications and updates given up, see the dead letters.")
+	metrics.GaugeFunc("outbox_queued", "Replies waiting in the outbox.", func() float64 {
+		return float64(o.queued())
+	})
+}
+
+// enqueue adds a reply to the queue of its channel, and starts a
+// worker for the channel if it was idle.
+func (o *outbox) enqueue(reply *BotReply) {
+	o.push(&outboxItem{reply: reply, queuedAt: time.Now()})
+}
+
+// enqueueUpdate queues the replacement of the message posted at
+// `timestamp` in `reply.To`, like a reply.
+func (o *outbox) enqueueUpdate(timestamp string, reply *BotReply) {
+	o.push(&outboxItem{reply: reply, updates: timestamp, queuedAt: time.Now()})
+}
+
+func (o *outbox) push(item *outboxItem) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	channel := item.reply.To
+	queue, busy := o.queues[channel]
+	o.queues[channel] = append(queue, item)
+	if !busy {
+		go o.work(channel)
+	}
+}
+
+// next pops the next reply of a channel, or forgets the channel when
+// its queue is empty, so the worker can exit.
+func (o *outbox) next(channel string) *outboxItem {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	queue := o.queues[channel]
+	if len(queue) == 0 {
+		delete(o.queues, channel)
+		return nil
+	}
+	o.queues[channel] = queue[1:]
+	return queue[0]
+}
+
+func (o *outbox) work(channel string) {
+	for item := o.next(channel); item != nil; item = o.next(channel) {
+		timestamp, err := o.deliver(item)
+		if item.reply.OnDelivery != nil {
+			item.reply.OnDelivery(timestamp, err)
+		}
+		time.Sleep(o.policy.interval)
+	}
+}
+
+// deliver posts a reply, retrying until it's sent or out of budget.
+func (o *outbox) deliver(item *outboxItem) (string, error) {
+	reply := item.reply
+	backoff := o.policy.backoff
+
+	var err error
+	attempt := 1
+	for ; ; attempt++ {
+		if age := time.Since(item.queuedAt); age > o.policy.maxAge {
+			err = fmt.Errorf("expired after %s in the queue", age)
+			break
+		}
+
+		var timestamp string
+		timestamp, err = o.post(item)
+		if err == nil {
+			o.sent.Inc()
+			return timestamp, nil
+		}
+
+		if permanentSendErrors[err.Error()] || attempt >= o.policy.maxAttempts {
+			break
+		}
+
+		wait := backoff
+		if rateLimited, ok := err.(*slack.RateLimitedError); ok {
+			wait = rateLimited.RetryAfter
+		} else {
+			backoff *= 2
+			if backoff > o.policy.maxBackoff {
+				backoff = o.policy.maxBackoff
+			}
+		}
+		o.logger.Warn("Error sending, retrying", "to", reply.To, "updates", item.updates,
+			"attempt", attempt, "delay", wait, "err", err)
+		time.Sleep(wait)
+	}
+
+	o.logger.Error("Could not deliver message", "to", reply.To, "updates", item.updates,
+		"attempts", attempt, "err", err, "text", Body(reply.Text))
+	o.deadLetter(DeadLetter{Reply: reply, Updates: item.updates, Err: err, Attempts: attempt, At: time.Now()})
+	o.failed.Inc()
+	return "", err
+}
+
+// post sends a new message, or updates one, returning the message's
+// timestamp.
+func (o *outbox) post(item *outboxItem) (string, error) {
+	if item.updates == "" {
+		return o.send(item.reply)
+	}
+	return item.updates, o.update(item.reply.To, item.updates, item.reply)
+}
+
+// queued returns the number of replies waiting in the queues.
+func (o *outbox) queued() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	pending := 0
+	for _, queue := range o.queues {
+		pending += len(queue)
+	}
+	return pending
+}
+
+// flush waits until all queued replies are delivered or given up, or
+// until `ctx` is done.
+func (o *outbox) flush(ctx context.Context) error {
+	for {
+		o.mu.Lock()
+		idle := len(o.queues) == 0
+		o.mu.Unlock()
+
+		if idle {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%d replies still queued: %s", o.queued(), ctx.Err())
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+}
+
+func (o *outbox) deadLetter(letter DeadLetter) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.deadLetters = append(o.deadLetters, letter)
+	if extra := len(o.deadLetters) - o.policy.maxDeadLetters; extra > 0 {
+		o.deadLetters = o.deadLetters[extra:]
+	}
+}
+
+// DeadLetters returns the latest messages that could not be delivered.
+func (o *outbox) DeadLetters() []DeadLetter {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return append([]DeadLetter(nil), o.deadLetters...)
+}
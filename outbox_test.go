@@ -0,0 +1,202 @@
+package plotbot
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+var testOutboxPolicy = outboxPolicy{
+	interval:       time.Millisecond,
+	maxAttempts:    3,
+	backoff:        time.Millisecond,
+	maxBackoff:     5 * time.Millisecond,
+	maxAge:         time.Second,
+	maxDeadLetters: 2,
+}
+
+type deliveryResult struct {
+	timestamp string
+	err       error
+}
+
+func sendAndWait(t *testing.T, o *outbox, reply *BotReply) deliveryResult {
+	done := make(chan deliveryResult, 1)
+	reply.OnDelivery = func(timestamp string, err error) {
+		done <- deliveryResult{timestamp, err}
+	}
+	o.enqueue(reply)
+
+	select {
+	case result := <-done:
+		return result
+	case <-time.After(time.Second):
+		t.Fatalf("no delivery report for %q", reply.Text)
+	}
+	return deliveryResult{}
+}
+
+func TestOutboxRetries(t *testing.T) {
+	var attempts []time.Time
+	o := newOutbox(func(reply *BotReply) (string, error) {
+		attempts = append(attempts, time.Now())
+		switch len(attempts) {
+		case 1:
+			return "", &slack.RateLimitedError{RetryAfter: 50 * time.Millisecond}
+		case 2:
+			return "", errors.New("internal_error")
+		}
+		return "1234.5678", nil
+	}, nil, testOutboxPolicy)
+
+	result := sendAndWait(t, o, &BotReply{To: "C1", Text: "hello"})
+	if result.err != nil || result.timestamp != "1234.5678" {
+		t.Errorf("expected delivery, got %#v", result)
+	}
+	if len(attempts) != 3 {
+		t.Fatalf("expected 3 attempts, got %d", len(attempts))
+	}
+	if attempts[1].Sub(attempts[0]) < 50*time.Millisecond {
+		t.Error("expected Retry-After to be honored")
+	}
+	if len(o.DeadLetters()) != 0 {
+		t.Error("expected no dead letter")
+	}
+}
+
+func TestOutboxDeadLetters(t *testing.T) {
+	attempts := 0
+	o := newOutbox(func(reply *BotReply) (string, error) {
+		attempts++
+		if reply.To == "C404" {
+			return "", errors.New("channel_not_found")
+		}
+		return "", errors.New("internal_error")
+	}, nil, testOutboxPolicy)
+
+	result := sendAndWait(t, o, &BotReply{To: "C404", Text: "lost"})
+	if result.err == nil || attempts != 1 {
+		t.Errorf("expected permanent errors not to be retried, got %d attempts", attempts)
+	}
+
+	attempts = 0
+	result = sendAndWait(t, o, &BotReply{To: "C1", Text: "flaky"})
+	if result.err == nil || attempts != testOutboxPolicy.maxAttempts {
+		t.Errorf("expected %d attempts, got %d", testOutboxPolicy.maxAttempts, attempts)
+	}
+
+	sendAndWait(t, o, &BotReply{To: "C1", Text: "flaky again"})
+
+	letters := o.DeadLetters()
+	if len(letters) != 2 {
+		t.Fatalf("expected the 2 latest dead letters, got %d", len(letters))
+	}
+	if letters[0].Reply.Text != "flaky" || letters[0].Attempts != 3 || letters[1].Reply.Text != "flaky again" {
+		t.Errorf("unexpected dead letters %#v", letters)
+	}
+}
+
+func TestOutboxChannelsAreIndependent(t *testing.T) {
+	blocked := make(chan bool)
+	var mu sync.Mutex
+	var sent []string
+
+	o := newOutbox(func(reply *BotReply) (string, error) {
+		if reply.To == "C1" {
+			<-blocked
+		}
+		mu.Lock()
+		sent = append(sent, reply.Text)
+		mu.Unlock()
+		return "1234.5678", nil
+	}, nil, testOutboxPolicy)
+
+	// Queuing never blocks, even with a stuck channel.
+	for i := 0; i < 100; i++ {
+		o.enqueue(&BotReply{To: "C1", Text: "one"})
+	}
+
+	result := sendAndWait(t, o, &BotReply{To: "C2", Text: "two"})
+	if result.err != nil {
+		t.Fatal(result.err)
+	}
+	close(blocked)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(sent) == 0 || sent[0] != "two" {
+		t.Errorf("expected C2 not to wait for C1, got %v", sent)
+	}
+}
+
+func TestOutboxFlush(t *testing.T) {
+	release := make(chan bool)
+	o := newOutbox(func(reply *BotReply) (string, error) {
+		<-release
+		return "1", nil
+	}, nil, testOutboxPolicy)
+
+	o.enqueue(&BotReply{To: "C1", Text: "one"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := o.flush(ctx); err == nil {
+		t.Error("expected flush to time out while a reply is stuck")
+	}
+
+	close(release)
+	if err := o.flush(context.Background()); err != nil {
+		t.Errorf("expected flush to complete, got %s", err)
+	}
+}
+
+func TestOutboxUpdates(t *testing.T) {
+	var mu sync.Mutex
+	var posted []string
+	attempts := 0
+	o := newOutbox(func(reply *BotReply) (string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		posted = append(posted, "post "+reply.Text)
+		return "1234.5678", nil
+	}, func(channelID, timestamp string, reply *BotReply) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if timestamp == "1111.0000" {
+			return errors.New("message_not_found")
+		}
+		attempts++
+		if attempts == 1 {
+			return &slack.RateLimitedError{RetryAfter: 20 * time.Millisecond}
+		}
+		posted = append(posted, "update "+timestamp+" "+reply.Text)
+		return nil
+	}, testOutboxPolicy)
+
+	o.enqueueUpdate("1234.5678", &BotReply{To: "C1", Text: "edited"})
+	o.enqueue(&BotReply{To: "C1", Text: "next"})
+	if err := o.flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	if attempts != 2 || len(posted) != 2 || posted[0] != "update 1234.5678 edited" || posted[1] != "post next" {
+		t.Errorf("expected the rate limited update to be retried before the next post, got %v", posted)
+	}
+	mu.Unlock()
+
+	done := make(chan deliveryResult, 1)
+	o.enqueueUpdate("1111.0000", &BotReply{To: "C1", Text: "lost", OnDelivery: func(timestamp string, err error) {
+		done <- deliveryResult{timestamp, err}
+	}})
+	if result := <-done; result.err == nil {
+		t.Error("expected the update to fail")
+	}
+	letters := o.DeadLetters()
+	if len(letters) != 1 || letters[0].Updates != "1111.0000" || letters[0].Attempts != 1 {
+		t.Errorf("expected the update to be dead-lettered without retries, got %#v", letters)
+	}
+}
@@ -4,8 +4,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/plotly/plotbot"
@@ -13,10 +13,14 @@ import (
 
 type PlotBerry struct {
 	bot        *plotbot.Bot
+	sender     plotbot.BotLike
+	logger     *plotbot.Logger
+	commands   *plotbot.Router
 	totalUsers int
-	pingTime   time.Duration
-	eraLength  int
-	endpoint   string
+	// conf is read by the watcher and counter goroutines, and changed
+	// by `Reconfigure()`.
+	lock sync.Mutex
+	conf PlotberryConf
 }
 
 type TotalUsers struct {
@@ -24,54 +28,89 @@ type TotalUsers struct {
 }
 
 type PlotberryConf struct {
-	EraLength int
-	PingTime  int
-	EndPoint  string
+	// EraLength is the number of users between two celebrations.  It
+	// may not be zero, as it divides the user count.
+	EraLength int `config:"required"`
+	// PingTime is the number of seconds between two user counts.
+	PingTime int    `default:"60"`
+	EndPoint string `config:"required"`
 }
 
 func init() {
-	plotbot.RegisterPlugin(&PlotBerry{})
+	plotbot.RegisterPlugin("plotberry", &PlotBerry{})
+}
+
+func (plotberry *PlotBerry) InitLogger(logger *plotbot.Logger) {
+	plotberry.logger = logger
 }
 
 func (plotberry *PlotBerry) InitPlugin(bot *plotbot.Bot) {
 
-	var conf struct {
-		Plotberry PlotberryConf
-	}
-	err := bot.LoadConfig(&conf)
+	var conf PlotberryConf
+	err := plotbot.DecodeSection(bot.LoadConfig, "Plotberry", &conf)
 	if err != nil {
-		log.Fatalln("Error loading PlotBerry config section: ", err)
+		plotberry.logger.Fatal("Error loading PlotBerry config section", "err", err)
 		return
 	}
 
 	plotberry.bot = bot
-	plotberry.pingTime = time.Duration(conf.Plotberry.PingTime) * time.Second
-	plotberry.eraLength = conf.Plotberry.EraLength
-	plotberry.endpoint = conf.Plotberry.EndPoint
-
-	// if plotberry.eraLength is 0 we will get a divide by zero error - lets abort first
-	if plotberry.eraLength == 0 {
-		log.Fatal("Plotberry.eraLength may not be zero (divide by zero error), please set the configuration")
-		return
-	}
+	plotberry.sender = bot.PluginBot()
+	plotberry.setConf(conf)
 
 	statchan := make(chan TotalUsers, 100)
 
 	go plotberry.launchWatcher(statchan)
 	go plotberry.launchCounter(statchan)
 
+	plotberry.commands = plotbot.NewRouter()
+	plotberry.commands.Add(&plotbot.Command{
+		Usage:       "how many user|users",
+		Description: "tell how many users signed up",
+		HandlerFunc: plotberry.usersCommand,
+	})
+
 	bot.ListenFor(&plotbot.Conversation{
 		HandlerFunc: plotberry.ChatHandler,
 	})
 }
 
+func (plotberry *PlotBerry) ConfigSchema() (string, interface{}) {
+	return "Plotberry", &PlotberryConf{}
+}
+
+// Reconfigure takes the new endpoint, ping time and era length.
+func (plotberry *PlotBerry) Reconfigure(load func(config interface{}) error) error {
+	var conf PlotberryConf
+	if err := plotbot.DecodeSection(load, "Plotberry", &conf); err != nil {
+		return err
+	}
+	plotberry.setConf(conf)
+	return nil
+}
+
+func (plotberry *PlotBerry) setConf(conf PlotberryConf) {
+	plotberry.lock.Lock()
+	defer plotberry.lock.Unlock()
+	plotberry.conf = conf
+}
+
+func (plotberry *PlotBerry) settings() PlotberryConf {
+	plotberry.lock.Lock()
+	defer plotberry.lock.Unlock()
+	return plotberry.conf
+}
+
 func (plotberry *PlotBerry) ChatHandler(conv *plotbot.Conversation, msg *plotbot.Message) {
-	if msg.MentionsMe && msg.Contains("how many user") {
-		conv.Reply(msg, fmt.Sprintf("We got %d users!", plotberry.totalUsers))
+	if msg.MentionsMe {
+		plotberry.commands.Handle(conv, msg)
 	}
 	return
 }
 
+func (plotberry *PlotBerry) usersCommand(conv *plotbot.Conversation, msg *plotbot.Message, args plotbot.CommandArgs) {
+	conv.Reply(msg, fmt.Sprintf("We got %d users!", plotberry.totalUsers))
+}
+
 func getplotberry(endpoint string) (*TotalUsers, error) {
 
 	var data TotalUsers
@@ -97,14 +136,20 @@ func getplotberry(endpoint string) (*TotalUsers, error) {
 }
 
 func (plotberry *PlotBerry) launchWatcher(statchan chan TotalUsers) {
+	defer close(statchan)
 
 	for {
-		time.Sleep(plotberry.pingTime)
+		conf := plotberry.settings()
+		select {
+		case <-time.After(time.Duration(conf.PingTime) * time.Second):
+		case <-plotberry.bot.Context().Done():
+			return
+		}
 
-		data, err := getplotberry(plotberry.endpoint)
+		data, err := getplotberry(conf.EndPoint)
 
 		if err != nil {
-			log.Print(err)
+			plotberry.logger.Warn("Could not count the users", "err", err)
 			continue
 		}
 
@@ -122,21 +167,22 @@ func (plotberry *PlotBerry) launchCounter(statchan chan TotalUsers) {
 	var countDownActive bool
 
 	send := func(msg string) {
-		plotberry.bot.SendToChannel(plotberry.bot.Config.GeneralChannel, msg)
+		plotberry.sender.SendToChannel(plotberry.bot.Config.GeneralChannel, msg)
 	}
 
 	doFinale := func(msg string) {
 		send(msg)
 		go func() {
 			time.Sleep(22 * time.Second)
-			plotberry.bot.SendToChannel(plotberry.bot.Config.GeneralChannel, "...I like mimosas")
+			plotberry.sender.SendToChannel(plotberry.bot.Config.GeneralChannel, "...I like mimosas")
 		}()
 	}
 
 	for data := range statchan {
 
+		eraLength := plotberry.settings().EraLength
 		totalUsers := data.Plotberries
-		untilNext := plotberry.eraLength - totalUsers%plotberry.eraLength
+		untilNext := eraLength - totalUsers%eraLength
 		nextEra := untilNext + totalUsers
 
 		// we have already seen this count
@@ -173,7 +219,7 @@ func (plotberry *PlotBerry) launchCounter(statchan chan TotalUsers) {
 			send(fmt.Sprintf("%d user until %d.\nYOU'RE ALL MAGIC!", untilNext, nextEra))
 
 			// use plotberry era as untilNext will == plotbot.era when totalUsers mod era == 0
-		case plotberry.eraLength:
+		case eraLength:
 			doFinale(fmt.Sprintf("@all !!!\n We're at %d user signups!!!!! Whup Whup - Party for me this weekend", totalUsers))
 			countDownActive = false
 		default:
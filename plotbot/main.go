@@ -2,6 +2,8 @@ package main
 
 import (
 	"flag"
+	"fmt"
+	"io"
 	"os"
 
 	"github.com/plotly/plotbot"
@@ -9,14 +11,146 @@ import (
 	_ "github.com/plotly/plotbot/deployer"
 	_ "github.com/plotly/plotbot/mooder"
 	_ "github.com/plotly/plotbot/plotberry"
+	_ "github.com/plotly/plotbot/reminders"
+	_ "github.com/plotly/plotbot/standup"
 )
 
 var configFile = flag.String("config", os.Getenv("HOME")+"/.plotbot", "config file")
+var checkConfig = flag.Bool("check-config", false, "validate the config file and exit, without connecting to Slack")
+
+const usage = `Usage: plotbot [flags]
+       plotbot [flags] db export [file]
+       plotbot [flags] db import [file]
+       plotbot [flags] audit export [file]
+
+The db commands dump the database to JSON lines, or restore such a dump,
+to or from the standard streams when no file is given.  audit export
+writes the records of the audit log as JSON lines, oldest first.  Stop
+the bot first, as it locks the database.
+
+Flags:
+`
 
 func main() {
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
 	flag.Parse()
 
 	bot := plotbot.New(*configFile)
 
+	if *checkConfig {
+		errs := bot.CheckConfig()
+		for _, err := range errs {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		if len(errs) != 0 {
+			os.Exit(1)
+		}
+		fmt.Println("Configuration OK")
+		return
+	}
+
+	if flag.NArg() != 0 {
+		run := runDBCommand
+		if flag.Arg(0) == "audit" {
+			run = runAuditCommand
+		}
+		if err := run(bot, flag.Args()); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	bot.Run()
 }
+
+// runDBCommand runs `plotbot db export` and `plotbot db import`.
+func runDBCommand(bot *plotbot.Bot, args []string) error {
+	if len(args) < 2 || len(args) > 3 || args[0] != "db" || (args[1] != "export" && args[1] != "import") {
+		flag.Usage()
+		os.Exit(2)
+	}
+	file := "-"
+	if len(args) == 3 {
+		file = args[2]
+	}
+
+	if err := bot.OpenDB(); err != nil {
+		return fmt.Errorf("could not open the database: %s", err)
+	}
+	defer bot.CloseDB()
+
+	if args[1] == "export" {
+		var out io.WriteCloser = os.Stdout
+		if file != "-" {
+			f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+			if err != nil {
+				return err
+			}
+			out = f
+		}
+		count, err := plotbot.ExportStore(bot.Store, out)
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return fmt.Errorf("export failed after %d values: %s", count, err)
+		}
+		fmt.Fprintf(os.Stderr, "Exported %d values\n", count)
+		return nil
+	}
+
+	var in io.ReadCloser = os.Stdin
+	if file != "-" {
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		in = f
+	}
+	defer in.Close()
+	count, err := plotbot.ImportStore(bot.Store, in)
+	if err != nil {
+		return fmt.Errorf("import failed after %d values: %s", count, err)
+	}
+	fmt.Fprintf(os.Stderr, "Imported %d values\n", count)
+	return nil
+}
+
+// runAuditCommand runs `plotbot audit export`.
+func runAuditCommand(bot *plotbot.Bot, args []string) error {
+	if len(args) < 2 || len(args) > 3 || args[1] != "export" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err := bot.OpenDB(); err != nil {
+		return fmt.Errorf("could not open the database: %s", err)
+	}
+	defer bot.CloseDB()
+	audit, err := plotbot.NewAuditLog(bot.Store.Namespace("_audit"), plotbot.AuditConfig{})
+	if err != nil {
+		return err
+	}
+
+	var out io.WriteCloser = os.Stdout
+	if len(args) == 3 && args[2] != "-" {
+		f, err := os.OpenFile(args[2], os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+		if err != nil {
+			return err
+		}
+		out = f
+	}
+	count, err := audit.Export(out, plotbot.AuditQuery{})
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("export failed after %d records: %s", count, err)
+	}
+	fmt.Fprintf(os.Stderr, "Exported %d audit records\n", count)
+	return nil
+}
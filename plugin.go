@@ -1,8 +1,14 @@
 package plotbot
 
 import (
-	"log"
+	"context"
+	"encoding/json"
+	"fmt"
 	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/gorilla/sessions"
@@ -19,6 +25,22 @@ type PluginInitializer interface {
 	InitPlugin(*Bot)
 }
 
+// PluginStopper is implemented by plugins that need to clean up when
+// the bot shuts down, like finishing or interrupting a running job.
+// `Stop()` must return once `ctx` is done, even if it is not finished.
+type PluginStopper interface {
+	Stop(ctx context.Context) error
+}
+
+// PluginReconfigurer is implemented by plugins that can take a new
+// configuration without restarting the bot, see `Bot.ReloadConfig()`.
+// `load` decodes the new configuration file, like `Bot.LoadConfig()`.
+// A plugin rejecting its new section must return an error and keep
+// running with its current configuration.
+type PluginReconfigurer interface {
+	Reconfigure(load func(config interface{}) error) error
+}
+
 type WebServer interface {
 	// Used internally by the `slick` library.
 	InitWebServer(*Bot, []string)
@@ -45,24 +67,239 @@ type WebServerAuth interface {
 	InitWebServerAuth(bot *Bot, webserver WebServer)
 }
 
-var registeredPlugins = make([]Plugin, 0)
+// registeredPlugin is a plugin compiled in the bot, see
+// `RegisterPlugin()`.
+type registeredPlugin struct {
+	name   string
+	plugin Plugin
+}
+
+var registeredPlugins = make([]registeredPlugin, 0)
+
+// RegisterPlugin makes a plugin available under `name`, the name used
+// to enable it in the `Plugins` section of the configuration.  Plugins
+// register themselves in their package's `init()`.  It panics if the
+// name is already taken.
+func RegisterPlugin(name string, plugin Plugin) {
+	for _, registered := range registeredPlugins {
+		if registered.name == name {
+			panic(fmt.Sprintf("plugin %q registered twice", name))
+		}
+	}
+	registeredPlugins = append(registeredPlugins, registeredPlugin{name, plugin})
+}
+
+// PluginsConfig is the `Plugins` section of the configuration, which
+// lists the plugins to run by name:
+//
+//	"Plugins": {
+//	  "deployer": {"allow_channels": ["#devops"]},
+//	  "standup": {},
+//	  "plotberry": {"disabled": true}
+//	}
+//
+// When the section is missing, all the registered plugins run.
+//
+// Entries with a `command` run an external plugin, see external.go:
+//
+//	"jira": {
+//	  "command": "/usr/local/bin/plotbot-jira",
+//	  "args": ["-verbose"],
+//	  "env": {"JIRA_TOKEN": "${env:JIRA_TOKEN}"},
+//	  "config": {"project": "OPS"}
+//	}
+type PluginsConfig map[string]PluginSettings
+
+// PluginSettings restricts the channels where a plugin listens.  Names
+// can be given with or without their `#`, or channels by ID.  Direct
+// messages are not restricted.
+type PluginSettings struct {
+	Disabled bool
+	// AllowChannels, when set, limits the plugin to these channels.
+	AllowChannels []string `json:"allow_channels"`
+	// DenyChannels keeps the plugin out of these channels.
+	DenyChannels []string `json:"deny_channels"`
+
+	// Command, with its Args and added Env variables, is the
+	// executable of an external plugin.
+	Command string            `json:"command"`
+	Args    []string          `json:"args"`
+	Env     map[string]string `json:"env"`
+	// Config is handed to an external plugin when it starts.
+	Config json.RawMessage `json:"config"`
+}
+
+// allowsChannel reports whether the plugin may use `channel`.  A nil
+// channel is taken as a direct message.
+func (settings PluginSettings) allowsChannel(channel *slack.Channel) bool {
+	if channel == nil || channel.IsIM {
+		return true
+	}
 
-func RegisterPlugin(plugin Plugin) {
-	registeredPlugins = append(registeredPlugins, plugin)
+	matches := func(list []string) bool {
+		for _, entry := range list {
+			if entry == channel.ID || strings.TrimLeft(entry, "#") == channel.Name {
+				return true
+			}
+		}
+		return false
+	}
+	if matches(settings.DenyChannels) {
+		return false
+	}
+	return len(settings.AllowChannels) == 0 || matches(settings.AllowChannels)
+}
+
+// enabledPlugin is a plugin run by the bot, with its settings.
+type enabledPlugin struct {
+	name     string
+	plugin   Plugin
+	settings PluginSettings
+
+	// settingsLock guards the channel settings, which are reloaded
+	// while the plugin sends messages.
+	settingsLock sync.Mutex
 }
 
+// allowsChannel reports whether the plugin's current settings let it
+// use `channel`.
+func (enabled *enabledPlugin) allowsChannel(channel *slack.Channel) bool {
+	enabled.settingsLock.Lock()
+	defer enabled.settingsLock.Unlock()
+	return enabled.settings.allowsChannel(channel)
+}
+
+// setSettings applies the settings of a reloaded configuration.
+func (enabled *enabledPlugin) setSettings(settings PluginSettings) {
+	enabled.settingsLock.Lock()
+	defer enabled.settingsLock.Unlock()
+	enabled.settings = settings
+}
+
+// enablePlugins selects the registered plugins listed in `config`, or
+// all of them when it is nil, and the external plugins it lists.  It
+// returns an error for each plugin name that is neither registered nor
+// given a command.
+func enablePlugins(config PluginsConfig) ([]*enabledPlugin, []error) {
+	plugins := make([]*enabledPlugin, 0)
+	for _, registered := range registeredPlugins {
+		settings, listed := config[registered.name]
+		if config != nil && (!listed || settings.Disabled) {
+			continue
+		}
+		plugins = append(plugins, &enabledPlugin{name: registered.name, plugin: registered.plugin, settings: settings})
+	}
+
+	errs := make([]error, 0)
+	external := make([]*enabledPlugin, 0)
+	names := make([]string, 0, len(registeredPlugins))
+	known := make(map[string]bool)
+	for _, registered := range registeredPlugins {
+		names = append(names, registered.name)
+		known[registered.name] = true
+	}
+	for name, settings := range config {
+		if settings.Command != "" {
+			if known[name] {
+				errs = append(errs, fmt.Errorf("plugin %q is compiled in, and can't have a command", name))
+			} else if !settings.Disabled {
+				external = append(external, &enabledPlugin{name: name, plugin: newExternalPlugin(name, settings), settings: settings})
+			}
+			continue
+		}
+		if !known[name] {
+			errs = append(errs, fmt.Errorf("unknown plugin %q in the Plugins section, available plugins are: %s",
+				name, strings.Join(names, ", ")))
+		}
+	}
+	sort.Slice(external, func(i, j int) bool { return external[i].name < external[j].name })
+	plugins = append(plugins, external...)
+	sort.Slice(errs, func(i, j int) bool { return errs[i].Error() < errs[j].Error() })
+	return plugins, errs
+}
+
+// initChatPlugins initializes the enabled plugins.  The Conversations
+// a plugin opens in its `InitPlugin()` are restricted to the channels
+// of its settings, see `PluginBot()`.  Plugins keeping data get their Store first, and
+// those implementing PluginLoggerInitializer their Logger.
 func initChatPlugins(bot *Bot) {
-	for _, plugin := range registeredPlugins {
-		chatPlugin, ok := plugin.(PluginInitializer)
+	for _, enabled := range bot.plugins {
+		if storer, ok := enabled.plugin.(PluginStoreInitializer); ok && bot.Store != nil {
+			storer.InitStore(bot.Store.Namespace(enabled.name))
+		}
+		if instrumented, ok := enabled.plugin.(PluginMetricsInitializer); ok && bot.Metrics != nil {
+			instrumented.InitMetrics(bot.Metrics.Namespace(enabled.name))
+		}
+		if logging, ok := enabled.plugin.(PluginLoggerInitializer); ok {
+			logging.InitLogger(bot.Logger.With("plugin", enabled.name))
+		}
+		if audited, ok := enabled.plugin.(PluginAuditInitializer); ok && bot.Audit != nil {
+			audited.InitAudit(bot.Audit.For(enabled.name))
+		}
+		chatPlugin, ok := enabled.plugin.(PluginInitializer)
 		if ok {
+			bot.initializing = enabled
 			chatPlugin.InitPlugin(bot)
+			bot.initializing = nil
+		}
+	}
+}
+
+// stopPlugins calls `Stop()` on all the PluginStoppers concurrently,
+// and waits for them at most `timeout`.
+func stopPlugins(plugins []*enabledPlugin, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for _, enabled := range plugins {
+		plugin := enabled.plugin
+		stopper, ok := plugin.(PluginStopper)
+		if !ok {
+			continue
+		}
+		wg.Add(1)
+		go func(plugin Plugin) {
+			defer wg.Done()
+			if err := stopper.Stop(ctx); err != nil {
+				defaultLogger.Error("Error stopping plugin", "type", fmt.Sprintf("%T", plugin), "err", err)
+			}
+		}(plugin)
+	}
+
+	done := make(chan bool)
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		defaultLogger.Warn("Timed out waiting for plugins to stop")
+	}
+}
+
+// reconfigurePlugins calls `Reconfigure()` on all the
+// PluginReconfigurers, and returns the errors of those which rejected
+// their new configuration.
+func reconfigurePlugins(plugins []*enabledPlugin, load func(config interface{}) error) []error {
+	errs := make([]error, 0)
+	for _, enabled := range plugins {
+		reconfigurer, ok := enabled.plugin.(PluginReconfigurer)
+		if !ok {
+			continue
+		}
+		if err := reconfigurer.Reconfigure(load); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %s", enabled.name, err))
 		}
 	}
+	return errs
 }
 
 func initWebServer(bot *Bot, enabledPlugins []string) {
-	for _, plugin := range registeredPlugins {
-		webServer, ok := plugin.(WebServer)
+	for _, enabled := range bot.plugins {
+		webServer, ok := enabled.plugin.(WebServer)
 		if ok {
 			webServer.InitWebServer(bot, enabledPlugins)
 			bot.WebServer = webServer
@@ -76,7 +313,8 @@ func initWebPlugins(bot *Bot) {
 		return
 	}
 
-	for _, plugin := range registeredPlugins {
+	for _, enabled := range bot.plugins {
+		plugin := enabled.plugin
 		if webPlugin, ok := plugin.(WebPlugin); ok {
 			webPlugin.InitWebPlugin(bot, bot.WebServer.PrivateRouter(), bot.WebServer.PublicRouter())
 		}
@@ -86,7 +324,7 @@ func initWebPlugins(bot *Bot) {
 			count += 1
 
 			if count > 1 {
-				log.Fatalln("Can not load two WebServerAuth plugins. Already loaded one.")
+				defaultLogger.Fatal("Can not load two WebServerAuth plugins. Already loaded one.")
 			}
 			webServerAuth.InitWebServerAuth(bot, bot.WebServer)
 		}
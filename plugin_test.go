@@ -0,0 +1,204 @@
+package plotbot
+
+import (
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+type echoPlugin struct{}
+
+func (p *echoPlugin) InitPlugin(bot *Bot) {
+	bot.ListenFor(&Conversation{
+		MentionsMeOnly: true,
+		HandlerFunc: func(conv *Conversation, msg *Message) {
+			conv.Reply(msg, "echo "+msg.Channel)
+		},
+	})
+}
+
+func TestEnablePlugins(t *testing.T) {
+	plugins := registeredPlugins
+	defer func() { registeredPlugins = plugins }()
+	registeredPlugins = nil
+	RegisterPlugin("echo", &echoPlugin{})
+	RegisterPlugin("other", &echoPlugin{})
+	RegisterPlugin("disabled", &echoPlugin{})
+
+	names := func(enabled []*enabledPlugin) string {
+		list := make([]string, 0)
+		for _, plugin := range enabled {
+			list = append(list, plugin.name)
+		}
+		return strings.Join(list, ",")
+	}
+
+	enabled, errs := enablePlugins(nil)
+	if names(enabled) != "echo,other,disabled" || len(errs) != 0 {
+		t.Errorf("expected all plugins without config, got %s, %v", names(enabled), errs)
+	}
+
+	enabled, errs = enablePlugins(PluginsConfig{
+		"echo":     {},
+		"disabled": {Disabled: true},
+		"funny":    {},
+	})
+	if names(enabled) != "echo" {
+		t.Errorf("expected only the listed plugins, got %s", names(enabled))
+	}
+	if len(errs) != 1 || errs[0].Error() != `unknown plugin "funny" in the Plugins section, available plugins are: echo, other, disabled` {
+		t.Errorf("expected an error for the unknown plugin, got %v", errs)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected registering a name twice to panic")
+		}
+	}()
+	RegisterPlugin("echo", &echoPlugin{})
+}
+
+func TestPluginChannelSettings(t *testing.T) {
+	adapter := newFakeAdapter()
+	adapter.users = []slack.User{{ID: "U1", Name: "hodor"}}
+	for _, channel := range []struct{ id, name string }{{"C1", "general"}, {"C2", "random"}, {"C3", "devops"}} {
+		adapter.channels = append(adapter.channels, slack.Channel{
+			GroupConversation: slack.GroupConversation{
+				Conversation: slack.Conversation{ID: channel.id},
+				Name:         channel.name,
+			},
+		})
+	}
+	bot := newTestBot(adapter)
+	defer bot.Disconnect()
+	bot.plugins = []*enabledPlugin{{
+		name:   "echo",
+		plugin: &echoPlugin{},
+		settings: PluginSettings{
+			AllowChannels: []string{"#general", "C2"},
+			DenyChannels:  []string{"random"},
+		},
+	}}
+	initChatPlugins(bot)
+	time.Sleep(50 * time.Millisecond)
+
+	for _, channel := range []string{"C1", "C2", "C3", "D1"} {
+		adapter.events <- &MessageEvent{Msg: slack.Msg{
+			User:    "U1",
+			Channel: channel,
+			Text:    "<@UBOT> hello",
+		}}
+	}
+
+	replies := make([]string, 0)
+	timeout := time.After(200 * time.Millisecond)
+	for done := false; !done; {
+		select {
+		case reply := <-adapter.sent:
+			replies = append(replies, reply.Text)
+		case <-timeout:
+			done = true
+		}
+	}
+	sort.Strings(replies)
+	if strings.Join(replies, ",") != "echo C1,echo D1" {
+		t.Errorf("expected replies in #general and in private only, got %v", replies)
+	}
+}
+
+// announcerPlugin announces in #random from outside of its
+// Conversations, and opens a Conversation once it gets a message.
+type announcerPlugin struct {
+	bot   BotLike
+	later *Conversation
+}
+
+func (p *announcerPlugin) InitPlugin(bot *Bot) {
+	p.bot = bot.PluginBot()
+	bot.ListenFor(&Conversation{
+		MentionsMeOnly: true,
+		HandlerFunc: func(conv *Conversation, msg *Message) {
+			p.later = &Conversation{
+				Contains: "later",
+				HandlerFunc: func(conv *Conversation, msg *Message) {
+					conv.Reply(msg, "later "+msg.Channel)
+				},
+			}
+			conv.Bot.ListenFor(p.later)
+		},
+	})
+}
+
+func TestPluginChannelSettingsOutgoing(t *testing.T) {
+	adapter := newFakeAdapter()
+	adapter.users = []slack.User{{ID: "U1", Name: "hodor"}}
+	for _, channel := range []struct{ id, name string }{{"C1", "general"}, {"C2", "random"}} {
+		adapter.channels = append(adapter.channels, slack.Channel{
+			GroupConversation: slack.GroupConversation{
+				Conversation: slack.Conversation{ID: channel.id},
+				Name:         channel.name,
+			},
+		})
+	}
+	bot := newTestBot(adapter)
+	defer bot.Disconnect()
+	announcer := &announcerPlugin{}
+	bot.plugins = []*enabledPlugin{{
+		name:     "announcer",
+		plugin:   announcer,
+		settings: PluginSettings{DenyChannels: []string{"#random"}},
+	}}
+	initChatPlugins(bot)
+	time.Sleep(50 * time.Millisecond)
+
+	announcer.bot.Notify("#random", "", "dropped")
+	announcer.bot.NotifyRich("C2", &RichMessage{Text: "dropped"})
+	announcer.bot.SendToChannel("random", "dropped")
+	var deliveryErr error
+	announcer.bot.Send(&BotReply{To: "C2", Text: "dropped", OnDelivery: func(timestamp string, err error) {
+		deliveryErr = err
+	}})
+	if deliveryErr == nil {
+		t.Error("expected the dropped reply to be reported")
+	}
+	if err := announcer.bot.UpdateMessage("C2", "1234.5678", &RichMessage{Text: "dropped"}); err == nil {
+		t.Error("expected updates in #random to fail")
+	}
+	announcer.bot.Notify("#general", "", "sent")
+	announcer.bot.Send(&BotReply{To: "U1", Text: "sent privately"})
+
+	// The Conversation opened by the handler is restricted too, in
+	// both directions.
+	adapter.events <- &MessageEvent{Msg: slack.Msg{User: "U1", Channel: "C1", Text: "<@UBOT> hello"}}
+	time.Sleep(50 * time.Millisecond)
+	for _, channel := range []string{"C1", "C2"} {
+		adapter.events <- &MessageEvent{Msg: slack.Msg{User: "U1", Channel: channel, Text: "see you later"}}
+	}
+
+	replies := make([]string, 0)
+	timeout := time.After(200 * time.Millisecond)
+	for done := false; !done; {
+		select {
+		case reply := <-adapter.sent:
+			replies = append(replies, reply.Text)
+		case <-timeout:
+			done = true
+		}
+	}
+	sort.Strings(replies)
+	if strings.Join(replies, ",") != "later C1,sent,sent privately" {
+		t.Errorf("expected nothing sent to #random, got %v", replies)
+	}
+
+	// Replies from the Conversation to a denied channel are dropped,
+	// even for messages it got elsewhere.
+	announcer.later.Reply(&Message{Msg: &slack.Msg{Channel: "C2"}}, "dropped")
+	select {
+	case reply := <-adapter.sent:
+		t.Errorf("expected the reply to #random to be dropped, got %q", reply.Text)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,129 @@
+package plotbot
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/slack-go/slack"
+)
+
+// pluginBot is the Bot as seen by a plugin, see `Bot.PluginBot()`.
+// Messages to the channels the plugin's settings keep it out of are
+// dropped, like the messages it would get there.
+type pluginBot struct {
+	*Bot
+	plugin *enabledPlugin
+}
+
+// PluginBot returns the Bot a plugin should keep in its `InitPlugin()`
+// and send its messages through.  Messages to the channels its
+// settings keep it out of are dropped, and the Conversations it opens
+// are restricted like those opened in `InitPlugin()`.  Outside of
+// `InitPlugin()`, it returns the Bot itself.
+func (bot *Bot) PluginBot() BotLike {
+	if bot.initializing == nil {
+		return bot
+	}
+	return &pluginBot{bot, bot.initializing}
+}
+
+// allows reports whether the plugin may post to `to`, and logs the
+// messages it may not.
+func (pb *pluginBot) allows(to string) bool {
+	if pb.plugin.allowsChannel(pb.destination(to)) {
+		return true
+	}
+	pb.Logger.Warn("Dropping message to a channel outside of the plugin's settings", "plugin", pb.plugin.name, "to", to)
+	return false
+}
+
+func (pb *pluginBot) ListenFor(conv *Conversation) error {
+	conv.plugin = pb.plugin
+	return pb.Bot.ListenFor(conv)
+}
+
+// HandleInteraction registers the handler like `Bot.HandleInteraction()`.
+// The handler gets the plugin's Bot, to update the messages in the
+// channels of its settings only.
+func (pb *pluginBot) HandleInteraction(callbackID string, handler InteractionHandler) {
+	pb.Bot.HandleInteraction(callbackID, func(interaction *Interaction) {
+		interaction.Bot = pb
+		handler(interaction)
+	})
+}
+
+func (pb *pluginBot) Reply(msg *Message, reply string) {
+	if pb.allows(msg.Channel) {
+		pb.Bot.Reply(msg, reply)
+	}
+}
+
+func (pb *pluginBot) ReplyMention(msg *Message, reply string) {
+	pb.Reply(msg, msg.AtMentionIfPublic(reply))
+}
+
+func (pb *pluginBot) ReplyInThread(msg *Message, reply string) {
+	if pb.allows(msg.Channel) {
+		pb.Bot.ReplyInThread(msg, reply)
+	}
+}
+
+func (pb *pluginBot) ReplyRich(msg *Message, rich *RichMessage) {
+	if pb.allows(msg.Channel) {
+		pb.Bot.ReplyRich(msg, rich)
+	}
+}
+
+func (pb *pluginBot) Notify(room, color, msg string) {
+	if pb.allows(room) {
+		pb.Bot.Notify(room, color, msg)
+	}
+}
+
+func (pb *pluginBot) NotifyRich(room string, rich *RichMessage) {
+	if pb.allows(room) {
+		pb.Bot.NotifyRich(room, rich)
+	}
+}
+
+// Send queues the reply like `Bot.Send()`.  Dropped replies are
+// reported to `reply.OnDelivery`.
+func (pb *pluginBot) Send(reply *BotReply) {
+	if pb.allows(reply.To) {
+		pb.Bot.Send(reply)
+	} else if reply.OnDelivery != nil {
+		reply.OnDelivery("", fmt.Errorf("plugin %q may not post to %s", pb.plugin.name, reply.To))
+	}
+}
+
+func (pb *pluginBot) SendToChannel(channelName string, message string) {
+	if pb.allows(channelName) {
+		pb.Bot.SendToChannel(channelName, message)
+	}
+}
+
+func (pb *pluginBot) UpdateMessage(channelID, timestamp string, rich *RichMessage) error {
+	if !pb.allows(channelID) {
+		return fmt.Errorf("plugin %q may not post to %s", pb.plugin.name, channelID)
+	}
+	return pb.Bot.UpdateMessage(channelID, timestamp, rich)
+}
+
+// destination returns the channel of `to`, a channel ID or name, or
+// nil for direct messages and users.  Channels the bot doesn't know
+// are made up from what was given.
+func (bot *Bot) destination(to string) *slack.Channel {
+	if to == "" {
+		return nil
+	}
+	channel := bot.GetChannelByName(to)
+	if channel == nil && !strings.HasPrefix(to, "#") {
+		channel = bot.Directory.Channel(to)
+	}
+	if channel == nil && !strings.HasPrefix(to, "D") && !strings.HasPrefix(to, "U") && !strings.HasPrefix(to, "W") {
+		channel = &slack.Channel{}
+		channel.ID = to
+		channel.Name = strings.TrimLeft(to, "#")
+	}
+	return channel
+}
@@ -0,0 +1,319 @@
+package reminders
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/plotly/plotbot"
+)
+
+// Reminders delivers the reminders users ask for, like "remind me in
+// 2h to call mom".  Each reminder is a one-shot job of the Scheduler,
+// so they survive restarts, numbered for each user.  Dates are in the
+// time zone of the user asking.
+type Reminders struct {
+	bot       *plotbot.Bot
+	sender    plotbot.BotLike
+	store     plotbot.Store
+	logger    *plotbot.Logger
+	scheduler *plotbot.Scheduler
+	commands  *plotbot.Router
+	now       func() time.Time
+
+	// lock serializes the numbering of the reminders.
+	lock sync.Mutex
+}
+
+// reminder is the Data of a reminder job.
+type reminder struct {
+	Number int
+	// User is the ID of the user who asked for the reminder.
+	User string
+	// To is the ID of the channel the reminder is posted to, or the
+	// user's ID for a direct message.
+	To string
+	// ChannelName is set for reminders posted to a channel, for
+	// display.
+	ChannelName string `json:",omitempty"`
+	Text        string
+}
+
+func init() {
+	plotbot.RegisterPlugin("reminders", &Reminders{})
+}
+
+func (reminders *Reminders) InitStore(store plotbot.Store) {
+	reminders.store = store
+}
+
+func (reminders *Reminders) InitLogger(logger *plotbot.Logger) {
+	reminders.logger = logger
+}
+
+func (reminders *Reminders) InitPlugin(bot *plotbot.Bot) {
+	reminders.bot = bot
+	reminders.sender = bot.PluginBot()
+	reminders.scheduler = bot.Scheduler
+	reminders.now = time.Now
+
+	reminders.scheduler.Handle("reminders", reminders.deliver)
+
+	reminders.commands = plotbot.NewRouter()
+	reminders.commands.Add(&plotbot.Command{
+		Usage:       "remind <who> <reminder...>",
+		Description: "<who> is me, us or a #channel, and <reminder> is when, then \"to\" and what: in 20 minutes, at 4pm, tomorrow [at 10am], next monday [at 9:30]...",
+		Examples: []string{
+			"remind me in 2h to call mom",
+			"remind us at 4pm to ship the release",
+			"remind #devops next Monday to rotate the keys",
+		},
+		HandlerFunc: reminders.remindCommand,
+	})
+	reminders.commands.Add(&plotbot.Command{
+		Usage:       "list [my] reminders",
+		Description: "Lists your upcoming reminders.",
+		HandlerFunc: reminders.listCommand,
+	})
+	reminders.commands.Add(&plotbot.Command{
+		Usage:       "cancel reminder <number:int>",
+		Description: "Cancels one of your reminders, by the number in the list.",
+		Examples:    []string{"cancel reminder 3"},
+		HandlerFunc: reminders.cancelCommand,
+	})
+
+	bot.ListenFor(&plotbot.Conversation{
+		MentionsMeOnly: true,
+		HandlerFunc:    reminders.ChatHandler,
+	})
+}
+
+func (reminders *Reminders) ChatHandler(conv *plotbot.Conversation, msg *plotbot.Message) {
+	reminders.commands.Handle(conv, msg)
+}
+
+func (reminders *Reminders) remindCommand(conv *plotbot.Conversation, msg *plotbot.Message, args plotbot.CommandArgs) {
+	rem := reminder{User: msg.User}
+	switch who := args.String("who"); strings.ToLower(who) {
+	case "me":
+		rem.To = msg.User
+	case "us":
+		rem.To = msg.Channel
+		if msg.FromChannel != nil {
+			rem.ChannelName = msg.FromChannel.Name
+		}
+		if msg.IsPrivate() {
+			rem.To = msg.User
+		}
+	default:
+		id, name := parseChannel(who)
+		if id == "" && name != "" {
+			if channel := reminders.bot.GetChannelByName(name); channel != nil {
+				id = channel.ID
+			}
+		}
+		if id == "" {
+			conv.Reply(msg, fmt.Sprintf("Sorry, I can remind you, us or a #channel, but I don't know %q.", who))
+			return
+		}
+		rem.To, rem.ChannelName = id, name
+	}
+
+	now := reminders.now().In(reminders.bot.Location(msg.FromUser))
+	at, text, err := splitReminder(args.String("reminder"), now)
+	if err != nil {
+		conv.Reply(msg, fmt.Sprintf("Sorry, %s.\n%s", err, reminders.commands.Commands()[0].Help(reminders.bot.AtMention())))
+		return
+	}
+	rem.Text = text
+
+	rem, err = reminders.add(rem, at)
+	if err != nil {
+		reminders.logger.Error("Could not schedule reminder", "user", rem.User, "err", err)
+		conv.Reply(msg, "Sorry, I could not save your reminder.")
+		return
+	}
+	conv.Reply(msg, fmt.Sprintf("OK, I'll remind %s %s (reminder %d).",
+		rem.audience(), formatWhen(at, now), rem.Number))
+}
+
+func (reminders *Reminders) listCommand(conv *plotbot.Conversation, msg *plotbot.Message, args plotbot.CommandArgs) {
+	jobs := reminders.list(msg.User)
+	if len(jobs) == 0 {
+		conv.Reply(msg, "You have no reminders.")
+		return
+	}
+
+	now := reminders.now().In(reminders.bot.Location(msg.FromUser))
+	lines := []string{"Your reminders:"}
+	for _, job := range jobs {
+		var rem reminder
+		if err := job.Decode(&rem); err != nil {
+			reminders.logger.Error("Invalid reminder job", "job", job.ID, "err", err)
+			continue
+		}
+		line := fmt.Sprintf("• %d: %s, %s", rem.Number, rem.Text, formatWhen(job.Next, now))
+		if rem.ChannelName != "" {
+			line += " in #" + rem.ChannelName
+		}
+		lines = append(lines, line)
+	}
+	conv.Reply(msg, strings.Join(lines, "\n"))
+}
+
+func (reminders *Reminders) cancelCommand(conv *plotbot.Conversation, msg *plotbot.Message, args plotbot.CommandArgs) {
+	rem, ok, err := reminders.cancel(msg.User, args.Int("number"))
+	switch {
+	case err != nil:
+		reminders.logger.Error("Could not cancel reminder", "user", msg.User, "err", err)
+		conv.Reply(msg, "Sorry, I could not cancel your reminder.")
+	case !ok:
+		conv.Reply(msg, fmt.Sprintf("Sorry, you have no reminder %d.", args.Int("number")))
+	default:
+		conv.Reply(msg, fmt.Sprintf("OK, cancelled reminder %d: %s", rem.Number, rem.Text))
+	}
+}
+
+// add numbers a reminder, and schedules its delivery `at` that time.
+func (reminders *Reminders) add(rem reminder, at time.Time) (reminder, error) {
+	reminders.lock.Lock()
+	defer reminders.lock.Unlock()
+
+	counterKey := "counter:" + rem.User
+	if err := reminders.store.Get(counterKey, &rem.Number); err != nil && err != plotbot.ErrNotFound {
+		return rem, err
+	}
+	rem.Number++
+	if err := reminders.store.Put(counterKey, rem.Number); err != nil {
+		return rem, err
+	}
+
+	job := plotbot.Job{
+		ID:      jobID(rem.User, rem.Number),
+		Handler: "reminders",
+		At:      at,
+	}
+	var err error
+	if job.Data, err = json.Marshal(rem); err != nil {
+		return rem, err
+	}
+	_, err = reminders.scheduler.Schedule(job)
+	return rem, err
+}
+
+// list returns the pending reminder jobs of a user, soonest first.
+func (reminders *Reminders) list(user string) []plotbot.Job {
+	jobs := reminders.scheduler.Jobs(jobID(user, 0))
+	sort.SliceStable(jobs, func(i, j int) bool { return jobs[i].Next.Before(jobs[j].Next) })
+	return jobs
+}
+
+// cancel deletes a pending reminder of a user, and returns it.
+func (reminders *Reminders) cancel(user string, number int) (reminder, bool, error) {
+	var rem reminder
+	job, ok := reminders.scheduler.Job(jobID(user, number))
+	if !ok {
+		return rem, false, nil
+	}
+	if err := job.Decode(&rem); err != nil {
+		return rem, false, err
+	}
+	return rem, true, reminders.scheduler.Cancel(job.ID)
+}
+
+// deliver is the JobHandler posting due reminders.
+func (reminders *Reminders) deliver(job plotbot.Job, scheduledAt time.Time) {
+	var rem reminder
+	if err := job.Decode(&rem); err != nil {
+		reminders.logger.Error("Invalid reminder job", "job", job.ID, "err", err)
+		return
+	}
+
+	text := fmt.Sprintf(":alarm_clock: Reminder: %s", rem.Text)
+	if rem.To != rem.User {
+		text = fmt.Sprintf(":alarm_clock: <@%s> asked me to remind you: %s", rem.User, rem.Text)
+	}
+	if late := reminders.now().Sub(scheduledAt); late > time.Minute {
+		text += fmt.Sprintf(" (sorry, this was due %s ago)", late.Round(time.Minute))
+	}
+	reminders.sender.Send(&plotbot.BotReply{To: rem.To, Text: text})
+}
+
+func (rem reminder) audience() string {
+	switch {
+	case rem.To == rem.User:
+		return "you"
+	case rem.ChannelName != "":
+		return "#" + rem.ChannelName
+	default:
+		return "this channel"
+	}
+}
+
+// jobID returns the ID of the job of a reminder.  Number 0 gives the
+// prefix of all the reminders of the user.
+func jobID(user string, number int) string {
+	if number == 0 {
+		return fmt.Sprintf("reminders:%s:", user)
+	}
+	return fmt.Sprintf("reminders:%s:%d", user, number)
+}
+
+var reChannelMention = regexp.MustCompile(`^<#([A-Z0-9]+)(\|([^>]*))?>$`)
+
+// parseChannel returns the ID and name of a channel mentioned as
+// "<#C024BE91L|general>", or only the name of one written "#general".
+func parseChannel(text string) (id, name string) {
+	if match := reChannelMention.FindStringSubmatch(text); match != nil {
+		return match[1], match[3]
+	}
+	if strings.HasPrefix(text, "#") && len(text) > 1 {
+		return "", text[1:]
+	}
+	return "", ""
+}
+
+// splitReminder splits "in 2h to call mom", or "to call mom in 2h",
+// into the time of the reminder and its text.
+func splitReminder(text string, now time.Time) (time.Time, string, error) {
+	text = strings.TrimSpace(text)
+	words := strings.Fields(text)
+
+	if len(words) > 0 && strings.ToLower(words[0]) != "to" {
+		for i, word := range words {
+			if strings.ToLower(word) != "to" || i == len(words)-1 {
+				continue
+			}
+			if at, err := plotbot.ParseWhen(strings.Join(words[:i], " "), now); err == nil {
+				return at, strings.Join(words[i+1:], " "), nil
+			}
+		}
+		return time.Time{}, "", fmt.Errorf("I don't understand when to remind you")
+	}
+
+	// "to <what> <when>": the longest suffix that is a date.
+	for i := 2; i < len(words); i++ {
+		if at, err := plotbot.ParseWhen(strings.Join(words[i:], " "), now); err == nil {
+			return at, strings.Join(words[1:i], " "), nil
+		}
+	}
+	return time.Time{}, "", fmt.Errorf("I don't understand when to remind you")
+}
+
+// formatWhen formats the time of a reminder for replies.
+func formatWhen(at, now time.Time) string {
+	y1, m1, d1 := at.Date()
+	y2, m2, d2 := now.Date()
+	switch {
+	case y1 == y2 && m1 == m2 && d1 == d2:
+		return at.Format("at 15:04 MST")
+	case at.Sub(now) < 7*24*time.Hour:
+		return at.Format("on Monday at 15:04 MST")
+	default:
+		return at.Format("on Mon Jan 2 at 15:04 MST")
+	}
+}
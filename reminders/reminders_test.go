@@ -0,0 +1,99 @@
+package reminders
+
+import (
+	"testing"
+	"time"
+
+	"github.com/plotly/plotbot"
+)
+
+func TestSplitReminder(t *testing.T) {
+	now := time.Date(2020, 5, 1, 10, 17, 0, 0, time.UTC)
+
+	for _, test := range []struct {
+		text     string
+		expected time.Time
+		what     string
+	}{
+		{"in 2h to call mom", now.Add(2 * time.Hour), "call mom"},
+		{"tomorrow at 10am to go to the bank", time.Date(2020, 5, 2, 10, 0, 0, 0, time.UTC), "go to the bank"},
+		{"next Monday to rotate the keys", time.Date(2020, 5, 4, 9, 0, 0, 0, time.UTC), "rotate the keys"},
+		{"to ship the release at 4pm", time.Date(2020, 5, 1, 16, 0, 0, 0, time.UTC), "ship the release"},
+		{"to talk to Bob in 20 minutes", now.Add(20 * time.Minute), "talk to Bob"},
+	} {
+		at, what, err := splitReminder(test.text, now)
+		if err != nil {
+			t.Errorf("%q: unexpected error %s", test.text, err)
+			continue
+		}
+		if !at.Equal(test.expected) || what != test.what {
+			t.Errorf("%q: expected %q at %s, got %q at %s", test.text, test.what, test.expected, what, at)
+		}
+	}
+
+	for _, text := range []string{"", "soon to call mom", "to call mom", "in 2h to", "to"} {
+		if at, what, err := splitReminder(text, now); err == nil {
+			t.Errorf("%q: expected an error, got %q at %s", text, what, at)
+		}
+	}
+}
+
+func TestParseChannel(t *testing.T) {
+	for _, test := range []struct{ text, id, name string }{
+		{"<#C024BE91L|general>", "C024BE91L", "general"},
+		{"<#C024BE91L>", "C024BE91L", ""},
+		{"#devops", "", "devops"},
+		{"bob", "", ""},
+	} {
+		if id, name := parseChannel(test.text); id != test.id || name != test.name {
+			t.Errorf("%q: expected %q and %q, got %q and %q", test.text, test.id, test.name, id, name)
+		}
+	}
+}
+
+func TestAddListCancel(t *testing.T) {
+	scheduler, err := plotbot.NewScheduler(plotbot.NewMemoryStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	reminders := &Reminders{store: plotbot.NewMemoryStore(), scheduler: scheduler}
+	now := time.Now()
+
+	first, err := reminders.add(reminder{User: "U1", To: "U1", Text: "call mom"}, now.Add(2*time.Hour))
+	if err != nil || first.Number != 1 {
+		t.Fatalf("expected reminder 1, got %d, %v", first.Number, err)
+	}
+	second, _ := reminders.add(reminder{User: "U1", To: "C1", ChannelName: "devops", Text: "deploy"}, now.Add(time.Hour))
+	reminders.add(reminder{User: "U2", To: "U2", Text: "not mine"}, now.Add(time.Hour))
+	if second.Number != 2 {
+		t.Errorf("expected reminders to be numbered for each user, got %d", second.Number)
+	}
+
+	jobs := reminders.list("U1")
+	if len(jobs) != 2 || jobs[0].ID != "reminders:U1:2" || jobs[1].ID != "reminders:U1:1" {
+		t.Fatalf("expected the reminders of the user, soonest first, got %#v", jobs)
+	}
+	var rem reminder
+	if err := jobs[0].Decode(&rem); err != nil || rem != second {
+		t.Errorf("expected the reminder in the job, got %#v, %v", rem, err)
+	}
+
+	if _, ok, _ := reminders.cancel("U2", 1); !ok {
+		t.Error("expected the reminder of U2 to be cancelled")
+	}
+	if _, ok, _ := reminders.cancel("U1", 3); ok {
+		t.Error("expected unknown reminders not to be cancelled")
+	}
+	rem, ok, err := reminders.cancel("U1", 1)
+	if err != nil || !ok || rem.Text != "call mom" {
+		t.Errorf("expected reminder 1 to be cancelled, got %#v, %v, %v", rem, ok, err)
+	}
+	if jobs := reminders.list("U1"); len(jobs) != 1 {
+		t.Errorf("expected one reminder left, got %d", len(jobs))
+	}
+
+	third, _ := reminders.add(reminder{User: "U1", To: "U1", Text: "again"}, now.Add(time.Hour))
+	if third.Number != 3 {
+		t.Errorf("expected numbers not to be reused, got %d", third.Number)
+	}
+}
@@ -0,0 +1,123 @@
+package plotbot
+
+import (
+	"strings"
+
+	"github.com/slack-go/slack"
+)
+
+// RichMessage builds a structured message out of Block Kit blocks.
+// Every method returns the message itself, so calls can be chained:
+//
+//	msg := plotbot.NewRichMessage("Deploy launched").
+//		Section("*Launching deploy*").
+//		Fields("*Environment*\nprod", "*Branch*\nmaster").
+//		Context("monitor in #deploys")
+//
+// `Text` is shown in notifications and by clients that can't render
+// blocks.  When `Color` is set, the blocks are wrapped in a colored
+// attachment, like `Notify()` does for plain text.
+type RichMessage struct {
+	Text   string
+	Color  string
+	Blocks []slack.Block
+	// Thread starts a thread under the message replied to, like
+	// `ReplyInThread()` does.
+	Thread bool
+}
+
+func NewRichMessage(text string) *RichMessage {
+	return &RichMessage{Text: text}
+}
+
+// WithColor sets the color of the bar shown next to the message.
+func (m *RichMessage) WithColor(color string) *RichMessage {
+	m.Color = color
+	return m
+}
+
+// InThread makes replies start a thread under the message replied
+// to, if it is not in one already.
+func (m *RichMessage) InThread() *RichMessage {
+	m.Thread = true
+	return m
+}
+
+// Section adds a paragraph of markdown text.
+func (m *RichMessage) Section(text string) *RichMessage {
+	return m.add(slack.NewSectionBlock(markdownText(text), nil, nil))
+}
+
+// Fields adds a section laid out as a two-column grid of markdown
+// texts.  Slack shows at most 10 fields per section, so longer lists
+// are split over several sections.
+func (m *RichMessage) Fields(fields ...string) *RichMessage {
+	for len(fields) > 0 {
+		n := len(fields)
+		if n > 10 {
+			n = 10
+		}
+		objects := make([]*slack.TextBlockObject, 0, n)
+		for _, field := range fields[:n] {
+			objects = append(objects, markdownText(field))
+		}
+		m.add(slack.NewSectionBlock(nil, objects, nil))
+		fields = fields[n:]
+	}
+	return m
+}
+
+// Context adds a line of small, grey markdown texts.
+func (m *RichMessage) Context(texts ...string) *RichMessage {
+	elements := make([]slack.MixedElement, 0, len(texts))
+	for _, text := range texts {
+		elements = append(elements, markdownText(text))
+	}
+	return m.add(slack.NewContextBlock("", elements...))
+}
+
+// Divider adds a horizontal rule.
+func (m *RichMessage) Divider() *RichMessage {
+	return m.add(slack.NewDividerBlock())
+}
+
+// maxSectionText is the longest text Slack accepts in a section.
+const maxSectionText = 3000
+
+// Code adds a preformatted block of text, such as a table.  Long texts
+// are split between lines over several sections.
+func (m *RichMessage) Code(text string) *RichMessage {
+	text = strings.Replace(strings.Trim(text, "\n"), "```", "'''", -1)
+
+	chunk := ""
+	for _, line := range strings.Split(text, "\n") {
+		if chunk != "" && len(chunk)+len(line)+7 > maxSectionText {
+			m.Section("```" + chunk + "```")
+			chunk = ""
+		}
+		if chunk != "" {
+			chunk += "\n"
+		}
+		chunk += line
+	}
+	return m.Section("```" + chunk + "```")
+}
+
+// BotReply returns the reply posting this message to `to`.
+func (m *RichMessage) BotReply(to string) *BotReply {
+	return &BotReply{
+		To:     to,
+		Text:   m.Text,
+		Color:  m.Color,
+		Blocks: m.Blocks,
+	}
+}
+
+func (m *RichMessage) add(block slack.Block) *RichMessage {
+	m.Blocks = append(m.Blocks, block)
+	return m
+}
+
+func markdownText(text string) *slack.TextBlockObject {
+	return slack.NewTextBlockObject(slack.MarkdownType, text, false, false)
+}
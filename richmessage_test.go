@@ -0,0 +1,71 @@
+package plotbot
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/slack-go/slack"
+)
+
+func TestRichMessageBlocks(t *testing.T) {
+	msg := NewRichMessage("fallback").
+		WithColor("#ff0000").
+		Section("*title*").
+		Fields("a", "b").
+		Divider().
+		Context("small", "print").
+		Code("line 1\nline 2\n")
+
+	expected := []slack.MessageBlockType{
+		slack.MBTSection, slack.MBTSection, slack.MBTDivider, slack.MBTContext, slack.MBTSection,
+	}
+	if len(msg.Blocks) != len(expected) {
+		t.Fatalf("expected %d blocks, got %d", len(expected), len(msg.Blocks))
+	}
+	for i, block := range msg.Blocks {
+		if block.BlockType() != expected[i] {
+			t.Errorf("block %d: expected %s, got %s", i, expected[i], block.BlockType())
+		}
+	}
+
+	fields := msg.Blocks[1].(*slack.SectionBlock).Fields
+	if len(fields) != 2 || fields[0].Text != "a" || fields[0].Type != slack.MarkdownType {
+		t.Errorf("unexpected fields %#v", fields)
+	}
+
+	code := msg.Blocks[4].(*slack.SectionBlock).Text.Text
+	if code != "```line 1\nline 2```" {
+		t.Errorf("unexpected code block %q", code)
+	}
+
+	reply := msg.BotReply("C1")
+	if reply.To != "C1" || reply.Text != "fallback" || reply.Color != "#ff0000" || len(reply.Blocks) != 5 {
+		t.Errorf("unexpected reply %#v", reply)
+	}
+}
+
+func TestRichMessageSplitsLongContent(t *testing.T) {
+	fields := make([]string, 13)
+	for i := range fields {
+		fields[i] = "field"
+	}
+	line := strings.Repeat("x", 99)
+	lines := make([]string, 50)
+	for i := range lines {
+		lines[i] = line
+	}
+
+	msg := NewRichMessage("").Fields(fields...).Code(strings.Join(lines, "\n"))
+	if len(msg.Blocks) != 4 {
+		t.Fatalf("expected 2 field sections and 2 code sections, got %d blocks", len(msg.Blocks))
+	}
+	if n := len(msg.Blocks[0].(*slack.SectionBlock).Fields); n != 10 {
+		t.Errorf("expected 10 fields in the first section, got %d", n)
+	}
+	for _, block := range msg.Blocks[2:] {
+		text := block.(*slack.SectionBlock).Text.Text
+		if len(text) > maxSectionText {
+			t.Errorf("code section is %d characters long", len(text))
+		}
+	}
+}
@@ -0,0 +1,404 @@
+package plotbot
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule computes the run times of a recurring job.
+type Schedule interface {
+	// Next returns the first run time strictly after `after`, or the
+	// zero time if there is none.
+	Next(after time.Time) time.Time
+}
+
+// ParseSchedule parses a cron expression or a natural schedule.  Cron
+// expressions have the five usual fields, "minute hour day-of-month
+// month day-of-week", with `*`, lists, ranges, steps and names:
+//
+//	30 9 * * mon-fri
+//	*/15 8-18 * * *
+//	0 0 1,15 * *
+//
+// along with the @yearly, @monthly, @weekly, @daily, @hourly and
+// "@every <duration>" shorthands.  Natural schedules read like:
+//
+//	every day at 9:00
+//	every weekday at 12pm
+//	every monday, wednesday and friday at 17:30
+//	every month on the 1st at 10am
+//	every 15 minutes
+//
+// Times are in UTC, unless a time zone is given with a "TZ=Zone "
+// prefix, or a trailing "in Zone" for natural schedules:
+//
+//	TZ=Europe/Paris 0 9 * * *
+//	every weekday at 9:30 in America/Montreal
+//
+// Times skipped by a daylight saving change are shifted by its length,
+// and repeated ones run once.
+func ParseSchedule(spec string) (Schedule, error) {
+	return ParseScheduleIn(spec, time.UTC)
+}
+
+// ParseScheduleIn is ParseSchedule for times in `loc`, unless the spec
+// gives a time zone.
+func ParseScheduleIn(spec string, loc *time.Location) (Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if strings.HasPrefix(spec, "TZ=") || strings.HasPrefix(spec, "CRON_TZ=") {
+		fields := strings.SplitN(spec, " ", 2)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("missing schedule after %q", fields[0])
+		}
+		var err error
+		if loc, err = loadLocation(fields[0][strings.Index(fields[0], "=")+1:]); err != nil {
+			return nil, err
+		}
+		spec = strings.TrimSpace(fields[1])
+	}
+
+	if strings.HasPrefix(spec, "@") {
+		return parseCronShorthand(spec, loc)
+	}
+	if strings.HasPrefix(strings.ToLower(spec), "every ") {
+		return parseNaturalSchedule(spec, loc)
+	}
+	return parseCron(spec, loc)
+}
+
+func loadLocation(name string) (*time.Location, error) {
+	loc, err := time.LoadLocation(name)
+	if err != nil || name == "" {
+		return nil, fmt.Errorf("unknown time zone %q", name)
+	}
+	return loc, nil
+}
+
+// intervalSchedule runs every `interval`, from the previous run.
+type intervalSchedule struct {
+	interval time.Duration
+}
+
+func (s intervalSchedule) Next(after time.Time) time.Time {
+	return after.Add(s.interval)
+}
+
+// cronSchedule is a parsed cron expression.  Fields are bit sets of
+// the allowed values.
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	// domStar and dowStar are set when the day of month or the day of
+	// week is `*`: when both are restricted, either one matching is
+	// enough, like in cron.
+	domStar, dowStar bool
+	loc              *time.Location
+}
+
+// cronSearchYears bounds the search for the next run, for expressions
+// like "0 0 30 2 *" which never match.
+const cronSearchYears = 5
+
+func (s *cronSchedule) Next(after time.Time) time.Time {
+	local := after.In(s.loc)
+	day := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, s.loc)
+	for i := 0; i < cronSearchYears*366; i++ {
+		year, month, date := day.Date()
+		current := time.Date(year, month, date+i, 0, 0, 0, 0, s.loc)
+		if !s.matchesDay(current) {
+			continue
+		}
+		for hour := 0; hour < 24; hour++ {
+			if s.hour&(1<<uint(hour)) == 0 {
+				continue
+			}
+			for minute := 0; minute < 60; minute++ {
+				if s.minute&(1<<uint(minute)) == 0 {
+					continue
+				}
+				next := s.date(current, hour, minute)
+				if next.After(after) {
+					return next
+				}
+			}
+		}
+	}
+	return time.Time{}
+}
+
+// date returns the time of `hour:minute` on `day`.  Times skipped by a
+// daylight saving change are shifted by its length, so 2:30 becomes
+// 3:30 when clocks jump from 2:00 to 3:00.
+func (s *cronSchedule) date(day time.Time, hour, minute int) time.Time {
+	t := time.Date(day.Year(), day.Month(), day.Day(), hour, minute, 0, 0, s.loc)
+	if t.Hour() == hour && t.Minute() == minute {
+		return t
+	}
+	wall := time.Date(day.Year(), day.Month(), day.Day(), hour, minute, 0, 0, time.UTC)
+	_, offsetBefore := wall.Add(-24 * time.Hour).In(s.loc).Zone()
+	return wall.Add(-time.Duration(offsetBefore) * time.Second).In(s.loc)
+}
+
+func (s *cronSchedule) matchesDay(t time.Time) bool {
+	if s.month&(1<<uint(t.Month())) == 0 {
+		return false
+	}
+	domMatch := s.dom&(1<<uint(t.Day())) != 0
+	dowMatch := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domStar || s.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
+
+var cronMonths = map[string]int{
+	"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+	"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+}
+
+var cronWeekdays = map[string]int{
+	"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+}
+
+func parseCronShorthand(spec string, loc *time.Location) (Schedule, error) {
+	fields := strings.Fields(spec)
+	switch strings.ToLower(fields[0]) {
+	case "@yearly", "@annually":
+		return parseCron("0 0 1 1 *", loc)
+	case "@monthly":
+		return parseCron("0 0 1 * *", loc)
+	case "@weekly":
+		return parseCron("0 0 * * 0", loc)
+	case "@daily", "@midnight":
+		return parseCron("0 0 * * *", loc)
+	case "@hourly":
+		return parseCron("0 * * * *", loc)
+	case "@every":
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("expected a duration after @every, like \"@every 1h30m\"")
+		}
+		interval, err := time.ParseDuration(fields[1])
+		if err != nil {
+			return nil, err
+		}
+		if interval < time.Minute {
+			return nil, fmt.Errorf("@every needs at least a minute, got %s", interval)
+		}
+		return intervalSchedule{interval}, nil
+	}
+	return nil, fmt.Errorf("unknown schedule %q", fields[0])
+}
+
+func parseCron(spec string, loc *time.Location) (Schedule, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("expected 5 fields in cron expression %q, got %d", spec, len(fields))
+	}
+
+	s := &cronSchedule{loc: loc}
+	var err error
+	if s.minute, err = parseCronField(fields[0], 0, 59, nil); err != nil {
+		return nil, fmt.Errorf("minute: %s", err)
+	}
+	if s.hour, err = parseCronField(fields[1], 0, 23, nil); err != nil {
+		return nil, fmt.Errorf("hour: %s", err)
+	}
+	if s.dom, err = parseCronField(fields[2], 1, 31, nil); err != nil {
+		return nil, fmt.Errorf("day of month: %s", err)
+	}
+	if s.month, err = parseCronField(fields[3], 1, 12, cronMonths); err != nil {
+		return nil, fmt.Errorf("month: %s", err)
+	}
+	if s.dow, err = parseCronField(fields[4], 0, 7, cronWeekdays); err != nil {
+		return nil, fmt.Errorf("day of week: %s", err)
+	}
+	// Both 0 and 7 are Sunday.
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	s.domStar = fields[2] == "*" || fields[2] == "?"
+	s.dowStar = fields[4] == "*" || fields[4] == "?"
+	return s, nil
+}
+
+// parseCronField parses a comma-separated list of `*`, values, ranges
+// and steps into a bit set.
+func parseCronField(field string, min, max int, names map[string]int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step in %q", part)
+			}
+			part = part[:i]
+		}
+
+		start, end := min, max
+		switch {
+		case part == "*" || part == "?":
+		case strings.Contains(part, "-"):
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if start, err = parseCronValue(bounds[0], min, max, names); err != nil {
+				return 0, err
+			}
+			if end, err = parseCronValue(bounds[1], min, max, names); err != nil {
+				return 0, err
+			}
+			if start > end {
+				return 0, fmt.Errorf("invalid range %q", part)
+			}
+		default:
+			var err error
+			if start, err = parseCronValue(part, min, max, names); err != nil {
+				return 0, err
+			}
+			end = start
+			if step != 1 {
+				end = max
+			}
+		}
+
+		for value := start; value <= end; value += step {
+			bits |= 1 << uint(value)
+		}
+	}
+	return bits, nil
+}
+
+func parseCronValue(value string, min, max int, names map[string]int) (int, error) {
+	if number, ok := names[strings.ToLower(value)]; ok {
+		return number, nil
+	}
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", value)
+	}
+	if number < min || number > max {
+		return 0, fmt.Errorf("%d is out of range %d-%d", number, min, max)
+	}
+	return number, nil
+}
+
+var (
+	reNaturalZone     = regexp.MustCompile(`(?i)\s+in\s+([A-Za-z_]+(/[A-Za-z_+\-0-9]+)*)$`)
+	reNaturalInterval = regexp.MustCompile(`(?i)^every\s+(\d+\s+)?(minute|hour|day)s?$`)
+	reNaturalMonthly  = regexp.MustCompile(`(?i)^every\s+month\s+on\s+the\s+(\d{1,2})(st|nd|rd|th)?(\s+at\s+(.+))?$`)
+	reNaturalDays     = regexp.MustCompile(`(?i)^every\s+(.+?)(\s+at\s+(.+))?$`)
+	reNaturalTime     = regexp.MustCompile(`(?i)^(\d{1,2})(:(\d{2}))?\s*(am|pm)?$`)
+)
+
+var naturalDays = map[string]string{
+	"day": "*", "weekday": "1-5", "weekend": "0,6",
+	"sunday": "0", "monday": "1", "tuesday": "2", "wednesday": "3",
+	"thursday": "4", "friday": "5", "saturday": "6",
+}
+
+// parseNaturalSchedule parses "every ..." schedules into cron
+// expressions, see ParseSchedule.
+func parseNaturalSchedule(spec string, loc *time.Location) (Schedule, error) {
+	if match := reNaturalZone.FindStringSubmatch(spec); match != nil {
+		var err error
+		if loc, err = loadLocation(match[1]); err != nil {
+			return nil, err
+		}
+		spec = spec[:len(spec)-len(match[0])]
+	}
+
+	if match := reNaturalInterval.FindStringSubmatch(spec); match != nil {
+		count := 1
+		if match[1] != "" {
+			count, _ = strconv.Atoi(strings.TrimSpace(match[1]))
+		}
+		if count <= 0 {
+			return nil, fmt.Errorf("invalid interval in %q", spec)
+		}
+		switch unit := strings.ToLower(match[2]); {
+		case unit == "minute" && 60%count == 0:
+			return parseCron(fmt.Sprintf("*/%d * * * *", count), loc)
+		case unit == "hour" && 24%count == 0:
+			return parseCron(fmt.Sprintf("0 */%d * * *", count), loc)
+		case unit == "day" && count == 1:
+			return parseCron("0 0 * * *", loc)
+		case unit == "minute":
+			return intervalSchedule{time.Duration(count) * time.Minute}, nil
+		case unit == "hour":
+			return intervalSchedule{time.Duration(count) * time.Hour}, nil
+		default:
+			return intervalSchedule{time.Duration(count) * 24 * time.Hour}, nil
+		}
+	}
+
+	if match := reNaturalMonthly.FindStringSubmatch(spec); match != nil {
+		minute, hour, err := parseNaturalTime(match[4])
+		if err != nil {
+			return nil, err
+		}
+		return parseCron(fmt.Sprintf("%d %d %s * *", minute, hour, match[1]), loc)
+	}
+
+	match := reNaturalDays.FindStringSubmatch(spec)
+	if match == nil {
+		return nil, fmt.Errorf("could not understand schedule %q", spec)
+	}
+	days := make([]string, 0)
+	for _, day := range regexp.MustCompile(`\s*(,|\band\b)\s*`).Split(match[1], -1) {
+		day = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(day)), "s")
+		if day == "" {
+			continue
+		}
+		cronDay, ok := naturalDays[day]
+		if !ok {
+			return nil, fmt.Errorf("could not understand %q in schedule %q", day, spec)
+		}
+		days = append(days, cronDay)
+	}
+	if len(days) == 0 {
+		return nil, fmt.Errorf("could not understand schedule %q", spec)
+	}
+	minute, hour, err := parseNaturalTime(match[3])
+	if err != nil {
+		return nil, err
+	}
+	return parseCron(fmt.Sprintf("%d %d * * %s", minute, hour, strings.Join(days, ",")), loc)
+}
+
+// parseNaturalTime parses times like "9", "9:30", "9am", "5:30 pm",
+// "17:30", "noon" and "midnight".  An empty time is midnight.
+func parseNaturalTime(text string) (minute, hour int, err error) {
+	text = strings.ToLower(strings.TrimSpace(text))
+	switch text {
+	case "", "midnight":
+		return 0, 0, nil
+	case "noon":
+		return 0, 12, nil
+	}
+
+	match := reNaturalTime.FindStringSubmatch(text)
+	if match == nil {
+		return 0, 0, fmt.Errorf("could not understand time %q", text)
+	}
+	hour, _ = strconv.Atoi(match[1])
+	if match[3] != "" {
+		minute, _ = strconv.Atoi(match[3])
+	}
+	switch match[4] {
+	case "am", "pm":
+		if hour < 1 || hour > 12 {
+			return 0, 0, fmt.Errorf("invalid time %q", text)
+		}
+		hour %= 12
+		if match[4] == "pm" {
+			hour += 12
+		}
+	}
+	if hour > 23 || minute > 59 {
+		return 0, 0, fmt.Errorf("invalid time %q", text)
+	}
+	return minute, hour, nil
+}
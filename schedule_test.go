@@ -0,0 +1,127 @@
+package plotbot
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSchedule(t *testing.T) {
+	paris, _ := time.LoadLocation("Europe/Paris")
+	montreal, _ := time.LoadLocation("America/Montreal")
+	// A Friday
+	after := time.Date(2020, 5, 1, 10, 17, 0, 0, time.UTC)
+
+	for _, test := range []struct {
+		spec     string
+		expected []time.Time
+	}{
+		{"30 9 * * mon-fri", []time.Time{
+			time.Date(2020, 5, 4, 9, 30, 0, 0, time.UTC),
+			time.Date(2020, 5, 5, 9, 30, 0, 0, time.UTC),
+		}},
+		{"*/20 10-11 * * *", []time.Time{
+			time.Date(2020, 5, 1, 10, 20, 0, 0, time.UTC),
+			time.Date(2020, 5, 1, 10, 40, 0, 0, time.UTC),
+			time.Date(2020, 5, 1, 11, 0, 0, 0, time.UTC),
+		}},
+		{"0 0 1,15 * *", []time.Time{
+			time.Date(2020, 5, 15, 0, 0, 0, 0, time.UTC),
+			time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC),
+		}},
+		// Either the day of month or the day of week
+		{"0 12 13 * 7", []time.Time{
+			time.Date(2020, 5, 3, 12, 0, 0, 0, time.UTC),
+			time.Date(2020, 5, 10, 12, 0, 0, 0, time.UTC),
+			time.Date(2020, 5, 13, 12, 0, 0, 0, time.UTC),
+		}},
+		{"0 0 29 feb *", []time.Time{time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)}},
+		{"@monthly", []time.Time{time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)}},
+		{"@every 1h30m", []time.Time{
+			time.Date(2020, 5, 1, 11, 47, 0, 0, time.UTC),
+			time.Date(2020, 5, 1, 13, 17, 0, 0, time.UTC),
+		}},
+		{"TZ=Europe/Paris 0 9 * * *", []time.Time{time.Date(2020, 5, 2, 9, 0, 0, 0, paris)}},
+		{"every day at 9:00", []time.Time{time.Date(2020, 5, 2, 9, 0, 0, 0, time.UTC)}},
+		{"every weekday at 12pm in America/Montreal", []time.Time{
+			time.Date(2020, 5, 1, 12, 0, 0, 0, montreal),
+			time.Date(2020, 5, 4, 12, 0, 0, 0, montreal),
+		}},
+		{"every Monday, Wednesday and Friday at 5:30 pm", []time.Time{
+			time.Date(2020, 5, 1, 17, 30, 0, 0, time.UTC),
+			time.Date(2020, 5, 4, 17, 30, 0, 0, time.UTC),
+			time.Date(2020, 5, 6, 17, 30, 0, 0, time.UTC),
+		}},
+		{"every weekend at noon", []time.Time{
+			time.Date(2020, 5, 2, 12, 0, 0, 0, time.UTC),
+			time.Date(2020, 5, 3, 12, 0, 0, 0, time.UTC),
+		}},
+		{"every month on the 1st at 10am", []time.Time{time.Date(2020, 6, 1, 10, 0, 0, 0, time.UTC)}},
+		{"every 15 minutes", []time.Time{
+			time.Date(2020, 5, 1, 10, 30, 0, 0, time.UTC),
+			time.Date(2020, 5, 1, 10, 45, 0, 0, time.UTC),
+		}},
+		{"every hour", []time.Time{time.Date(2020, 5, 1, 11, 0, 0, 0, time.UTC)}},
+		{"every 90 minutes", []time.Time{time.Date(2020, 5, 1, 11, 47, 0, 0, time.UTC)}},
+	} {
+		schedule, err := ParseSchedule(test.spec)
+		if err != nil {
+			t.Errorf("%q: %s", test.spec, err)
+			continue
+		}
+		at := after
+		for _, expected := range test.expected {
+			at = schedule.Next(at)
+			if !at.Equal(expected) {
+				t.Errorf("%q: expected %s, got %s", test.spec, expected, at)
+				break
+			}
+		}
+	}
+}
+
+func TestScheduleDaylightSaving(t *testing.T) {
+	schedule, err := ParseSchedule("TZ=America/Montreal 30 2 * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	montreal, _ := time.LoadLocation("America/Montreal")
+
+	// 2:30 doesn't exist on March 8th 2020, and runs at 3:30 instead.
+	next := schedule.Next(time.Date(2020, 3, 7, 12, 0, 0, 0, montreal))
+	if expected := time.Date(2020, 3, 8, 3, 30, 0, 0, montreal); !next.Equal(expected) {
+		t.Errorf("expected %s, got %s", expected, next)
+	}
+	next = schedule.Next(next)
+	if expected := time.Date(2020, 3, 9, 2, 30, 0, 0, montreal); !next.Equal(expected) {
+		t.Errorf("expected %s, got %s", expected, next)
+	}
+}
+
+func TestParseScheduleErrors(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"* * * *",
+		"60 * * * *",
+		"* * * foo *",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"@every 10s",
+		"@sometimes",
+		"TZ=Mars/Olympus 0 9 * * *",
+		"every blue moon",
+		"every day at 25:00",
+		"every day at 13pm",
+	} {
+		if _, err := ParseSchedule(spec); err == nil {
+			t.Errorf("expected %q to be refused", spec)
+		}
+	}
+
+	schedule, err := ParseSchedule("0 0 30 2 *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next := schedule.Next(time.Now()); !next.IsZero() {
+		t.Errorf("expected February 30th never to come, got %s", next)
+	}
+}
@@ -0,0 +1,350 @@
+package plotbot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MissedRunPolicy tells what to do with the runs of a job that were
+// missed, while the bot was down or the job's handler not registered
+// yet.
+type MissedRunPolicy string
+
+const (
+	// RunMissedOnce runs a job once for all its missed runs.  It is
+	// the default.
+	RunMissedOnce MissedRunPolicy = ""
+	// RunMissedAll runs a job for each of its missed runs, up to
+	// maxMissedRuns.
+	RunMissedAll MissedRunPolicy = "all"
+	// SkipMissed forgets the missed runs.
+	SkipMissed MissedRunPolicy = "skip"
+)
+
+// missedRunGrace is how late a run can be without being considered
+// missed.
+const missedRunGrace = time.Minute
+
+// maxMissedRuns bounds the runs caught up with RunMissedAll.
+const maxMissedRuns = 100
+
+// Job is a scheduled job, kept in the Store so it survives restarts.
+// Recurring jobs have a `Spec`, see ParseSchedule, and one-shot jobs
+// run once `At` a given time, after which they are deleted.
+type Job struct {
+	// ID identifies the job.  Plugins should prefix it with their
+	// name, like "mooder:daily".
+	ID string
+	// Handler is the name of the JobHandler running the job, see
+	// `Scheduler.Handle()`.
+	Handler string
+	Spec    string    `json:",omitempty"`
+	At      time.Time `json:",omitempty"`
+	// Data is handed to the handler, see `Decode()`.
+	Data   json.RawMessage `json:",omitempty"`
+	Missed MissedRunPolicy `json:",omitempty"`
+
+	// Next is the time of the next run, and LastRun of the latest
+	// one.
+	Next    time.Time
+	LastRun time.Time `json:",omitempty"`
+}
+
+// Decode decodes the Data of the job into `value`.
+func (job *Job) Decode(value interface{}) error {
+	return json.Unmarshal(job.Data, value)
+}
+
+// schedule returns the schedule of a recurring job, nil for a one-shot
+// job.  Specs without a time zone are in `loc`.
+func (job *Job) schedule(loc *time.Location) (Schedule, error) {
+	if job.Spec == "" {
+		return nil, nil
+	}
+	return ParseScheduleIn(job.Spec, loc)
+}
+
+// JobHandler runs a job.  `scheduledAt` is the time the run was
+// scheduled for, which is in the past for missed runs.
+type JobHandler func(job Job, scheduledAt time.Time)
+
+// UpcomingRun is a future run of a job, see `Scheduler.Upcoming()`.
+type UpcomingRun struct {
+	Job Job
+	At  time.Time
+}
+
+// Scheduler runs jobs at the times of their schedule.  Jobs are
+// persisted, while their handlers are registered by the plugins upon
+// each start: a job whose handler is not registered waits for it, and
+// then applies its MissedRunPolicy.
+type Scheduler struct {
+	store    Store
+	now      func() time.Time
+	location *time.Location
+	logger   *Logger
+
+	lock     sync.Mutex
+	jobs     map[string]*Job
+	handlers map[string]JobHandler
+	wake     chan bool
+}
+
+// NewScheduler returns a Scheduler keeping its jobs in `store`, and
+// loads those already there.
+func NewScheduler(store Store) (*Scheduler, error) {
+	s := &Scheduler{
+		store:    store,
+		now:      time.Now,
+		location: time.UTC,
+		logger:   defaultLogger.With("component", "scheduler"),
+		jobs:     make(map[string]*Job),
+		handlers: make(map[string]JobHandler),
+		wake:     make(chan bool, 1),
+	}
+
+	err := store.Iterate("", func(entry StoreEntry) error {
+		var job Job
+		if err := entry.Decode(&job); err != nil {
+			return fmt.Errorf("job %q: %s", entry.Key, err)
+		}
+		if _, err := job.schedule(time.UTC); err != nil {
+			s.logger.Warn("Ignoring job", "job", job.ID, "err", err)
+			return nil
+		}
+		s.jobs[job.ID] = &job
+		return nil
+	})
+	return s, err
+}
+
+// SetLocation sets the time zone of the specs which don't give one, UTC
+// by default.  The bot sets it to its default time zone, before the
+// plugins schedule their jobs.
+func (s *Scheduler) SetLocation(loc *time.Location) {
+	s.lock.Lock()
+	s.location = loc
+	s.lock.Unlock()
+}
+
+// Handle registers the handler of the jobs naming it.  Handlers run in
+// their own goroutine.
+func (s *Scheduler) Handle(name string, handler JobHandler) {
+	s.lock.Lock()
+	s.handlers[name] = handler
+	s.lock.Unlock()
+	s.notify()
+}
+
+// Schedule adds a job, or replaces the one with the same ID.  A job
+// rescheduled with the same `Spec` or `At` keeps its next run, so runs
+// missed while the bot was down are still caught up.  It returns the
+// job as scheduled.
+func (s *Scheduler) Schedule(job Job) (Job, error) {
+	if job.ID == "" || job.Handler == "" {
+		return job, fmt.Errorf("jobs need an ID and a Handler")
+	}
+	if (job.Spec == "") == job.At.IsZero() {
+		return job, fmt.Errorf("job %q needs either a Spec or an At time", job.ID)
+	}
+	switch job.Missed {
+	case RunMissedOnce, RunMissedAll, SkipMissed:
+	default:
+		return job, fmt.Errorf("job %q: unknown missed run policy %q", job.ID, job.Missed)
+	}
+	s.lock.Lock()
+	defer s.notify()
+	defer s.lock.Unlock()
+
+	schedule, err := job.schedule(s.location)
+	if err != nil {
+		return job, fmt.Errorf("job %q: %s", job.ID, err)
+	}
+
+	existing := s.jobs[job.ID]
+	switch {
+	case existing != nil && existing.Spec == job.Spec && existing.At.Equal(job.At):
+		job.Next, job.LastRun = existing.Next, existing.LastRun
+	case schedule != nil:
+		job.Next = schedule.Next(s.now())
+		if job.Next.IsZero() {
+			return job, fmt.Errorf("job %q never runs", job.ID)
+		}
+	default:
+		job.Next = job.At
+	}
+
+	if err := s.store.Put(job.ID, &job); err != nil {
+		return job, err
+	}
+	s.jobs[job.ID] = &job
+	return job, nil
+}
+
+// Cancel deletes a job.  Cancelling an unknown job is not an error.
+func (s *Scheduler) Cancel(id string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	delete(s.jobs, id)
+	return s.store.Delete(id)
+}
+
+// Job returns a job by ID.
+func (s *Scheduler) Job(id string) (Job, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	job, ok := s.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return *job, true
+}
+
+// Jobs returns the jobs whose ID starts with `prefix`, by ID.
+func (s *Scheduler) Jobs(prefix string) []Job {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	jobs := make([]Job, 0)
+	for id, job := range s.jobs {
+		if strings.HasPrefix(id, prefix) {
+			jobs = append(jobs, *job)
+		}
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].ID < jobs[j].ID })
+	return jobs
+}
+
+// Upcoming returns the next `count` runs of all the jobs before
+// `until`, in order.
+func (s *Scheduler) Upcoming(count int, until time.Time) []UpcomingRun {
+	s.lock.Lock()
+	loc := s.location
+	s.lock.Unlock()
+
+	runs := make([]UpcomingRun, 0)
+	for _, job := range s.Jobs("") {
+		schedule, _ := job.schedule(loc)
+		for at := job.Next; !at.IsZero() && at.Before(until); {
+			runs = append(runs, UpcomingRun{job, at})
+			if schedule == nil || len(runs) > count*2 {
+				break
+			}
+			at = schedule.Next(at)
+		}
+	}
+	sort.SliceStable(runs, func(i, j int) bool { return runs[i].At.Before(runs[j].At) })
+	if len(runs) > count {
+		runs = runs[:count]
+	}
+	return runs
+}
+
+// Run runs the jobs as they are due, until `ctx` is done.
+func (s *Scheduler) Run(ctx context.Context) {
+	for {
+		// Wake up at least hourly, in case the clock jumped.
+		wait := time.Hour
+		if next := s.runDue(); !next.IsZero() && next.Sub(s.now()) < wait {
+			wait = next.Sub(s.now())
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-s.wake:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// notify wakes `Run()` up, after the jobs or handlers changed.
+func (s *Scheduler) notify() {
+	select {
+	case s.wake <- true:
+	default:
+	}
+}
+
+// runDue starts the handlers of the due jobs, updates the jobs, and
+// returns the time of the next run of a job with a handler.
+func (s *Scheduler) runDue() time.Time {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	now := s.now()
+	var next time.Time
+	for _, job := range s.jobs {
+		handler, ok := s.handlers[job.Handler]
+		if !ok {
+			continue
+		}
+		if job.Next.After(now) {
+			if next.IsZero() || job.Next.Before(next) {
+				next = job.Next
+			}
+			continue
+		}
+
+		for _, at := range s.runTimes(job, now) {
+			go handler(*job, at)
+			job.LastRun = at
+		}
+
+		schedule, _ := job.schedule(s.location)
+		if schedule == nil {
+			delete(s.jobs, job.ID)
+			if err := s.store.Delete(job.ID); err != nil {
+				s.logger.Error("Error deleting job", "job", job.ID, "err", err)
+			}
+			continue
+		}
+		job.Next = schedule.Next(now)
+		if err := s.store.Put(job.ID, job); err != nil {
+			s.logger.Error("Error saving job", "job", job.ID, "err", err)
+		}
+		if !job.Next.IsZero() && (next.IsZero() || job.Next.Before(next)) {
+			next = job.Next
+		}
+	}
+	return next
+}
+
+// runTimes returns the times a due job must run for now, according to
+// its MissedRunPolicy.
+func (s *Scheduler) runTimes(job *Job, now time.Time) []time.Time {
+	if now.Sub(job.Next) <= missedRunGrace {
+		return []time.Time{job.Next}
+	}
+
+	switch job.Missed {
+	case SkipMissed:
+		s.logger.Info("Skipping a missed run", "job", job.ID, "missed", job.Next)
+		return nil
+	case RunMissedAll:
+		schedule, _ := job.schedule(s.location)
+		times := make([]time.Time, 0)
+		for at := job.Next; !at.IsZero() && !at.After(now) && len(times) < maxMissedRuns; {
+			times = append(times, at)
+			if schedule == nil {
+				break
+			}
+			at = schedule.Next(at)
+		}
+		s.logger.Info("Catching up with missed runs", "job", job.ID, "runs", len(times))
+		return times
+	default:
+		s.logger.Info("Running a missed job", "job", job.ID, "missed", job.Next)
+		return []time.Time{job.Next}
+	}
+}
@@ -0,0 +1,156 @@
+package plotbot
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+type schedulerRun struct {
+	id string
+	at time.Time
+}
+
+// testScheduler returns a Scheduler on a fake clock, and the channel of
+// its runs of the "test" handler.
+func testScheduler(t *testing.T, store Store, now *time.Time) (*Scheduler, chan schedulerRun) {
+	s, err := NewScheduler(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.now = func() time.Time { return *now }
+	runs := make(chan schedulerRun, 200)
+	s.Handle("test", func(job Job, scheduledAt time.Time) {
+		runs <- schedulerRun{job.ID, scheduledAt}
+	})
+	return s, runs
+}
+
+// receiveRuns returns the runs made so far, sorted, as handlers run
+// concurrently.
+func receiveRuns(runs chan schedulerRun) string {
+	time.Sleep(20 * time.Millisecond)
+	received := make([]string, 0)
+	for {
+		select {
+		case run := <-runs:
+			received = append(received, run.id+"@"+run.at.UTC().Format("15:04"))
+		default:
+			sort.Strings(received)
+			return strings.Join(received, ",")
+		}
+	}
+}
+
+func TestScheduler(t *testing.T) {
+	now := time.Date(2020, 5, 1, 8, 0, 0, 0, time.UTC)
+	store := NewMemoryStore()
+	s, runs := testScheduler(t, store, &now)
+
+	if _, err := s.Schedule(Job{ID: "test:hourly", Handler: "test", Spec: "0 * * * *"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Schedule(Job{ID: "test:once", Handler: "test", At: now.Add(90 * time.Minute),
+		Data: json.RawMessage(`{"text": "hi"}`)}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Schedule(Job{ID: "bad", Handler: "test", Spec: "every blue moon"}); err == nil {
+		t.Error("expected an invalid spec to be refused")
+	}
+
+	if next := s.runDue(); !next.Equal(time.Date(2020, 5, 1, 9, 0, 0, 0, time.UTC)) {
+		t.Errorf("expected to wake up for the next run, got %s", next)
+	}
+	if received := receiveRuns(runs); received != "" {
+		t.Errorf("expected no runs yet, got %s", received)
+	}
+
+	now = time.Date(2020, 5, 1, 9, 0, 5, 0, time.UTC)
+	s.runDue()
+	now = time.Date(2020, 5, 1, 9, 30, 0, 0, time.UTC)
+	s.runDue()
+	if received := receiveRuns(runs); received != "test:hourly@09:00,test:once@09:30" {
+		t.Errorf("expected the due runs, got %s", received)
+	}
+	if _, ok := s.Job("test:once"); ok {
+		t.Error("expected the one-shot job to be deleted once run")
+	}
+	job, _ := s.Job("test:hourly")
+	if !job.Next.Equal(time.Date(2020, 5, 1, 10, 0, 0, 0, time.UTC)) || !job.LastRun.Equal(time.Date(2020, 5, 1, 9, 0, 0, 0, time.UTC)) {
+		t.Errorf("expected the job to be rescheduled, got %#v", job)
+	}
+
+	upcoming := s.Upcoming(3, now.Add(24*time.Hour))
+	if len(upcoming) != 3 || !upcoming[2].At.Equal(time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC)) {
+		t.Errorf("expected the next 3 runs, got %v", upcoming)
+	}
+
+	if err := s.Cancel("test:hourly"); err != nil {
+		t.Fatal(err)
+	}
+	if jobs := s.Jobs("test:"); len(jobs) != 0 {
+		t.Errorf("expected the job to be cancelled, got %v", jobs)
+	}
+}
+
+func TestSchedulerMissedRuns(t *testing.T) {
+	now := time.Date(2020, 5, 1, 8, 30, 0, 0, time.UTC)
+	store := NewMemoryStore()
+	s, _ := testScheduler(t, store, &now)
+	for _, job := range []Job{
+		{ID: "once", Handler: "test", Spec: "0 * * * *"},
+		{ID: "all", Handler: "test", Spec: "0 * * * *", Missed: RunMissedAll},
+		{ID: "skip", Handler: "test", Spec: "0 * * * *", Missed: SkipMissed},
+		{ID: "later", Handler: "test", At: now.Add(time.Hour), Data: json.RawMessage(`"data"`)},
+		{ID: "other", Handler: "other", Spec: "0 * * * *"},
+	} {
+		if _, err := s.Schedule(job); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The bot restarts 3 hours later, and the plugins schedule their
+	// jobs again.
+	now = time.Date(2020, 5, 1, 11, 30, 0, 0, time.UTC)
+	s, runs := testScheduler(t, store, &now)
+	if _, err := s.Schedule(Job{ID: "once", Handler: "test", Spec: "0 * * * *"}); err != nil {
+		t.Fatal(err)
+	}
+	job, _ := s.Job("later")
+	var data string
+	if err := job.Decode(&data); err != nil || data != "data" {
+		t.Errorf("expected the job's data to be kept, got %q, %v", data, err)
+	}
+
+	s.runDue()
+	expected := "all@09:00,all@10:00,all@11:00,later@09:30,once@09:00"
+	if received := receiveRuns(runs); received != expected {
+		t.Errorf("expected %s, got %s", expected, received)
+	}
+	for _, id := range []string{"once", "all", "skip"} {
+		if job, _ := s.Job(id); !job.Next.Equal(time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC)) {
+			t.Errorf("expected %s to run next at noon, got %s", id, job.Next)
+		}
+	}
+	if job, _ := s.Job("other"); !job.Next.Equal(time.Date(2020, 5, 1, 9, 0, 0, 0, time.UTC)) {
+		t.Errorf("expected jobs without a handler to wait, got %s", job.Next)
+	}
+}
+
+func TestSchedulerLocation(t *testing.T) {
+	now := time.Date(2020, 5, 1, 8, 0, 0, 0, time.UTC)
+	s, _ := testScheduler(t, NewMemoryStore(), &now)
+	montreal, _ := time.LoadLocation("America/Montreal")
+	s.SetLocation(montreal)
+
+	job, err := s.Schedule(Job{ID: "test:local", Handler: "test", Spec: "every day at 9:00"})
+	if err != nil || !job.Next.Equal(time.Date(2020, 5, 1, 9, 0, 0, 0, montreal)) {
+		t.Errorf("expected the spec in the scheduler's zone, got %s, %v", job.Next, err)
+	}
+	job, err = s.Schedule(Job{ID: "test:utc", Handler: "test", Spec: "every day at 9:00 in UTC"})
+	if err != nil || !job.Next.Equal(time.Date(2020, 5, 1, 9, 0, 0, 0, time.UTC)) {
+		t.Errorf("expected the zone of the spec to win, got %s, %v", job.Next, err)
+	}
+}
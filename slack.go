@@ -0,0 +1,222 @@
+package plotbot
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/slack-go/slack"
+)
+
+// NewSlackAdapter returns the Slack Adapter selected by `config.Mode`.
+func NewSlackAdapter(config SlackConfig) (Adapter, error) {
+	switch config.Mode {
+	case "", "rtm":
+		return NewSlackRTM(config), nil
+	case "events":
+		return NewSlackEvents(config), nil
+	case "socket":
+		return NewSlackSocket(config), nil
+	}
+	return nil, fmt.Errorf("unknown Slack mode %q", config.Mode)
+}
+
+// slackLogger logs the activity of the Slack adapters.
+var slackLogger = defaultLogger.With("component", "slack")
+
+// slackOptions adds the logging options to those of a Slack client:
+// with `debug` set, it logs its requests and the events it receives.
+func slackOptions(config SlackConfig, options ...slack.Option) []slack.Option {
+	return append(options, slack.OptionDebug(config.Debug),
+		slack.OptionLog(log.New(slackLogger.Writer(LevelDebug), "", 0)))
+}
+
+// translateSlackEvent converts the slack-go events shared by the RTM
+// and the Events API into plotbot Events.  It returns nil for events
+// the Bot does not care about.
+func translateSlackEvent(data interface{}) Event {
+	switch ev := data.(type) {
+	case *slack.MessageEvent:
+		switch ev.SubType {
+		case "message_changed":
+			return translateMessageChanged(ev)
+		case "message_deleted":
+			return translateMessageDeleted(ev)
+		}
+		return &MessageEvent{Msg: ev.Msg, SubMessage: ev.SubMessage}
+
+	case *slack.PresenceChangeEvent:
+		return &PresenceChangeEvent{UserID: ev.User, Presence: ev.Presence}
+
+	// TODO: manage im_open, im_close, and im_created ?
+
+	/**
+	 * User changes
+	 */
+	case *slack.UserChangeEvent:
+		return &UserChangeEvent{User: ev.User}
+
+	/**
+	 * Handle channel changes
+	 */
+	case *slack.ChannelRenameEvent:
+		return &ChannelRenameEvent{ChannelID: ev.Channel.ID, Name: ev.Channel.Name}
+
+	case *slack.ChannelJoinedEvent:
+		return &ChannelChangeEvent{Channel: ev.Channel}
+
+	case *slack.ChannelCreatedEvent:
+		// NICE: poll the API to get a full Channel object ? many
+		// things are missing here
+		return &ChannelChangeEvent{Channel: createdChannel(ev.Channel)}
+
+	case *slack.ChannelDeletedEvent:
+		return &ChannelDeleteEvent{ChannelID: ev.Channel}
+
+	case *slack.ChannelArchiveEvent:
+		return &ChannelArchiveEvent{ChannelID: ev.Channel, Archived: true}
+
+	case *slack.ChannelUnarchiveEvent:
+		return &ChannelArchiveEvent{ChannelID: ev.Channel, Archived: false}
+
+	case *slack.MemberJoinedChannelEvent:
+		return &MemberJoinedEvent{ChannelID: ev.Channel, UserID: ev.User}
+
+	case *slack.MemberLeftChannelEvent:
+		return &MemberLeftEvent{ChannelID: ev.Channel, UserID: ev.User}
+
+	/**
+	 * Handle group changes
+	 */
+	case *slack.GroupRenameEvent:
+		return &ChannelRenameEvent{ChannelID: ev.Group.ID, Name: ev.Group.Name}
+
+	case *slack.GroupJoinedEvent:
+		return &ChannelChangeEvent{Channel: ev.Channel}
+
+	case *slack.GroupCreatedEvent:
+		return &ChannelChangeEvent{Channel: createdChannel(ev.Channel)}
+
+	case *slack.GroupCloseEvent:
+		// TODO: when a group is "closed"... does that mean removed ?
+		// TODO: how do we even manage groups ?!?!
+		return &ChannelDeleteEvent{ChannelID: ev.Channel}
+
+	case *slack.GroupArchiveEvent:
+		return &ChannelArchiveEvent{ChannelID: ev.Channel, Archived: true}
+
+	case *slack.GroupUnarchiveEvent:
+		return &ChannelArchiveEvent{ChannelID: ev.Channel, Archived: false}
+
+	default:
+		slackLogger.Debug("Ignoring unexpected event", "type", fmt.Sprintf("%T", ev))
+	}
+	return nil
+}
+
+// translateMessageChanged turns a `message_changed` message into a
+// MessageEditEvent.  Slack also sends them when it unfurls links, in
+// which case the text didn't change and nil is returned.
+func translateMessageChanged(ev *slack.MessageEvent) Event {
+	if ev.SubMessage == nil {
+		return nil
+	}
+
+	edit := &MessageEditEvent{Msg: *ev.SubMessage}
+	edit.Msg.Channel = ev.Channel
+	if ev.PreviousMessage != nil {
+		if ev.PreviousMessage.Text == edit.Msg.Text {
+			return nil
+		}
+		edit.PreviousText = ev.PreviousMessage.Text
+	}
+	return edit
+}
+
+// translateMessageDeleted turns a `message_deleted` message into a
+// MessageDeleteEvent.
+func translateMessageDeleted(ev *slack.MessageEvent) Event {
+	deleted := &MessageDeleteEvent{}
+	if ev.PreviousMessage != nil {
+		deleted.Msg = *ev.PreviousMessage
+	}
+	deleted.Msg.Channel = ev.Channel
+	deleted.Msg.Timestamp = ev.DeletedTimestamp
+	return deleted
+}
+
+// slackMsgOptions renders a BotReply.  Plain replies are sent the way
+// plotbot always has: as a single attachment, colored when `Color` is
+// set.  Replies with `Blocks` send them at the top level, or in the
+// attachment when colored, with `Text` as fallback.
+func slackMsgOptions(reply *BotReply) slack.MsgOption {
+	var options []slack.MsgOption
+	switch {
+	case len(reply.Blocks) == 0:
+		options = append(options, slack.MsgOptionAttachments(slack.Attachment{
+			Color: reply.Color,
+			Text:  reply.Text,
+		}))
+	case reply.Color != "":
+		options = append(options,
+			slack.MsgOptionText(reply.Text, false),
+			slack.MsgOptionAttachments(slack.Attachment{
+				Color:    reply.Color,
+				Fallback: reply.Text,
+				Blocks:   slack.Blocks{BlockSet: reply.Blocks},
+			}))
+	default:
+		options = append(options,
+			slack.MsgOptionText(reply.Text, false),
+			slack.MsgOptionBlocks(reply.Blocks...))
+	}
+	if reply.ThreadTimestamp != "" {
+		options = append(options, slack.MsgOptionTS(reply.ThreadTimestamp))
+	}
+	return slack.MsgOptionCompose(options...)
+}
+
+// getSlackChannels lists channels and private groups, all as
+// `slack.Channel`.  Archived ones are excluded.
+func getSlackChannels(client *slack.Client) ([]slack.Channel, error) {
+	channels, err := client.GetChannels(true)
+	if err != nil {
+		return nil, err
+	}
+
+	groups, err := client.GetGroups(true)
+	if err != nil {
+		slackLogger.Error("Error fetching groups", "err", err)
+		return channels, nil
+	}
+
+	for _, group := range groups {
+		channels = append(channels, slack.Channel{
+			GroupConversation: slack.GroupConversation{
+				Conversation: slack.Conversation{
+					ID:         group.ID,
+					NumMembers: group.NumMembers,
+				},
+				Name:       group.Name,
+				Creator:    group.Creator,
+				IsArchived: group.IsArchived,
+				Members:    group.Members,
+				Topic:      group.Topic,
+				Purpose:    group.Purpose,
+			},
+			IsChannel: false,
+			IsMember:  true,
+		})
+	}
+
+	return channels, nil
+}
+
+func createdChannel(info slack.ChannelCreatedInfo) slack.Channel {
+	return slack.Channel{
+		GroupConversation: slack.GroupConversation{
+			Conversation: slack.Conversation{ID: info.ID},
+			Name:         info.Name,
+			Creator:      info.Creator,
+		},
+	}
+}
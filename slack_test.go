@@ -0,0 +1,67 @@
+package plotbot
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/slack-go/slack"
+)
+
+func TestTranslateMessageEditions(t *testing.T) {
+	changed := &slack.MessageEvent{Msg: slack.Msg{
+		Type:    "message",
+		SubType: "message_changed",
+		Channel: "C1",
+	}}
+	changed.SubMessage = &slack.Msg{User: "U1", Text: "!today fix bugs", Timestamp: "1355517523.000005"}
+	changed.PreviousMessage = &slack.Msg{User: "U1", Text: "!today fix bug", Timestamp: "1355517523.000005"}
+
+	edit, ok := translateSlackEvent(changed).(*MessageEditEvent)
+	if !ok {
+		t.Fatalf("expected a MessageEditEvent")
+	}
+	if edit.Msg.Channel != "C1" || edit.Msg.Text != "!today fix bugs" ||
+		edit.Msg.Timestamp != "1355517523.000005" || edit.PreviousText != "!today fix bug" {
+		t.Errorf("unexpected edit %#v", edit)
+	}
+
+	// Unfurling a link doesn't change the text.
+	changed.PreviousMessage.Text = changed.SubMessage.Text
+	if event := translateSlackEvent(changed); event != nil {
+		t.Errorf("expected unfurls to be ignored, got %#v", event)
+	}
+
+	deletedEvent := &slack.MessageEvent{Msg: slack.Msg{
+		Type:             "message",
+		SubType:          "message_deleted",
+		Channel:          "C1",
+		DeletedTimestamp: "1355517523.000005",
+	}}
+	deletedEvent.PreviousMessage = &slack.Msg{User: "U1", Text: "!today fix bug"}
+
+	deleted, ok := translateSlackEvent(deletedEvent).(*MessageDeleteEvent)
+	if !ok {
+		t.Fatalf("expected a MessageDeleteEvent")
+	}
+	if deleted.Msg.Channel != "C1" || deleted.Msg.User != "U1" ||
+		deleted.Msg.Text != "!today fix bug" || deleted.Msg.Timestamp != "1355517523.000005" {
+		t.Errorf("unexpected deletion %#v", deleted)
+	}
+}
+
+func TestTranslateMembershipEvents(t *testing.T) {
+	raw := json.RawMessage(`{"type": "member_joined_channel", "user": "U1", "channel": "C1", "channel_type": "C"}`)
+	event, err := parseSlackInnerEvent(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	joined, ok := translateSlackEvent(event).(*MemberJoinedEvent)
+	if !ok || joined.ChannelID != "C1" || joined.UserID != "U1" {
+		t.Errorf("unexpected join %#v", joined)
+	}
+
+	left, ok := translateSlackEvent(&slack.MemberLeftChannelEvent{User: "U1", Channel: "C1"}).(*MemberLeftEvent)
+	if !ok || left.ChannelID != "C1" || left.UserID != "U1" {
+		t.Errorf("unexpected leave %#v", left)
+	}
+}
@@ -0,0 +1,294 @@
+package plotbot
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+const (
+	defaultEventsPath        = "/slack/events"
+	defaultInteractivityPath = "/slack/interactivity"
+
+	// maxSlackRequestSize bounds the bodies read from Slack.
+	maxSlackRequestSize = 1 << 20
+	// seenEventsTTL is how long event IDs are remembered, so Slack's
+	// retries, the last one 5 minutes after the first try, are only
+	// acknowledged.
+	seenEventsTTL = 10 * time.Minute
+)
+
+// SlackEvents is the Adapter receiving Slack events over HTTP, through
+// the Events API.  Replies still go through the Web API.
+//
+// SlackEvents is an `http.Handler`: it verifies the request signature,
+// answers `url_verification` challenges and feeds `event_callback`
+// payloads to the Bot.  Clicks on interactive components are posted to
+// a separate endpoint, see `ServeInteractivity()`.
+//
+// Events are queued without waiting for the Bot, so Slack gets its
+// answer within its 3 seconds, and retries of events already queued
+// are only acknowledged.  When the queue is full, the request fails
+// for Slack to retry later.
+type SlackEvents struct {
+	config SlackConfig
+	client *slack.Client
+	server *http.Server
+	events chan Event
+
+	seenLock sync.Mutex
+	seen     map[string]time.Time
+}
+
+type slackEventsEnvelope struct {
+	Type      string          `json:"type"`
+	Challenge string          `json:"challenge"`
+	EventID   string          `json:"event_id"`
+	Event     json.RawMessage `json:"event"`
+}
+
+func NewSlackEvents(config SlackConfig) *SlackEvents {
+	return &SlackEvents{
+		config: config,
+		client: slack.New(config.ApiToken, slackOptions(config)...),
+		events: make(chan Event, 100),
+		seen:   make(map[string]time.Time),
+	}
+}
+
+func (a *SlackEvents) Connect() error {
+	auth, err := a.client.AuthTest()
+	if err != nil {
+		return err
+	}
+
+	if a.server == nil {
+		path := a.config.EventsPath
+		if path == "" {
+			path = defaultEventsPath
+		}
+		interactivityPath := a.config.InteractivityPath
+		if interactivityPath == "" {
+			interactivityPath = defaultInteractivityPath
+		}
+		mux := http.NewServeMux()
+		mux.Handle(path, a)
+		mux.HandleFunc(interactivityPath, a.ServeInteractivity)
+		a.server = &http.Server{Addr: a.config.EventsListen, Handler: mux}
+
+		go func() {
+			slackLogger.Info("Listening for Slack events", "listen", a.config.EventsListen,
+				"path", path, "interactivity_path", interactivityPath)
+			err := a.server.ListenAndServe()
+			if err != nil && err != http.ErrServerClosed {
+				slackLogger.Error("Slack events server error", "err", err)
+			}
+		}()
+	}
+
+	a.events <- &ConnectedEvent{Myself: &slack.UserDetails{
+		ID:   auth.UserID,
+		Name: auth.User,
+	}}
+	return nil
+}
+
+func (a *SlackEvents) Disconnect() {
+	if a.server != nil {
+		a.server.Close()
+		a.server = nil
+	}
+}
+
+func (a *SlackEvents) Events() <-chan Event {
+	return a.events
+}
+
+func (a *SlackEvents) Send(reply *BotReply) (string, error) {
+	_, timestamp, err := a.client.PostMessage(reply.To, slackMsgOptions(reply))
+	return timestamp, err
+}
+
+func (a *SlackEvents) Update(channelID, timestamp string, reply *BotReply) error {
+	_, _, _, err := a.client.UpdateMessage(channelID, timestamp, slackMsgOptions(reply))
+	return err
+}
+
+func (a *SlackEvents) GetUsers() ([]slack.User, error) {
+	return a.client.GetUsers()
+}
+
+func (a *SlackEvents) GetChannels() ([]slack.Channel, error) {
+	return getSlackChannels(a.client)
+}
+
+func (a *SlackEvents) GetUser(id string) (*slack.User, error) {
+	return a.client.GetUserInfo(id)
+}
+
+func (a *SlackEvents) GetChannel(id string) (*slack.Channel, error) {
+	return a.client.GetConversationInfo(id, false)
+}
+
+func (a *SlackEvents) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := verifySlackRequest(w, r, a.config.SigningSecret)
+	if err != nil {
+		slackLogger.Warn("Rejecting Slack events request", "err", err)
+		http.Error(w, "invalid request", http.StatusUnauthorized)
+		return
+	}
+
+	var envelope slackEventsEnvelope
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+
+	switch envelope.Type {
+	case "url_verification":
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(envelope.Challenge))
+
+	case "event_callback":
+		event, err := parseSlackInnerEvent(envelope.Event)
+		if err != nil {
+			slackLogger.Error("Error parsing Slack event", "err", err)
+		} else if translated := translateSlackEvent(event); translated != nil {
+			if !a.queueOnce(envelope.EventID, translated) {
+				slackLogger.Warn("Slack events queue is full, refusing event", "event_id", envelope.EventID,
+					"retry", r.Header.Get("X-Slack-Retry-Num"))
+				http.Error(w, "busy", http.StatusServiceUnavailable)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusOK)
+
+	default:
+		slackLogger.Warn("Unexpected Slack events payload", "type", envelope.Type)
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// queueOnce queues an event for the Bot, unless the event with the
+// same ID was already queued.  It returns false when the queue is full.
+func (a *SlackEvents) queueOnce(eventID string, event Event) bool {
+	a.seenLock.Lock()
+	defer a.seenLock.Unlock()
+
+	now := time.Now()
+	for id, at := range a.seen {
+		if now.Sub(at) > seenEventsTTL {
+			delete(a.seen, id)
+		}
+	}
+	if _, seen := a.seen[eventID]; seen && eventID != "" {
+		return true
+	}
+
+	select {
+	case a.events <- event:
+		a.seen[eventID] = now
+		return true
+	default:
+		return false
+	}
+}
+
+// ServeInteractivity handles the interactivity requests Slack posts
+// when a user clicks a button or picks an option in a menu.  The
+// callback is form-encoded as a JSON `payload`.
+func (a *SlackEvents) ServeInteractivity(w http.ResponseWriter, r *http.Request) {
+	body, err := verifySlackRequest(w, r, a.config.SigningSecret)
+	if err != nil {
+		slackLogger.Warn("Rejecting Slack interactivity request", "err", err)
+		http.Error(w, "invalid request", http.StatusUnauthorized)
+		return
+	}
+
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+
+	var callback slack.InteractionCallback
+	if err := json.Unmarshal([]byte(form.Get("payload")), &callback); err != nil {
+		slackLogger.Error("Error parsing interactive payload", "err", err)
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+
+	select {
+	case a.events <- &InteractionEvent{Callback: callback}:
+		w.WriteHeader(http.StatusOK)
+	default:
+		slackLogger.Warn("Slack events queue is full, refusing interaction", "callback_id", callback.CallbackID)
+		http.Error(w, "busy", http.StatusServiceUnavailable)
+	}
+}
+
+// verifySlackRequest checks the `X-Slack-Signature` of a request
+// against the signing secret, and returns its body, of at most
+// `maxSlackRequestSize`.
+func verifySlackRequest(w http.ResponseWriter, r *http.Request, signingSecret string) ([]byte, error) {
+	if signingSecret == "" {
+		return nil, fmt.Errorf("no signing_secret configured")
+	}
+
+	verifier, err := slack.NewSecretsVerifier(r.Header, signingSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxSlackRequestSize))
+	if err != nil {
+		return nil, err
+	}
+
+	verifier.Write(body)
+	if err := verifier.Ensure(); err != nil {
+		return nil, err
+	}
+
+	return body, nil
+}
+
+// ignoredSlackEvents are inner events apps may subscribe to, which
+// bring nothing the bot doesn't get otherwise: app mentions are also
+// delivered as messages.
+var ignoredSlackEvents = map[string]bool{
+	"app_mention": true,
+}
+
+// parseSlackInnerEvent decodes an Events API inner event into the same
+// slack-go type the RTM would have delivered.  Ignored events decode to
+// nil.
+func parseSlackInnerEvent(raw json.RawMessage) (interface{}, error) {
+	var typed struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(raw, &typed); err != nil {
+		return nil, err
+	}
+	if ignoredSlackEvents[typed.Type] {
+		return nil, nil
+	}
+
+	mapped, ok := slack.EventMapping[typed.Type]
+	if !ok {
+		return nil, fmt.Errorf("unsupported event type %q", typed.Type)
+	}
+
+	event := reflect.New(reflect.TypeOf(mapped)).Interface()
+	if err := json.Unmarshal(raw, event); err != nil {
+		return nil, err
+	}
+	return event, nil
+}
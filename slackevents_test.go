@@ -0,0 +1,206 @@
+package plotbot
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testSigningSecret = "8f742231b10e8888abcd99yyyzzz85a5"
+
+const urlVerificationPayload = `{
+  "token": "Jhj5dZrVaK7ZwHHjRyZWjbDl",
+  "challenge": "3eZbrw1aBm2rZgRNFdxV2595E9CY3gmdALWMmHkvFXO7tYXAYM8P",
+  "type": "url_verification"
+}`
+
+const messageCallbackPayload = `{
+  "token": "XXYYZZ",
+  "team_id": "TXXXXXXXX",
+  "api_app_id": "AXXXXXXXXX",
+  "event": {
+    "type": "message",
+    "channel": "C2147483705",
+    "user": "U2147483697",
+    "text": "<@UBOT> deploy to stage",
+    "ts": "1355517523.000005"
+  },
+  "type": "event_callback",
+  "event_id": "Ev08MFMKH6",
+  "event_time": 1234567890
+}`
+
+func postSignedPayload(t *testing.T, url, secret, payload string) *http.Response {
+	return postSigned(t, url, secret, "application/json", payload)
+}
+
+func postSigned(t *testing.T, url, secret, contentType, payload string) *http.Response {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(fmt.Sprintf("v0:%s:%s", timestamp, payload)))
+
+	req, err := http.NewRequest("POST", url, bytes.NewBufferString(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("X-Slack-Request-Timestamp", timestamp)
+	req.Header.Set("X-Slack-Signature", "v0="+hex.EncodeToString(mac.Sum(nil)))
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func TestSlackEventsURLVerification(t *testing.T) {
+	adapter := NewSlackEvents(SlackConfig{SigningSecret: testSigningSecret})
+	server := httptest.NewServer(adapter)
+	defer server.Close()
+
+	res := postSignedPayload(t, server.URL, testSigningSecret, urlVerificationPayload)
+	defer res.Body.Close()
+
+	body, _ := ioutil.ReadAll(res.Body)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", res.StatusCode)
+	}
+	if string(body) != "3eZbrw1aBm2rZgRNFdxV2595E9CY3gmdALWMmHkvFXO7tYXAYM8P" {
+		t.Errorf("expected challenge to be echoed, got %q", body)
+	}
+}
+
+func TestSlackEventsBadSignature(t *testing.T) {
+	adapter := NewSlackEvents(SlackConfig{SigningSecret: testSigningSecret})
+	server := httptest.NewServer(adapter)
+	defer server.Close()
+
+	res := postSignedPayload(t, server.URL, "not-the-secret", messageCallbackPayload)
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected status 401, got %d", res.StatusCode)
+	}
+	if len(adapter.events) != 0 {
+		t.Error("expected no event to be dispatched")
+	}
+}
+
+func TestSlackEventsMessageCallback(t *testing.T) {
+	adapter := NewSlackEvents(SlackConfig{SigningSecret: testSigningSecret})
+	server := httptest.NewServer(adapter)
+	defer server.Close()
+
+	res := postSignedPayload(t, server.URL, testSigningSecret, messageCallbackPayload)
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", res.StatusCode)
+	}
+
+	select {
+	case event := <-adapter.Events():
+		ev, ok := event.(*MessageEvent)
+		if !ok {
+			t.Fatalf("expected a *MessageEvent, got %#v", event)
+		}
+		if ev.Msg.Channel != "C2147483705" || ev.Msg.User != "U2147483697" {
+			t.Errorf("unexpected message %#v", ev.Msg)
+		}
+		if ev.Msg.Text != "<@UBOT> deploy to stage" {
+			t.Errorf("unexpected text %q", ev.Msg.Text)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no event dispatched")
+	}
+}
+
+func TestSlackEventsRetries(t *testing.T) {
+	adapter := NewSlackEvents(SlackConfig{SigningSecret: testSigningSecret})
+	server := httptest.NewServer(adapter)
+	defer server.Close()
+
+	for i := 0; i < 3; i++ {
+		res := postSignedPayload(t, server.URL, testSigningSecret, messageCallbackPayload)
+		res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", res.StatusCode)
+		}
+	}
+
+	if len(adapter.events) != 1 {
+		t.Errorf("expected retries to be dispatched once, got %d events", len(adapter.events))
+	}
+}
+
+func TestSlackEventsFullQueue(t *testing.T) {
+	adapter := NewSlackEvents(SlackConfig{SigningSecret: testSigningSecret})
+	adapter.events = make(chan Event)
+	server := httptest.NewServer(adapter)
+	defer server.Close()
+
+	res := postSignedPayload(t, server.URL, testSigningSecret, messageCallbackPayload)
+	res.Body.Close()
+	if res.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("expected status 503, got %d", res.StatusCode)
+	}
+
+	// The event wasn't queued, so Slack's retry is
+	adapter.events = make(chan Event, 1)
+	res = postSignedPayload(t, server.URL, testSigningSecret, messageCallbackPayload)
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK || len(adapter.events) != 1 {
+		t.Errorf("expected the retry to be dispatched, got status %d", res.StatusCode)
+	}
+}
+
+func TestSlackEventsIgnoresAppMentions(t *testing.T) {
+	adapter := NewSlackEvents(SlackConfig{SigningSecret: testSigningSecret})
+	server := httptest.NewServer(adapter)
+	defer server.Close()
+
+	payload := `{
+  "type": "event_callback",
+  "event_id": "Ev0APPMENTION",
+  "event": {
+    "type": "app_mention",
+    "channel": "C2147483705",
+    "user": "U2147483697",
+    "text": "<@UBOT> deploy to stage",
+    "ts": "1355517523.000005"
+  }
+}`
+	res := postSignedPayload(t, server.URL, testSigningSecret, payload)
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", res.StatusCode)
+	}
+	if len(adapter.events) != 0 {
+		t.Error("expected app mentions not to be dispatched")
+	}
+}
+
+func TestSlackEventsRequestSize(t *testing.T) {
+	adapter := NewSlackEvents(SlackConfig{SigningSecret: testSigningSecret})
+	server := httptest.NewServer(adapter)
+	defer server.Close()
+
+	payload := `{"type": "event_callback", "padding": "` + strings.Repeat("x", maxSlackRequestSize) + `"}`
+	res := postSignedPayload(t, server.URL, testSigningSecret, payload)
+	res.Body.Close()
+
+	if res.StatusCode == http.StatusOK {
+		t.Error("expected oversized requests to be rejected")
+	}
+}
@@ -0,0 +1,90 @@
+package plotbot
+
+import (
+	"github.com/slack-go/slack"
+)
+
+// SlackRTM is the Adapter speaking to Slack over the Real Time
+// Messaging websocket.
+type SlackRTM struct {
+	config SlackConfig
+	client *slack.Client
+	rtm    *slack.RTM
+	events chan Event
+}
+
+func NewSlackRTM(config SlackConfig) *SlackRTM {
+	return &SlackRTM{
+		config: config,
+		events: make(chan Event, 100),
+	}
+}
+
+func (a *SlackRTM) Connect() error {
+	a.client = slack.New(a.config.ApiToken, slackOptions(a.config)...)
+	a.rtm = a.client.NewRTM()
+
+	go a.rtm.ManageConnection()
+	go a.translateEvents(a.rtm)
+
+	return nil
+}
+
+func (a *SlackRTM) Disconnect() {
+	if a.rtm != nil {
+		a.rtm.Disconnect()
+	}
+}
+
+func (a *SlackRTM) Events() <-chan Event {
+	return a.events
+}
+
+func (a *SlackRTM) Send(reply *BotReply) (string, error) {
+	_, timestamp, err := a.client.PostMessage(reply.To, slackMsgOptions(reply))
+	return timestamp, err
+}
+
+func (a *SlackRTM) Update(channelID, timestamp string, reply *BotReply) error {
+	_, _, _, err := a.client.UpdateMessage(channelID, timestamp, slackMsgOptions(reply))
+	return err
+}
+
+func (a *SlackRTM) GetUsers() ([]slack.User, error) {
+	return a.client.GetUsers()
+}
+
+func (a *SlackRTM) GetChannels() ([]slack.Channel, error) {
+	return getSlackChannels(a.client)
+}
+
+func (a *SlackRTM) GetUser(id string) (*slack.User, error) {
+	return a.client.GetUserInfo(id)
+}
+
+func (a *SlackRTM) GetChannel(id string) (*slack.Channel, error) {
+	return a.client.GetConversationInfo(id, false)
+}
+
+func (a *SlackRTM) translateEvents(rtm *slack.RTM) {
+	for event := range rtm.IncomingEvents {
+		switch ev := event.Data.(type) {
+		case *slack.HelloEvent:
+			slackLogger.Debug("Got a HELLO from websocket")
+
+		case *slack.ConnectedEvent:
+			slackLogger.Info("Connected, syncing users and channels")
+			a.events <- &ConnectedEvent{Myself: rtm.GetInfo().User}
+
+		case slack.LatencyReport:
+			break
+		case *slack.IncomingEventError:
+			slackLogger.Error("RTM error", "err", ev)
+
+		default:
+			if translated := translateSlackEvent(ev); translated != nil {
+				a.events <- translated
+			}
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package plotbot
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/slack-go/slack"
+)
+
+// SlackSocket is the Adapter receiving Slack events through Socket
+// Mode: the bot opens an outgoing websocket with its app-level token,
+// so no public URL is needed.  Replies still go through the Web API.
+type SlackSocket struct {
+	config SlackConfig
+	apiURL string
+	client *slack.Client
+	events chan Event
+
+	mu     sync.Mutex
+	conn   *websocket.Conn
+	closed bool
+}
+
+type socketEnvelope struct {
+	Type       string          `json:"type"`
+	EnvelopeID string          `json:"envelope_id"`
+	Payload    json.RawMessage `json:"payload"`
+	Reason     string          `json:"reason"`
+}
+
+type socketAck struct {
+	EnvelopeID string `json:"envelope_id"`
+}
+
+func NewSlackSocket(config SlackConfig) *SlackSocket {
+	return newSlackSocket(config, slack.APIURL)
+}
+
+func newSlackSocket(config SlackConfig, apiURL string) *SlackSocket {
+	return &SlackSocket{
+		config: config,
+		apiURL: apiURL,
+		client: slack.New(config.ApiToken, slackOptions(config, slack.OptionAPIURL(apiURL))...),
+		events: make(chan Event, 100),
+	}
+}
+
+func (a *SlackSocket) Connect() error {
+	if a.config.AppToken == "" {
+		return fmt.Errorf("Socket Mode requires an app_token")
+	}
+
+	auth, err := a.client.AuthTest()
+	if err != nil {
+		return err
+	}
+
+	conn, err := a.dial()
+	if err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	a.conn = conn
+	a.closed = false
+	a.mu.Unlock()
+
+	go a.readLoop(conn)
+
+	a.events <- &ConnectedEvent{Myself: &slack.UserDetails{
+		ID:   auth.UserID,
+		Name: auth.User,
+	}}
+	return nil
+}
+
+func (a *SlackSocket) Disconnect() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.closed = true
+	if a.conn != nil {
+		a.conn.Close()
+		a.conn = nil
+	}
+}
+
+func (a *SlackSocket) Events() <-chan Event {
+	return a.events
+}
+
+func (a *SlackSocket) Send(reply *BotReply) (string, error) {
+	_, timestamp, err := a.client.PostMessage(reply.To, slackMsgOptions(reply))
+	return timestamp, err
+}
+
+func (a *SlackSocket) Update(channelID, timestamp string, reply *BotReply) error {
+	_, _, _, err := a.client.UpdateMessage(channelID, timestamp, slackMsgOptions(reply))
+	return err
+}
+
+func (a *SlackSocket) GetUsers() ([]slack.User, error) {
+	return a.client.GetUsers()
+}
+
+func (a *SlackSocket) GetChannels() ([]slack.Channel, error) {
+	return getSlackChannels(a.client)
+}
+
+func (a *SlackSocket) GetUser(id string) (*slack.User, error) {
+	return a.client.GetUserInfo(id)
+}
+
+func (a *SlackSocket) GetChannel(id string) (*slack.Channel, error) {
+	return a.client.GetConversationInfo(id, false)
+}
+
+// dial asks Slack for a fresh Socket Mode URL with
+// `apps.connections.open`, and connects to it.
+func (a *SlackSocket) dial() (*websocket.Conn, error) {
+	req, err := http.NewRequest("POST", a.apiURL+"apps.connections.open", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+a.config.AppToken)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	var open struct {
+		Ok    bool   `json:"ok"`
+		Error string `json:"error"`
+		URL   string `json:"url"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&open); err != nil {
+		return nil, err
+	}
+	if !open.Ok {
+		return nil, fmt.Errorf("apps.connections.open: %s", open.Error)
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial(open.URL, nil)
+	return conn, err
+}
+
+// readLoop acknowledges and translates envelopes until the connection
+// drops, then reconnects unless `Disconnect()` was called.
+func (a *SlackSocket) readLoop(conn *websocket.Conn) {
+	for {
+		var envelope socketEnvelope
+		if err := conn.ReadJSON(&envelope); err != nil {
+			slackLogger.Error("Socket Mode read error", "err", err)
+			break
+		}
+
+		if envelope.EnvelopeID != "" {
+			if err := conn.WriteJSON(socketAck{EnvelopeID: envelope.EnvelopeID}); err != nil {
+				slackLogger.Error("Socket Mode ack error", "err", err)
+			}
+		}
+
+		if envelope.Type == "disconnect" {
+			slackLogger.Info("Socket Mode disconnect requested", "reason", envelope.Reason)
+			break
+		}
+
+		if event := a.translateEnvelope(&envelope); event != nil {
+			a.events <- event
+		}
+	}
+
+	conn.Close()
+	a.reconnect(conn)
+}
+
+func (a *SlackSocket) reconnect(old *websocket.Conn) {
+	for attempt := 1; ; attempt++ {
+		a.mu.Lock()
+		if a.closed || a.conn != old {
+			a.mu.Unlock()
+			return
+		}
+		a.mu.Unlock()
+
+		conn, err := a.dial()
+		if err == nil {
+			a.mu.Lock()
+			a.conn = conn
+			a.mu.Unlock()
+			go a.readLoop(conn)
+			return
+		}
+
+		slackLogger.Error("Socket Mode reconnection failed", "err", err)
+		if attempt > 5 {
+			attempt = 5
+		}
+		time.Sleep(time.Duration(attempt) * 3 * time.Second)
+	}
+}
+
+func (a *SlackSocket) translateEnvelope(envelope *socketEnvelope) Event {
+	switch envelope.Type {
+	case "hello":
+		slackLogger.Debug("Got a HELLO from Socket Mode")
+
+	case "events_api":
+		var callback slackEventsEnvelope
+		if err := json.Unmarshal(envelope.Payload, &callback); err != nil {
+			slackLogger.Error("Error parsing Socket Mode event", "err", err)
+			return nil
+		}
+		event, err := parseSlackInnerEvent(callback.Event)
+		if err != nil {
+			slackLogger.Error("Error parsing Slack event", "err", err)
+			return nil
+		}
+		return translateSlackEvent(event)
+
+	case "slash_commands":
+		var command slack.SlashCommand
+		if err := json.Unmarshal(envelope.Payload, &command); err != nil {
+			slackLogger.Error("Error parsing slash command", "err", err)
+			return nil
+		}
+		return &SlashCommandEvent{Command: command}
+
+	case "interactive":
+		var callback slack.InteractionCallback
+		if err := json.Unmarshal(envelope.Payload, &callback); err != nil {
+			slackLogger.Error("Error parsing interactive payload", "err", err)
+			return nil
+		}
+		return &InteractionEvent{Callback: callback}
+
+	default:
+		slackLogger.Warn("Unexpected Socket Mode envelope", "type", envelope.Type)
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package plotbot
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const socketEventsAPIEnvelope = `{
+  "envelope_id": "env-1",
+  "type": "events_api",
+  "accepts_response_payload": false,
+  "payload": {
+    "type": "event_callback",
+    "event": {
+      "type": "message",
+      "channel": "C1",
+      "user": "U1",
+      "text": "<@UBOT> lock deployment",
+      "ts": "1355517523.000005"
+    }
+  }
+}`
+
+const socketSlashCommandEnvelope = `{
+  "envelope_id": "env-2",
+  "type": "slash_commands",
+  "accepts_response_payload": true,
+  "payload": {
+    "command": "/plotbot",
+    "text": "deploy to stage",
+    "channel_id": "C1",
+    "user_id": "U1"
+  }
+}`
+
+func newSocketModeServer(t *testing.T, acks chan string) *httptest.Server {
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+
+	mux.HandleFunc("/auth.test", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"ok": true, "user_id": "UBOT", "user": "plotbot"}`)
+	})
+	mux.HandleFunc("/apps.connections.open", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer xapp-test" {
+			fmt.Fprint(w, `{"ok": false, "error": "invalid_auth"}`)
+			return
+		}
+		url := "ws" + strings.TrimPrefix(server.URL, "http") + "/link"
+		fmt.Fprintf(w, `{"ok": true, "url": %q}`, url)
+	})
+	mux.HandleFunc("/link", func(w http.ResponseWriter, r *http.Request) {
+		upgrader := websocket.Upgrader{}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+
+		conn.WriteMessage(websocket.TextMessage, []byte(`{"type": "hello"}`))
+		for _, envelope := range []string{socketEventsAPIEnvelope, socketSlashCommandEnvelope} {
+			conn.WriteMessage(websocket.TextMessage, []byte(envelope))
+
+			var ack socketAck
+			if err := conn.ReadJSON(&ack); err != nil {
+				return
+			}
+			acks <- ack.EnvelopeID
+		}
+
+		// Wait for the client to hang up.
+		conn.ReadMessage()
+	})
+
+	return server
+}
+
+func TestSlackSocketEnvelopes(t *testing.T) {
+	acks := make(chan string, 10)
+	server := newSocketModeServer(t, acks)
+	defer server.Close()
+
+	adapter := newSlackSocket(SlackConfig{AppToken: "xapp-test"}, server.URL+"/")
+	if err := adapter.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer adapter.Disconnect()
+
+	events := make([]Event, 0)
+	timeout := time.After(2 * time.Second)
+	for len(events) < 3 {
+		select {
+		case event := <-adapter.Events():
+			events = append(events, event)
+		case <-timeout:
+			t.Fatalf("expected 3 events, got %d", len(events))
+		}
+	}
+
+	connected, ok := events[0].(*ConnectedEvent)
+	if !ok || connected.Myself.ID != "UBOT" {
+		t.Errorf("expected a ConnectedEvent for UBOT, got %#v", events[0])
+	}
+
+	message, ok := events[1].(*MessageEvent)
+	if !ok || message.Msg.Text != "<@UBOT> lock deployment" {
+		t.Errorf("expected the message event, got %#v", events[1])
+	}
+
+	command, ok := events[2].(*SlashCommandEvent)
+	if !ok || command.Command.Text != "deploy to stage" || command.Command.UserID != "U1" {
+		t.Errorf("expected the slash command, got %#v", events[2])
+	}
+
+	for _, expected := range []string{"env-1", "env-2"} {
+		select {
+		case id := <-acks:
+			if id != expected {
+				t.Errorf("expected ack for %s, got %s", expected, id)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("envelope %s was not acknowledged", expected)
+		}
+	}
+}
+
+func TestSlackSocketRequiresAppToken(t *testing.T) {
+	adapter := NewSlackSocket(SlackConfig{ApiToken: "xoxb-test"})
+	if err := adapter.Connect(); err == nil {
+		t.Error("expected an error without app_token")
+	}
+}
@@ -39,6 +39,17 @@ func extractSectionAndText(input string, res [][]int) []sectionMatch {
 	return out
 }
 
+// sectionsOf returns the text of each section found in `input`, by
+// lower-cased section name.
+func sectionsOf(input string) map[string]string {
+	sections := make(map[string]string)
+	res := sectionRegexp.FindAllStringSubmatchIndex(input, -1)
+	for _, section := range extractSectionAndText(input, res) {
+		sections[strings.ToLower(section.name)] = section.text
+	}
+	return sections
+}
+
 func (standup *Standup) TriggerReminders(msg *plotbot.Message, section string) {
 	standup.sectionUpdates <- sectionUpdate{section, msg}
 }
@@ -71,7 +82,7 @@ func (standup *Standup) manageUpdatesInteraction() {
 				progress.sectionsDone[update.section] = true
 				numDone := len(progress.sectionsDone)
 				if numDone == 3 {
-					standup.bot.ReplyMention(update.msg, "got it!")
+					standup.sender.ReplyMention(update.msg, "got it!")
 					delete(userProgressMap, update.msg.FromUser.Profile.Email)
 				} else {
 					progress.cancelTimer = make(chan bool)
@@ -109,7 +120,7 @@ func (standup *Standup) manageUpdatesInteraction() {
 			remain := strings.Join(remains, " or ")
 
 			if remain != "" {
-				standup.bot.ReplyMention(msg, fmt.Sprintf("what about %s ? Could you please copy your message, paste it back, change it to fix this and sent it again ? (I didn't have my coffee this morning) ", remain))
+				standup.sender.ReplyInThread(msg, msg.AtMentionIfPublic(fmt.Sprintf("what about %s ? Could you please copy your message, paste it back, change it to fix this and sent it again ? (I didn't have my coffee this morning) ", remain)))
 			}
 		}
 	}
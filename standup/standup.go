@@ -1,85 +1,122 @@
 package standup
 
 import (
-	"encoding/json"
-	"log"
 	"time"
 
 	"github.com/slack-go/slack"
 	"github.com/plotly/plotbot"
 	"github.com/plotly/plotbot/util"
-	levelutil "github.com/syndtr/goleveldb/leveldb/util"
 )
 
 type Standup struct {
 	bot            *plotbot.Bot
+	sender         plotbot.BotLike
+	store          plotbot.Store
+	logger         *plotbot.Logger
 	sectionUpdates chan sectionUpdate
+	commands       *plotbot.Router
 }
 
 const TODAY = 0
 const WEEKAGO = -6 // [0,-6] == 7 days
 
 func init() {
-	plotbot.RegisterPlugin(&Standup{})
+	plotbot.RegisterPlugin("standup", &Standup{})
+}
+
+// Migrations records the layout of the standup keys, see standupKey.
+// Changing it needs a new migration rewriting the stored standups.
+func (standup *Standup) Migrations() []plotbot.Migration {
+	return []plotbot.Migration{
+		{Version: 1, Description: "standups keyed by stand:<unix date>:<email>"},
+		{
+			Version:     2,
+			Description: "standup dates at midnight UTC instead of server time",
+			Migrate: func(store plotbot.Store) error {
+				return rekeyStandups(store, time.Local)
+			},
+		},
+	}
+}
+
+func (standup *Standup) InitStore(store plotbot.Store) {
+	standup.store = store
+}
+
+func (standup *Standup) InitLogger(logger *plotbot.Logger) {
+	standup.logger = logger
 }
 
 func (standup *Standup) InitPlugin(bot *plotbot.Bot) {
 	standup.bot = bot
+	standup.sender = bot.PluginBot()
 	standup.sectionUpdates = make(chan sectionUpdate, 15)
 
 	go standup.manageUpdatesInteraction()
 
+	standup.commands = plotbot.NewRouter()
+	standup.commands.Add(&plotbot.Command{
+		Usage:       "standup report [<period...>]",
+		Description: "<period> is [last | past] [n] [days | weeks], 7 days by default.  Say \"my standup report\" for yours only.",
+		Examples: []string{
+			"standup report",
+			"give me my standup report for the last 3 days",
+		},
+		HandlerFunc: standup.reportCommand,
+	})
+
 	bot.ListenFor(&plotbot.Conversation{
-		HandlerFunc: standup.ChatHandler,
+		HandlerFunc:   standup.ChatHandler,
+		MatchEditions: true,
 	})
 }
 
 func (standup *Standup) ChatHandler(conv *plotbot.Conversation, msg *plotbot.Message) {
+	if msg.IsEdition || msg.IsDeletion {
+		standup.handleEdition(msg)
+		return
+	}
+
 	res := sectionRegexp.FindAllStringSubmatchIndex(msg.Text, -1)
 	if res != nil {
 		for _, section := range extractSectionAndText(msg.Text, res) {
 			standup.TriggerReminders(msg, section.name)
 			err := standup.StoreLine(msg, section.name, section.text)
 			if err != nil {
-				log.Println(err)
-			}
-		}
-	} else if msg.MentionsMe && msg.Contains("standup report") {
-		daysAgo := util.GetDaysFromQuery(msg.Text)
-		smap, err := standup.getRange(getStandupDate(-daysAgo), getStandupDate(TODAY))
-		if err != nil {
-			log.Println(err)
-			conv.Reply(msg, standup.bot.WithMood("Sorry, could not retrieve your report...",
-				"I am the eggman and the walrus ate your report - Fzaow!"))
-		} else {
-			if msg.Contains(" my ") {
-				conv.Reply(msg, "```"+smap.filterByEmail(msg.FromUser.Profile.Email).String()+"```")
-			} else {
-				conv.Reply(msg, "```"+smap.String()+"```")
+				standup.logger.Error("Could not store the standup", "user", msg.User, "err", err)
 			}
 		}
+	} else if msg.MentionsMe {
+		standup.commands.Handle(conv, msg)
 	}
 }
 
-func (standup *Standup) getRange(from, to standupDate) (standupMap, error) {
-	db := standup.bot.DB
-	// Range is [Start, Limit) - ie, limit is not inclusive, so we bump date one next.
-	srange := levelutil.Range{
-		Start: standupKey{date: from}.key(),
-		Limit: standupKey{date: to.next()}.key(),
+func (standup *Standup) reportCommand(conv *plotbot.Conversation, msg *plotbot.Message, args plotbot.CommandArgs) {
+	daysAgo := util.GetDaysFromQuery(args.String("period"))
+	loc := standup.bot.Location(msg.FromUser)
+	smap, err := standup.getRange(getStandupDate(loc, -daysAgo), getStandupDate(loc, TODAY))
+	if err != nil {
+		standup.logger.Error("Could not retrieve the standups", "err", err)
+		conv.Reply(msg, standup.bot.WithMood("Sorry, could not retrieve your report...",
+			"I am the eggman and the walrus ate your report - Fzaow!"))
+	} else {
+		if msg.Contains(" my ") {
+			smap = smap.filterByEmail(msg.FromUser.Profile.Email)
+		}
+		conv.ReplyRich(msg, smap.RichMessage())
 	}
-	iter := db.NewIterator(&srange, nil)
+}
 
+func (standup *Standup) getRange(from, to standupDate) (standupMap, error) {
 	// keep a map of users so we don't ask plotbot to grab users from the chatapp
 	// that we have already loaded.
 	seenUsers := make(map[string]standupUser)
 
 	smap := make(standupMap)
 
-	for iter.Next() {
-		// Remember that the contents of the returned slice should not be modified, and
-		// only valid until the next call to Next.
-		key := standupKeyFromBytes(iter.Key())
+	// Range is [Start, Limit) - ie, limit is not inclusive, so we bump date one next.
+	err := standup.store.Range(standupKey{date: from}.key(), standupKey{date: to.next()}.key(), func(entry plotbot.StoreEntry) error {
+		key := standupKeyFromString(entry.Key)
 		email := key.email
 		standupDate := key.date
 
@@ -101,52 +138,61 @@ func (standup *Standup) getRange(from, to standupDate) (standupMap, error) {
 			// store a copy of user (with blank data) inside map for later lookup
 			seenUsers[email] = user
 		}
-		data := iter.Value()
-		err := json.Unmarshal(data, &user.data)
-		if err != nil {
-			return smap, err
+		if err := entry.Decode(&user.data); err != nil {
+			return err
 		}
 
 		smap[standupDate] = append(smap[standupDate], user)
-	}
-
-	iter.Release()
-	return smap, iter.Error()
+		return nil
+	})
+	return smap, err
 }
 
 func (standup *Standup) get(u standupUser, sd standupDate) (stand standupData, err error) {
 	key := standupKey{sd, u.Profile.Email}.key()
-
-	db := standup.bot.DB
-	data, err := db.Get(key, nil)
-	if err != nil {
-		return
-	}
-
-	err = json.Unmarshal(data, &stand)
+	err = standup.store.Get(key, &stand)
 	return
 }
 
-func (standup *Standup) put(u standupUser, sd standupDate) (err error) {
-	db := standup.bot.DB
+func (standup *Standup) put(u standupUser, sd standupDate) error {
+	key := standupKey{sd, u.Profile.Email}.key()
+	return standup.store.Put(key, u.data)
+}
 
-	jdata, err := json.Marshal(u.data)
-	if err != nil {
+// handleEdition updates the standup of the day an edited or deleted
+// message was originally posted, in its author's time zone: the sections of the new text are
+// stored, and the sections only found in the previous text are
+// cleared.  Reminders are left alone, as they were triggered by the
+// original message.
+func (standup *Standup) handleEdition(msg *plotbot.Message) {
+	if msg.FromUser == nil {
 		return
 	}
 
-	key := standupKey{sd, u.Profile.Email}.key()
-	err = db.Put(key, jdata, nil)
-	return
+	sections := sectionsOf(msg.Text)
+	for name := range sectionsOf(msg.PreviousText) {
+		if _, ok := sections[name]; !ok {
+			sections[name] = ""
+		}
+	}
+
+	standupDate := standupDateOf(msg.Time().In(standup.bot.Location(msg.FromUser)))
+	for name, text := range sections {
+		if err := standup.storeLine(standupDate, msg, name, text); err != nil {
+			standup.logger.Error("Could not store the edited standup", "user", msg.User, "err", err)
+		}
+	}
 }
 
 func (standup *Standup) StoreLine(msg *plotbot.Message, section string, line string) error {
+	return standup.storeLine(getStandupDate(standup.bot.Location(msg.FromUser), TODAY), msg, section, line)
+}
 
-	standupDate := getStandupDate(TODAY)
+func (standup *Standup) storeLine(standupDate standupDate, msg *plotbot.Message, section string, line string) error {
 	user := standupUser{msg.FromUser, standupData{}}
 	data, err := standup.get(user, standupDate)
 	if err != nil {
-		log.Printf("Standup data for %s does not exist - using fresh\n", user.Name)
+		standup.logger.Debug("Standup data does not exist - using fresh", "user", user.Name)
 	}
 
 	// update the userdata with data from the database
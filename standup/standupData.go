@@ -5,6 +5,8 @@ import (
 	"sort"
 	"strings"
 	"time"
+
+	"github.com/plotly/plotbot"
 )
 
 type standupData struct {
@@ -92,26 +94,9 @@ func (sm standupMap) String() (str string) {
 	sorted := sm.Keys()
 	sort.Sort(sorted)
 
-	// first pass detects if there are multiple users or a single user (email is used as unique ID)
-	seenUsers := make(map[string]standupUser)
-	var lastUser standupUser
-	singleUserReport := false
-
-	for _, sdate := range sorted {
-		users := sm[sdate]
-		for _, user := range users {
-			seenUsers[user.Profile.Email] = user
-			lastUser = user
-		}
-	}
-
 	// write header depending on single or multiple user case
-	if len(seenUsers) == 1 {
-		singleUserReport = true
-		str += fmt.Sprintf("Standup Report for %s\n", lastUser.Name)
-	} else {
-		str += "Standup Report\n"
-	}
+	singleUserReport := sm.singleUser() != nil
+	str += sm.header() + "\n"
 
 	// second pass stringifies the body and only prints user name if multiple users exist
 	for _, sdate := range sorted {
@@ -131,3 +116,60 @@ func (sm standupMap) String() (str string) {
 	str = strings.TrimRight(str, "\n") + "\n"
 	return
 }
+
+// singleUser returns the only user in the report, if there is only one
+// (email is used as unique ID).
+func (sm standupMap) singleUser() *standupUser {
+	seenUsers := make(map[string]standupUser)
+	var lastUser standupUser
+	for _, users := range sm {
+		for _, user := range users {
+			seenUsers[user.Profile.Email] = user
+			lastUser = user
+		}
+	}
+	if len(seenUsers) != 1 {
+		return nil
+	}
+	return &lastUser
+}
+
+func (sm standupMap) header() string {
+	if user := sm.singleUser(); user != nil {
+		return fmt.Sprintf("Standup Report for %s", user.Name)
+	}
+	return "Standup Report"
+}
+
+// RichMessage renders the report as a structured message, with one
+// section per day and the three answers of each user side by side.
+// `String()` is used as fallback text.
+func (sm standupMap) RichMessage() *plotbot.RichMessage {
+	sorted := sm.Keys()
+	sort.Sort(sorted)
+	singleUserReport := sm.singleUser() != nil
+
+	msg := plotbot.NewRichMessage(sm.String()).Section("*" + sm.header() + "*")
+	for _, sdate := range sorted {
+		msg.Divider().Section(fmt.Sprintf("*%s*", sdate.String()))
+		for _, user := range sm[sdate] {
+			if !singleUserReport {
+				msg.Context(user.Name)
+			}
+			msg.Fields(
+				"*Yesterday*\n"+orDash(user.data.Yesterday),
+				"*Today*\n"+orDash(user.data.Today),
+				"*Blocking*\n"+orDash(user.data.Blocking),
+			)
+		}
+	}
+	return msg
+}
+
+// orDash avoids empty texts, which Slack rejects in blocks.
+func orDash(s string) string {
+	if strings.TrimSpace(s) == "" {
+		return "-"
+	}
+	return s
+}
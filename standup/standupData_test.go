@@ -124,3 +124,23 @@ Blocking: 1
 	}
 
 }
+
+func TestMapRichMessage(t *testing.T) {
+	sm := getTestStandupMap()
+
+	msg := sm.RichMessage()
+	if msg.Text != sm.String() {
+		t.Errorf("expected the fallback text to be the plain report")
+	}
+
+	// header, then for each of the 2 days: divider, date, and a name and
+	// answers for each of the 2 users.
+	if len(msg.Blocks) != 1+2*(2+2*2) {
+		t.Fatalf("unexpected number of blocks: %d", len(msg.Blocks))
+	}
+
+	single := sm.filterByEmail("B@test.ly").RichMessage()
+	if len(single.Blocks) != 1+2*(2+1) {
+		t.Fatalf("unexpected number of blocks for a single user: %d", len(single.Blocks))
+	}
+}
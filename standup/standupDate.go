@@ -11,40 +11,44 @@ type standupDate struct {
 	day   int
 }
 
-func getStandupDate(daysFromToday int) standupDate {
-	d := time.Now().Add(time.Duration(daysFromToday) * 24 * time.Hour)
+// getStandupDate returns the date `daysFromToday` days from today, in
+// the time zone `loc`.
+func getStandupDate(loc *time.Location, daysFromToday int) standupDate {
+	return standupDateOf(time.Now().In(loc).AddDate(0, 0, daysFromToday))
+}
+
+// standupDateOf returns the date of `t`, in its location.
+func standupDateOf(t time.Time) standupDate {
 	return standupDate{
-		year:  d.Year(),
-		month: d.Month(),
-		day:   d.Day(),
+		year:  t.Year(),
+		month: t.Month(),
+		day:   t.Day(),
 	}
 }
 
+// unixToStandupDate is the reverse of `Unix()`.
 func unixToStandupDate(unix int64) standupDate {
-	d := time.Unix(unix, 0)
-	return standupDate{
-		year:  d.Year(),
-		month: d.Month(),
-		day:   d.Day(),
-	}
+	return standupDateOf(time.Unix(unix, 0).UTC())
 }
 
 func (sd standupDate) next() standupDate {
-	current := time.Date(sd.year, sd.month, sd.day, 0, 0, 0, 0, time.Local)
-	next := current.Add(24 * time.Hour)
-	return standupDate{
-		year:  next.Year(),
-		month: next.Month(),
-		day:   next.Day(),
-	}
+	return standupDateOf(sd.time().AddDate(0, 0, 1))
+}
+
+// time returns midnight UTC on the date, which stands for the date
+// whatever the time zone of the users.
+func (sd standupDate) time() time.Time {
+	return time.Date(sd.year, sd.month, sd.day, 0, 0, 0, 0, time.UTC)
 }
 
 func (sd standupDate) String() string {
 	return strconv.Itoa(sd.year) + "-" + sd.month.String() + "-" + strconv.Itoa(sd.day)
 }
 
+// Unix returns the Unix time of midnight UTC on the date, used in the
+// keys of standups.
 func (sd standupDate) Unix() int64 {
-	return time.Date(sd.year, sd.month, sd.day, 0, 0, 0, 0, time.Local).Unix()
+	return sd.time().Unix()
 }
 
 func (sd standupDate) toUnixUTCString() string {
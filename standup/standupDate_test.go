@@ -8,9 +8,9 @@ import (
 
 func TestNextDate(t *testing.T) {
 
-	tomorrow := time.Now().Add(24 * time.Hour)
+	tomorrow := time.Now().AddDate(0, 0, 1)
 
-	d := getStandupDate(0).next()
+	d := getStandupDate(time.Local, 0).next()
 
 	if d.year != tomorrow.Year() {
 		t.Error("expected", d.year, "to be", tomorrow.Year())
@@ -28,7 +28,7 @@ func TestNextDate(t *testing.T) {
 
 func TestUnixAndBack(t *testing.T) {
 
-	d := getStandupDate(0)
+	d := getStandupDate(time.Local, 0)
 	unixStr := d.toUnixUTCString()
 	unix, err := strconv.ParseInt(unixStr, 10, 64)
 	if err != nil {
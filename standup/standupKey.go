@@ -1,30 +1,35 @@
 package standup
 
 import (
-	"bytes"
 	"strconv"
+	"strings"
+	"time"
+
+	"github.com/plotly/plotbot"
 )
 
-const standupPrefix = "standup:stand"
+// standupPrefix starts the keys of standups, within the plugin's
+// "standup" Store namespace.
+const standupPrefix = "stand"
 
 type standupKey struct {
 	date  standupDate
 	email string
 }
 
-func standupKeyFromBytes(key []byte) standupKey {
-	// standup:stand:unix:email
+func standupKeyFromString(key string) standupKey {
+	// stand:unix:email
 
-	fields := bytes.Split(key, []byte(":"))
+	fields := strings.Split(key, ":")
 
 	skey := standupKey{}
 
-	if len(fields) == 4 {
-		skey.email = string(fields[3])
+	if len(fields) == 3 {
+		skey.email = fields[2]
 	}
 
-	if len(fields) > 2 {
-		unixStr := string(fields[2])
+	if len(fields) > 1 {
+		unixStr := fields[1]
 		unix, err := strconv.ParseInt(unixStr, 10, 64)
 		if err != nil {
 			skey.date = standupDate{}
@@ -36,7 +41,7 @@ func standupKeyFromBytes(key []byte) standupKey {
 
 }
 
-func (k standupKey) key() []byte {
+func (k standupKey) key() string {
 	keystr := standupPrefix + ":" + k.date.toUnixUTCString()
 
 	// partial key construction is useful as we can use this to grab all users
@@ -45,5 +50,33 @@ func (k standupKey) key() []byte {
 	if k.email != "" {
 		keystr += ":" + k.email
 	}
-	return []byte(keystr)
+	return keystr
+}
+
+// rekeyStandups moves the standups keyed by the Unix time of midnight
+// in `loc`, as they were before schema version 2, to the keys of their
+// date at midnight UTC.
+func rekeyStandups(store plotbot.Store, loc *time.Location) error {
+	batch := plotbot.NewStoreBatch()
+	err := store.Iterate(standupPrefix+":", func(entry plotbot.StoreEntry) error {
+		fields := strings.Split(entry.Key, ":")
+		if len(fields) != 3 {
+			return nil
+		}
+		unix, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return nil
+		}
+
+		key := standupKey{standupDateOf(time.Unix(unix, 0).In(loc)), fields[2]}.key()
+		if key != entry.Key {
+			batch.Delete(entry.Key)
+			batch.Put(key, entry.Raw())
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return store.Write(batch)
 }
@@ -10,11 +10,11 @@ func TestKeyFromBytes(t *testing.T) {
 	now := time.Now()
 
 	key := standupKey{
-		date:  getStandupDate(0),
+		date:  getStandupDate(time.Local, 0),
 		email: "bot@bot.ly",
 	}
 
-	key2 := standupKeyFromBytes(key.key())
+	key2 := standupKeyFromString(key.key())
 
 	if key2.email != "bot@bot.ly" {
 		t.Error("expected email to be 'bot@bot.ly' instead got", key2.email)
@@ -1,6 +1,14 @@
 package standup
 
-import "testing"
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/plotly/plotbot"
+	"github.com/slack-go/slack"
+)
 
 func TestRegexpMatch(t *testing.T) {
 	input := `!blocking this is good
@@ -20,3 +28,96 @@ func TestRegexpMatch(t *testing.T) {
 		t.Error("res[1].text should be 'thank you'")
 	}
 }
+
+func TestEditionUpdatesStandup(t *testing.T) {
+	standup := &Standup{bot: &plotbot.Bot{}, store: plotbot.NewMemoryStore()}
+
+	user := &slack.User{Name: "hodor", Profile: slack.UserProfile{Email: "hodor@test.ly"}}
+	posted := time.Now().Add(-48 * time.Hour)
+	msg := &plotbot.Message{
+		Msg: &slack.Msg{
+			Text:      "!today fix bugs\n!blocking nothing",
+			Timestamp: fmt.Sprintf("%d.000005", posted.Unix()),
+		},
+		FromUser:     user,
+		IsEdition:    true,
+		PreviousText: "!today fix bug\n!yesterday nothing much\n!blocking none",
+	}
+	standup.ChatHandler(nil, msg)
+
+	data, err := standup.get(standupUser{user, standupData{}}, standupDateOf(posted.In(time.Local)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data.Today != "fix bugs" || data.Blocking != "nothing" || data.Yesterday != "" {
+		t.Errorf("unexpected standup after edition %#v", data)
+	}
+
+	if _, err := standup.get(standupUser{user, standupData{}}, getStandupDate(time.Local, TODAY)); err == nil {
+		t.Error("expected the edition not to be stored today")
+	}
+
+	msg.IsEdition = false
+	msg.IsDeletion = true
+	msg.PreviousText = msg.Text
+	msg.Text = ""
+	standup.ChatHandler(nil, msg)
+
+	data, _ = standup.get(standupUser{user, standupData{}}, standupDateOf(posted.In(time.Local)))
+	if data.Today != "" || data.Blocking != "" {
+		t.Errorf("expected the deletion to clear the standup, got %#v", data)
+	}
+}
+
+func TestStandupDateInUserZone(t *testing.T) {
+	standup := &Standup{bot: &plotbot.Bot{}, store: plotbot.NewMemoryStore()}
+
+	// May 2 in Auckland, still May 1 in Honolulu.  Their keys are
+	// midnight UTC on their date.
+	posted := time.Date(2020, 5, 1, 20, 0, 0, 0, time.UTC)
+	for _, user := range []*slack.User{
+		{Name: "kiri", TZ: "Pacific/Auckland", Profile: slack.UserProfile{Email: "kiri@test.ly"}},
+		{Name: "kai", TZ: "Pacific/Honolulu", Profile: slack.UserProfile{Email: "kai@test.ly"}},
+	} {
+		standup.ChatHandler(nil, &plotbot.Message{
+			Msg: &slack.Msg{
+				Text:      "!today surf",
+				Timestamp: fmt.Sprintf("%d.000005", posted.Unix()),
+			},
+			FromUser:  user,
+			IsEdition: true,
+		})
+	}
+
+	for _, expected := range []string{"stand:1588291200:kai@test.ly", "stand:1588377600:kiri@test.ly"} {
+		if err := standup.store.Get(expected, &standupData{}); err != nil {
+			t.Errorf("expected each standup on the date of its author, %s: %v", expected, err)
+		}
+	}
+}
+
+func TestRekeyStandups(t *testing.T) {
+	montreal, _ := time.LoadLocation("America/Montreal")
+	store := plotbot.NewMemoryStore()
+	// Midnight in Montreal on May 18 and 19, 2015.
+	store.Put("stand:1431921600:a@test.ly", standupData{Today: "0"})
+	store.Put("stand:1432008000:a@test.ly", standupData{Today: "1"})
+	store.Put("other", 1)
+
+	if err := rekeyStandups(store, montreal); err != nil {
+		t.Fatal(err)
+	}
+
+	keys := make([]string, 0)
+	store.Iterate("", func(entry plotbot.StoreEntry) error {
+		keys = append(keys, entry.Key)
+		return nil
+	})
+	if strings.Join(keys, ",") != "other,stand:1431907200:a@test.ly,stand:1431993600:a@test.ly" {
+		t.Errorf("expected the standups to be keyed by midnight UTC, got %v", keys)
+	}
+	var data standupData
+	if err := store.Get("stand:1431993600:a@test.ly", &data); err != nil || data.Today != "1" {
+		t.Errorf("expected the standup to be moved, got %#v, %v", data, err)
+	}
+}
@@ -0,0 +1,399 @@
+package plotbot
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// ErrNotFound is returned by `Store.Get()` for missing or expired keys.
+var ErrNotFound = errors.New("not found")
+
+// Store keeps JSON values by key, within a namespace.  Plugins
+// implementing PluginStoreInitializer get a Store of their own, whose
+// keys are prefixed with their name, as in "standup:<key>".
+//
+// Keys are ordered as strings, so `Range()` and `Iterate()` walk them in
+// that order.  Values are encoded with encoding/json.
+type Store interface {
+	// Get decodes the value of `key` into `value`, or returns
+	// ErrNotFound.
+	Get(key string, value interface{}) error
+	// Put stores `value` under `key`.
+	Put(key string, value interface{}) error
+	// PutTTL stores `value` under `key`, until `ttl` has elapsed.
+	PutTTL(key string, value interface{}, ttl time.Duration) error
+	// Delete removes a key.  Deleting a missing key is not an error.
+	Delete(key string) error
+
+	// Iterate calls `fn` with the entries whose key starts with
+	// `prefix`, in order.  An error returned by `fn` stops the
+	// iteration, and is returned.
+	Iterate(prefix string, fn func(entry StoreEntry) error) error
+	// Range is Iterate for the keys from `start` included to `limit`
+	// excluded.  An empty limit means no limit.
+	Range(start, limit string, fn func(entry StoreEntry) error) error
+
+	// Write applies all the changes of a batch, or none of them.
+	Write(batch *StoreBatch) error
+
+	// Namespace returns a Store whose keys are prefixed with
+	// `name` and ":" within this one.
+	Namespace(name string) Store
+
+	// DeleteExpired removes the values whose TTL has elapsed, and
+	// returns how many there were.  Expired values are never returned,
+	// this only reclaims their space.
+	DeleteExpired() (int, error)
+}
+
+// PluginStoreInitializer is implemented by plugins keeping data.  They
+// get a Store namespaced with their name before `InitPlugin()`.
+type PluginStoreInitializer interface {
+	InitStore(store Store)
+}
+
+// StoreEntry is a key and its value, as found by `Store.Iterate()`.
+type StoreEntry struct {
+	// Key is relative to the Store's namespace.
+	Key string
+	// Expires is when the value expires, zero if it doesn't.
+	Expires time.Time
+	value   []byte
+}
+
+// Decode decodes the value of the entry into `value`.
+func (entry StoreEntry) Decode(value interface{}) error {
+	return json.Unmarshal(entry.value, value)
+}
+
+// Raw returns the JSON value of the entry.
+func (entry StoreEntry) Raw() json.RawMessage {
+	return entry.value
+}
+
+// StoreBatch collects changes to write at once with `Store.Write()`.
+// Its keys are relative to the Store it is written to.
+type StoreBatch struct {
+	ops []storeOp
+	err error
+}
+
+type storeOp struct {
+	key   string
+	value []byte
+	// delete is set for deletions, where value is nil.
+	delete bool
+}
+
+func NewStoreBatch() *StoreBatch {
+	return &StoreBatch{}
+}
+
+// Put adds the storage of a value to the batch.
+func (batch *StoreBatch) Put(key string, value interface{}) {
+	batch.put(key, value, 0)
+}
+
+// PutTTL adds the storage of an expiring value to the batch.
+func (batch *StoreBatch) PutTTL(key string, value interface{}, ttl time.Duration) {
+	batch.put(key, value, ttl)
+}
+
+// Delete adds the deletion of a key to the batch.
+func (batch *StoreBatch) Delete(key string) {
+	batch.ops = append(batch.ops, storeOp{key: key, delete: true})
+}
+
+// Len returns the number of changes in the batch.
+func (batch *StoreBatch) Len() int {
+	return len(batch.ops)
+}
+
+func (batch *StoreBatch) put(key string, value interface{}, ttl time.Duration) {
+	encoded, err := encodeStoreValue(value, ttl)
+	if err != nil {
+		if batch.err == nil {
+			batch.err = err
+		}
+		return
+	}
+	batch.ops = append(batch.ops, storeOp{key: key, value: encoded})
+}
+
+// storeNow is the clock of expiring values, replaced in tests.
+var storeNow = time.Now
+
+// Values with a TTL are stored as expiringMark, followed by their
+// expiration as big-endian Unix nanoseconds, and their JSON.  JSON
+// never starts with that byte, so plain values are stored as is, and
+// stay readable without a Store.
+const expiringMark = 0
+
+func encodeStoreValue(value interface{}, ttl time.Duration) ([]byte, error) {
+	encoded, err := json.Marshal(value)
+	if err != nil || ttl == 0 {
+		return encoded, err
+	}
+
+	header := make([]byte, 9)
+	header[0] = expiringMark
+	binary.BigEndian.PutUint64(header[1:], uint64(storeNow().Add(ttl).UnixNano()))
+	return append(header, encoded...), nil
+}
+
+// decodeStoreValue returns the JSON of a stored value, and when it
+// expires, zero if it doesn't.
+func decodeStoreValue(stored []byte) ([]byte, time.Time) {
+	if len(stored) < 9 || stored[0] != expiringMark {
+		return stored, time.Time{}
+	}
+	expiration := int64(binary.BigEndian.Uint64(stored[1:9]))
+	return stored[9:], time.Unix(0, expiration)
+}
+
+func isExpired(expires time.Time) bool {
+	return !expires.IsZero() && !storeNow().Before(expires)
+}
+
+// storeBackend is the raw key/value storage under a Store.
+type storeBackend interface {
+	get(key []byte) ([]byte, error)
+	write(ops []storeOp) error
+	// iterate calls fn with the keys in [start, limit), where a nil
+	// limit means no limit.  The slices are only valid during the
+	// call.
+	iterate(start, limit []byte, fn func(key, value []byte) error) error
+}
+
+// NewLevelDBStore returns a Store over a LevelDB database, with no
+// namespace.
+func NewLevelDBStore(db *leveldb.DB) Store {
+	return &namespacedStore{backend: &levelDBBackend{db}}
+}
+
+// NewMemoryStore returns an empty Store kept in memory, for tests.
+func NewMemoryStore() Store {
+	return &namespacedStore{backend: &memoryBackend{values: make(map[string][]byte)}}
+}
+
+// namespacedStore implements Store over a backend, for the keys
+// starting with `prefix`.
+type namespacedStore struct {
+	backend storeBackend
+	prefix  string
+}
+
+func (store *namespacedStore) Get(key string, value interface{}) error {
+	stored, err := store.backend.get([]byte(store.prefix + key))
+	if err != nil {
+		return err
+	}
+	encoded, expires := decodeStoreValue(stored)
+	if isExpired(expires) {
+		return ErrNotFound
+	}
+	return json.Unmarshal(encoded, value)
+}
+
+func (store *namespacedStore) Put(key string, value interface{}) error {
+	return store.PutTTL(key, value, 0)
+}
+
+func (store *namespacedStore) PutTTL(key string, value interface{}, ttl time.Duration) error {
+	batch := NewStoreBatch()
+	batch.PutTTL(key, value, ttl)
+	return store.Write(batch)
+}
+
+func (store *namespacedStore) Delete(key string) error {
+	batch := NewStoreBatch()
+	batch.Delete(key)
+	return store.Write(batch)
+}
+
+func (store *namespacedStore) Iterate(prefix string, fn func(entry StoreEntry) error) error {
+	start := []byte(store.prefix + prefix)
+	return store.iterate(start, prefixLimit(start), fn)
+}
+
+func (store *namespacedStore) Range(start, limit string, fn func(entry StoreEntry) error) error {
+	rangeLimit := prefixLimit([]byte(store.prefix))
+	if limit != "" {
+		rangeLimit = []byte(store.prefix + limit)
+	}
+	return store.iterate([]byte(store.prefix+start), rangeLimit, fn)
+}
+
+func (store *namespacedStore) iterate(start, limit []byte, fn func(entry StoreEntry) error) error {
+	return store.backend.iterate(start, limit, func(key, value []byte) error {
+		encoded, expires := decodeStoreValue(value)
+		if isExpired(expires) {
+			return nil
+		}
+		return fn(StoreEntry{
+			Key:     string(key[len(store.prefix):]),
+			Expires: expires,
+			value:   append([]byte(nil), encoded...),
+		})
+	})
+}
+
+func (store *namespacedStore) Write(batch *StoreBatch) error {
+	if batch.err != nil {
+		return batch.err
+	}
+	ops := make([]storeOp, 0, len(batch.ops))
+	for _, op := range batch.ops {
+		op.key = store.prefix + op.key
+		ops = append(ops, op)
+	}
+	return store.backend.write(ops)
+}
+
+func (store *namespacedStore) Namespace(name string) Store {
+	return &namespacedStore{backend: store.backend, prefix: store.prefix + name + ":"}
+}
+
+func (store *namespacedStore) DeleteExpired() (int, error) {
+	prefix := []byte(store.prefix)
+	batch := make([]storeOp, 0)
+	err := store.backend.iterate(prefix, prefixLimit(prefix), func(key, value []byte) error {
+		if _, expires := decodeStoreValue(value); isExpired(expires) {
+			batch = append(batch, storeOp{key: string(key), delete: true})
+		}
+		return nil
+	})
+	if err != nil || len(batch) == 0 {
+		return 0, err
+	}
+	return len(batch), store.backend.write(batch)
+}
+
+// deleteExpiredEvery calls `DeleteExpired()` every `interval`, until
+// `ctx` is done.
+func deleteExpiredEvery(ctx context.Context, store Store, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			count, err := store.DeleteExpired()
+			if err != nil {
+				defaultLogger.Error("Error deleting expired values", "err", err)
+			} else if count != 0 {
+				defaultLogger.Info("Deleted expired values", "count", count)
+			}
+		}
+	}
+}
+
+// prefixLimit returns the first key after all those starting with
+// `prefix`, or nil when there is none.
+func prefixLimit(prefix []byte) []byte {
+	limit := append([]byte(nil), prefix...)
+	for i := len(limit) - 1; i >= 0; i-- {
+		if limit[i] < 0xff {
+			limit[i]++
+			return limit[:i+1]
+		}
+	}
+	return nil
+}
+
+type levelDBBackend struct {
+	db *leveldb.DB
+}
+
+func (backend *levelDBBackend) get(key []byte) ([]byte, error) {
+	value, err := backend.db.Get(key, nil)
+	if err == leveldb.ErrNotFound {
+		return nil, ErrNotFound
+	}
+	return value, err
+}
+
+func (backend *levelDBBackend) write(ops []storeOp) error {
+	batch := new(leveldb.Batch)
+	for _, op := range ops {
+		if op.delete {
+			batch.Delete([]byte(op.key))
+		} else {
+			batch.Put([]byte(op.key), op.value)
+		}
+	}
+	return backend.db.Write(batch, nil)
+}
+
+func (backend *levelDBBackend) iterate(start, limit []byte, fn func(key, value []byte) error) error {
+	iter := backend.db.NewIterator(&util.Range{Start: start, Limit: limit}, nil)
+	defer iter.Release()
+	for iter.Next() {
+		if err := fn(iter.Key(), iter.Value()); err != nil {
+			return err
+		}
+	}
+	return iter.Error()
+}
+
+type memoryBackend struct {
+	lock   sync.RWMutex
+	values map[string][]byte
+}
+
+func (backend *memoryBackend) get(key []byte) ([]byte, error) {
+	backend.lock.RLock()
+	defer backend.lock.RUnlock()
+
+	value, ok := backend.values[string(key)]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return value, nil
+}
+
+func (backend *memoryBackend) write(ops []storeOp) error {
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+
+	for _, op := range ops {
+		if op.delete {
+			delete(backend.values, op.key)
+		} else {
+			backend.values[op.key] = op.value
+		}
+	}
+	return nil
+}
+
+// iterate walks a snapshot of the keys, so `fn` can change the store.
+func (backend *memoryBackend) iterate(start, limit []byte, fn func(key, value []byte) error) error {
+	backend.lock.RLock()
+	keys := make([]string, 0)
+	values := make(map[string][]byte)
+	for key, value := range backend.values {
+		if bytes.Compare([]byte(key), start) >= 0 && (limit == nil || bytes.Compare([]byte(key), limit) < 0) {
+			keys = append(keys, key)
+			values[key] = value
+		}
+	}
+	backend.lock.RUnlock()
+
+	sort.Strings(keys)
+	for _, key := range keys {
+		if err := fn([]byte(key), values[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
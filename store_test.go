@@ -0,0 +1,207 @@
+package plotbot
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+type storeRecord struct {
+	Name  string
+	Count int
+}
+
+// forEachStore runs a test with both Store implementations.
+func forEachStore(t *testing.T, test func(t *testing.T, store Store)) {
+	t.Run("memory", func(t *testing.T) {
+		test(t, NewMemoryStore())
+	})
+	t.Run("leveldb", func(t *testing.T) {
+		db, err := leveldb.Open(storage.NewMemStorage(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		test(t, NewLevelDBStore(db))
+	})
+}
+
+func storeKeys(t *testing.T, iterate func(fn func(entry StoreEntry) error) error) string {
+	keys := make([]string, 0)
+	err := iterate(func(entry StoreEntry) error {
+		keys = append(keys, entry.Key)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Join(keys, ",")
+}
+
+func TestStore(t *testing.T) {
+	forEachStore(t, func(t *testing.T, root Store) {
+		store := root.Namespace("plugin")
+		other := root.Namespace("plugin2")
+
+		if err := store.Put("a", storeRecord{"hodor", 1}); err != nil {
+			t.Fatal(err)
+		}
+		var record storeRecord
+		if err := store.Get("a", &record); err != nil || record != (storeRecord{"hodor", 1}) {
+			t.Errorf("expected the stored record, got %#v, %v", record, err)
+		}
+		if err := other.Get("a", &record); err != ErrNotFound {
+			t.Errorf("expected namespaces to be isolated, got %v", err)
+		}
+		if err := root.Get("plugin:a", &record); err != nil {
+			t.Errorf("expected namespaced keys to be prefixed, got %v", err)
+		}
+
+		for _, key := range []string{"user:2", "user:1", "user:10", "channel:1", "z"} {
+			store.Put(key, storeRecord{Name: key})
+		}
+		other.Put("user:3", storeRecord{})
+
+		keys := storeKeys(t, func(fn func(StoreEntry) error) error { return store.Iterate("user:", fn) })
+		if keys != "user:1,user:10,user:2" {
+			t.Errorf("expected the keys with the prefix in order, got %s", keys)
+		}
+		keys = storeKeys(t, func(fn func(StoreEntry) error) error { return store.Range("b", "user:10", fn) })
+		if keys != "channel:1,user:1" {
+			t.Errorf("expected the keys in the range, got %s", keys)
+		}
+		keys = storeKeys(t, func(fn func(StoreEntry) error) error { return store.Range("user:2", "", fn) })
+		if keys != "user:2,z" {
+			t.Errorf("expected the keys up to the end of the namespace, got %s", keys)
+		}
+
+		store.Iterate("channel:", func(entry StoreEntry) error {
+			if err := entry.Decode(&record); err != nil || record.Name != "channel:1" {
+				t.Errorf("expected the entry to decode, got %#v, %v", record, err)
+			}
+			return nil
+		})
+
+		if err := store.Delete("z"); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Get("z", &record); err != ErrNotFound {
+			t.Errorf("expected the key to be deleted, got %v", err)
+		}
+	})
+}
+
+func TestStoreBatch(t *testing.T) {
+	forEachStore(t, func(t *testing.T, root Store) {
+		store := root.Namespace("plugin")
+		store.Put("old", 1)
+
+		batch := NewStoreBatch()
+		batch.Put("a", 1)
+		batch.Put("b", 2)
+		batch.Delete("old")
+		if err := store.Write(batch); err != nil {
+			t.Fatal(err)
+		}
+		keys := storeKeys(t, func(fn func(StoreEntry) error) error { return store.Iterate("", fn) })
+		if keys != "a,b" {
+			t.Errorf("expected the batch to be written, got %s", keys)
+		}
+
+		batch = NewStoreBatch()
+		batch.Put("c", 3)
+		batch.Put("d", func() {})
+		if err := store.Write(batch); err == nil {
+			t.Error("expected the batch to be refused")
+		}
+		if err := store.Get("c", new(int)); err != ErrNotFound {
+			t.Errorf("expected nothing of a refused batch to be written, got %v", err)
+		}
+	})
+}
+
+func TestStoreTTL(t *testing.T) {
+	now := time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC)
+	defer func() { storeNow = time.Now }()
+	storeNow = func() time.Time { return now }
+
+	forEachStore(t, func(t *testing.T, root Store) {
+		store := root.Namespace("plugin")
+		store.PutTTL("short", "soon gone", time.Minute)
+		store.PutTTL("long", "still there", time.Hour)
+		store.Put("forever", "always there")
+
+		var value string
+		if err := store.Get("short", &value); err != nil || value != "soon gone" {
+			t.Errorf("expected the value before it expires, got %q, %v", value, err)
+		}
+
+		now = now.Add(2 * time.Minute)
+		if err := store.Get("short", &value); err != ErrNotFound {
+			t.Errorf("expected the value to expire, got %q, %v", value, err)
+		}
+		keys := storeKeys(t, func(fn func(StoreEntry) error) error { return store.Iterate("", fn) })
+		if keys != "forever,long" {
+			t.Errorf("expected expired values to be skipped, got %s", keys)
+		}
+
+		count, err := root.DeleteExpired()
+		if err != nil || count != 1 {
+			t.Errorf("expected one expired value to be deleted, got %d, %v", count, err)
+		}
+		if err := store.Get("long", &value); err != nil || value != "still there" {
+			t.Errorf("expected unexpired values to be kept, got %q, %v", value, err)
+		}
+		now = now.Add(-2 * time.Minute)
+	})
+}
+
+func TestExportImportStore(t *testing.T) {
+	now := time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC)
+	defer func() { storeNow = time.Now }()
+	storeNow = func() time.Time { return now }
+
+	store := NewMemoryStore()
+	store.Namespace("standup").Put("stand:1588291200:hodor@example.com", storeRecord{"hodor", 1})
+	store.Namespace("reminders").PutTTL("r1", "call mom", time.Hour)
+	store.Namespace("reminders").PutTTL("r2", "expired", time.Second)
+	store.Put("top", 42)
+	now = now.Add(time.Minute)
+
+	var dump bytes.Buffer
+	count, err := ExportStore(store, &dump)
+	if err != nil || count != 3 {
+		t.Fatalf("expected 3 values exported, got %d, %v", count, err)
+	}
+	expected := `{"namespace":"reminders","key":"r1","value":"call mom","expires":"2020-05-01T13:00:00Z"}
+{"namespace":"standup","key":"stand:1588291200:hodor@example.com","value":{"Name":"hodor","Count":1}}
+{"namespace":"","key":"top","value":42}
+`
+	if dump.String() != expected {
+		t.Errorf("unexpected dump:\n%s", dump.String())
+	}
+
+	restored := NewMemoryStore()
+	count, err = ImportStore(restored, strings.NewReader(dump.String()+"\n"))
+	if err != nil || count != 3 {
+		t.Fatalf("expected 3 values imported, got %d, %v", count, err)
+	}
+	var record storeRecord
+	if err := restored.Namespace("standup").Get("stand:1588291200:hodor@example.com", &record); err != nil || record.Name != "hodor" {
+		t.Errorf("expected the standup to be restored, got %#v, %v", record, err)
+	}
+	now = now.Add(time.Hour)
+	var reminder string
+	if err := restored.Namespace("reminders").Get("r1", &reminder); err != ErrNotFound {
+		t.Errorf("expected the restored value to keep its expiration, got %q, %v", reminder, err)
+	}
+
+	_, err = ImportStore(restored, strings.NewReader("{\"key\":\"a\",\"value\":1}\nnot json\n"))
+	if err == nil || !strings.HasPrefix(err.Error(), "line 2: ") {
+		t.Errorf("expected the invalid line to be reported, got %v", err)
+	}
+}
@@ -0,0 +1,129 @@
+package plotbot
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// dumpRecord is a line of a store dump.
+type dumpRecord struct {
+	Namespace string          `json:"namespace"`
+	Key       string          `json:"key"`
+	Value     json.RawMessage `json:"value"`
+	Expires   *time.Time      `json:"expires,omitempty"`
+}
+
+// importBatchSize is the number of values written at once on import.
+const importBatchSize = 1000
+
+// ExportStore writes all the values of `store` as JSON lines, like:
+//
+//	{"namespace":"standup","key":"stand:1588291200:hodor@example.com","value":{...}}
+//
+// The namespace is the part of the key before its first ":", empty for
+// keys without one.  Expiring values have an `expires` date, and
+// expired ones are left out.  It returns the number of values written.
+func ExportStore(store Store, w io.Writer) (int, error) {
+	count := 0
+	encoder := json.NewEncoder(w)
+	err := store.Iterate("", func(entry StoreEntry) error {
+		if !json.Valid(entry.Raw()) {
+			defaultLogger.Warn("Skipping a value which is not JSON", "key", entry.Key)
+			return nil
+		}
+
+		record := dumpRecord{Key: entry.Key, Value: entry.Raw()}
+		if i := strings.Index(entry.Key, ":"); i >= 0 {
+			record.Namespace, record.Key = entry.Key[:i], entry.Key[i+1:]
+		}
+		if !entry.Expires.IsZero() {
+			expires := entry.Expires.UTC()
+			record.Expires = &expires
+		}
+
+		count++
+		return encoder.Encode(&record)
+	})
+	return count, err
+}
+
+// ImportStore writes the values of a dump made by ExportStore to
+// `store`.  Existing keys are overwritten, others are left alone, and
+// values which expired since the export are skipped.  It returns the
+// number of values written.
+func ImportStore(store Store, r io.Reader) (int, error) {
+	count := 0
+	batch := NewStoreBatch()
+	flush := func() error {
+		if err := store.Write(batch); err != nil {
+			return err
+		}
+		count += batch.Len()
+		batch = NewStoreBatch()
+		return nil
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxExternalLine)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var record dumpRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return count, fmt.Errorf("line %d: %s", line, err)
+		}
+		if record.Key == "" || record.Value == nil {
+			return count, fmt.Errorf("line %d: key and value are required", line)
+		}
+
+		key := record.Key
+		if record.Namespace != "" {
+			key = record.Namespace + ":" + key
+		}
+		if record.Expires == nil {
+			batch.Put(key, record.Value)
+		} else if ttl := record.Expires.Sub(storeNow()); ttl > 0 {
+			batch.PutTTL(key, record.Value, ttl)
+		}
+
+		if batch.Len() >= importBatchSize {
+			if err := flush(); err != nil {
+				return count, err
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return count, err
+	}
+	return count, flush()
+}
+
+// OpenDB opens the LevelDB database of the configuration, without
+// running the bot, for maintenance like `plotbot db export`.  Close it
+// with `CloseDB()`.
+func (bot *Bot) OpenDB() error {
+	bot.loadBaseConfig()
+	return bot.openDB()
+}
+
+func (bot *Bot) openDB() error {
+	db, err := leveldb.OpenFile(bot.LevelDBConfig.Path, nil)
+	if err != nil {
+		return err
+	}
+	bot.DB = db
+	bot.Store = NewLevelDBStore(db)
+	return nil
+}
+
+// CloseDB closes the database opened with `OpenDB()`.
+func (bot *Bot) CloseDB() error {
+	return bot.DB.Close()
+}
@@ -3,12 +3,15 @@ package testutils
 import (
 	"fmt"
 
-	"github.com/slack-go/slack"
 	"github.com/plotly/plotbot"
+	"github.com/slack-go/slack"
 )
 
 var DefaultFromUser = "hodor"
 
+// DefaultTimestamp is the timestamp of messages built by ToBotMsg.
+var DefaultTimestamp = "1355517523.000005"
+
 func applyFromUserToMessage(m *plotbot.Message, user string) {
 	m.FromUser.ID = user
 	m.FromUser.Name = user
@@ -26,9 +29,10 @@ func ToBotMsg(bot plotbot.BotLike, msg string) *plotbot.Message {
 	channelId := "channelId"
 
 	smsg := &slack.Msg{
-		User:    "abcdef",
-		Channel: channelId,
-		Text:    msg,
+		User:      "abcdef",
+		Channel:   channelId,
+		Text:      msg,
+		Timestamp: DefaultTimestamp,
 	}
 
 	suser := &slack.User{}
@@ -2,24 +2,33 @@ package testutils
 
 import (
 	"fmt"
+	"sync"
 	"time"
 
-	"github.com/slack-go/slack"
 	"github.com/plotly/plotbot"
+	"github.com/slack-go/slack"
 )
 
 var BotId = "mockbotid"
 
 type MockBot struct {
-	Channels      map[string]slack.Channel
-	Config        plotbot.SlackConfig
-	MentionPrefix string
-	Myself        *slack.UserDetails
-	TestReplies   []*plotbot.BotReply
-	TestNotifies  [][]string
-	Users         map[string]slack.User
-	conversations []*plotbot.Conversation
-	mood          plotbot.Mood
+	Channels         map[string]slack.Channel
+	Config           plotbot.SlackConfig
+	MentionPrefix    string
+	Myself           *slack.UserDetails
+	TestReplies      []*plotbot.BotReply
+	TestNotifies     [][]string
+	TestRichNotifies []*plotbot.RichMessage
+	TestUpdates      []*plotbot.BotReply
+	Users            map[string]slack.User
+	conversations    []*plotbot.Conversation
+	interactions     map[string]plotbot.InteractionHandler
+	mood             plotbot.Mood
+
+	// lock guards the recording of replies and the interactions, which
+	// plugins do from their own goroutines.  Tests read the Test*
+	// fields once the plugin is done.
+	lock sync.Mutex
 }
 
 func NewMockBot(sconf plotbot.SlackConfig, userconf slack.UserDetails, mood plotbot.Mood) *MockBot {
@@ -63,6 +72,7 @@ func NewMockBot(sconf plotbot.SlackConfig, userconf slack.UserDetails, mood plot
 		TestNotifies:  [][]string{},
 		TestReplies:   []*plotbot.BotReply{},
 		Users:         make(map[string]slack.User),
+		interactions:  make(map[string]plotbot.InteractionHandler),
 		mood:          mood,
 	}
 
@@ -74,7 +84,11 @@ func NewDefaultMockBot() *MockBot {
 }
 
 func ClearMockBot(bot *MockBot) {
+	bot.lock.Lock()
+	defer bot.lock.Unlock()
 	bot.TestNotifies = [][]string{}
+	bot.TestRichNotifies = nil
+	bot.TestUpdates = nil
 	bot.TestReplies = []*plotbot.BotReply{}
 }
 
@@ -87,7 +101,13 @@ func (bot *MockBot) ListenFor(conv *plotbot.Conversation) error {
 }
 
 func (bot *MockBot) Reply(msg *plotbot.Message, reply string) {
-	bot.TestReplies = append(bot.TestReplies, msg.Reply(reply))
+	bot.addReply(msg.Reply(reply))
+}
+
+func (bot *MockBot) addReply(reply *plotbot.BotReply) {
+	bot.lock.Lock()
+	defer bot.lock.Unlock()
+	bot.TestReplies = append(bot.TestReplies, reply)
 }
 
 // ReplyMention replies with a @mention named prefixed, when replying in public. When replying in private, nothing is added.
@@ -96,19 +116,46 @@ func (bot *MockBot) ReplyMention(msg *plotbot.Message, reply string) {
 }
 
 func (bot *MockBot) ReplyPrivately(msg *plotbot.Message, reply string) {
-	bot.TestReplies = append(bot.TestReplies, msg.ReplyPrivately(reply))
+	bot.addReply(msg.ReplyPrivately(reply))
+}
+
+func (bot *MockBot) ReplyInThread(msg *plotbot.Message, reply string) {
+	bot.addReply(msg.ReplyInThread(reply))
+}
+
+func (bot *MockBot) ReplyRich(msg *plotbot.Message, rich *plotbot.RichMessage) {
+	bot.addReply(msg.ReplyRich(rich))
 }
 
 func (bot *MockBot) Notify(room, color, msg string) {
+	bot.lock.Lock()
+	defer bot.lock.Unlock()
 	bot.TestNotifies = append(bot.TestNotifies, []string{room, color, msg})
 }
 
+// NotifyRich records the notification like `Notify()`, with the
+// message's fallback text, and keeps the message in TestRichNotifies.
+func (bot *MockBot) NotifyRich(room string, rich *plotbot.RichMessage) {
+	bot.Notify(room, rich.Color, rich.Text)
+	bot.lock.Lock()
+	defer bot.lock.Unlock()
+	bot.TestRichNotifies = append(bot.TestRichNotifies, rich)
+}
+
+// Send records the reply, and reports it as delivered.
+func (bot *MockBot) Send(reply *plotbot.BotReply) {
+	bot.addReply(reply)
+	if reply.OnDelivery != nil {
+		reply.OnDelivery("1355517540.000009", nil)
+	}
+}
+
 func (bot *MockBot) SendToChannel(channelName string, message string) {
 	reply := &plotbot.BotReply{
 		To:   channelName,
 		Text: message,
 	}
-	bot.TestReplies = append(bot.TestReplies, reply)
+	bot.addReply(reply)
 }
 
 func (bot *MockBot) AtMention() string {
@@ -135,6 +182,72 @@ func (bot *MockBot) Id() string {
 	return bot.Myself.ID
 }
 
+func (bot *MockBot) HandleInteraction(callbackID string, handler plotbot.InteractionHandler) {
+	bot.lock.Lock()
+	defer bot.lock.Unlock()
+	bot.interactions[callbackID] = handler
+}
+
+func (bot *MockBot) RemoveInteraction(callbackID string) {
+	bot.lock.Lock()
+	defer bot.lock.Unlock()
+	delete(bot.interactions, callbackID)
+}
+
+// Click simulates a click by `user` on the button with `value` of the
+// components attached with `callbackID`.  It returns false when no
+// handler is registered for `callbackID`.
+func (bot *MockBot) Click(callbackID, value string, user *slack.User) bool {
+	bot.lock.Lock()
+	handler, ok := bot.interactions[callbackID]
+	bot.lock.Unlock()
+	if !ok {
+		return false
+	}
+	handler(&plotbot.Interaction{
+		Bot:              bot,
+		CallbackID:       callbackID,
+		Value:            value,
+		User:             user,
+		Channel:          "channelId",
+		MessageTimestamp: "1355517530.000007",
+	})
+	return true
+}
+
+// WaitForReplies returns the replies once there are at least `count`
+// of them, or those recorded when `timeout` expires.
+func (bot *MockBot) WaitForReplies(count int, timeout time.Duration) []*plotbot.BotReply {
+	deadline := time.Now().Add(timeout)
+	for {
+		bot.lock.Lock()
+		replies := append([]*plotbot.BotReply{}, bot.TestReplies...)
+		bot.lock.Unlock()
+		if len(replies) >= count || time.Now().After(deadline) {
+			return replies
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// CallbackIDs returns the callback IDs with a registered handler.
+func (bot *MockBot) CallbackIDs() []string {
+	bot.lock.Lock()
+	defer bot.lock.Unlock()
+	ids := make([]string, 0, len(bot.interactions))
+	for id := range bot.interactions {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func (bot *MockBot) UpdateMessage(channelID, timestamp string, rich *plotbot.RichMessage) error {
+	bot.lock.Lock()
+	defer bot.lock.Unlock()
+	bot.TestUpdates = append(bot.TestUpdates, rich.BotReply(channelID))
+	return nil
+}
+
 func (bot *MockBot) CloseConversation(conv *plotbot.Conversation) {
 	for {
 	}
@@ -36,7 +36,9 @@ func (r *MockRunner) Run(c string, s ...string) *exec.Cmd {
 	if r.ParseVars != nil {
 		env = r.ParseVars(c, s...)
 	}
-	env = append(env, "GO_WANT_CMD_PROCESS=1")
+	// Race-enabled binaries sleep for a second before exiting, unless
+	// told otherwise, which would slow down every command.
+	env = append(env, "GO_WANT_CMD_PROCESS=1", "GORACE=atexit_sleep_ms=0")
 	cmd.Env = env
 
 	return cmd
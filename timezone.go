@@ -0,0 +1,63 @@
+package plotbot
+
+import (
+	"sync"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// locations caches the time zones loaded by name, as users share a few.
+var locations sync.Map
+
+// LoadLocation is `time.LoadLocation()`, cached, and refusing the empty
+// name, which would be UTC.
+func LoadLocation(name string) (*time.Location, error) {
+	if loc, ok := locations.Load(name); ok {
+		return loc.(*time.Location), nil
+	}
+	loc, err := loadLocation(name)
+	if err != nil {
+		return nil, err
+	}
+	locations.Store(name, loc)
+	return loc, nil
+}
+
+// Location returns the time zone of a user, from their Slack profile,
+// or the default one when the user is nil or has no valid zone.
+//
+// "Today" for a user, like their standup date, must be computed in
+// that zone: `bot.Now(user)` is the current time there.
+func (bot *Bot) Location(user *slack.User) *time.Location {
+	if user != nil && user.TZ != "" {
+		if loc, err := LoadLocation(user.TZ); err == nil {
+			return loc
+		}
+	}
+	return bot.DefaultLocation()
+}
+
+// DefaultLocation returns the `time_zone` of the Slack configuration,
+// or the server's time zone when it is not set.
+func (bot *Bot) DefaultLocation() *time.Location {
+	if bot.Config.TimeZone != "" {
+		if loc, err := LoadLocation(bot.Config.TimeZone); err == nil {
+			return loc
+		}
+	}
+	return time.Local
+}
+
+// Now returns the current time in the time zone of a user.
+func (bot *Bot) Now(user *slack.User) time.Time {
+	return time.Now().In(bot.Location(user))
+}
+
+// StartOfDay returns midnight on the day of `t`, in the location of
+// `t`.  Use `t.AddDate()` to move by days, which unlike adding 24 hours
+// keeps the time of day across daylight saving changes.
+func StartOfDay(t time.Time) time.Time {
+	year, month, day := t.Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, t.Location())
+}
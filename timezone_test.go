@@ -0,0 +1,47 @@
+package plotbot
+
+import (
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+func TestLocation(t *testing.T) {
+	bot := &Bot{}
+	if loc := bot.Location(&slack.User{TZ: "Europe/Paris"}); loc.String() != "Europe/Paris" {
+		t.Errorf("expected the zone of the user's profile, got %s", loc)
+	}
+	if loc := bot.Location(&slack.User{}); loc != time.Local {
+		t.Errorf("expected the server's zone without a configured one, got %s", loc)
+	}
+
+	bot.Config.TimeZone = "America/Montreal"
+	for _, user := range []*slack.User{nil, {}, {TZ: "Nowhere/Special"}} {
+		if loc := bot.Location(user); loc.String() != "America/Montreal" {
+			t.Errorf("%#v: expected the configured zone, got %s", user, loc)
+		}
+	}
+
+	if _, err := LoadLocation(""); err == nil {
+		t.Error("expected the empty zone to be refused")
+	}
+	bot.Config.TimeZone = "Mars/Olympus"
+	if err := bot.Config.Validate(); err == nil {
+		t.Error("expected an unknown time_zone to be refused")
+	}
+}
+
+func TestStartOfDay(t *testing.T) {
+	montreal, _ := time.LoadLocation("America/Montreal")
+	// The day after clocks went forward.
+	at := time.Date(2020, 3, 9, 15, 30, 0, 0, montreal)
+
+	day := StartOfDay(at)
+	if !day.Equal(time.Date(2020, 3, 9, 0, 0, 0, 0, montreal)) {
+		t.Errorf("expected midnight in Montreal, got %s", day)
+	}
+	if before := StartOfDay(day.AddDate(0, 0, -1)); before.Day() != 8 || before.Hour() != 0 {
+		t.Errorf("expected midnight on the previous day across the change, got %s", before)
+	}
+}